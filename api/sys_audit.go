@@ -46,6 +46,35 @@ func (c *Sys) AuditHash(path string, input string) (string, error) {
 	return hashStr, nil
 }
 
+// AuditHashChainKey returns the key of the hash chain of an audit device,
+// which is used to verify its log
+func (c *Sys) AuditHashChainKey(path string) (string, error) {
+	r := c.c.NewRequest("GET", fmt.Sprintf("/v1/sys/audit-hash-chain-key/%s", path))
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+	resp, err := c.c.RawRequestWithContext(ctx, r)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	secret, err := ParseSecret(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if secret == nil || secret.Data == nil {
+		return "", errors.New("data from server response is empty")
+	}
+
+	key, ok := secret.Data["key"].(string)
+	if !ok {
+		return "", errors.New("key not found in response data")
+	}
+
+	return key, nil
+}
+
 func (c *Sys) ListAudit() (map[string]*Audit, error) {
 	r := c.c.NewRequest("GET", "/v1/sys/audit")
 
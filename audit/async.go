@@ -0,0 +1,92 @@
+package audit
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const (
+	// AsyncOption is the audit device option enabling asynchronous delivery
+	AsyncOption = "async"
+
+	// AsyncQueueSizeOption is the audit device option holding the number of
+	// entries queued for asynchronous delivery
+	AsyncQueueSizeOption = "async_queue_size"
+
+	// AsyncOverflowOption is the audit device option holding the policy
+	// applied when the queue of the device is full
+	AsyncOverflowOption = "async_overflow"
+
+	// DefaultAsyncQueueSize is the default size of the queue of a device
+	DefaultAsyncQueueSize = 1024
+)
+
+// OverflowPolicy is what happens to an entry when the queue of an
+// asynchronous device is full
+type OverflowPolicy string
+
+const (
+	// OverflowBlock waits for the queue to have room for the entry
+	OverflowBlock OverflowPolicy = "block"
+
+	// OverflowDrop drops the entry, as if the device had failed to log it
+	OverflowDrop OverflowPolicy = "drop"
+
+	// OverflowFail drops the entry and fails the request
+	OverflowFail OverflowPolicy = "fail"
+)
+
+// AsyncConfig is the configuration of the asynchronous delivery of the
+// entries of a device, which are queued and logged by a worker so that a slow
+// device doesn't delay requests.
+type AsyncConfig struct {
+	QueueSize int
+	Overflow  OverflowPolicy
+}
+
+// ParseAsyncConfig parses the asynchronous delivery options of a device,
+// returning nil if asynchronous delivery is not enabled
+func ParseAsyncConfig(options map[string]string) (*AsyncConfig, error) {
+	enabled := false
+	if raw, ok := options[AsyncOption]; ok {
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a boolean", AsyncOption)
+		}
+		enabled = b
+	}
+
+	config := &AsyncConfig{
+		QueueSize: DefaultAsyncQueueSize,
+		Overflow:  OverflowBlock,
+	}
+
+	if raw, ok := options[AsyncQueueSizeOption]; ok {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("%s must be a positive number", AsyncQueueSizeOption)
+		}
+		config.QueueSize = v
+	}
+
+	if raw, ok := options[AsyncOverflowOption]; ok {
+		switch policy := OverflowPolicy(raw); policy {
+		case OverflowBlock, OverflowDrop, OverflowFail:
+			config.Overflow = policy
+		default:
+			return nil, fmt.Errorf("%s must be one of %q, %q or %q", AsyncOverflowOption, OverflowBlock, OverflowDrop, OverflowFail)
+		}
+	}
+
+	if !enabled {
+		if _, ok := options[AsyncQueueSizeOption]; ok {
+			return nil, fmt.Errorf("%s requires %s", AsyncQueueSizeOption, AsyncOption)
+		}
+		if _, ok := options[AsyncOverflowOption]; ok {
+			return nil, fmt.Errorf("%s requires %s", AsyncOverflowOption, AsyncOption)
+		}
+		return nil, nil
+	}
+
+	return config, nil
+}
@@ -0,0 +1,60 @@
+package audit
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAsyncConfig(t *testing.T) {
+	cases := map[string]struct {
+		options  map[string]string
+		expected *AsyncConfig
+		err      bool
+	}{
+		"disabled": {
+			map[string]string{},
+			nil,
+			false,
+		},
+		"explicitly disabled": {
+			map[string]string{"async": "false"},
+			nil,
+			false,
+		},
+		"defaults": {
+			map[string]string{"async": "true"},
+			&AsyncConfig{QueueSize: DefaultAsyncQueueSize, Overflow: OverflowBlock},
+			false,
+		},
+		"configured": {
+			map[string]string{"async": "true", "async_queue_size": "10", "async_overflow": "drop"},
+			&AsyncConfig{QueueSize: 10, Overflow: OverflowDrop},
+			false,
+		},
+		"bad queue size": {
+			map[string]string{"async": "true", "async_queue_size": "0"},
+			nil,
+			true,
+		},
+		"bad overflow": {
+			map[string]string{"async": "true", "async_overflow": "ignore"},
+			nil,
+			true,
+		},
+		"options without async": {
+			map[string]string{"async_overflow": "fail"},
+			nil,
+			true,
+		},
+	}
+
+	for name, tc := range cases {
+		config, err := ParseAsyncConfig(tc.options)
+		if (err != nil) != tc.err {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if !reflect.DeepEqual(config, tc.expected) {
+			t.Fatalf("%s: expected %#v, got %#v", name, tc.expected, config)
+		}
+	}
+}
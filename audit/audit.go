@@ -3,6 +3,7 @@ package audit
 import (
 	"context"
 
+	log "github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/vault/sdk/helper/salt"
 	"github.com/hashicorp/vault/sdk/logical"
 )
@@ -36,6 +37,15 @@ type Backend interface {
 	Invalidate(context.Context)
 }
 
+// Closer is implemented by audit backends holding resources, such as
+// background goroutines, which must be released once the backend is disabled
+// or Vault is sealed.
+type Closer interface {
+	// Close flushes any buffered entries and stops the backend. The backend
+	// is not used after Close is called.
+	Close() error
+}
+
 // BackendConfig contains configuration parameters used in the factory func to
 // instantiate audit backends
 type BackendConfig struct {
@@ -47,6 +57,9 @@ type BackendConfig struct {
 
 	// Config is the opaque user configuration provided when mounting
 	Config map[string]string
+
+	// Logger is used by backends logging asynchronously, and may be nil
+	Logger log.Logger
 }
 
 // Factory is the factory function to create an audit backend.
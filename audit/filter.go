@@ -0,0 +1,318 @@
+package audit
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// FilterOption is the audit device option holding its filter expression
+const FilterOption = "filter"
+
+// FilterInput holds the fields of an audited request a filter is evaluated
+// against
+type FilterInput struct {
+	MountType string
+	MountPath string
+	Path      string
+	Operation string
+	Namespace string
+}
+
+// Filter is a parsed filter expression, which selects the requests logged by
+// an audit device. Its grammar is:
+//
+//	expr       = or
+//	or         = and { "or" and }
+//	and        = not { "and" not }
+//	not        = "not" not | "(" expr ")" | comparison
+//	comparison = field ( "==" | "!=" | "matches" ) string
+//	field      = "mount_type" | "mount_path" | "path" | "operation" | "namespace"
+//
+// Strings are double quoted. The "matches" operator matches a glob pattern,
+// in which "*" matches any sequence of characters, including "/".
+type Filter struct {
+	raw  string
+	root filterNode
+}
+
+// filterFields maps the fields of a filter expression to their value
+var filterFields = map[string]func(*FilterInput) string{
+	"mount_type": func(in *FilterInput) string { return in.MountType },
+	"mount_path": func(in *FilterInput) string { return in.MountPath },
+	"path":       func(in *FilterInput) string { return in.Path },
+	"operation":  func(in *FilterInput) string { return in.Operation },
+	"namespace":  func(in *FilterInput) string { return in.Namespace },
+}
+
+// ParseFilter parses a filter expression
+func ParseFilter(raw string) (*Filter, error) {
+	tokens, err := lexFilter(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &filterParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != filterTokenEOF {
+		return nil, fmt.Errorf("unexpected %s at position %d", tok, tok.pos)
+	}
+
+	return &Filter{
+		raw:  raw,
+		root: root,
+	}, nil
+}
+
+// Evaluate reports whether the request described by the input matches the
+// filter
+func (f *Filter) Evaluate(in *FilterInput) bool {
+	return f.root.evaluate(in)
+}
+
+// String returns the filter expression
+func (f *Filter) String() string {
+	return f.raw
+}
+
+type filterNode interface {
+	evaluate(*FilterInput) bool
+}
+
+type filterOr struct {
+	left, right filterNode
+}
+
+func (n *filterOr) evaluate(in *FilterInput) bool {
+	return n.left.evaluate(in) || n.right.evaluate(in)
+}
+
+type filterAnd struct {
+	left, right filterNode
+}
+
+func (n *filterAnd) evaluate(in *FilterInput) bool {
+	return n.left.evaluate(in) && n.right.evaluate(in)
+}
+
+type filterNot struct {
+	node filterNode
+}
+
+func (n *filterNot) evaluate(in *FilterInput) bool {
+	return !n.node.evaluate(in)
+}
+
+type filterComparison struct {
+	field   func(*FilterInput) string
+	op      string
+	value   string
+	pattern *regexp.Regexp
+}
+
+func (n *filterComparison) evaluate(in *FilterInput) bool {
+	v := n.field(in)
+	switch n.op {
+	case "==":
+		return v == n.value
+	case "!=":
+		return v != n.value
+	default:
+		return n.pattern.MatchString(v)
+	}
+}
+
+type filterTokenKind int
+
+const (
+	filterTokenEOF filterTokenKind = iota
+	filterTokenIdent
+	filterTokenString
+	filterTokenOp
+	filterTokenLParen
+	filterTokenRParen
+)
+
+type filterToken struct {
+	kind  filterTokenKind
+	value string
+	pos   int
+}
+
+func (t filterToken) String() string {
+	switch t.kind {
+	case filterTokenEOF:
+		return "end of expression"
+	case filterTokenString:
+		return strconv.Quote(t.value)
+	default:
+		return fmt.Sprintf("%q", t.value)
+	}
+}
+
+func lexFilter(raw string) ([]filterToken, error) {
+	var tokens []filterToken
+	for i := 0; i < len(raw); {
+		c := raw[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, filterToken{kind: filterTokenLParen, value: "(", pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, filterToken{kind: filterTokenRParen, value: ")", pos: i})
+			i++
+		case strings.HasPrefix(raw[i:], "==") || strings.HasPrefix(raw[i:], "!="):
+			tokens = append(tokens, filterToken{kind: filterTokenOp, value: raw[i : i+2], pos: i})
+			i += 2
+		case c == '"':
+			end := i + 1
+			for ; end < len(raw) && raw[end] != '"'; end++ {
+				if raw[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(raw) {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			value, err := strconv.Unquote(raw[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at position %d: %s", i, err)
+			}
+			tokens = append(tokens, filterToken{kind: filterTokenString, value: value, pos: i})
+			i = end + 1
+		case c == '_' || unicode.IsLetter(rune(c)):
+			end := i
+			for end < len(raw) && (raw[end] == '_' || unicode.IsLetter(rune(raw[end])) || unicode.IsDigit(rune(raw[end]))) {
+				end++
+			}
+			word := raw[i:end]
+			kind := filterTokenIdent
+			if word == "matches" {
+				kind = filterTokenOp
+			}
+			tokens = append(tokens, filterToken{kind: kind, value: word, pos: i})
+			i = end
+		default:
+			return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+		}
+	}
+	return append(tokens, filterToken{kind: filterTokenEOF, pos: len(raw)}), nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != filterTokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *filterParser) isKeyword(word string) bool {
+	tok := p.peek()
+	return tok.kind == filterTokenIdent && tok.value == word
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &filterOr{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("and") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &filterAnd{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseNot() (filterNode, error) {
+	if p.isKeyword("not") {
+		p.next()
+		node, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &filterNot{node: node}, nil
+	}
+
+	if p.peek().kind == filterTokenLParen {
+		p.next()
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if tok := p.next(); tok.kind != filterTokenRParen {
+			return nil, fmt.Errorf("expected \")\" at position %d, got %s", tok.pos, tok)
+		}
+		return node, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (filterNode, error) {
+	tok := p.next()
+	if tok.kind != filterTokenIdent {
+		return nil, fmt.Errorf("expected a field at position %d, got %s", tok.pos, tok)
+	}
+	field, ok := filterFields[tok.value]
+	if !ok {
+		return nil, fmt.Errorf("unknown field %q at position %d", tok.value, tok.pos)
+	}
+
+	op := p.next()
+	if op.kind != filterTokenOp {
+		return nil, fmt.Errorf("expected an operator at position %d, got %s", op.pos, op)
+	}
+
+	value := p.next()
+	if value.kind != filterTokenString {
+		return nil, fmt.Errorf("expected a string at position %d, got %s", value.pos, value)
+	}
+
+	n := &filterComparison{
+		field: field,
+		op:    op.value,
+		value: value.value,
+	}
+	if n.op == "matches" {
+		pattern := strings.Replace(regexp.QuoteMeta(n.value), `\*`, ".*", -1)
+		n.pattern = regexp.MustCompile("^" + pattern + "$")
+	}
+	return n, nil
+}
@@ -0,0 +1,98 @@
+package audit
+
+import (
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	tokenLookup := &FilterInput{
+		MountType: "token",
+		MountPath: "auth/token/",
+		Path:      "auth/token/lookup-self",
+		Operation: "read",
+	}
+	kvWrite := &FilterInput{
+		MountType: "kv",
+		MountPath: "secret/",
+		Path:      "secret/data/app/config",
+		Operation: "update",
+		Namespace: "team-a/",
+	}
+	sysMounts := &FilterInput{
+		MountType: "system",
+		MountPath: "sys/",
+		Path:      "sys/mounts",
+		Operation: "read",
+	}
+
+	testCases := []struct {
+		filter   string
+		expected map[*FilterInput]bool
+	}{
+		{
+			filter:   `mount_type == "token"`,
+			expected: map[*FilterInput]bool{tokenLookup: true, kvWrite: false, sysMounts: false},
+		},
+		{
+			filter:   `operation != "read"`,
+			expected: map[*FilterInput]bool{tokenLookup: false, kvWrite: true, sysMounts: false},
+		},
+		{
+			filter:   `path matches "auth/*" or path matches "sys/*"`,
+			expected: map[*FilterInput]bool{tokenLookup: true, kvWrite: false, sysMounts: true},
+		},
+		{
+			filter:   `path matches "secret/*/config" and namespace == "team-a/"`,
+			expected: map[*FilterInput]bool{tokenLookup: false, kvWrite: true, sysMounts: false},
+		},
+		{
+			filter:   `not (mount_path == "sys/" or mount_type == "token")`,
+			expected: map[*FilterInput]bool{tokenLookup: false, kvWrite: true, sysMounts: false},
+		},
+		{
+			// and binds tighter than or
+			filter:   `mount_type == "kv" or mount_type == "token" and operation == "update"`,
+			expected: map[*FilterInput]bool{tokenLookup: false, kvWrite: true, sysMounts: false},
+		},
+		{
+			filter:   `path matches "sys/mou.ts"`,
+			expected: map[*FilterInput]bool{tokenLookup: false, kvWrite: false, sysMounts: false},
+		},
+		{
+			filter:   `path == "secret/\"quoted\""`,
+			expected: map[*FilterInput]bool{tokenLookup: false, kvWrite: false, sysMounts: false},
+		},
+	}
+
+	for _, tc := range testCases {
+		f, err := ParseFilter(tc.filter)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.filter, err)
+		}
+		for in, expected := range tc.expected {
+			if actual := f.Evaluate(in); actual != expected {
+				t.Fatalf("%s: expected %t for %q, got %t", tc.filter, expected, in.Path, actual)
+			}
+		}
+	}
+}
+
+func TestFilter_Invalid(t *testing.T) {
+	for _, filter := range []string{
+		``,
+		`mount_type`,
+		`mount_type ==`,
+		`mount_type == token`,
+		`unknown == "token"`,
+		`mount_type < "token"`,
+		`mount_type == "token" or`,
+		`(mount_type == "token"`,
+		`mount_type == "token")`,
+		`mount_type == "token`,
+		`mount_type == "token" path == "foo"`,
+	} {
+		if _, err := ParseFilter(filter); err == nil {
+			t.Fatalf("expected an error parsing %q", filter)
+		}
+	}
+}
@@ -106,6 +106,7 @@ func (f *AuditFormatter) FormatRequest(ctx context.Context, w io.Writer, config
 			EntityID:                  auth.EntityID,
 			RemainingUses:             req.ClientTokenRemainingUses,
 			TokenType:                 auth.TokenType.String(),
+			PolicyResults:             auditPolicyResults(config, auth.PolicyResults),
 		},
 
 		Request: &AuditRequest{
@@ -258,6 +259,7 @@ func (f *AuditFormatter) FormatResponse(ctx context.Context, w io.Writer, config
 			RemainingUses:             req.ClientTokenRemainingUses,
 			EntityID:                  auth.EntityID,
 			TokenType:                 auth.TokenType.String(),
+			PolicyResults:             auditPolicyResults(config, auth.PolicyResults),
 		},
 
 		Request: &AuditRequest{
@@ -359,6 +361,22 @@ type AuditAuth struct {
 	RemainingUses             int                 `json:"remaining_uses,omitempty"`
 	EntityID                  string              `json:"entity_id,omitempty"`
 	TokenType                 string              `json:"token_type,omitempty"`
+	PolicyResults             *AuditPolicyResults `json:"policy_results,omitempty"`
+}
+
+type AuditPolicyResults struct {
+	Allowed          bool              `json:"allowed"`
+	GrantingPolicies []AuditPolicyInfo `json:"granting_policies,omitempty"`
+	DenyingPolicies  []AuditPolicyInfo `json:"denying_policies,omitempty"`
+	Path             string            `json:"path,omitempty"`
+	MatchType        string            `json:"match_type,omitempty"`
+}
+
+type AuditPolicyInfo struct {
+	Name          string `json:"name,omitempty"`
+	NamespaceID   string `json:"namespace_id,omitempty"`
+	NamespacePath string `json:"namespace_path,omitempty"`
+	Type          string `json:"type,omitempty"`
 }
 
 type AuditSecret struct {
@@ -395,6 +413,35 @@ func getClientCertificateSerialNumber(connState *tls.ConnectionState) string {
 	return connState.VerifiedChains[0][0].SerialNumber.String()
 }
 
+// auditPolicyResults returns the policy results of the entry, if they are
+// included
+func auditPolicyResults(config FormatterConfig, results *logical.PolicyResults) *AuditPolicyResults {
+	if !config.PolicyResults || results == nil {
+		return nil
+	}
+
+	policyInfos := func(policies []logical.PolicyInfo) []AuditPolicyInfo {
+		var ret []AuditPolicyInfo
+		for _, p := range policies {
+			ret = append(ret, AuditPolicyInfo{
+				Name:          p.Name,
+				NamespaceID:   p.NamespaceID,
+				NamespacePath: p.NamespacePath,
+				Type:          p.Type,
+			})
+		}
+		return ret
+	}
+
+	return &AuditPolicyResults{
+		Allowed:          results.Allowed,
+		GrantingPolicies: policyInfos(results.GrantingPolicies),
+		DenyingPolicies:  policyInfos(results.DenyingPolicies),
+		Path:             results.Path,
+		MatchType:        results.MatchType,
+	}
+}
+
 // parseVaultTokenFromJWT returns a string iff the token was a JWT and we could
 // extract the original token ID from inside
 func parseVaultTokenFromJWT(token string) *string {
@@ -0,0 +1,135 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/salt"
+	"github.com/hashicorp/vault/sdk/version"
+)
+
+const (
+	cefSeverity      = 3
+	cefSeverityError = 6
+)
+
+var (
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+)
+
+// CEFFormatWriter is an AuditFormatWriter implementation that structures data
+// into the ArcSight Common Event Format. The fields of the entries are mapped
+// to CEF extensions, and the request and response data are omitted.
+type CEFFormatWriter struct {
+	Prefix   string
+	SaltFunc func(context.Context) (*salt.Salt, error)
+}
+
+func (f *CEFFormatWriter) WriteRequest(w io.Writer, req *AuditRequestEntry) error {
+	if req == nil {
+		return fmt.Errorf("request entry was nil, cannot encode")
+	}
+
+	return f.write(w, req.Time, req.Type, req.Auth, req.Request, nil, req.Error)
+}
+
+func (f *CEFFormatWriter) WriteResponse(w io.Writer, resp *AuditResponseEntry) error {
+	if resp == nil {
+		return fmt.Errorf("response entry was nil, cannot encode")
+	}
+
+	return f.write(w, resp.Time, resp.Type, resp.Auth, resp.Request, resp.Response, resp.Error)
+}
+
+func (f *CEFFormatWriter) write(w io.Writer, entryTime, entryType string, auth *AuditAuth, req *AuditRequest, resp *AuditResponse, errString string) error {
+	if auth == nil {
+		auth = &AuditAuth{}
+	}
+	if req == nil {
+		req = &AuditRequest{}
+	}
+
+	severity := cefSeverity
+	outcome := "success"
+	if errString != "" {
+		severity = cefSeverityError
+		outcome = "failure"
+	}
+
+	var ext cefExtensions
+	if entryTime != "" {
+		t, err := time.Parse(time.RFC3339Nano, entryTime)
+		if err != nil {
+			return err
+		}
+		ext.add("rt", strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10))
+	}
+	ext.add("externalId", req.ID)
+	ext.add("act", string(req.Operation))
+	ext.add("request", req.Path)
+	ext.add("src", req.RemoteAddr)
+	ext.add("suser", auth.DisplayName)
+	ext.add("suid", auth.EntityID)
+	ext.add("outcome", outcome)
+	ext.add("reason", errString)
+	if req.Namespace != nil {
+		ext.addCustom(1, "namespace", req.Namespace.Path)
+	}
+	ext.addCustom(2, "client_token", auth.ClientToken)
+	ext.addCustom(3, "accessor", auth.Accessor)
+	ext.addCustom(4, "policies", strings.Join(auth.Policies, ","))
+	if resp != nil && resp.Secret != nil {
+		ext.addCustom(5, "lease_id", resp.Secret.LeaseID)
+	}
+	if resp != nil && resp.Auth != nil {
+		ext.addCustom(6, "response_accessor", resp.Auth.Accessor)
+	}
+
+	if len(f.Prefix) > 0 {
+		if _, err := w.Write([]byte(f.Prefix)); err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "CEF:0|HashiCorp|Vault|%s|%s|%s|%d|%s\n",
+		cefHeaderEscaper.Replace(version.GetVersion().VersionNumber()),
+		cefHeaderEscaper.Replace(entryType+":"+string(req.Operation)),
+		cefHeaderEscaper.Replace("Vault "+entryType),
+		severity,
+		ext.String())
+	return err
+}
+
+func (f *CEFFormatWriter) Salt(ctx context.Context) (*salt.Salt, error) {
+	return f.SaltFunc(ctx)
+}
+
+// cefExtensions holds the key=value pairs of a CEF event
+type cefExtensions struct {
+	pairs []string
+}
+
+func (e *cefExtensions) add(key, value string) {
+	if value == "" {
+		return
+	}
+	e.pairs = append(e.pairs, key+"="+cefExtensionEscaper.Replace(value))
+}
+
+// addCustom adds one of the csN custom string extensions, with its label
+func (e *cefExtensions) addCustom(n int, label, value string) {
+	if value == "" {
+		return
+	}
+	e.add(fmt.Sprintf("cs%d", n), value)
+	e.add(fmt.Sprintf("cs%dLabel", n), label)
+}
+
+func (e *cefExtensions) String() string {
+	return strings.Join(e.pairs, " ")
+}
@@ -0,0 +1,170 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/helper/salt"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/hashicorp/vault/sdk/version"
+)
+
+func TestFormatCEF_formatRequest(t *testing.T) {
+	salter, err := salt.NewSalt(context.Background(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saltFunc := func(context.Context) (*salt.Salt, error) {
+		return salter, nil
+	}
+
+	header := fmt.Sprintf("CEF:0|HashiCorp|Vault|%s|request:update|Vault request|", version.GetVersion().VersionNumber())
+
+	cases := map[string]struct {
+		Auth     *logical.Auth
+		Req      *logical.Request
+		Err      error
+		Prefix   string
+		Header   string
+		Expected []string
+	}{
+		"auth, request": {
+			&logical.Auth{
+				ClientToken: "foo",
+				Accessor:    "bar",
+				DisplayName: "testtoken",
+				EntityID:    "foobarentity",
+				Policies:    []string{"root", "default"},
+				TokenType:   logical.TokenTypeService,
+			},
+			&logical.Request{
+				ID:        "req-id",
+				Operation: logical.UpdateOperation,
+				Path:      "/foo",
+				Connection: &logical.Connection{
+					RemoteAddr: "127.0.0.1",
+				},
+			},
+			nil,
+			"",
+			header + "3|",
+			[]string{
+				"externalId=req-id",
+				"act=update",
+				"request=/foo",
+				"src=127.0.0.1",
+				"suser=testtoken",
+				"suid=foobarentity",
+				"outcome=success",
+				fmt.Sprintf("cs2=%s cs2Label=client_token", salter.GetIdentifiedHMAC("foo")),
+				"cs3=bar cs3Label=accessor",
+				"cs4=root,default cs4Label=policies",
+			},
+		},
+		"error with prefix and escaping": {
+			&logical.Auth{
+				ClientToken: "foo",
+				DisplayName: "test|token",
+			},
+			&logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "/foo=bar",
+			},
+			errors.New("this is\nan error"),
+			"@cef: ",
+			header + "6|",
+			[]string{
+				`request=/foo\=bar`,
+				"suser=test|token",
+				"outcome=failure",
+				`reason=this is\nan error`,
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		var buf bytes.Buffer
+		formatter := AuditFormatter{
+			AuditFormatWriter: &CEFFormatWriter{
+				Prefix:   tc.Prefix,
+				SaltFunc: saltFunc,
+			},
+		}
+		in := &logical.LogInput{
+			Auth:     tc.Auth,
+			Request:  tc.Req,
+			OuterErr: tc.Err,
+		}
+		if err := formatter.FormatRequest(namespace.RootContext(nil), &buf, FormatterConfig{}, in); err != nil {
+			t.Fatalf("bad: %s\nerr: %s", name, err)
+		}
+
+		line := buf.String()
+		if strings.Count(line, "\n") != 1 || !strings.HasSuffix(line, "\n") {
+			t.Fatalf("bad: %s\nexpected a single line, got %q", name, line)
+		}
+		if !strings.HasPrefix(line, tc.Prefix+tc.Header) {
+			t.Fatalf("bad: %s\nexpected %q to start with %q", name, line, tc.Prefix+tc.Header)
+		}
+		if !strings.Contains(line, "|rt=") {
+			t.Fatalf("bad: %s\nexpected %q to contain the time", name, line)
+		}
+		for _, expected := range tc.Expected {
+			if !strings.Contains(line, expected) {
+				t.Fatalf("bad: %s\nexpected %q to contain %q", name, line, expected)
+			}
+		}
+	}
+}
+
+func TestFormatCEF_formatResponse(t *testing.T) {
+	salter, err := salt.NewSalt(context.Background(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	formatter := AuditFormatter{
+		AuditFormatWriter: &CEFFormatWriter{
+			SaltFunc: func(context.Context) (*salt.Salt, error) {
+				return salter, nil
+			},
+		},
+	}
+	in := &logical.LogInput{
+		Auth: &logical.Auth{
+			ClientToken: "foo",
+		},
+		Request: &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "secret/foo",
+		},
+		Response: &logical.Response{
+			Secret: &logical.Secret{
+				LeaseID: "lease",
+			},
+			Data: map[string]interface{}{
+				"password": "hunter2",
+			},
+		},
+	}
+	if err := formatter.FormatResponse(namespace.RootContext(nil), &buf, FormatterConfig{}, in); err != nil {
+		t.Fatal(err)
+	}
+
+	line := buf.String()
+	if !strings.Contains(line, "|response:read|Vault response|3|") {
+		t.Fatalf("bad header: %q", line)
+	}
+	if !strings.Contains(line, "cs5=lease cs5Label=lease_id") {
+		t.Fatalf("expected %q to contain the lease", line)
+	}
+	if strings.Contains(line, "hunter2") {
+		t.Fatalf("expected %q to omit the response data", line)
+	}
+}
@@ -0,0 +1,290 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/salt"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/hashicorp/vault/sdk/version"
+)
+
+// OCSF API Activity class, see https://schema.ocsf.io/classes/api_activity
+const (
+	ocsfVersion     = "1.0.0"
+	ocsfCategoryUID = 6
+	ocsfClassUID    = 6003
+
+	ocsfActivityCreate = 1
+	ocsfActivityRead   = 2
+	ocsfActivityUpdate = 3
+	ocsfActivityDelete = 4
+	ocsfActivityOther  = 99
+
+	ocsfSeverityInformational = 1
+	ocsfSeverityMedium        = 3
+
+	ocsfStatusSuccess = 1
+	ocsfStatusFailure = 2
+)
+
+// OCSFFormatWriter is an AuditFormatWriter implementation that structures data
+// into OCSF API Activity events, encoded as JSON.
+type OCSFFormatWriter struct {
+	Prefix   string
+	SaltFunc func(context.Context) (*salt.Salt, error)
+}
+
+type ocsfEvent struct {
+	Time         int64                  `json:"time"`
+	CategoryUID  int                    `json:"category_uid"`
+	ClassUID     int                    `json:"class_uid"`
+	ActivityID   int                    `json:"activity_id"`
+	TypeUID      int                    `json:"type_uid"`
+	SeverityID   int                    `json:"severity_id"`
+	StatusID     int                    `json:"status_id"`
+	Status       string                 `json:"status"`
+	StatusDetail string                 `json:"status_detail,omitempty"`
+	Metadata     ocsfMetadata           `json:"metadata"`
+	Actor        ocsfActor              `json:"actor"`
+	API          ocsfAPI                `json:"api"`
+	SrcEndpoint  *ocsfEndpoint          `json:"src_endpoint,omitempty"`
+	Resources    []ocsfResource         `json:"resources,omitempty"`
+	Unmapped     map[string]interface{} `json:"unmapped,omitempty"`
+}
+
+type ocsfMetadata struct {
+	Version string      `json:"version"`
+	Product ocsfProduct `json:"product"`
+	UID     string      `json:"uid,omitempty"`
+	LogName string      `json:"log_name"`
+}
+
+type ocsfProduct struct {
+	Name       string `json:"name"`
+	VendorName string `json:"vendor_name"`
+	Version    string `json:"version"`
+}
+
+type ocsfActor struct {
+	User    *ocsfUser    `json:"user,omitempty"`
+	Session *ocsfSession `json:"session,omitempty"`
+}
+
+type ocsfUser struct {
+	Name string `json:"name,omitempty"`
+	UID  string `json:"uid,omitempty"`
+}
+
+type ocsfSession struct {
+	UID           string `json:"uid,omitempty"`
+	CredentialUID string `json:"credential_uid,omitempty"`
+}
+
+type ocsfAPI struct {
+	Operation string        `json:"operation"`
+	Request   ocsfRequest   `json:"request"`
+	Response  *ocsfResponse `json:"response,omitempty"`
+}
+
+type ocsfRequest struct {
+	UID  string                 `json:"uid,omitempty"`
+	Data map[string]interface{} `json:"data,omitempty"`
+}
+
+type ocsfResponse struct {
+	Data  map[string]interface{} `json:"data,omitempty"`
+	Error string                 `json:"error,omitempty"`
+}
+
+type ocsfEndpoint struct {
+	IP string `json:"ip"`
+}
+
+type ocsfResource struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+func (f *OCSFFormatWriter) WriteRequest(w io.Writer, req *AuditRequestEntry) error {
+	if req == nil {
+		return fmt.Errorf("request entry was nil, cannot encode")
+	}
+
+	event, err := newOCSFEvent(req.Time, req.Type, req.Auth, req.Request, req.Error)
+	if err != nil {
+		return err
+	}
+
+	return f.write(w, event)
+}
+
+func (f *OCSFFormatWriter) WriteResponse(w io.Writer, resp *AuditResponseEntry) error {
+	if resp == nil {
+		return fmt.Errorf("response entry was nil, cannot encode")
+	}
+
+	event, err := newOCSFEvent(resp.Time, resp.Type, resp.Auth, resp.Request, resp.Error)
+	if err != nil {
+		return err
+	}
+	if resp.Response != nil {
+		if event.API.Response == nil {
+			event.API.Response = new(ocsfResponse)
+		}
+		event.API.Response.Data = resp.Response.Data
+		if resp.Response.Secret != nil {
+			event.Unmapped["lease_id"] = resp.Response.Secret.LeaseID
+		}
+		if resp.Response.Auth != nil {
+			event.Unmapped["response_auth"] = resp.Response.Auth
+		}
+		if resp.Response.WrapInfo != nil {
+			event.Unmapped["wrap_info"] = resp.Response.WrapInfo
+		}
+		if len(resp.Response.Warnings) > 0 {
+			event.Unmapped["warnings"] = resp.Response.Warnings
+		}
+	}
+
+	return f.write(w, event)
+}
+
+func (f *OCSFFormatWriter) write(w io.Writer, event *ocsfEvent) error {
+	if len(f.Prefix) > 0 {
+		_, err := w.Write([]byte(f.Prefix))
+		if err != nil {
+			return err
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	return enc.Encode(event)
+}
+
+func (f *OCSFFormatWriter) Salt(ctx context.Context) (*salt.Salt, error) {
+	return f.SaltFunc(ctx)
+}
+
+// newOCSFEvent maps the fields common to requests and responses to an event
+func newOCSFEvent(entryTime, entryType string, auth *AuditAuth, req *AuditRequest, errString string) (*ocsfEvent, error) {
+	if auth == nil {
+		auth = &AuditAuth{}
+	}
+	if req == nil {
+		req = &AuditRequest{}
+	}
+
+	event := &ocsfEvent{
+		CategoryUID: ocsfCategoryUID,
+		ClassUID:    ocsfClassUID,
+		ActivityID:  ocsfActivityID(req.Operation),
+		SeverityID:  ocsfSeverityInformational,
+		StatusID:    ocsfStatusSuccess,
+		Status:      "Success",
+		Metadata: ocsfMetadata{
+			Version: ocsfVersion,
+			Product: ocsfProduct{
+				Name:       "Vault",
+				VendorName: "HashiCorp",
+				Version:    version.GetVersion().VersionNumber(),
+			},
+			UID:     req.ID,
+			LogName: entryType,
+		},
+		API: ocsfAPI{
+			Operation: string(req.Operation),
+			Request: ocsfRequest{
+				UID:  req.ID,
+				Data: req.Data,
+			},
+		},
+		Unmapped: make(map[string]interface{}),
+	}
+	event.TypeUID = ocsfClassUID*100 + event.ActivityID
+
+	if entryTime != "" {
+		t, err := time.Parse(time.RFC3339Nano, entryTime)
+		if err != nil {
+			return nil, err
+		}
+		event.Time = t.UnixNano() / int64(time.Millisecond)
+	}
+
+	if errString != "" {
+		event.SeverityID = ocsfSeverityMedium
+		event.StatusID = ocsfStatusFailure
+		event.Status = "Failure"
+		event.StatusDetail = errString
+		event.API.Response = &ocsfResponse{
+			Error: errString,
+		}
+	}
+
+	if auth.DisplayName != "" || auth.EntityID != "" {
+		event.Actor.User = &ocsfUser{
+			Name: auth.DisplayName,
+			UID:  auth.EntityID,
+		}
+	}
+	if auth.Accessor != "" || auth.ClientToken != "" {
+		event.Actor.Session = &ocsfSession{
+			UID:           auth.Accessor,
+			CredentialUID: auth.ClientToken,
+		}
+	}
+
+	if req.RemoteAddr != "" {
+		event.SrcEndpoint = &ocsfEndpoint{
+			IP: req.RemoteAddr,
+		}
+	}
+	if req.Path != "" {
+		event.Resources = []ocsfResource{
+			{
+				Name: req.Path,
+				Type: "path",
+			},
+		}
+	}
+
+	if req.Namespace != nil {
+		event.Unmapped["namespace"] = req.Namespace
+	}
+	if len(auth.Policies) > 0 {
+		event.Unmapped["policies"] = auth.Policies
+	}
+	if auth.TokenType != "" {
+		event.Unmapped["token_type"] = auth.TokenType
+	}
+	if len(auth.Metadata) > 0 {
+		event.Unmapped["metadata"] = auth.Metadata
+	}
+	if auth.PolicyResults != nil {
+		event.Unmapped["policy_results"] = auth.PolicyResults
+	}
+	if req.Headers != nil {
+		event.Unmapped["headers"] = req.Headers
+	}
+
+	return event, nil
+}
+
+// ocsfActivityID maps an operation to the activity of an API Activity event
+func ocsfActivityID(op logical.Operation) int {
+	switch op {
+	case logical.CreateOperation:
+		return ocsfActivityCreate
+	case logical.ReadOperation, logical.ListOperation:
+		return ocsfActivityRead
+	case logical.UpdateOperation:
+		return ocsfActivityUpdate
+	case logical.DeleteOperation:
+		return ocsfActivityDelete
+	default:
+		return ocsfActivityOther
+	}
+}
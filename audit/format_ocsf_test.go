@@ -0,0 +1,152 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/helper/jsonutil"
+	"github.com/hashicorp/vault/sdk/helper/salt"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestFormatOCSF_formatRequest(t *testing.T) {
+	salter, err := salt.NewSalt(context.Background(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	formatter := AuditFormatter{
+		AuditFormatWriter: &OCSFFormatWriter{
+			Prefix: "@ocsf: ",
+			SaltFunc: func(context.Context) (*salt.Salt, error) {
+				return salter, nil
+			},
+		},
+	}
+	in := &logical.LogInput{
+		Auth: &logical.Auth{
+			ClientToken: "foo",
+			Accessor:    "bar",
+			DisplayName: "testtoken",
+			EntityID:    "foobarentity",
+			Policies:    []string{"root"},
+			TokenType:   logical.TokenTypeService,
+		},
+		Request: &logical.Request{
+			ID:        "req-id",
+			Operation: logical.DeleteOperation,
+			Path:      "secret/foo",
+			Connection: &logical.Connection{
+				RemoteAddr: "127.0.0.1",
+			},
+		},
+		OuterErr: errors.New("permission denied"),
+	}
+	config := FormatterConfig{
+		HMACAccessor: true,
+	}
+	if err := formatter.FormatRequest(namespace.RootContext(nil), &buf, config, in); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(buf.String(), "@ocsf: ") {
+		t.Fatalf("no prefix: %q", buf.String())
+	}
+
+	var event ocsfEvent
+	if err := jsonutil.DecodeJSON(buf.Bytes()[len("@ocsf: "):], &event); err != nil {
+		t.Fatalf("bad json: %s", err)
+	}
+
+	if event.ClassUID != 6003 || event.CategoryUID != 6 {
+		t.Fatalf("bad class: %d %d", event.CategoryUID, event.ClassUID)
+	}
+	if event.ActivityID != ocsfActivityDelete || event.TypeUID != 600304 {
+		t.Fatalf("bad activity: %d %d", event.ActivityID, event.TypeUID)
+	}
+	if event.Time == 0 {
+		t.Fatal("expected a time")
+	}
+	if event.StatusID != ocsfStatusFailure || event.StatusDetail != "permission denied" {
+		t.Fatalf("bad status: %d %q", event.StatusID, event.StatusDetail)
+	}
+	if event.API.Response == nil || event.API.Response.Error != "permission denied" {
+		t.Fatalf("bad response: %#v", event.API.Response)
+	}
+	if event.API.Operation != "delete" || event.API.Request.UID != "req-id" {
+		t.Fatalf("bad api: %#v", event.API)
+	}
+	if event.Metadata.LogName != "request" || event.Metadata.Product.Name != "Vault" {
+		t.Fatalf("bad metadata: %#v", event.Metadata)
+	}
+	if event.Actor.User == nil || event.Actor.User.Name != "testtoken" || event.Actor.User.UID != "foobarentity" {
+		t.Fatalf("bad user: %#v", event.Actor.User)
+	}
+	if event.Actor.Session == nil ||
+		event.Actor.Session.CredentialUID != salter.GetIdentifiedHMAC("foo") ||
+		event.Actor.Session.UID != salter.GetIdentifiedHMAC("bar") {
+		t.Fatalf("bad session: %#v", event.Actor.Session)
+	}
+	if event.SrcEndpoint == nil || event.SrcEndpoint.IP != "127.0.0.1" {
+		t.Fatalf("bad src endpoint: %#v", event.SrcEndpoint)
+	}
+	if len(event.Resources) != 1 || event.Resources[0].Name != "secret/foo" {
+		t.Fatalf("bad resources: %#v", event.Resources)
+	}
+	if event.Unmapped["token_type"] != "service" {
+		t.Fatalf("bad unmapped: %#v", event.Unmapped)
+	}
+}
+
+func TestFormatOCSF_formatResponse(t *testing.T) {
+	salter, err := salt.NewSalt(context.Background(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	formatter := AuditFormatter{
+		AuditFormatWriter: &OCSFFormatWriter{
+			SaltFunc: func(context.Context) (*salt.Salt, error) {
+				return salter, nil
+			},
+		},
+	}
+	in := &logical.LogInput{
+		Auth: &logical.Auth{
+			ClientToken: "foo",
+		},
+		Request: &logical.Request{
+			Operation: logical.ListOperation,
+			Path:      "secret/",
+		},
+		Response: &logical.Response{
+			Data: map[string]interface{}{
+				"password": "hunter2",
+			},
+		},
+	}
+	if err := formatter.FormatResponse(namespace.RootContext(nil), &buf, FormatterConfig{}, in); err != nil {
+		t.Fatal(err)
+	}
+
+	var event ocsfEvent
+	if err := jsonutil.DecodeJSON(buf.Bytes(), &event); err != nil {
+		t.Fatalf("bad json: %s", err)
+	}
+
+	if event.ActivityID != ocsfActivityRead || event.StatusID != ocsfStatusSuccess {
+		t.Fatalf("bad event: %d %d", event.ActivityID, event.StatusID)
+	}
+	if event.Metadata.LogName != "response" {
+		t.Fatalf("bad log name: %q", event.Metadata.LogName)
+	}
+	if event.API.Response == nil || event.API.Response.Data["password"] != salter.GetIdentifiedHMAC("hunter2") {
+		t.Fatalf("bad response: %#v", event.API.Response)
+	}
+}
@@ -1,11 +1,15 @@
 package audit
 
 import (
+	"bytes"
 	"context"
 	"io"
 	"io/ioutil"
+	"reflect"
 	"testing"
 
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/helper/jsonutil"
 	"github.com/hashicorp/vault/sdk/helper/salt"
 	"github.com/hashicorp/vault/sdk/logical"
 )
@@ -70,3 +74,75 @@ func TestFormatResponseErrors(t *testing.T) {
 		t.Fatal("expected error due to nil writer")
 	}
 }
+
+func TestFormatRequest_PolicyResults(t *testing.T) {
+	salter, err := salt.NewSalt(context.Background(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	formatter := AuditFormatter{
+		AuditFormatWriter: &JSONFormatWriter{
+			SaltFunc: func(context.Context) (*salt.Salt, error) {
+				return salter, nil
+			},
+		},
+	}
+
+	in := &logical.LogInput{
+		Auth: &logical.Auth{
+			ClientToken: "foo",
+			PolicyResults: &logical.PolicyResults{
+				Allowed: true,
+				GrantingPolicies: []logical.PolicyInfo{
+					{
+						Name:        "dev",
+						NamespaceID: "root",
+						Type:        "acl",
+					},
+				},
+				Path:      "secret/*",
+				MatchType: "glob",
+			},
+		},
+		Request: &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "secret/foo",
+		},
+	}
+
+	for _, include := range []bool{false, true} {
+		var buf bytes.Buffer
+		config := FormatterConfig{PolicyResults: include}
+		if err := formatter.FormatRequest(namespace.RootContext(nil), &buf, config, in); err != nil {
+			t.Fatal(err)
+		}
+
+		var entry AuditRequestEntry
+		if err := jsonutil.DecodeJSON(buf.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+
+		if !include {
+			if entry.Auth.PolicyResults != nil {
+				t.Fatalf("unexpected policy results: %#v", entry.Auth.PolicyResults)
+			}
+			continue
+		}
+
+		expected := &AuditPolicyResults{
+			Allowed: true,
+			GrantingPolicies: []AuditPolicyInfo{
+				{
+					Name:        "dev",
+					NamespaceID: "root",
+					Type:        "acl",
+				},
+			},
+			Path:      "secret/*",
+			MatchType: "glob",
+		}
+		if !reflect.DeepEqual(entry.Auth.PolicyResults, expected) {
+			t.Fatalf("bad policy results: %#v", entry.Auth.PolicyResults)
+		}
+	}
+}
@@ -21,6 +21,10 @@ type FormatterConfig struct {
 	Raw          bool
 	HMACAccessor bool
 
+	// PolicyResults includes the policies and path rule which decided
+	// whether the request is allowed in the entries
+	PolicyResults bool
+
 	// This should only ever be used in a testing context
 	OmitTime bool
 }
@@ -0,0 +1,261 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/helper/salt"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// HashChainOption is the audit device option enabling hash chaining
+const HashChainOption = "hash_chain"
+
+// hashChainKeyID is the ID salted to derive the key of hash chains. Salted
+// IDs are plain hashes, so the key can't be obtained through the HMACs
+// computed by the audit-hash endpoint.
+const hashChainKeyID = "audit-hash-chain"
+
+// hashChainStateKey is the key the state of a hash chain is persisted under,
+// in the salt view of its audit device
+const hashChainStateKey = "hash_chain"
+
+// hashChainField is the field appended to chained entries
+var hashChainField = []byte(`,"chain":`)
+
+// HashChainKey derives the key of the hash chain of an audit device from its
+// salt
+func HashChainKey(salt *salt.Salt) []byte {
+	return []byte(salt.SaltID(hashChainKeyID))
+}
+
+// HashChainer is implemented by audit backends supporting hash chaining
+type HashChainer interface {
+	// HashChainKey returns the key of the hash chain of the backend
+	HashChainKey(context.Context) ([]byte, error)
+}
+
+// HashChainLink is the "chain" field of a chained entry
+type HashChainLink struct {
+	// Seq is the sequence number of the entry in its chain, starting at 1
+	Seq uint64 `json:"seq"`
+
+	// Prev is the HMAC of the previous entry, or empty for the first entry
+	Prev string `json:"prev"`
+
+	// HMAC is computed over the sequence number, the HMAC of the previous
+	// entry and the entry itself
+	HMAC string `json:"hmac"`
+}
+
+// HashChain links the JSON entries written by an audit device, making
+// deleted, inserted or modified entries detectable. Entries must be linked in
+// the order they are written.
+type HashChain struct {
+	sync.Mutex
+	seq  uint64
+	prev string
+
+	// view, if set, is where the state of the chain is persisted, so that
+	// it continues across restarts of the audit device
+	view   logical.Storage
+	loaded bool
+}
+
+// hashChainState is the persisted state of a hash chain
+type hashChainState struct {
+	Seq  uint64 `json:"seq"`
+	Prev string `json:"prev"`
+}
+
+// NewHashChain returns a hash chain whose state is persisted in the given
+// view, which is the salt view of the audit device. A new chain is only
+// started if no state was persisted yet.
+func NewHashChain(view logical.Storage) *HashChain {
+	return &HashChain{
+		view: view,
+	}
+}
+
+// Link returns the entry with its "chain" field appended
+func (c *HashChain) Link(ctx context.Context, key []byte, entry []byte) ([]byte, error) {
+	body := bytes.TrimRight(entry, "\n")
+	if len(body) == 0 || body[len(body)-1] != '}' {
+		return nil, fmt.Errorf("hash chaining requires JSON entries")
+	}
+	body = body[:len(body)-1]
+
+	c.Lock()
+	defer c.Unlock()
+
+	if c.view != nil && !c.loaded {
+		if err := c.load(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	link := &HashChainLink{
+		Seq:  c.seq + 1,
+		Prev: c.prev,
+	}
+	link.HMAC = hashChainHMAC(key, link, body)
+
+	linkJSON, err := json.Marshal(link)
+	if err != nil {
+		return nil, err
+	}
+
+	// The state is persisted before the entry is written, so that the
+	// chain never continues from an entry older than the last one written
+	if c.view != nil {
+		stateEntry, err := logical.StorageEntryJSON(hashChainStateKey, &hashChainState{
+			Seq:  link.Seq,
+			Prev: link.HMAC,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := c.view.Put(ctx, stateEntry); err != nil {
+			return nil, errwrap.Wrapf("failed to persist the hash chain: {{err}}", err)
+		}
+	}
+
+	c.seq = link.Seq
+	c.prev = link.HMAC
+
+	out := make([]byte, 0, len(body)+len(hashChainField)+len(linkJSON)+2)
+	out = append(out, body...)
+	out = append(out, hashChainField...)
+	out = append(out, linkJSON...)
+	return append(out, '}', '\n'), nil
+}
+
+func (c *HashChain) load(ctx context.Context) error {
+	entry, err := c.view.Get(ctx, hashChainStateKey)
+	if err != nil {
+		return errwrap.Wrapf("failed to read the hash chain: {{err}}", err)
+	}
+	if entry != nil {
+		var state hashChainState
+		if err := entry.DecodeJSON(&state); err != nil {
+			return errwrap.Wrapf("failed to decode the hash chain: {{err}}", err)
+		}
+		c.seq, c.prev = state.Seq, state.Prev
+	}
+	c.loaded = true
+	return nil
+}
+
+func hashChainHMAC(key []byte, link *HashChainLink, body []byte) string {
+	hm := hmac.New(sha256.New, key)
+	hm.Write([]byte(strconv.FormatUint(link.Seq, 10) + "\n" + link.Prev + "\n"))
+	hm.Write(body)
+	return "hmac-sha256:" + hex.EncodeToString(hm.Sum(nil))
+}
+
+// HashChainProblem is an inconsistency found verifying a hash chain
+type HashChainProblem struct {
+	Line    int
+	Message string
+}
+
+// HashChainReport is the result of verifying the hash chain of a log
+type HashChainReport struct {
+	// Entries is the number of verified entries
+	Entries int
+
+	// Restarts are the lines at which a new chain starts, which only
+	// happens when hash chaining is enabled on the audit device. A restart
+	// anywhere but at the first entry is also reported as a problem, since
+	// it may hide entries deleted from the end of the previous chain.
+	Restarts []int
+
+	Problems []*HashChainProblem
+}
+
+// Valid reports whether no problems were found
+func (r *HashChainReport) Valid() bool {
+	return len(r.Problems) == 0
+}
+
+// VerifyHashChain verifies the chain of the entries read from r, one per line.
+// The first entry may be in the middle of a chain, e.g. if the log was
+// rotated. Entries missing from the end of the log can't be detected until
+// another entry is written after them.
+func VerifyHashChain(r io.Reader, key []byte) (*HashChainReport, error) {
+	report := &HashChainReport{}
+	problem := func(line int, format string, args ...interface{}) {
+		report.Problems = append(report.Problems, &HashChainProblem{
+			Line:    line,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+
+	var prev *HashChainLink
+	for line := 1; scanner.Scan(); line++ {
+		entry := bytes.TrimSpace(scanner.Bytes())
+		if len(entry) == 0 {
+			continue
+		}
+		report.Entries++
+
+		// Chained entries end with their "chain" field. JSON strings can't
+		// contain it unescaped, and it is the last one in the entry.
+		i := bytes.LastIndex(entry, hashChainField)
+		if i < 0 || entry[len(entry)-1] != '}' {
+			problem(line, "entry is not chained")
+			prev = nil
+			continue
+		}
+		body := entry[:i]
+		var link HashChainLink
+		if err := json.Unmarshal(entry[i+len(hashChainField):len(entry)-1], &link); err != nil {
+			problem(line, "entry has an invalid chain field: %s", err)
+			prev = nil
+			continue
+		}
+
+		expected := hashChainHMAC(key, &link, body)
+		if !hmac.Equal([]byte(expected), []byte(link.HMAC)) {
+			problem(line, "entry %d was modified", link.Seq)
+		}
+
+		switch {
+		case link.Seq == 1 && link.Prev == "":
+			report.Restarts = append(report.Restarts, line)
+			if report.Entries > 1 {
+				problem(line, "a new chain starts in the middle of the log")
+			}
+		case prev == nil:
+			// The first entry of the log, or following an invalid one
+		case link.Seq <= prev.Seq:
+			problem(line, "entry %d follows entry %d", link.Seq, prev.Seq)
+		case link.Seq == prev.Seq+2:
+			problem(line, "entry %d is missing", prev.Seq+1)
+		case link.Seq != prev.Seq+1:
+			problem(line, "entries %d to %d are missing", prev.Seq+1, link.Seq-1)
+		case link.Prev != prev.HMAC:
+			problem(line, "entry %d does not follow the previous entry", link.Seq)
+		}
+
+		prev = &link
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
@@ -0,0 +1,160 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func testHashChainLog(t *testing.T, key []byte, chain *HashChain, n int) []string {
+	t.Helper()
+
+	var lines []string
+	for i := 1; i <= n; i++ {
+		entry, err := chain.Link(context.Background(), key, []byte(fmt.Sprintf(`{"type":"request","request":{"path":"secret/%d","data":{"chain":{"seq":1}}}}`+"\n", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, string(entry))
+	}
+	return lines
+}
+
+func verifyHashChainLines(t *testing.T, key []byte, lines []string) *HashChainReport {
+	t.Helper()
+
+	report, err := VerifyHashChain(strings.NewReader(strings.Join(lines, "")), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestHashChain(t *testing.T) {
+	key := []byte("key")
+	view := &logical.InmemStorage{}
+	lines := testHashChainLog(t, key, NewHashChain(view), 5)
+
+	// The chain continues when the audit device is set up again, e.g. after
+	// Vault is restarted
+	lines = append(lines, testHashChainLog(t, key, NewHashChain(view), 2)...)
+
+	report := verifyHashChainLines(t, key, lines)
+	if !report.Valid() || report.Entries != 7 {
+		t.Fatalf("expected 7 valid entries, got %d with problems %#v", report.Entries, report.Problems)
+	}
+	if len(report.Restarts) != 1 || report.Restarts[0] != 1 {
+		t.Fatalf("expected a chain starting at line 1, got %v", report.Restarts)
+	}
+
+	// Entries deleted from the end of the log are detected once the chain
+	// continues
+	report = verifyHashChainLines(t, key, append(lines[:3:3], lines[5:]...))
+	if len(report.Problems) != 1 || report.Problems[0].Line != 4 || report.Problems[0].Message != "entries 4 to 5 are missing" {
+		t.Fatalf("expected the deleted entries to be reported, got %#v", report.Problems)
+	}
+
+	// A new chain only starts at the beginning of the log, since one
+	// starting in the middle may hide entries deleted from the end of the
+	// previous chain
+	restarted := append(lines[:3:3], testHashChainLog(t, key, &HashChain{}, 2)...)
+	report = verifyHashChainLines(t, key, restarted)
+	if len(report.Problems) != 1 || report.Problems[0].Line != 4 || report.Problems[0].Message != "a new chain starts in the middle of the log" {
+		t.Fatalf("expected the restart to be reported, got %#v", report.Problems)
+	}
+
+	// The log may start in the middle of a chain
+	if report := verifyHashChainLines(t, key, lines[2:5]); !report.Valid() {
+		t.Fatalf("expected a valid chain, got %#v", report.Problems)
+	}
+
+	testCases := map[string]struct {
+		lines    func([]string) []string
+		line     int
+		expected string
+	}{
+		"deleted": {
+			lines: func(lines []string) []string {
+				return append(lines[:2:2], lines[4:]...)
+			},
+			line:     3,
+			expected: "entries 3 to 4 are missing",
+		},
+		"modified": {
+			lines: func(lines []string) []string {
+				lines[1] = strings.Replace(lines[1], "secret/2", "secret/9", 1)
+				return lines
+			},
+			line:     2,
+			expected: "entry 2 was modified",
+		},
+		"reordered": {
+			lines: func(lines []string) []string {
+				lines[1], lines[2] = lines[2], lines[1]
+				return lines
+			},
+			line:     2,
+			expected: "entry 2 is missing",
+		},
+		"inserted": {
+			lines: func(lines []string) []string {
+				return append(lines[:2:2], append([]string{`{"type":"request"}` + "\n"}, lines[2:]...)...)
+			},
+			line:     3,
+			expected: "entry is not chained",
+		},
+		"replaced": {
+			lines: func(lines []string) []string {
+				// An entry of another chain, with the same sequence number
+				other := &HashChain{}
+				for i := 0; i < 2; i++ {
+					if _, err := other.Link(context.Background(), key, []byte(`{"type":"request"}`)); err != nil {
+						t.Fatal(err)
+					}
+				}
+				lines[2] = testHashChainLog(t, key, other, 1)[0]
+				return lines
+			},
+			line:     3,
+			expected: "entry 3 does not follow the previous entry",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			lines := tc.lines(append([]string(nil), lines[:5]...))
+			report := verifyHashChainLines(t, key, lines)
+			if len(report.Problems) == 0 {
+				t.Fatal("expected a problem")
+			}
+			if p := report.Problems[0]; p.Line != tc.line || p.Message != tc.expected {
+				t.Fatalf("expected %q at line %d, got %q at line %d", tc.expected, tc.line, p.Message, p.Line)
+			}
+		})
+	}
+
+	// Entries can't be verified with another key
+	report = verifyHashChainLines(t, []byte("other"), lines)
+	if len(report.Problems) != 7 {
+		t.Fatalf("expected every entry to be reported, got %#v", report.Problems)
+	}
+}
+
+func TestHashChain_Link(t *testing.T) {
+	chain := &HashChain{}
+	entry, err := chain.Link(context.Background(), []byte("key"), []byte(`{"type":"request"}`+"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(entry, []byte(`{"type":"request","chain":{"seq":1,"prev":"","hmac":"hmac-sha256:`)) || !bytes.HasSuffix(entry, []byte("\"}}\n")) {
+		t.Fatalf("unexpected chained entry %q", entry)
+	}
+
+	if _, err := chain.Link(context.Background(), []byte("key"), []byte("<xml/>")); err == nil {
+		t.Fatal("expected an error chaining a non-JSON entry")
+	}
+}
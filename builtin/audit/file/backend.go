@@ -11,9 +11,12 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/hashicorp/errwrap"
+	log "github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/vault/audit"
+	"github.com/hashicorp/vault/sdk/helper/parseutil"
 	"github.com/hashicorp/vault/sdk/helper/salt"
 	"github.com/hashicorp/vault/sdk/logical"
 )
@@ -47,7 +50,7 @@ func Factory(ctx context.Context, conf *audit.BackendConfig) (audit.Backend, err
 		format = "json"
 	}
 	switch format {
-	case "json", "jsonx":
+	case "json", "jsonx", "cef", "ocsf":
 	default:
 		return nil, fmt.Errorf("unknown format type %q", format)
 	}
@@ -72,6 +75,31 @@ func Factory(ctx context.Context, conf *audit.BackendConfig) (audit.Backend, err
 		logRaw = b
 	}
 
+	// Check if the policy results are logged
+	logPolicyResults := false
+	if raw, ok := conf.Config["log_policy_results"]; ok {
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, err
+		}
+		logPolicyResults = b
+	}
+
+	// Check if hash chaining is enabled
+	var hashChain *audit.HashChain
+	if raw, ok := conf.Config[audit.HashChainOption]; ok {
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, err
+		}
+		if b {
+			if format != "json" && format != "ocsf" {
+				return nil, fmt.Errorf("%s requires the json or ocsf format", audit.HashChainOption)
+			}
+			hashChain = audit.NewHashChain(conf.SaltView)
+		}
+	}
+
 	// Check if mode is provided
 	mode := os.FileMode(0600)
 	if modeRaw, ok := conf.Config["mode"]; ok {
@@ -84,15 +112,32 @@ func Factory(ctx context.Context, conf *audit.BackendConfig) (audit.Backend, err
 		}
 	}
 
+	rotation, err := parseRotationConfig(conf.Config)
+	if err != nil {
+		return nil, err
+	}
+	if rotation.enabled() && (path == "stdout" || path == "discard") {
+		return nil, fmt.Errorf("rotation is not supported when logging to %s", path)
+	}
+
+	logger := conf.Logger
+	if logger == nil {
+		logger = log.NewNullLogger()
+	}
+
 	b := &Backend{
 		path:       path,
 		mode:       mode,
+		rotation:   rotation,
+		logger:     logger,
 		saltConfig: conf.SaltConfig,
 		saltView:   conf.SaltView,
 		salt:       new(atomic.Value),
+		hashChain:  hashChain,
 		formatConfig: audit.FormatterConfig{
-			Raw:          logRaw,
-			HMACAccessor: hmacAccessor,
+			Raw:           logRaw,
+			HMACAccessor:  hmacAccessor,
+			PolicyResults: logPolicyResults,
 		},
 	}
 
@@ -111,6 +156,16 @@ func Factory(ctx context.Context, conf *audit.BackendConfig) (audit.Backend, err
 			Prefix:   conf.Config["prefix"],
 			SaltFunc: b.Salt,
 		}
+	case "cef":
+		b.formatter.AuditFormatWriter = &audit.CEFFormatWriter{
+			Prefix:   conf.Config["prefix"],
+			SaltFunc: b.Salt,
+		}
+	case "ocsf":
+		b.formatter.AuditFormatWriter = &audit.OCSFFormatWriter{
+			Prefix:   conf.Config["prefix"],
+			SaltFunc: b.Salt,
+		}
 	}
 
 	switch path {
@@ -125,24 +180,100 @@ func Factory(ctx context.Context, conf *audit.BackendConfig) (audit.Backend, err
 		}
 	}
 
+	// Apply retention to the segments left by a previous run, and then
+	// periodically if a maximum age is set
+	if rotation.enabled() {
+		b.startMill()
+	}
+	if rotation.maxAge > 0 {
+		// The time the first entry of an existing file was written is
+		// unknown, so its age is counted from now
+		b.lastRotation = time.Now().UTC().Truncate(time.Millisecond)
+		b.stopCh = make(chan struct{})
+		b.millWg.Add(1)
+		go b.runRetention()
+	}
+
 	return b, nil
 }
 
-// Backend is the audit backend for the file-based audit store.
-//
-// NOTE: This audit backend is currently very simple: it appends to a file.
-// It doesn't do anything more at the moment to assist with rotation
-// or reset the write cursor, this should be done in the future.
+// parseRotationConfig parses the rotation and retention options
+func parseRotationConfig(config map[string]string) (rotationConfig, error) {
+	var rotation rotationConfig
+
+	if raw, ok := config["max_size"]; ok {
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || v < 0 {
+			return rotation, fmt.Errorf("max_size must be a number of bytes")
+		}
+		rotation.maxSize = v
+	}
+
+	if raw, ok := config["max_age"]; ok {
+		v, err := parseutil.ParseDurationSecond(raw)
+		if err != nil || v < 0 {
+			return rotation, fmt.Errorf("max_age must be a positive duration")
+		}
+		rotation.maxAge = v
+	}
+
+	if raw, ok := config["max_files"]; ok {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v < 0 {
+			return rotation, fmt.Errorf("max_files must be a positive number")
+		}
+		rotation.maxFiles = v
+	}
+
+	if raw, ok := config["compress"]; ok {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return rotation, err
+		}
+		rotation.compress = v
+	}
+
+	if !rotation.enabled() && (rotation.maxAge > 0 || rotation.maxFiles > 0 || rotation.compress) {
+		return rotation, fmt.Errorf("max_age, max_files and compress require max_size")
+	}
+
+	return rotation, nil
+}
+
+// Backend is the audit backend for the file-based audit store. It appends to
+// a file, which it rotates once it reaches its maximum size if one is set.
+// The file is otherwise reopened on SIGHUP, so that it can be rotated by
+// external tools.
 type Backend struct {
-	path string
+	path   string
+	logger log.Logger
 
 	formatter    audit.AuditFormatter
 	formatConfig audit.FormatterConfig
 
+	// hashChain, if set, links the entries in the order they are written
+	hashChain *audit.HashChain
+
 	fileLock sync.RWMutex
 	f        *os.File
 	mode     os.FileMode
 
+	// size is the size of the file, which is tracked when rotation is
+	// enabled
+	size         int64
+	rotation     rotationConfig
+	lastRotation time.Time
+
+	// closed is set under the file lock by Close, after which no retention
+	// is started
+	closed bool
+	stopCh chan struct{}
+
+	// millLock serializes the retention of the rotated segments, which runs
+	// in the background
+	millLock sync.Mutex
+	millWg   sync.WaitGroup
+
 	saltMutex  sync.RWMutex
 	salt       *atomic.Value
 	saltConfig *salt.Config
@@ -150,6 +281,8 @@ type Backend struct {
 }
 
 var _ audit.Backend = (*Backend)(nil)
+var _ audit.HashChainer = (*Backend)(nil)
+var _ audit.Closer = (*Backend)(nil)
 
 func (b *Backend) Salt(ctx context.Context) (*salt.Salt, error) {
 	s := b.salt.Load().(*salt.Salt)
@@ -175,6 +308,14 @@ func (b *Backend) Salt(ctx context.Context) (*salt.Salt, error) {
 	return newSalt, nil
 }
 
+func (b *Backend) HashChainKey(ctx context.Context) ([]byte, error) {
+	salt, err := b.Salt(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return audit.HashChainKey(salt), nil
+}
+
 func (b *Backend) GetHash(ctx context.Context, data string) (string, error) {
 	salt, err := b.Salt(ctx)
 	if err != nil {
@@ -203,10 +344,31 @@ func (b *Backend) LogRequest(ctx context.Context, in *logical.LogInput) error {
 }
 
 func (b *Backend) log(ctx context.Context, buf *bytes.Buffer, writer io.Writer) error {
-	reader := bytes.NewReader(buf.Bytes())
+	var hashChainKey []byte
+	if b.hashChain != nil {
+		var err error
+		if hashChainKey, err = b.HashChainKey(ctx); err != nil {
+			return errwrap.Wrapf("error fetching salt: {{err}}", err)
+		}
+	}
 
 	b.fileLock.Lock()
 
+	entry := buf.Bytes()
+	if b.hashChain != nil {
+		var err error
+		if entry, err = b.hashChain.Link(ctx, hashChainKey, entry); err != nil {
+			b.fileLock.Unlock()
+			return err
+		}
+	}
+	if writer == nil {
+		if err := b.rotateIfNeeded(len(entry)); err != nil {
+			b.logger.Error("failed to rotate audit log", "path", b.path, "error", err)
+		}
+	}
+	reader := bytes.NewReader(entry)
+
 	if writer == nil {
 		if err := b.open(); err != nil {
 			b.fileLock.Unlock()
@@ -215,7 +377,8 @@ func (b *Backend) log(ctx context.Context, buf *bytes.Buffer, writer io.Writer)
 		writer = b.f
 	}
 
-	if _, err := reader.WriteTo(writer); err == nil {
+	if n, err := reader.WriteTo(writer); err == nil {
+		b.size += n
 		b.fileLock.Unlock()
 		return nil
 	} else if b.path == "stdout" {
@@ -235,7 +398,8 @@ func (b *Backend) log(ctx context.Context, buf *bytes.Buffer, writer io.Writer)
 	}
 
 	reader.Seek(0, io.SeekStart)
-	_, err := reader.WriteTo(writer)
+	n, err := reader.WriteTo(writer)
+	b.size += n
 	b.fileLock.Unlock()
 	return err
 }
@@ -286,6 +450,12 @@ func (b *Backend) open() error {
 		}
 	}
 
+	info, err := b.f.Stat()
+	if err != nil {
+		return err
+	}
+	b.size = info.Size()
+
 	return nil
 }
 
@@ -313,6 +483,30 @@ func (b *Backend) Reload(_ context.Context) error {
 	return b.open()
 }
 
+// Close closes the file, once the retention of the rotated segments is done
+func (b *Backend) Close() error {
+	b.fileLock.Lock()
+	if !b.closed {
+		b.closed = true
+		if b.stopCh != nil {
+			close(b.stopCh)
+		}
+	}
+	b.fileLock.Unlock()
+
+	b.millWg.Wait()
+
+	b.fileLock.Lock()
+	defer b.fileLock.Unlock()
+
+	if b.f == nil {
+		return nil
+	}
+	err := b.f.Close()
+	b.f = nil
+	return err
+}
+
 func (b *Backend) Invalidate(_ context.Context) {
 	b.saltMutex.Lock()
 	defer b.saltMutex.Unlock()
@@ -1,11 +1,17 @@
 package file
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -141,3 +147,295 @@ func BenchmarkAuditFile_request(b *testing.B) {
 		}
 	})
 }
+
+func TestAuditFile_rotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault-test_audit_file-rotation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+
+	// A segment past the maximum age, left by a previous run
+	old := strings.TrimSuffix(path, ".log") + "-" + time.Now().Add(-48*time.Hour).UTC().Format(segmentTimeFormat) + ".log"
+	if err := ioutil.WriteFile(old, []byte("old\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	be, err := Factory(context.Background(), &audit.BackendConfig{
+		SaltConfig: &salt.Config{},
+		SaltView:   &logical.InmemStorage{},
+		Config: map[string]string{
+			"path":      path,
+			"max_size":  "2000",
+			"max_age":   "24h",
+			"max_files": "100",
+			"compress":  "true",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := be.(*Backend)
+
+	// Entries written concurrently with rotations are all kept
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				in := &logical.LogInput{
+					Request: &logical.Request{
+						Operation: logical.ReadOperation,
+						Path:      fmt.Sprintf("secret/%d/%d", i, j),
+					},
+				}
+				if err := b.LogRequest(namespace.RootContext(nil), in); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Fatalf("expected the segment past the maximum age to be deleted, got %v", err)
+	}
+
+	segments, err := b.segments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) < 2 {
+		t.Fatalf("expected the file to be rotated, got %d segments", len(segments))
+	}
+
+	var content bytes.Buffer
+	for i := len(segments) - 1; i >= 0; i-- {
+		if !strings.HasSuffix(segments[i].name, compressSuffix) {
+			t.Fatalf("expected segment %q to be compressed", segments[i].name)
+		}
+		f, err := os.Open(segments[i].name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(&content, gz); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	current, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(current) > 2000 {
+		t.Fatalf("expected the file to be at most 2000 bytes, got %d", len(current))
+	}
+	content.Write(current)
+
+	if n := strings.Count(content.String(), "\n"); n != 100 {
+		t.Fatalf("expected 100 entries, got %d", n)
+	}
+	for i := 0; i < 5; i++ {
+		for j := 0; j < 20; j++ {
+			if !strings.Contains(content.String(), fmt.Sprintf(`"path":"secret/%d/%d"`, i, j)) {
+				t.Fatalf("entry secret/%d/%d is missing", i, j)
+			}
+		}
+	}
+}
+
+func TestAuditFile_rotationMaxFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault-test_audit_file-rotation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	be, err := Factory(context.Background(), &audit.BackendConfig{
+		SaltConfig: &salt.Config{},
+		SaltView:   &logical.InmemStorage{},
+		Config: map[string]string{
+			"path":      path,
+			"max_size":  "1",
+			"max_files": "2",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := be.(*Backend)
+
+	// Each entry rotates the previous one
+	for i := 0; i < 5; i++ {
+		in := &logical.LogInput{
+			Request: &logical.Request{Path: fmt.Sprintf("secret/%d", i)},
+		}
+		if err := b.LogRequest(namespace.RootContext(nil), in); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	segments, err := b.segments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 2 {
+		t.Fatalf("expected 2 segments, got %d", len(segments))
+	}
+	for i, expected := range []string{"secret/3", "secret/2"} {
+		content, err := ioutil.ReadFile(segments[i].name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(content), expected) {
+			t.Fatalf("expected segment %d to hold %q, got %q", i, expected, content)
+		}
+	}
+}
+
+func TestAuditFile_rotationMaxAge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault-test_audit_file-rotation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	be, err := Factory(context.Background(), &audit.BackendConfig{
+		SaltConfig: &salt.Config{},
+		SaltView:   &logical.InmemStorage{},
+		Config: map[string]string{
+			"path":     path,
+			"max_size": "1000000",
+			"max_age":  "1s",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := be.(*Backend)
+	defer b.Close()
+
+	in := &logical.LogInput{
+		Request: &logical.Request{Path: "secret/foo"},
+	}
+	if err := b.LogRequest(namespace.RootContext(nil), in); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file is rotated once it is older than the maximum age, although it
+	// doesn't grow, and the segment is deleted once it is past it as well
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		segments, err := b.segments()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() == 0 && len(segments) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the entry to be deleted, got a file of %d bytes and %d segments", info.Size(), len(segments))
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestAuditFile_rotationInvalid(t *testing.T) {
+	for _, config := range []map[string]string{
+		{"path": "stdout", "max_size": "1000"},
+		{"path": "/tmp/audit.log", "max_files": "3"},
+		{"path": "/tmp/audit.log", "max_size": "-1"},
+		{"path": "/tmp/audit.log", "max_size": "1000", "max_age": "foo"},
+	} {
+		_, err := Factory(context.Background(), &audit.BackendConfig{
+			SaltConfig: &salt.Config{},
+			SaltView:   &logical.InmemStorage{},
+			Config:     config,
+		})
+		if err == nil {
+			t.Fatalf("expected an error for %v", config)
+		}
+	}
+}
+
+func TestAuditFile_hashChainPersisted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault-test_audit_file-hash_chain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	view := &logical.InmemStorage{}
+
+	// The device is set up twice with the same salt view, as when Vault is
+	// sealed and unsealed again
+	var key []byte
+	for i := 0; i < 2; i++ {
+		be, err := Factory(context.Background(), &audit.BackendConfig{
+			SaltConfig: &salt.Config{},
+			SaltView:   view,
+			Config: map[string]string{
+				"path":       path,
+				"hash_chain": "true",
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := be.(*Backend)
+
+		for j := 0; j < 2; j++ {
+			in := &logical.LogInput{
+				Request: &logical.Request{
+					Operation: logical.ReadOperation,
+					Path:      fmt.Sprintf("secret/%d/%d", i, j),
+				},
+			}
+			if err := b.LogRequest(namespace.RootContext(nil), in); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if key, err = b.HashChainKey(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// The chain continues rather than restarting
+	report, err := audit.VerifyHashChain(f, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Valid() || report.Entries != 4 || len(report.Restarts) != 1 {
+		t.Fatalf("expected a single valid chain of 4 entries, got %d entries, restarts %v and problems %#v", report.Entries, report.Restarts, report.Problems)
+	}
+}
@@ -0,0 +1,281 @@
+package file
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// segmentTimeFormat is the format of the time of rotation in the names of
+// rotated segments, which sort in the order they were rotated
+const segmentTimeFormat = "2006-01-02T15-04-05.000"
+
+const compressSuffix = ".gz"
+
+// maxRetentionInterval is the longest interval between two checks of the age
+// of the file and of the rotated segments
+const maxRetentionInterval = time.Hour
+
+// rotationConfig holds the rotation and retention options of the backend
+type rotationConfig struct {
+	// maxSize is the size above which the file is rotated, or zero
+	maxSize int64
+
+	// maxAge is the age past which rotated segments are deleted, or zero
+	maxAge time.Duration
+
+	// maxFiles is the number of rotated segments kept, or zero
+	maxFiles int
+
+	// compress enables the compression of rotated segments
+	compress bool
+}
+
+func (c *rotationConfig) enabled() bool {
+	return c.maxSize > 0
+}
+
+// segment is a rotated segment of the log
+type segment struct {
+	name      string
+	rotatedAt time.Time
+}
+
+// rotateIfNeeded rotates the file if writing n more bytes would make it
+// exceed its maximum size. The file lock must be held before calling this.
+func (b *Backend) rotateIfNeeded(n int) error {
+	if !b.rotation.enabled() || b.f == nil || b.size == 0 || b.size+int64(n) <= b.rotation.maxSize {
+		return nil
+	}
+	return b.rotate()
+}
+
+// rotate moves the content of the file to a new segment, without the path of
+// the file ever being missing: the segment is created as a link to the file,
+// which is then replaced by an empty one. The file lock must be held before
+// calling this.
+func (b *Backend) rotate() error {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	if !now.After(b.lastRotation) {
+		// Segment names must be unique
+		now = b.lastRotation.Add(time.Millisecond)
+	}
+	segmentPath := b.segmentPath(now)
+
+	// A zero mode leaves the mode of the file as it is
+	mode := b.mode
+	if mode == 0 {
+		info, err := b.f.Stat()
+		if err != nil {
+			return err
+		}
+		mode = info.Mode().Perm()
+	}
+
+	tmp := b.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_APPEND|os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, mode); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Link(b.path, segmentPath); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, b.path); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		os.Remove(segmentPath)
+		return err
+	}
+
+	b.f.Close()
+	b.f = f
+	b.size = 0
+	b.lastRotation = now
+
+	b.startMill()
+
+	return nil
+}
+
+// startMill runs mill in the background, unless the backend is closed. The
+// file lock must be held before calling this.
+func (b *Backend) startMill() {
+	if b.closed {
+		return
+	}
+	b.millWg.Add(1)
+	go func() {
+		defer b.millWg.Done()
+		b.mill()
+	}()
+}
+
+// retentionInterval returns how often the age of the file and of the rotated
+// segments is checked
+func (c *rotationConfig) retentionInterval() time.Duration {
+	interval := c.maxAge / 2
+	if interval > maxRetentionInterval {
+		interval = maxRetentionInterval
+	}
+	if interval < time.Second {
+		interval = time.Second
+	}
+	return interval
+}
+
+// runRetention periodically rotates the file once it is older than the
+// maximum age, and deletes the segments past it, so that retention applies
+// even when the file doesn't grow. It runs until the backend is closed.
+func (b *Backend) runRetention() {
+	defer b.millWg.Done()
+
+	ticker := time.NewTicker(b.rotation.retentionInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		b.fileLock.Lock()
+		if !b.closed && b.f != nil && b.size > 0 && time.Since(b.lastRotation) >= b.rotation.maxAge {
+			if err := b.rotate(); err != nil {
+				b.logger.Error("failed to rotate audit log", "path", b.path, "error", err)
+			}
+		}
+		b.fileLock.Unlock()
+
+		b.mill()
+	}
+}
+
+// segmentPath returns the path of the segment rotated at the given time. The
+// time is inserted before the extension of the file, e.g. the segments of
+// "audit.log" are named like "audit-2020-01-02T15-04-05.000.log".
+func (b *Backend) segmentPath(t time.Time) string {
+	ext := filepath.Ext(b.path)
+	return fmt.Sprintf("%s-%s%s", strings.TrimSuffix(b.path, ext), t.Format(segmentTimeFormat), ext)
+}
+
+// segments returns the rotated segments of the log, newest first
+func (b *Backend) segments() ([]segment, error) {
+	dir := filepath.Dir(b.path)
+	ext := filepath.Ext(b.path)
+	prefix := strings.TrimSuffix(filepath.Base(b.path), ext) + "-"
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []segment
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		ts := strings.TrimSuffix(strings.TrimPrefix(name, prefix), compressSuffix)
+		if !strings.HasSuffix(ts, ext) {
+			continue
+		}
+		rotatedAt, err := time.Parse(segmentTimeFormat, strings.TrimSuffix(ts, ext))
+		if err != nil {
+			continue
+		}
+		segments = append(segments, segment{
+			name:      filepath.Join(dir, name),
+			rotatedAt: rotatedAt,
+		})
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].rotatedAt.After(segments[j].rotatedAt)
+	})
+	return segments, nil
+}
+
+// mill deletes the segments past retention, and compresses the others if
+// compression is enabled. It runs in the background after each rotation, and
+// periodically if a maximum age is set.
+func (b *Backend) mill() {
+	b.millLock.Lock()
+	defer b.millLock.Unlock()
+
+	segments, err := b.segments()
+	if err != nil {
+		b.logger.Error("failed to list rotated audit log segments", "error", err)
+		return
+	}
+
+	cutoff := time.Now().Add(-b.rotation.maxAge)
+	for i, s := range segments {
+		if (b.rotation.maxFiles > 0 && i >= b.rotation.maxFiles) ||
+			(b.rotation.maxAge > 0 && s.rotatedAt.Before(cutoff)) {
+			if err := os.Remove(s.name); err != nil && !os.IsNotExist(err) {
+				b.logger.Error("failed to delete rotated audit log segment", "segment", s.name, "error", err)
+			}
+			continue
+		}
+
+		if b.rotation.compress && !strings.HasSuffix(s.name, compressSuffix) {
+			if err := compressSegment(s.name, b.mode); err != nil {
+				b.logger.Error("failed to compress rotated audit log segment", "segment", s.name, "error", err)
+			}
+		}
+	}
+}
+
+// compressSegment replaces a segment with its compressed copy
+func compressSegment(name string, mode os.FileMode) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if mode == 0 {
+		mode = 0600
+	}
+	tmp := name + compressSuffix + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(out)
+	_, err = io.Copy(gz, in)
+	if err == nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, name+compressSuffix)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Remove(name)
+}
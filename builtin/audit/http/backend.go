@@ -0,0 +1,628 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/errwrap"
+	cleanhttp "github.com/hashicorp/go-cleanhttp"
+	log "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/audit"
+	"github.com/hashicorp/vault/sdk/helper/parseutil"
+	"github.com/hashicorp/vault/sdk/helper/salt"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	defaultBatchSize     = 100
+	defaultBatchInterval = time.Second
+	defaultQueueSize     = 10000
+	defaultTimeout       = 5 * time.Second
+	defaultMaxRetries    = 3
+	defaultMinBackoff    = time.Second
+	defaultMaxBackoff    = 30 * time.Second
+	defaultSpoolMaxSize  = 100 * 1024 * 1024
+)
+
+func Factory(ctx context.Context, conf *audit.BackendConfig) (audit.Backend, error) {
+	if conf.SaltConfig == nil {
+		return nil, fmt.Errorf("nil salt config")
+	}
+	if conf.SaltView == nil {
+		return nil, fmt.Errorf("nil salt view")
+	}
+
+	rawURL, ok := conf.Config["url"]
+	if !ok {
+		return nil, fmt.Errorf("url is required")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to parse url: {{err}}", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("url must use the http or https scheme")
+	}
+
+	spoolDir, ok := conf.Config["spool_dir"]
+	if !ok || spoolDir == "" {
+		return nil, fmt.Errorf("spool_dir is required")
+	}
+	spoolMaxSize, err := parseIntOption(conf.Config, "spool_max_size", defaultSpoolMaxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	format, ok := conf.Config["format"]
+	if !ok {
+		format = "json"
+	}
+	switch format {
+	case "json", "ocsf":
+	default:
+		return nil, fmt.Errorf("unknown format type %q", format)
+	}
+
+	batchSize, err := parseIntOption(conf.Config, "batch_size", defaultBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	queueSize, err := parseIntOption(conf.Config, "queue_size", defaultQueueSize)
+	if err != nil {
+		return nil, err
+	}
+	maxRetries, err := parseIntOption(conf.Config, "max_retries", defaultMaxRetries)
+	if err != nil {
+		return nil, err
+	}
+	if batchSize < 1 || queueSize < 1 || maxRetries < 0 {
+		return nil, fmt.Errorf("batch_size and queue_size must be positive, and max_retries must not be negative")
+	}
+
+	batchInterval, err := parseDurationOption(conf.Config, "batch_interval", defaultBatchInterval)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := parseDurationOption(conf.Config, "timeout", defaultTimeout)
+	if err != nil {
+		return nil, err
+	}
+	minBackoff, err := parseDurationOption(conf.Config, "min_backoff", defaultMinBackoff)
+	if err != nil {
+		return nil, err
+	}
+	maxBackoff, err := parseDurationOption(conf.Config, "max_backoff", defaultMaxBackoff)
+	if err != nil {
+		return nil, err
+	}
+	if batchInterval <= 0 || timeout <= 0 || minBackoff <= 0 || maxBackoff < minBackoff {
+		return nil, fmt.Errorf("batch_interval, timeout and min_backoff must be positive, and max_backoff must be at least min_backoff")
+	}
+
+	var headers map[string]string
+	if raw, ok := conf.Config["headers"]; ok {
+		if err := json.Unmarshal([]byte(raw), &headers); err != nil {
+			return nil, errwrap.Wrapf("failed to parse headers, which must be a JSON object of strings: {{err}}", err)
+		}
+	}
+
+	// Check if hashing of accessor is disabled
+	hmacAccessor := true
+	if hmacAccessorRaw, ok := conf.Config["hmac_accessor"]; ok {
+		value, err := strconv.ParseBool(hmacAccessorRaw)
+		if err != nil {
+			return nil, err
+		}
+		hmacAccessor = value
+	}
+
+	// Check if raw logging is enabled
+	logRaw := false
+	if raw, ok := conf.Config["log_raw"]; ok {
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, err
+		}
+		logRaw = b
+	}
+
+	// Check if the policy results are logged
+	logPolicyResults := false
+	if raw, ok := conf.Config["log_policy_results"]; ok {
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, err
+		}
+		logPolicyResults = b
+	}
+
+	logger := conf.Logger
+	if logger == nil {
+		logger = log.NewNullLogger()
+	}
+
+	b := &Backend{
+		saltConfig: conf.SaltConfig,
+		saltView:   conf.SaltView,
+		formatConfig: audit.FormatterConfig{
+			Raw:           logRaw,
+			HMACAccessor:  hmacAccessor,
+			PolicyResults: logPolicyResults,
+		},
+
+		logger:        logger,
+		url:           u.String(),
+		headers:       headers,
+		tlsOptions:    conf.Config,
+		timeout:       timeout,
+		batchSize:     batchSize,
+		batchInterval: batchInterval,
+		maxRetries:    maxRetries,
+		minBackoff:    minBackoff,
+		maxBackoff:    maxBackoff,
+
+		queue:  make(chan []byte, queueSize),
+		fullCh: make(chan struct{}, 1),
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+	switch format {
+	case "json":
+		b.formatter.AuditFormatWriter = &audit.JSONFormatWriter{
+			SaltFunc: b.Salt,
+		}
+	case "ocsf":
+		b.formatter.AuditFormatWriter = &audit.OCSFFormatWriter{
+			SaltFunc: b.Salt,
+		}
+	}
+
+	if b.client, err = b.newClient(); err != nil {
+		return nil, err
+	}
+
+	if b.spool, err = newSpool(spoolDir, int64(spoolMaxSize)); err != nil {
+		return nil, err
+	}
+
+	go b.run()
+
+	return b, nil
+}
+
+// Backend is the audit backend sending batches of JSON entries to an HTTP
+// collector. Entries are queued in memory and sent in the background, so that
+// a slow collector doesn't block requests. Batches which can't be delivered
+// are written to the spool directory, and resent once the collector is
+// available again. The queue is volatile: entries which are neither sent nor
+// spooled yet are lost if Vault exits without closing the backend.
+type Backend struct {
+	formatter    audit.AuditFormatter
+	formatConfig audit.FormatterConfig
+
+	logger        log.Logger
+	url           string
+	headers       map[string]string
+	tlsOptions    map[string]string
+	timeout       time.Duration
+	batchSize     int
+	batchInterval time.Duration
+	maxRetries    int
+	minBackoff    time.Duration
+	maxBackoff    time.Duration
+
+	clientLock sync.RWMutex
+	client     *http.Client
+
+	queue     chan []byte
+	fullCh    chan struct{}
+	spool     *spool
+	stopCh    chan struct{}
+	doneCh    chan struct{}
+	closeOnce sync.Once
+
+	saltMutex  sync.RWMutex
+	salt       *salt.Salt
+	saltConfig *salt.Config
+	saltView   logical.Storage
+}
+
+var _ audit.Backend = (*Backend)(nil)
+var _ audit.Closer = (*Backend)(nil)
+
+func (b *Backend) GetHash(ctx context.Context, data string) (string, error) {
+	salt, err := b.Salt(ctx)
+	if err != nil {
+		return "", err
+	}
+	return audit.HashString(salt, data), nil
+}
+
+func (b *Backend) LogRequest(ctx context.Context, in *logical.LogInput) error {
+	var buf bytes.Buffer
+	if err := b.formatter.FormatRequest(ctx, &buf, b.formatConfig, in); err != nil {
+		return err
+	}
+
+	return b.enqueue(ctx, buf.Bytes())
+}
+
+func (b *Backend) LogResponse(ctx context.Context, in *logical.LogInput) error {
+	var buf bytes.Buffer
+	if err := b.formatter.FormatResponse(ctx, &buf, b.formatConfig, in); err != nil {
+		return err
+	}
+
+	return b.enqueue(ctx, buf.Bytes())
+}
+
+// enqueue queues an entry. If the queue is full, it waits for room for at
+// most twice the request timeout, which is long enough for the sender to give
+// up on its current batch and spool it, and returns an error if there is
+// still none, so that the request fails rather than going unaudited. Entries
+// are only ever spooled by the sender, so that they are delivered in order.
+func (b *Backend) enqueue(ctx context.Context, entry []byte) error {
+	entry = bytes.TrimSpace(entry)
+
+	select {
+	case <-b.stopCh:
+		return errors.New("http audit backend is closed")
+	default:
+	}
+
+	select {
+	case b.queue <- entry:
+		return nil
+	default:
+	}
+
+	// Tell the sender to stop retrying and spool its batch
+	select {
+	case b.fullCh <- struct{}{}:
+	default:
+	}
+
+	timer := time.NewTimer(2 * b.timeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case b.queue <- entry:
+		return nil
+	case <-b.stopCh:
+		return errors.New("http audit backend is closed")
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timer.C:
+		err = errors.New("timed out waiting for room in the queue")
+	}
+	metrics.IncrCounter([]string{"audit", "http", "rejected"}, 1)
+	return errwrap.Wrapf("http audit queue is full: {{err}}", err)
+}
+
+// run sends the queued entries in batches of at most batchSize entries, at
+// least every batchInterval, and resends the spooled batches. A batch which
+// can be neither sent nor spooled is retained and retried on every tick;
+// meanwhile the queue isn't read, so that once it is full new entries are
+// rejected by enqueue instead of piling up in memory.
+func (b *Backend) run() {
+	defer close(b.doneCh)
+
+	ticker := time.NewTicker(b.batchInterval)
+	defer ticker.Stop()
+
+	queue := b.queue
+	var retained [][]byte
+	batch := make([][]byte, 0, b.batchSize)
+	for {
+		select {
+		case entry := <-queue:
+			batch = append(batch, entry)
+			if len(batch) < b.batchSize {
+				continue
+			}
+			if !b.flush(batch, b.maxRetries) {
+				retained, queue = batch, nil
+			}
+			batch = make([][]byte, 0, b.batchSize)
+
+		case <-ticker.C:
+			if retained != nil {
+				if !b.flush(retained, 0) {
+					continue
+				}
+				retained, queue = nil, b.queue
+			}
+			if len(batch) > 0 {
+				if !b.flush(batch, b.maxRetries) {
+					retained, queue = batch, nil
+				}
+				batch = make([][]byte, 0, b.batchSize)
+			}
+			if retained == nil {
+				b.drainSpool()
+			}
+
+		case <-b.stopCh:
+			// Send or spool what is left once. Only the entries for which
+			// both fail are lost.
+			pending := append(retained, batch...)
+			for len(b.queue) > 0 {
+				pending = append(pending, <-b.queue)
+			}
+			for len(pending) > 0 {
+				n := len(pending)
+				if n > b.batchSize {
+					n = b.batchSize
+				}
+				if !b.flush(pending[:n], 0) {
+					b.logger.Error("failed to send or spool audit entries on close, dropping them", "entries", n)
+					metrics.IncrCounter([]string{"audit", "http", "dropped"}, float32(n))
+				}
+				pending = pending[n:]
+			}
+			return
+		}
+	}
+}
+
+// flush sends a batch, retrying at most the given number of times, and
+// spools it if it can't be sent. While the spool isn't empty, batches are
+// spooled right away so that entries are delivered in order. It returns
+// false if the batch was neither sent nor spooled.
+func (b *Backend) flush(batch [][]byte, retries int) bool {
+	payload := encodeBatch(batch)
+
+	if b.spool.len() > 0 {
+		return b.spoolBatch(payload, len(batch))
+	}
+
+	if err := b.send(payload, retries); err != nil {
+		metrics.IncrCounter([]string{"audit", "http", "failure"}, 1)
+		b.logger.Warn("failed to send audit entries, spooling them", "entries", len(batch), "error", err)
+		return b.spoolBatch(payload, len(batch))
+	}
+
+	metrics.IncrCounter([]string{"audit", "http", "sent"}, float32(len(batch)))
+	return true
+}
+
+func (b *Backend) spoolBatch(payload []byte, entries int) bool {
+	if err := b.spool.write(payload); err != nil {
+		b.logger.Error("failed to spool audit entries", "entries", entries, "error", err)
+		return false
+	}
+	metrics.IncrCounter([]string{"audit", "http", "spooled"}, float32(entries))
+	return true
+}
+
+// drainSpool resends the spooled batches, oldest first, until the spool is
+// empty or a batch can't be sent
+func (b *Backend) drainSpool() {
+	for {
+		select {
+		case <-b.stopCh:
+			return
+		default:
+		}
+
+		name, payload, err := b.spool.oldest()
+		if err != nil {
+			b.logger.Error("failed to read spooled audit entries", "error", err)
+			return
+		}
+		if name == "" {
+			return
+		}
+
+		if err := b.send(payload, 0); err != nil {
+			b.logger.Debug("failed to resend spooled audit entries", "error", err)
+			return
+		}
+		if err := b.spool.remove(name); err != nil {
+			b.logger.Error("failed to remove spooled audit entries", "file", name, "error", err)
+			return
+		}
+		metrics.IncrCounter([]string{"audit", "http", "resent"}, 1)
+	}
+}
+
+// send posts a payload to the collector, retrying with exponential backoff.
+// It gives up early once the queue is full, so that the batch is spooled and
+// the queue read again.
+func (b *Backend) send(payload []byte, retries int) error {
+	backoff := b.minBackoff
+	for attempt := 0; ; attempt++ {
+		err := b.post(payload)
+		if err == nil || attempt >= retries || len(b.queue) == cap(b.queue) {
+			return err
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-b.fullCh:
+			return err
+		case <-b.stopCh:
+			return err
+		}
+		backoff *= 2
+		if backoff > b.maxBackoff {
+			backoff = b.maxBackoff
+		}
+	}
+}
+
+func (b *Backend) post(payload []byte) error {
+	req, err := http.NewRequest(http.MethodPost, b.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range b.headers {
+		req.Header.Set(k, v)
+	}
+
+	b.clientLock.RLock()
+	client := b.client
+	b.clientLock.RUnlock()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// newClient returns an HTTP client configured with the TLS options, reading
+// the certificate files
+func (b *Backend) newClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{
+		ServerName: b.tlsOptions["tls_server_name"],
+	}
+
+	if raw, ok := b.tlsOptions["tls_skip_verify"]; ok {
+		skip, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, errwrap.Wrapf("failed to parse tls_skip_verify: {{err}}", err)
+		}
+		tlsConfig.InsecureSkipVerify = skip
+	}
+
+	if caFile, ok := b.tlsOptions["tls_ca_cert"]; ok {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, errwrap.Wrapf("failed to read tls_ca_cert: {{err}}", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in tls_ca_cert %q", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	certFile, hasCert := b.tlsOptions["tls_client_cert"]
+	keyFile, hasKey := b.tlsOptions["tls_client_key"]
+	switch {
+	case hasCert && hasKey:
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, errwrap.Wrapf("failed to load client certificate: {{err}}", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	case hasCert || hasKey:
+		return nil, fmt.Errorf("tls_client_cert and tls_client_key must be set together")
+	}
+
+	transport := cleanhttp.DefaultPooledTransport()
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   b.timeout,
+	}, nil
+}
+
+// Reload reads the TLS certificate files again
+func (b *Backend) Reload(_ context.Context) error {
+	client, err := b.newClient()
+	if err != nil {
+		return err
+	}
+
+	b.clientLock.Lock()
+	old := b.client
+	b.client = client
+	b.clientLock.Unlock()
+
+	old.CloseIdleConnections()
+	return nil
+}
+
+// Close sends the queued entries, spooling them if they can't be sent, and
+// stops the background sender. Entries which can be neither sent nor spooled
+// are dropped and counted.
+func (b *Backend) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.stopCh)
+	})
+	<-b.doneCh
+	return nil
+}
+
+func (b *Backend) Salt(ctx context.Context) (*salt.Salt, error) {
+	b.saltMutex.RLock()
+	if b.salt != nil {
+		defer b.saltMutex.RUnlock()
+		return b.salt, nil
+	}
+	b.saltMutex.RUnlock()
+	b.saltMutex.Lock()
+	defer b.saltMutex.Unlock()
+	if b.salt != nil {
+		return b.salt, nil
+	}
+	salt, err := salt.NewSalt(ctx, b.saltView, b.saltConfig)
+	if err != nil {
+		return nil, err
+	}
+	b.salt = salt
+	return salt, nil
+}
+
+func (b *Backend) Invalidate(_ context.Context) {
+	b.saltMutex.Lock()
+	defer b.saltMutex.Unlock()
+	b.salt = nil
+}
+
+// encodeBatch encodes entries, which are JSON objects, as a JSON array
+func encodeBatch(batch [][]byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	buf.Write(bytes.Join(batch, []byte(",")))
+	buf.WriteByte(']')
+	return buf.Bytes()
+}
+
+func parseIntOption(config map[string]string, name string, def int) (int, error) {
+	raw, ok := config[name]
+	if !ok {
+		return def, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, errwrap.Wrapf(fmt.Sprintf("failed to parse %s: {{err}}", name), err)
+	}
+	return v, nil
+}
+
+func parseDurationOption(config map[string]string, name string, def time.Duration) (time.Duration, error) {
+	raw, ok := config[name]
+	if !ok {
+		return def, nil
+	}
+	v, err := parseutil.ParseDurationSecond(raw)
+	if err != nil {
+		return 0, errwrap.Wrapf(fmt.Sprintf("failed to parse %s: {{err}}", name), err)
+	}
+	return v, nil
+}
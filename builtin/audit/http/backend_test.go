@@ -0,0 +1,463 @@
+package http
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/audit"
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/helper/salt"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// testCollector is an HTTP collector recording the audit entries it receives
+type testCollector struct {
+	sync.Mutex
+	entries []map[string]interface{}
+	headers http.Header
+	down    bool
+}
+
+func (c *testCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.down {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	var batch []map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	c.entries = append(c.entries, batch...)
+	c.headers = r.Header
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *testCollector) setDown(down bool) {
+	c.Lock()
+	defer c.Unlock()
+	c.down = down
+}
+
+// paths returns the request paths of the received entries
+func (c *testCollector) paths() []string {
+	c.Lock()
+	defer c.Unlock()
+
+	var paths []string
+	for _, entry := range c.entries {
+		paths = append(paths, entry["request"].(map[string]interface{})["path"].(string))
+	}
+	return paths
+}
+
+func (c *testCollector) waitFor(t *testing.T, n int) []string {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if paths := c.paths(); len(paths) >= n {
+			return paths
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected %d entries, got %d", n, len(c.paths()))
+	return nil
+}
+
+func testBackend(t *testing.T, config map[string]string) *Backend {
+	t.Helper()
+
+	b, err := Factory(context.Background(), &audit.BackendConfig{
+		SaltConfig: &salt.Config{},
+		SaltView:   &logical.InmemStorage{},
+		Config:     config,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.(*Backend)
+}
+
+func testSpoolDir(t *testing.T) (string, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "vault-test_audit_http-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func logRequests(t *testing.T, b *Backend, paths ...string) {
+	t.Helper()
+
+	for _, path := range paths {
+		in := &logical.LogInput{
+			Auth: &logical.Auth{
+				ClientToken: "foo",
+			},
+			Request: &logical.Request{
+				Operation: logical.ReadOperation,
+				Path:      path,
+			},
+		}
+		if err := b.LogRequest(namespace.RootContext(nil), in); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestHTTP_Batches(t *testing.T) {
+	collector := &testCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	dir, cleanup := testSpoolDir(t)
+	defer cleanup()
+
+	b := testBackend(t, map[string]string{
+		"url":            server.URL,
+		"spool_dir":      dir,
+		"batch_size":     "2",
+		"batch_interval": "50ms",
+		"headers":        `{"Authorization": "Bearer collector-token"}`,
+	})
+	defer b.Close()
+
+	logRequests(t, b, "one", "two", "three")
+	paths := collector.waitFor(t, 3)
+	for i, expected := range []string{"one", "two", "three"} {
+		if paths[i] != expected {
+			t.Fatalf("expected entries %v in order, got %v", []string{"one", "two", "three"}, paths)
+		}
+	}
+
+	collector.Lock()
+	defer collector.Unlock()
+	if auth := collector.headers.Get("Authorization"); auth != "Bearer collector-token" {
+		t.Fatalf("expected the configured header, got %q", auth)
+	}
+	token := collector.entries[0]["auth"].(map[string]interface{})["client_token"].(string)
+	if token == "foo" {
+		t.Fatal("expected the client token to be hashed")
+	}
+}
+
+func TestHTTP_Spool(t *testing.T) {
+	collector := &testCollector{down: true}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	dir, cleanup := testSpoolDir(t)
+	defer cleanup()
+
+	config := map[string]string{
+		"url":            server.URL,
+		"batch_interval": "50ms",
+		"max_retries":    "0",
+		"spool_dir":      dir,
+	}
+	b := testBackend(t, config)
+
+	// Entries which can't be delivered are spooled, and kept when the
+	// backend is closed
+	logRequests(t, b, "one", "two")
+	deadline := time.Now().Add(10 * time.Second)
+	for b.spool.len() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	logRequests(t, b, "three")
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*"+spoolFileSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) < 2 {
+		t.Fatalf("expected the batches to be spooled, got %v", files)
+	}
+
+	// A new backend resends the spooled entries in order once the collector
+	// is back
+	collector.setDown(false)
+	b = testBackend(t, config)
+	defer b.Close()
+
+	logRequests(t, b, "four")
+	paths := collector.waitFor(t, 4)
+	for i, expected := range []string{"one", "two", "three", "four"} {
+		if paths[i] != expected {
+			t.Fatalf("expected entries in order, got %v", paths)
+		}
+	}
+	deadline = time.Now().Add(10 * time.Second)
+	for b.spool.len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := b.spool.len(); n != 0 {
+		t.Fatalf("expected the spool to be empty, got %d batches", n)
+	}
+}
+
+func TestHTTP_QueueFull(t *testing.T) {
+	block := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer server.Close()
+
+	dir, cleanup := testSpoolDir(t)
+	defer cleanup()
+
+	b := testBackend(t, map[string]string{
+		"url":            server.URL,
+		"spool_dir":      dir,
+		"spool_max_size": "1",
+		"batch_size":     "1",
+		"queue_size":     "1",
+		"timeout":        "100ms",
+	})
+	defer b.Close()
+	defer close(block)
+
+	// The sender is blocked on the first entry and the second fills the
+	// queue. The first one can't be spooled once the post times out, so the
+	// queue isn't read again and the third one is rejected rather than
+	// blocking the request forever or being dropped.
+	logRequests(t, b, "one")
+	deadline := time.Now().Add(10 * time.Second)
+	for len(b.queue) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	logRequests(t, b, "two")
+
+	err := b.LogRequest(namespace.RootContext(nil), &logical.LogInput{
+		Request: &logical.Request{Path: "three"},
+	})
+	if err == nil {
+		t.Fatal("expected an error when the entry can be neither queued nor spooled")
+	}
+}
+
+func TestHTTP_QueueFullOrder(t *testing.T) {
+	collector := &testCollector{down: true}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	dir, cleanup := testSpoolDir(t)
+	defer cleanup()
+
+	b := testBackend(t, map[string]string{
+		"url":            server.URL,
+		"spool_dir":      dir,
+		"batch_size":     "1",
+		"batch_interval": "50ms",
+		"queue_size":     "2",
+		"min_backoff":    "10s",
+	})
+	defer b.Close()
+
+	// While the sender backs off, the queue fills. The sender then spools its
+	// batch and reads the queue again, so that the waiting entries are
+	// spooled after the older ones rather than ahead of them.
+	paths := []string{"one", "two", "three", "four", "five", "six"}
+	logRequests(t, b, paths...)
+	collector.setDown(false)
+
+	received := collector.waitFor(t, len(paths))
+	for i, expected := range paths {
+		if received[i] != expected {
+			t.Fatalf("expected entries in order, got %v", received)
+		}
+	}
+}
+
+func TestHTTP_Retained(t *testing.T) {
+	collector := &testCollector{down: true}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	dir, cleanup := testSpoolDir(t)
+	defer cleanup()
+
+	b := testBackend(t, map[string]string{
+		"url":            server.URL,
+		"spool_dir":      dir,
+		"spool_max_size": "1",
+		"batch_size":     "1",
+		"batch_interval": "50ms",
+		"max_retries":    "0",
+		"min_backoff":    "10ms",
+	})
+	defer b.Close()
+
+	// A batch which can be neither sent nor spooled is kept, and delivered
+	// once the collector is back
+	logRequests(t, b, "one")
+	time.Sleep(200 * time.Millisecond)
+	if n := b.spool.len(); n != 0 {
+		t.Fatalf("expected nothing to be spooled, got %d batches", n)
+	}
+	collector.setDown(false)
+
+	logRequests(t, b, "two")
+	paths := collector.waitFor(t, 2)
+	for i, expected := range []string{"one", "two"} {
+		if paths[i] != expected {
+			t.Fatalf("expected entries in order, got %v", paths)
+		}
+	}
+}
+
+func TestHTTP_MutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault-test_audit_http-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	clientCert, clientKey := testClientCert(t, dir)
+	clientPEM, err := ioutil.ReadFile(clientCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AppendCertsFromPEM(clientPEM)
+
+	collector := &testCollector{}
+	server := httptest.NewUnstartedServer(collector)
+	server.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	caCert := filepath.Join(dir, "ca.pem")
+	err = ioutil.WriteFile(caCert, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: server.Certificate().Raw,
+	}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := testBackend(t, map[string]string{
+		"url":             server.URL,
+		"spool_dir":       filepath.Join(dir, "spool"),
+		"batch_interval":  "50ms",
+		"tls_ca_cert":     caCert,
+		"tls_client_cert": clientCert,
+		"tls_client_key":  clientKey,
+	})
+	defer b.Close()
+
+	logRequests(t, b, "one")
+	collector.waitFor(t, 1)
+
+	// The client certificate requires its key
+	_, err = Factory(context.Background(), &audit.BackendConfig{
+		SaltConfig: &salt.Config{},
+		SaltView:   &logical.InmemStorage{},
+		Config: map[string]string{
+			"url":             server.URL,
+			"spool_dir":       filepath.Join(dir, "spool"),
+			"tls_client_cert": clientCert,
+		},
+	})
+	if err == nil {
+		t.Fatal("expected an error setting the certificate without its key")
+	}
+
+	// The spool directory is required
+	_, err = Factory(context.Background(), &audit.BackendConfig{
+		SaltConfig: &salt.Config{},
+		SaltView:   &logical.InmemStorage{},
+		Config: map[string]string{
+			"url": server.URL,
+		},
+	})
+	if err == nil {
+		t.Fatal("expected an error without a spool directory")
+	}
+
+	// Without a client certificate, the collector rejects the connection
+	b = testBackend(t, map[string]string{
+		"url":         server.URL,
+		"spool_dir":   filepath.Join(dir, "spool"),
+		"tls_ca_cert": caCert,
+	})
+	defer b.Close()
+	client := b.client
+	if err := b.post([]byte("[]")); err == nil {
+		t.Fatal("expected an error without a client certificate")
+	}
+
+	// Reloading reads the certificate files again
+	if err := b.Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if b.client == client {
+		t.Fatal("expected a new client after reloading")
+	}
+}
+
+// testClientCert writes a self-signed client certificate and its key to dir
+func testClientCert(t *testing.T, dir string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "vault"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client-key.pem")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
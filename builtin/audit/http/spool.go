@@ -0,0 +1,159 @@
+package http
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/errwrap"
+)
+
+const spoolFileSuffix = ".json"
+
+// spool stores batches which could not be sent as files of a directory, named
+// after an increasing sequence number so that they are resent in order. The
+// total size of the files is bounded.
+type spool struct {
+	sync.Mutex
+
+	dir     string
+	maxSize int64
+	size    int64
+	seq     uint64
+	files   []spoolFile
+}
+
+type spoolFile struct {
+	name string
+	size int64
+}
+
+// newSpool opens a spool directory, creating it if needed, and picks up the
+// batches spooled by a previous run
+func newSpool(dir string, maxSize int64) (*spool, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("spool_max_size must be positive")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errwrap.Wrapf("failed to create spool directory: {{err}}", err)
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to read spool directory: {{err}}", err)
+	}
+
+	s := &spool{
+		dir:     dir,
+		maxSize: maxSize,
+	}
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+		name := info.Name()
+
+		// Remove the leftovers of interrupted writes
+		if strings.HasSuffix(name, ".tmp") {
+			os.Remove(filepath.Join(dir, name))
+			continue
+		}
+
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, spoolFileSuffix), 10, 64)
+		if err != nil || !strings.HasSuffix(name, spoolFileSuffix) {
+			continue
+		}
+		if seq >= s.seq {
+			s.seq = seq + 1
+		}
+		s.files = append(s.files, spoolFile{name: name, size: info.Size()})
+		s.size += info.Size()
+	}
+	sort.Slice(s.files, func(i, j int) bool {
+		return s.files[i].name < s.files[j].name
+	})
+
+	return s, nil
+}
+
+func (s *spool) len() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.files)
+}
+
+// write spools a batch, failing if the spool would exceed its maximum size
+func (s *spool) write(payload []byte) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.size+int64(len(payload)) > s.maxSize {
+		return fmt.Errorf("spool is full")
+	}
+
+	// The sequence number is zero padded so that names sort in order
+	name := fmt.Sprintf("%020d%s", s.seq, spoolFileSuffix)
+	path := filepath.Join(s.dir, name)
+	tmp := path + ".tmp"
+
+	if err := ioutil.WriteFile(tmp, payload, 0600); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	s.seq++
+	s.files = append(s.files, spoolFile{name: name, size: int64(len(payload))})
+	s.size += int64(len(payload))
+	return nil
+}
+
+// oldest returns the name and contents of the oldest spooled batch, or an
+// empty name if the spool is empty
+func (s *spool) oldest() (string, []byte, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	for len(s.files) > 0 {
+		f := s.files[0]
+		payload, err := ioutil.ReadFile(filepath.Join(s.dir, f.name))
+		switch {
+		case os.IsNotExist(err):
+			// The file was removed from outside of Vault
+			s.files = s.files[1:]
+			s.size -= f.size
+		case err != nil:
+			return "", nil, err
+		default:
+			return f.name, payload, nil
+		}
+	}
+	return "", nil, nil
+}
+
+// remove removes a batch once it has been resent
+func (s *spool) remove(name string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	for i, f := range s.files {
+		if f.name != name {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		s.files = append(s.files[:i], s.files[i+1:]...)
+		s.size -= f.size
+		return nil
+	}
+	return nil
+}
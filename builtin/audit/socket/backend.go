@@ -9,6 +9,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/hashicorp/errwrap"
 	multierror "github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/vault/audit"
 	"github.com/hashicorp/vault/sdk/helper/parseutil"
@@ -48,7 +49,7 @@ func Factory(ctx context.Context, conf *audit.BackendConfig) (audit.Backend, err
 		format = "json"
 	}
 	switch format {
-	case "json", "jsonx":
+	case "json", "jsonx", "cef", "ocsf":
 	default:
 		return nil, fmt.Errorf("unknown format type %q", format)
 	}
@@ -73,12 +74,39 @@ func Factory(ctx context.Context, conf *audit.BackendConfig) (audit.Backend, err
 		logRaw = b
 	}
 
+	// Check if the policy results are logged
+	logPolicyResults := false
+	if raw, ok := conf.Config["log_policy_results"]; ok {
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, err
+		}
+		logPolicyResults = b
+	}
+
+	// Check if hash chaining is enabled
+	var hashChain *audit.HashChain
+	if raw, ok := conf.Config[audit.HashChainOption]; ok {
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, err
+		}
+		if b {
+			if format != "json" && format != "ocsf" {
+				return nil, fmt.Errorf("%s requires the json or ocsf format", audit.HashChainOption)
+			}
+			hashChain = audit.NewHashChain(conf.SaltView)
+		}
+	}
+
 	b := &Backend{
+		hashChain:  hashChain,
 		saltConfig: conf.SaltConfig,
 		saltView:   conf.SaltView,
 		formatConfig: audit.FormatterConfig{
-			Raw:          logRaw,
-			HMACAccessor: hmacAccessor,
+			Raw:           logRaw,
+			HMACAccessor:  hmacAccessor,
+			PolicyResults: logPolicyResults,
 		},
 
 		writeDuration: writeDuration,
@@ -97,6 +125,16 @@ func Factory(ctx context.Context, conf *audit.BackendConfig) (audit.Backend, err
 			Prefix:   conf.Config["prefix"],
 			SaltFunc: b.Salt,
 		}
+	case "cef":
+		b.formatter.AuditFormatWriter = &audit.CEFFormatWriter{
+			Prefix:   conf.Config["prefix"],
+			SaltFunc: b.Salt,
+		}
+	case "ocsf":
+		b.formatter.AuditFormatWriter = &audit.OCSFFormatWriter{
+			Prefix:   conf.Config["prefix"],
+			SaltFunc: b.Salt,
+		}
 	}
 
 	return b, nil
@@ -109,6 +147,9 @@ type Backend struct {
 	formatter    audit.AuditFormatter
 	formatConfig audit.FormatterConfig
 
+	// hashChain, if set, links the entries in the order they are written
+	hashChain *audit.HashChain
+
 	writeDuration time.Duration
 	address       string
 	socketType    string
@@ -122,6 +163,15 @@ type Backend struct {
 }
 
 var _ audit.Backend = (*Backend)(nil)
+var _ audit.HashChainer = (*Backend)(nil)
+
+func (b *Backend) HashChainKey(ctx context.Context) ([]byte, error) {
+	salt, err := b.Salt(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return audit.HashChainKey(salt), nil
+}
 
 func (b *Backend) GetHash(ctx context.Context, data string) (string, error) {
 	salt, err := b.Salt(ctx)
@@ -137,21 +187,7 @@ func (b *Backend) LogRequest(ctx context.Context, in *logical.LogInput) error {
 		return err
 	}
 
-	b.Lock()
-	defer b.Unlock()
-
-	err := b.write(ctx, buf.Bytes())
-	if err != nil {
-		rErr := b.reconnect(ctx)
-		if rErr != nil {
-			err = multierror.Append(err, rErr)
-		} else {
-			// Try once more after reconnecting
-			err = b.write(ctx, buf.Bytes())
-		}
-	}
-
-	return err
+	return b.log(ctx, buf.Bytes())
 }
 
 func (b *Backend) LogResponse(ctx context.Context, in *logical.LogInput) error {
@@ -160,17 +196,36 @@ func (b *Backend) LogResponse(ctx context.Context, in *logical.LogInput) error {
 		return err
 	}
 
+	return b.log(ctx, buf.Bytes())
+}
+
+func (b *Backend) log(ctx context.Context, entry []byte) error {
+	var hashChainKey []byte
+	if b.hashChain != nil {
+		var err error
+		if hashChainKey, err = b.HashChainKey(ctx); err != nil {
+			return errwrap.Wrapf("error fetching salt: {{err}}", err)
+		}
+	}
+
 	b.Lock()
 	defer b.Unlock()
 
-	err := b.write(ctx, buf.Bytes())
+	if b.hashChain != nil {
+		var err error
+		if entry, err = b.hashChain.Link(ctx, hashChainKey, entry); err != nil {
+			return err
+		}
+	}
+
+	err := b.write(ctx, entry)
 	if err != nil {
 		rErr := b.reconnect(ctx)
 		if rErr != nil {
 			err = multierror.Append(err, rErr)
 		} else {
 			// Try once more after reconnecting
-			err = b.write(ctx, buf.Bytes())
+			err = b.write(ctx, entry)
 		}
 	}
 
@@ -17,6 +17,12 @@ const (
 	secretIDLocalPrefix         = "secret_id_local/"
 	secretIDAccessorPrefix      = "accessor/"
 	secretIDAccessorLocalPrefix = "accessor_local/"
+	secretIDUsagePrefix         = "secret_id_usage/"
+	secretIDUsageLocalPrefix    = "secret_id_usage_local/"
+
+	// secretIDUsageWriteInterval is the minimum interval between two writes of
+	// the usage of a SecretID by the same client
+	secretIDUsageWriteInterval = time.Minute
 )
 
 type backend struct {
@@ -105,6 +111,7 @@ func Backend(conf *logical.BackendConfig) (*backend, error) {
 			LocalStorage: []string{
 				secretIDLocalPrefix,
 				secretIDAccessorLocalPrefix,
+				secretIDUsageLocalPrefix,
 			},
 		},
 		Paths: framework.PathAppend(
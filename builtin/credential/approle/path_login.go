@@ -86,6 +86,7 @@ func (b *backend) pathLoginUpdate(ctx context.Context, req *logical.Request, dat
 
 	metadata := make(map[string]string)
 	var entry *secretIDStorageEntry
+	var usageHMAC, usageIndex string
 	if role.BindSecretID {
 		secretID := strings.TrimSpace(data.Get("secret_id").(string))
 		if secretID == "" {
@@ -180,6 +181,8 @@ func (b *backend) pathLoginUpdate(ctx context.Context, req *logical.Request, dat
 					return logical.ErrorResponse(errwrap.Wrapf(fmt.Sprintf("source address %q unauthorized through CIDR restrictions on the secret ID: {{err}}", req.Connection.RemoteAddr), err).Error()), nil
 				}
 			}
+
+			usageHMAC, usageIndex = secretIDHMAC, entryIndex
 		default:
 			//
 			// If the SecretIDNumUses is non-zero, it means that its use-count should be updated
@@ -213,10 +216,14 @@ func (b *backend) pathLoginUpdate(ctx context.Context, req *logical.Request, dat
 				if err != nil {
 					return nil, errwrap.Wrapf("failed to delete secret ID: {{err}}", err)
 				}
+				if err := b.deleteSecretIDUsageEntry(ctx, req.Storage, entryIndex); err != nil {
+					return nil, err
+				}
 			} else {
 				// If the use count is greater than one, decrement it and update the last updated time.
 				entry.SecretIDNumUses -= 1
 				entry.LastUpdatedTime = time.Now()
+				usageHMAC, usageIndex = secretIDHMAC, entryIndex
 
 				sEntry, err := logical.StorageEntryJSON(entryIndex, &entry)
 				if err != nil {
@@ -251,6 +258,10 @@ func (b *backend) pathLoginUpdate(ctx context.Context, req *logical.Request, dat
 		}
 
 		metadata = entry.Metadata
+
+		// The usage of the SecretID is recorded without holding its lock
+		unlockFunc()
+		unlockFunc = func() {}
 	}
 
 	if len(role.SecretIDBoundCIDRs) != 0 {
@@ -295,6 +306,10 @@ func (b *backend) pathLoginUpdate(ctx context.Context, req *logical.Request, dat
 	// Allow for overridden token bound CIDRs
 	auth.BoundCIDRs = tokenBoundCIDRs
 
+	if usageIndex != "" {
+		b.recordSecretIDUsage(ctx, req, usageHMAC, usageIndex)
+	}
+
 	return &logical.Response{
 		Auth: auth,
 	}, nil
@@ -264,3 +264,227 @@ func generateRenewRequest(s logical.Storage, auth *logical.Auth) *logical.Reques
 
 	return renewReq
 }
+
+func TestAppRole_SecretIDUsageTracking(t *testing.T) {
+	var resp *logical.Response
+	var err error
+	b, s := createBackendWithStorage(t)
+
+	for _, numUses := range []int{0, 5} {
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Path:      "role/testrole",
+			Operation: logical.CreateOperation,
+			Data: map[string]interface{}{
+				"secret_id_num_uses": numUses,
+			},
+			Storage: s,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%v resp:%#v", err, resp)
+		}
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Path:      "role/testrole/role-id",
+			Operation: logical.ReadOperation,
+			Storage:   s,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%v resp:%#v", err, resp)
+		}
+		roleID := resp.Data["role_id"]
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Path:      "role/testrole/secret-id",
+			Operation: logical.UpdateOperation,
+			Storage:   s,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%v resp:%#v", err, resp)
+		}
+		secretID := resp.Data["secret_id"]
+		accessor := resp.Data["secret_id_accessor"]
+
+		accessorLookupReq := &logical.Request{
+			Path:      "role/testrole/secret-id-accessor/lookup",
+			Operation: logical.UpdateOperation,
+			Data: map[string]interface{}{
+				"secret_id_accessor": accessor,
+			},
+			Storage: s,
+		}
+		resp, err = b.HandleRequest(context.Background(), accessorLookupReq)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%v resp:%#v", err, resp)
+		}
+		if !resp.Data["last_used_time"].(time.Time).IsZero() || resp.Data["last_used_remote_addr"].(string) != "" {
+			t.Fatalf("expected unused secret ID, got: %#v", resp.Data)
+		}
+
+		before := time.Now()
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Path:      "login",
+			Operation: logical.UpdateOperation,
+			Data: map[string]interface{}{
+				"role_id":   roleID,
+				"secret_id": secretID,
+			},
+			Storage:    s,
+			Connection: &logical.Connection{RemoteAddr: "127.0.0.2"},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%v resp:%#v", err, resp)
+		}
+
+		resp, err = b.HandleRequest(context.Background(), accessorLookupReq)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%v resp:%#v", err, resp)
+		}
+		if resp.Data["last_used_time"].(time.Time).Before(before) {
+			t.Fatalf("num_uses %d: expected last_used_time to be updated, got: %#v", numUses, resp.Data)
+		}
+		if resp.Data["last_used_remote_addr"].(string) != "127.0.0.2" {
+			t.Fatalf("num_uses %d: bad last_used_remote_addr: %#v", numUses, resp.Data)
+		}
+	}
+}
+
+// readOnlyStorage rejects all writes, as the storage of a performance standby
+type readOnlyStorage struct {
+	logical.Storage
+}
+
+func (s *readOnlyStorage) Put(context.Context, *logical.StorageEntry) error {
+	return logical.ErrReadOnly
+}
+
+func TestAppRole_SecretIDUsageBestEffort(t *testing.T) {
+	var resp *logical.Response
+	var err error
+	b, s := createBackendWithStorage(t)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Path:      "role/testrole",
+		Operation: logical.CreateOperation,
+		Storage:   s,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Path:      "role/testrole/role-id",
+		Operation: logical.ReadOperation,
+		Storage:   s,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+	roleID := resp.Data["role_id"]
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Path:      "role/testrole/secret-id",
+		Operation: logical.UpdateOperation,
+		Storage:   s,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+	secretID := resp.Data["secret_id"]
+	accessor := resp.Data["secret_id_accessor"]
+
+	login := func(storage logical.Storage, remoteAddr string) {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Path:      "login",
+			Operation: logical.UpdateOperation,
+			Data: map[string]interface{}{
+				"role_id":   roleID,
+				"secret_id": secretID,
+			},
+			Storage:    storage,
+			Connection: &logical.Connection{RemoteAddr: remoteAddr},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%v resp:%#v", err, resp)
+		}
+	}
+	lookup := func() map[string]interface{} {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Path:      "role/testrole/secret-id-accessor/lookup",
+			Operation: logical.UpdateOperation,
+			Data: map[string]interface{}{
+				"secret_id_accessor": accessor,
+			},
+			Storage: s,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%v resp:%#v", err, resp)
+		}
+		return resp.Data
+	}
+
+	// Failing to record the usage doesn't fail the login
+	login(&readOnlyStorage{s}, "127.0.0.2")
+	if data := lookup(); !data["last_used_time"].(time.Time).IsZero() {
+		t.Fatalf("expected no usage to be recorded, got: %#v", data)
+	}
+
+	// The usage is written at most once per interval for the same client
+	login(s, "127.0.0.2")
+	first := lookup()["last_used_time"].(time.Time)
+	login(s, "127.0.0.2")
+	if data := lookup(); !data["last_used_time"].(time.Time).Equal(first) {
+		t.Fatalf("expected the usage write to be throttled, got: %#v", data)
+	}
+	login(s, "127.0.0.3")
+	if data := lookup(); data["last_used_remote_addr"].(string) != "127.0.0.3" {
+		t.Fatalf("expected the usage of a new client to be recorded, got: %#v", data)
+	}
+
+	// Locate the usage entry so that a racing login can be replayed below
+	roleKeys, err := s.List(context.Background(), secretIDUsagePrefix)
+	if err != nil || len(roleKeys) != 1 {
+		t.Fatalf("err:%v keys:%v", err, roleKeys)
+	}
+	hmacKeys, err := s.List(context.Background(), secretIDUsagePrefix+roleKeys[0])
+	if err != nil || len(hmacKeys) != 1 {
+		t.Fatalf("err:%v keys:%v", err, hmacKeys)
+	}
+	secretIDHMAC := hmacKeys[0]
+	secretIDIndex := secretIDPrefix + roleKeys[0] + secretIDHMAC
+
+	// The usage is deleted along with the SecretID
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Path:      "role/testrole/secret-id-accessor/destroy",
+		Operation: logical.UpdateOperation,
+		Data: map[string]interface{}{
+			"secret_id_accessor": accessor,
+		},
+		Storage: s,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+	keys, err := s.List(context.Background(), secretIDUsagePrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 0 {
+		t.Fatalf("expected the usage entry to be deleted, got: %v", keys)
+	}
+
+	// A login that validated the SecretID before it was destroyed doesn't
+	// recreate its usage entry
+	b.recordSecretIDUsage(context.Background(), &logical.Request{
+		Storage:    s,
+		Connection: &logical.Connection{RemoteAddr: "127.0.0.4"},
+	}, secretIDHMAC, secretIDIndex)
+	keys, err = s.List(context.Background(), secretIDUsagePrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 0 {
+		t.Fatalf("expected no orphaned usage entry, got: %v", keys)
+	}
+}
@@ -76,6 +76,16 @@ type roleStorageEntry struct {
 	// SecretIDPrefix is the storage prefix for persisting secret IDs. This
 	// differs based on whether the secret IDs are cluster local or not.
 	SecretIDPrefix string `json:"secret_id_prefix" mapstructure:"secret_id_prefix"`
+
+	// A constraint, if set, requires the response of the SecretID issuing
+	// endpoints to be response-wrapped
+	SecretIDWrappingRequired bool `json:"secret_id_wrapping_required" mapstructure:"secret_id_wrapping_required"`
+
+	// Minimum wrapping TTL that is accepted when issuing a SecretID, if set
+	SecretIDWrappingMinTTL time.Duration `json:"secret_id_wrapping_min_ttl" mapstructure:"secret_id_wrapping_min_ttl"`
+
+	// Maximum wrapping TTL that is accepted when issuing a SecretID, if set
+	SecretIDWrappingMaxTTL time.Duration `json:"secret_id_wrapping_max_ttl" mapstructure:"secret_id_wrapping_max_ttl"`
 }
 
 // roleIDStorageEntry represents the reverse mapping from RoleID to Role
@@ -166,6 +176,24 @@ to 0, meaning no expiration.`,
 				Description: `If set, the secret IDs generated using this role will be cluster local. This
 can only be set during role creation and once set, it can't be reset later.`,
 			},
+
+			"secret_id_wrapping_required": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `If set, requests to issue a SecretID against this role must ask for the
+response to be wrapped. Defaults to 'false'.`,
+			},
+
+			"secret_id_wrapping_min_ttl": &framework.FieldSchema{
+				Type: framework.TypeDurationSecond,
+				Description: `Minimum wrapping TTL in seconds that is accepted when a SecretID is issued
+against this role. Defaults to 0, meaning no minimum.`,
+			},
+
+			"secret_id_wrapping_max_ttl": &framework.FieldSchema{
+				Type: framework.TypeDurationSecond,
+				Description: `Maximum wrapping TTL in seconds that is accepted when a SecretID is issued
+against this role. Defaults to 0, meaning no maximum.`,
+			},
 		},
 		ExistenceCheck: b.pathRoleExistenceCheck,
 		Callbacks: map[logical.Operation]framework.OperationFunc{
@@ -961,6 +989,25 @@ func (b *backend) pathRoleCreateUpdate(ctx context.Context, req *logical.Request
 		role.SecretIDTTL = time.Second * time.Duration(data.Get("secret_id_ttl").(int))
 	}
 
+	if wrappingRequiredRaw, ok := data.GetOk("secret_id_wrapping_required"); ok {
+		role.SecretIDWrappingRequired = wrappingRequiredRaw.(bool)
+	}
+
+	if wrappingMinTTLRaw, ok := data.GetOk("secret_id_wrapping_min_ttl"); ok {
+		role.SecretIDWrappingMinTTL = time.Second * time.Duration(wrappingMinTTLRaw.(int))
+	}
+
+	if wrappingMaxTTLRaw, ok := data.GetOk("secret_id_wrapping_max_ttl"); ok {
+		role.SecretIDWrappingMaxTTL = time.Second * time.Duration(wrappingMaxTTLRaw.(int))
+	}
+
+	if role.SecretIDWrappingMinTTL < 0 || role.SecretIDWrappingMaxTTL < 0 {
+		return logical.ErrorResponse("secret_id_wrapping_min_ttl and secret_id_wrapping_max_ttl cannot be negative"), nil
+	}
+	if role.SecretIDWrappingMaxTTL != 0 && role.SecretIDWrappingMinTTL > role.SecretIDWrappingMaxTTL {
+		return logical.ErrorResponse("secret_id_wrapping_min_ttl cannot be greater than secret_id_wrapping_max_ttl"), nil
+	}
+
 	// handle upgrade cases
 	{
 		if err := tokenutil.UpgradeValue(data, "policies", "token_policies", &role.Policies, &role.TokenPolicies); err != nil {
@@ -1013,6 +1060,10 @@ func (b *backend) pathRoleRead(ctx context.Context, req *logical.Request, data *
 		"secret_id_num_uses":    role.SecretIDNumUses,
 		"secret_id_ttl":         role.SecretIDTTL / time.Second,
 		"local_secret_ids":      false,
+
+		"secret_id_wrapping_required": role.SecretIDWrappingRequired,
+		"secret_id_wrapping_min_ttl":  role.SecretIDWrappingMinTTL / time.Second,
+		"secret_id_wrapping_max_ttl":  role.SecretIDWrappingMaxTTL / time.Second,
 	}
 	role.PopulateTokenData(respData)
 
@@ -1177,12 +1228,19 @@ func (b *backend) pathRoleSecretIDLookupUpdate(ctx context.Context, req *logical
 		return logical.ErrorResponse("invalid secret id"), nil
 	}
 
+	usage, err := b.secretIDUsageEntry(ctx, req.Storage, entryIndex)
+	if err != nil {
+		return nil, err
+	}
+
 	return &logical.Response{
-		Data: secretIDEntry.ToResponseData(),
+		Data: secretIDEntry.ToResponseData(usage),
 	}, nil
 }
 
-func (entry *secretIDStorageEntry) ToResponseData() map[string]interface{} {
+// ToResponseData returns the properties of the SecretID, along with its usage
+// if it is known
+func (entry *secretIDStorageEntry) ToResponseData(usage *secretIDUsageStorageEntry) map[string]interface{} {
 	ret := map[string]interface{}{
 		"secret_id_accessor": entry.SecretIDAccessor,
 		"secret_id_num_uses": entry.SecretIDNumUses,
@@ -1193,6 +1251,13 @@ func (entry *secretIDStorageEntry) ToResponseData() map[string]interface{} {
 		"metadata":           entry.Metadata,
 		"cidr_list":          entry.CIDRList,
 		"token_bound_cidrs":  entry.TokenBoundCIDRs,
+
+		"last_used_time":        time.Time{},
+		"last_used_remote_addr": "",
+	}
+	if usage != nil {
+		ret["last_used_time"] = usage.LastUsedTime
+		ret["last_used_remote_addr"] = usage.LastUsedRemoteAddr
 	}
 	if len(entry.TokenBoundCIDRs) == 0 {
 		ret["token_bound_cidrs"] = []string{}
@@ -1257,6 +1322,10 @@ func (b *backend) pathRoleSecretIDDestroyUpdateDelete(ctx context.Context, req *
 		return nil, errwrap.Wrapf("failed to delete secret_id: {{err}}", err)
 	}
 
+	if err := b.deleteSecretIDUsageEntry(ctx, req.Storage, entryIndex); err != nil {
+		return nil, err
+	}
+
 	return nil, nil
 }
 
@@ -1314,8 +1383,13 @@ func (b *backend) pathRoleSecretIDAccessorLookupUpdate(ctx context.Context, req
 		return nil, nil
 	}
 
+	usage, err := b.secretIDUsageEntry(ctx, req.Storage, fmt.Sprintf("%s%s/%s", role.SecretIDPrefix, roleNameHMAC, accessorEntry.SecretIDHMAC))
+	if err != nil {
+		return nil, err
+	}
+
 	return &logical.Response{
-		Data: secretIDEntry.ToResponseData(),
+		Data: secretIDEntry.ToResponseData(usage),
 	}, nil
 }
 
@@ -1371,6 +1445,10 @@ func (b *backend) pathRoleSecretIDAccessorDestroyUpdateDelete(ctx context.Contex
 		return nil, errwrap.Wrapf("failed to delete secret_id: {{err}}", err)
 	}
 
+	if err := b.deleteSecretIDUsageEntry(ctx, req.Storage, entryIndex); err != nil {
+		return nil, err
+	}
+
 	return nil, nil
 }
 
@@ -2306,6 +2384,10 @@ func (b *backend) handleRoleSecretIDCommon(ctx context.Context, req *logical.Req
 		return logical.ErrorResponse("bind_secret_id is not set on the role"), nil
 	}
 
+	if err := validateSecretIDWrapping(role, req.WrapInfo); err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+
 	secretIDCIDRs := data.Get("cidr_list").([]string)
 
 	// Validate the list of CIDR blocks
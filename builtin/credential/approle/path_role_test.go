@@ -1931,3 +1931,92 @@ func TestAppRole_TokenutilUpgrade(t *testing.T) {
 		})
 	}
 }
+
+func TestAppRole_SecretIDWrappingRequired(t *testing.T) {
+	var resp *logical.Response
+	var err error
+	b, storage := createBackendWithStorage(t)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Path:      "role/role1",
+		Operation: logical.CreateOperation,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"secret_id_wrapping_required": true,
+			"secret_id_wrapping_min_ttl":  "1m",
+			"secret_id_wrapping_max_ttl":  "10m",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Path:      "role/role1",
+		Operation: logical.ReadOperation,
+		Storage:   storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+	if !resp.Data["secret_id_wrapping_required"].(bool) ||
+		resp.Data["secret_id_wrapping_min_ttl"].(time.Duration) != 60 ||
+		resp.Data["secret_id_wrapping_max_ttl"].(time.Duration) != 600 {
+		t.Fatalf("bad: role data: %#v", resp.Data)
+	}
+
+	secretIDReq := &logical.Request{
+		Path:      "role/role1/secret-id",
+		Operation: logical.UpdateOperation,
+		Storage:   storage,
+	}
+
+	testCases := []struct {
+		name     string
+		wrapInfo *logical.RequestWrapInfo
+		valid    bool
+	}{
+		{"not wrapped", nil, false},
+		{"zero TTL", &logical.RequestWrapInfo{}, false},
+		{"below minimum", &logical.RequestWrapInfo{TTL: 30 * time.Second}, false},
+		{"above maximum", &logical.RequestWrapInfo{TTL: time.Hour}, false},
+		{"within bounds", &logical.RequestWrapInfo{TTL: 5 * time.Minute}, true},
+	}
+	for _, tc := range testCases {
+		secretIDReq.WrapInfo = tc.wrapInfo
+		resp, err = b.HandleRequest(context.Background(), secretIDReq)
+		valid := err == nil && resp != nil && !resp.IsError()
+		if valid != tc.valid {
+			t.Fatalf("%s: expected valid to be %t, err:%v resp:%#v", tc.name, tc.valid, err, resp)
+		}
+	}
+
+	// The custom secret ID endpoint is subject to the same constraint
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Path:      "role/role1/custom-secret-id",
+		Operation: logical.UpdateOperation,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"secret_id": "abcd123",
+		},
+	})
+	if err == nil && resp != nil && !resp.IsError() {
+		t.Fatalf("expected an error issuing an unwrapped custom secret_id")
+	}
+
+	// A minimum greater than the maximum is rejected
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Path:      "role/role1",
+		Operation: logical.UpdateOperation,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"secret_id_wrapping_min_ttl": "1h",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, got resp:%#v", resp)
+	}
+}
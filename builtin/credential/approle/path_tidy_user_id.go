@@ -116,7 +116,7 @@ func (b *backend) tidySecretID(ctx context.Context, req *logical.Request) (*logi
 					if err := s.Delete(ctx, entryIndex); err != nil {
 						return errwrap.Wrapf(fmt.Sprintf("error deleting secret ID %q from storage: {{err}}", secretIDHMAC), err)
 					}
-					return nil
+					return b.deleteSecretIDUsageEntry(ctx, s, entryIndex)
 				}
 
 				// ExpirationTime not being set indicates non-expiring SecretIDs
@@ -132,7 +132,7 @@ func (b *backend) tidySecretID(ctx context.Context, req *logical.Request) (*logi
 						return errwrap.Wrapf(fmt.Sprintf("error deleting SecretID %q from storage: {{err}}", secretIDHMAC), err)
 					}
 
-					return nil
+					return b.deleteSecretIDUsageEntry(ctx, s, entryIndex)
 				}
 
 				// At this point, the secret ID is not expired and is valid. Delete
@@ -162,6 +162,35 @@ func (b *backend) tidySecretID(ctx context.Context, req *logical.Request) (*logi
 				}
 			}
 
+			// Usage entries are written without holding the lock of their
+			// SecretID, so one may be left behind by a SecretID deleted while
+			// its usage was being recorded. Clean up such entries.
+			usagePrefix := secretIDUsageIndex(secretIDPrefixToUse)
+			usageRoleNameHMACs, err := s.List(ctx, usagePrefix)
+			if err != nil {
+				return err
+			}
+			for _, roleNameHMAC := range usageRoleNameHMACs {
+				secretIDHMACs, err := s.List(ctx, usagePrefix+roleNameHMAC)
+				if err != nil {
+					return err
+				}
+				for _, secretIDHMAC := range secretIDHMACs {
+					lock := b.secretIDLock(secretIDHMAC)
+					lock.Lock()
+					entryIndex := fmt.Sprintf("%s%s%s", secretIDPrefixToUse, roleNameHMAC, secretIDHMAC)
+					secretIDEntry, err := s.Get(ctx, entryIndex)
+					if err == nil && secretIDEntry == nil {
+						logger.Trace("found dangling secret ID usage, removing")
+						err = b.deleteSecretIDUsageEntry(ctx, s, entryIndex)
+					}
+					lock.Unlock()
+					if err != nil {
+						return err
+					}
+				}
+			}
+
 			// Accessor indexes were not getting cleaned up until 0.9.3. This is a fix
 			// to clean up the dangling accessor entries.
 			if len(accessorMap) > 0 {
@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/errwrap"
@@ -70,6 +71,123 @@ type secretIDAccessorStorageEntry struct {
 	SecretIDHMAC string `json:"secret_id_hmac" mapstructure:"secret_id_hmac"`
 }
 
+// secretIDUsageStorageEntry records the most recent login performed with a
+// SecretID. It is kept apart from the SecretID entry so that it can be
+// written on a best-effort basis, without holding the lock of the SecretID.
+type secretIDUsageStorageEntry struct {
+	// The time when the SecretID was last used to perform the login operation
+	LastUsedTime time.Time `json:"last_used_time"`
+
+	// The remote address of the client that last used the SecretID to perform
+	// the login operation
+	LastUsedRemoteAddr string `json:"last_used_remote_addr"`
+}
+
+// secretIDUsageIndex returns the storage index of the usage entry of the
+// SecretID stored at the given index
+func secretIDUsageIndex(secretIDIndex string) string {
+	if strings.HasPrefix(secretIDIndex, secretIDLocalPrefix) {
+		return secretIDUsageLocalPrefix + strings.TrimPrefix(secretIDIndex, secretIDLocalPrefix)
+	}
+	return secretIDUsagePrefix + strings.TrimPrefix(secretIDIndex, secretIDPrefix)
+}
+
+// secretIDUsageEntry returns the usage entry of the SecretID stored at the
+// given index, or nil if the SecretID was never used to log in
+func (b *backend) secretIDUsageEntry(ctx context.Context, s logical.Storage, secretIDIndex string) (*secretIDUsageStorageEntry, error) {
+	entry, err := s.Get(ctx, secretIDUsageIndex(secretIDIndex))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var result secretIDUsageStorageEntry
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// recordSecretIDUsage updates the usage entry of the SecretID stored at the
+// given index with the details of the login request. The entry is written at
+// most once per secretIDUsageWriteInterval for a given client address, and
+// failures are only logged since they should never fail the login: the
+// storage may be read-only, as on a performance standby. The SecretID lock is
+// held for reading while checking that the SecretID still exists, so that an
+// entry isn't recreated for a SecretID destroyed since the login.
+func (b *backend) recordSecretIDUsage(ctx context.Context, req *logical.Request, secretIDHMAC, secretIDIndex string) {
+	var remoteAddr string
+	if req.Connection != nil {
+		remoteAddr = req.Connection.RemoteAddr
+	}
+
+	lock := b.secretIDLock(secretIDHMAC)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	secretIDEntry, err := req.Storage.Get(ctx, secretIDIndex)
+	if err != nil {
+		b.Logger().Warn("failed to read secret ID", "error", err)
+		return
+	}
+	if secretIDEntry == nil {
+		return
+	}
+
+	usage, err := b.secretIDUsageEntry(ctx, req.Storage, secretIDIndex)
+	if err != nil {
+		b.Logger().Warn("failed to read secret ID usage", "error", err)
+		return
+	}
+	now := time.Now()
+	if usage != nil && usage.LastUsedRemoteAddr == remoteAddr && now.Sub(usage.LastUsedTime) < secretIDUsageWriteInterval {
+		return
+	}
+
+	entry, err := logical.StorageEntryJSON(secretIDUsageIndex(secretIDIndex), &secretIDUsageStorageEntry{
+		LastUsedTime:       now,
+		LastUsedRemoteAddr: remoteAddr,
+	})
+	if err != nil {
+		b.Logger().Warn("failed to encode secret ID usage", "error", err)
+		return
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		b.Logger().Debug("failed to record secret ID usage", "error", err)
+	}
+}
+
+// deleteSecretIDUsageEntry deletes the usage entry of the SecretID stored at
+// the given index
+func (b *backend) deleteSecretIDUsageEntry(ctx context.Context, s logical.Storage, secretIDIndex string) error {
+	if err := s.Delete(ctx, secretIDUsageIndex(secretIDIndex)); err != nil {
+		return errwrap.Wrapf("failed to delete secret ID usage entry: {{err}}", err)
+	}
+	return nil
+}
+
+// validateSecretIDWrapping checks that a request to issue a SecretID asks for
+// response wrapping within the bounds set on the role, if the role requires it
+func validateSecretIDWrapping(role *roleStorageEntry, wrapInfo *logical.RequestWrapInfo) error {
+	if !role.SecretIDWrappingRequired {
+		return nil
+	}
+
+	if wrapInfo == nil || wrapInfo.TTL == 0 {
+		return fmt.Errorf("response wrapping is required to issue a secret_id against role %q", role.name)
+	}
+	if role.SecretIDWrappingMinTTL != 0 && wrapInfo.TTL < role.SecretIDWrappingMinTTL {
+		return fmt.Errorf("wrapping TTL %q is less than the minimum of %q allowed by role %q", wrapInfo.TTL, role.SecretIDWrappingMinTTL, role.name)
+	}
+	if role.SecretIDWrappingMaxTTL != 0 && wrapInfo.TTL > role.SecretIDWrappingMaxTTL {
+		return fmt.Errorf("wrapping TTL %q is greater than the maximum of %q allowed by role %q", wrapInfo.TTL, role.SecretIDWrappingMaxTTL, role.name)
+	}
+
+	return nil
+}
+
 // verifyCIDRRoleSecretIDSubset checks if the CIDR blocks set on the secret ID
 // are a subset of CIDR blocks set on the role
 func verifyCIDRRoleSecretIDSubset(secretIDCIDRs []string, roleBoundCIDRList []string) error {
@@ -384,6 +502,10 @@ func (b *backend) flushRoleSecrets(ctx context.Context, s logical.Storage, roleN
 			lock.Unlock()
 			return errwrap.Wrapf(fmt.Sprintf("error deleting SecretID %q from storage: {{err}}", secretIDHMAC), err)
 		}
+		if err := b.deleteSecretIDUsageEntry(ctx, s, entryIndex); err != nil {
+			lock.Unlock()
+			return err
+		}
 		lock.Unlock()
 	}
 	return nil
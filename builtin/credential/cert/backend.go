@@ -4,9 +4,11 @@ import (
 	"context"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
+	cache "github.com/patrickmn/go-cache"
 )
 
 func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend, error) {
@@ -39,6 +41,7 @@ func Backend() *backend {
 	}
 
 	b.crlUpdateMutex = &sync.RWMutex{}
+	b.revocationCache = cache.New(cache.NoExpiration, 5*time.Minute)
 
 	return &b
 }
@@ -49,6 +52,10 @@ type backend struct {
 
 	crls           map[string]CRLInfo
 	crlUpdateMutex *sync.RWMutex
+
+	// revocationCache holds OCSP responses and CRLs fetched from
+	// distribution points, each expiring when it is no longer fresh
+	revocationCache *cache.Cache
 }
 
 func (b *backend) invalidate(_ context.Context, key string) {
@@ -57,6 +64,8 @@ func (b *backend) invalidate(_ context.Context, key string) {
 		b.crlUpdateMutex.Lock()
 		defer b.crlUpdateMutex.Unlock()
 		b.crls = nil
+	case key == "config":
+		b.revocationCache.Flush()
 	}
 }
 
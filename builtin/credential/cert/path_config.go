@@ -2,12 +2,15 @@ package cert
 
 import (
 	"context"
+	"time"
 
 	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
+const defaultRevocationRequestTimeout = 10 * time.Second
+
 func pathConfig(b *backend) *framework.Path {
 	return &framework.Path{
 		Pattern: "config",
@@ -17,19 +20,52 @@ func pathConfig(b *backend) *framework.Path {
 				Default:     false,
 				Description: `If set, during renewal, skips the matching of presented client identity with the client identity used during login. Defaults to false.`,
 			},
+
+			"enable_ocsp": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Default:     false,
+				Description: `If set, the revocation status of the client certificate chain is checked against the OCSP responders listed in the certificates. Defaults to false.`,
+			},
+
+			"enable_crl_distribution_points": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Default:     false,
+				Description: `If set, CRLs are fetched from the distribution points listed in the client certificate chain and checked during login. Defaults to false.`,
+			},
+
+			"revocation_fail_open": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Default:     false,
+				Description: `If set, logins are allowed when the revocation status of a certificate cannot be determined from its OCSP responders or CRL distribution points. Defaults to false.`,
+			},
+
+			"revocation_max_staleness": &framework.FieldSchema{
+				Type:        framework.TypeDurationSecond,
+				Description: `The maximum age of an OCSP response or fetched CRL, measured from its issue time, that is accepted and kept in the cache. If unset, responses are used until their next update time.`,
+			},
+
+			"revocation_request_timeout": &framework.FieldSchema{
+				Type:        framework.TypeDurationSecond,
+				Default:     int(defaultRevocationRequestTimeout.Seconds()),
+				Description: `The timeout for requests made to OCSP responders and CRL distribution points. Defaults to 10 seconds.`,
+			},
 		},
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
 			logical.UpdateOperation: b.pathConfigWrite,
+			logical.ReadOperation:   b.pathConfigRead,
 		},
 	}
 }
 
 func (b *backend) pathConfigWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	disableBinding := data.Get("disable_binding").(bool)
-
 	entry, err := logical.StorageEntryJSON("config", config{
-		DisableBinding: disableBinding,
+		DisableBinding:              data.Get("disable_binding").(bool),
+		EnableOCSP:                  data.Get("enable_ocsp").(bool),
+		EnableCRLDistributionPoints: data.Get("enable_crl_distribution_points").(bool),
+		RevocationFailOpen:          data.Get("revocation_fail_open").(bool),
+		RevocationMaxStaleness:      time.Duration(data.Get("revocation_max_staleness").(int)) * time.Second,
+		RevocationRequestTimeout:    time.Duration(data.Get("revocation_request_timeout").(int)) * time.Second,
 	})
 	if err != nil {
 		return nil, err
@@ -38,9 +74,32 @@ func (b *backend) pathConfigWrite(ctx context.Context, req *logical.Request, dat
 	if err := req.Storage.Put(ctx, entry); err != nil {
 		return nil, err
 	}
+
+	// Cached revocation results may have been gathered under a different
+	// staleness limit, so start over
+	b.revocationCache.Flush()
+
 	return nil, nil
 }
 
+func (b *backend) pathConfigRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	cfg, err := b.Config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"disable_binding":                cfg.DisableBinding,
+			"enable_ocsp":                    cfg.EnableOCSP,
+			"enable_crl_distribution_points": cfg.EnableCRLDistributionPoints,
+			"revocation_fail_open":           cfg.RevocationFailOpen,
+			"revocation_max_staleness":       int64(cfg.RevocationMaxStaleness.Seconds()),
+			"revocation_request_timeout":     int64(cfg.revocationRequestTimeout().Seconds()),
+		},
+	}, nil
+}
+
 // Config returns the configuration for this backend.
 func (b *backend) Config(ctx context.Context, s logical.Storage) (*config, error) {
 	entry, err := s.Get(ctx, "config")
@@ -59,5 +118,23 @@ func (b *backend) Config(ctx context.Context, s logical.Storage) (*config, error
 }
 
 type config struct {
-	DisableBinding bool `json:"disable_binding"`
+	DisableBinding              bool          `json:"disable_binding"`
+	EnableOCSP                  bool          `json:"enable_ocsp"`
+	EnableCRLDistributionPoints bool          `json:"enable_crl_distribution_points"`
+	RevocationFailOpen          bool          `json:"revocation_fail_open"`
+	RevocationMaxStaleness      time.Duration `json:"revocation_max_staleness"`
+	RevocationRequestTimeout    time.Duration `json:"revocation_request_timeout"`
+}
+
+// remoteRevocationEnabled returns whether any revocation source beyond the
+// manually uploaded CRLs should be consulted during login.
+func (c *config) remoteRevocationEnabled() bool {
+	return c.EnableOCSP || c.EnableCRLDistributionPoints
+}
+
+func (c *config) revocationRequestTimeout() time.Duration {
+	if c.RevocationRequestTimeout <= 0 {
+		return defaultRevocationRequestTimeout
+	}
+	return c.RevocationRequestTimeout
 }
@@ -188,6 +188,11 @@ func (b *backend) verifyCredentials(ctx context.Context, req *logical.Request, d
 		certName = d.Get("name").(string)
 	}
 
+	config, err := b.Config(ctx, req.Storage)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	// Load the trusted certificates
 	roots, trusted, trustedNonCAs := b.loadTrustedCerts(ctx, req.Storage, certName)
 
@@ -198,15 +203,26 @@ func (b *backend) verifyCredentials(ctx context.Context, req *logical.Request, d
 		return nil, nil, err
 	}
 
+	// Drop any chains revoked according to the OCSP responders or CRL
+	// distribution points listed in the certificates
+	if config.remoteRevocationEnabled() {
+		trustedChains = b.filterRemotelyRevokedChains(ctx, config, trustedChains)
+	}
+
 	// If trustedNonCAs is not empty it means that client had registered a non-CA cert
 	// with the backend.
 	if len(trustedNonCAs) != 0 {
+		var trustedCerts []*x509.Certificate
+		for _, trust := range trusted {
+			trustedCerts = append(trustedCerts, trust.Certificates...)
+		}
 		for _, trustedNonCA := range trustedNonCAs {
 			tCert := trustedNonCA.Certificates[0]
 			// Check for client cert being explicitly listed in the config (and matching other constraints)
 			if tCert.SerialNumber.Cmp(clientCert.SerialNumber) == 0 &&
 				bytes.Equal(tCert.AuthorityKeyId, clientCert.AuthorityKeyId) &&
-				b.matchesConstraints(clientCert, trustedNonCA.Certificates, trustedNonCA) {
+				b.matchesConstraints(clientCert, trustedNonCA.Certificates, trustedNonCA) &&
+				!b.checkForLeafRemotelyRevoked(ctx, config, connState.PeerCertificates, trustedCerts) {
 				return trustedNonCA, nil, nil
 			}
 		}
@@ -0,0 +1,336 @@
+package cert
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	cleanhttp "github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/go-multierror"
+	"golang.org/x/crypto/ocsp"
+)
+
+// maxRevocationResponseSize caps the size of OCSP responses and CRLs read
+// from remote servers.
+const maxRevocationResponseSize = 32 * 1024 * 1024
+
+type revocationStatus int
+
+const (
+	revocationStatusUnknown revocationStatus = iota
+	revocationStatusGood
+	revocationStatusRevoked
+)
+
+// crlDistributionPointEntry is the cached form of a CRL fetched from a
+// distribution point.
+type crlDistributionPointEntry struct {
+	Serials map[string]struct{}
+}
+
+// filterRemotelyRevokedChains returns the chains in which no certificate has
+// been reported revoked by its OCSP responders or CRL distribution points.
+func (b *backend) filterRemotelyRevokedChains(ctx context.Context, cfg *config, chains [][]*x509.Certificate) [][]*x509.Certificate {
+	var ret [][]*x509.Certificate
+	for _, chain := range chains {
+		if !b.checkForChainRemotelyRevoked(ctx, cfg, chain) {
+			ret = append(ret, chain)
+		}
+	}
+	return ret
+}
+
+// checkForChainRemotelyRevoked checks every certificate in the chain that is
+// followed by its issuer against the OCSP responders and CRL distribution
+// points it lists. A certificate whose status cannot be determined marks the
+// chain as revoked unless the backend is configured to fail open.
+func (b *backend) checkForChainRemotelyRevoked(ctx context.Context, cfg *config, chain []*x509.Certificate) bool {
+	if !cfg.remoteRevocationEnabled() {
+		return false
+	}
+
+	for i := 0; i < len(chain)-1; i++ {
+		cert, issuer := chain[i], chain[i+1]
+		if cert.CheckSignatureFrom(issuer) != nil {
+			continue
+		}
+
+		status, err := b.remoteRevocationStatus(ctx, cfg, cert, issuer)
+		switch status {
+		case revocationStatusRevoked:
+			b.Logger().Debug("certificate has been revoked", "serial_number", cert.SerialNumber.String())
+			return true
+		case revocationStatusUnknown:
+			if cfg.RevocationFailOpen {
+				b.Logger().Warn("unable to determine certificate revocation status, allowing", "serial_number", cert.SerialNumber.String(), "error", err)
+				continue
+			}
+			b.Logger().Warn("unable to determine certificate revocation status, denying", "serial_number", cert.SerialNumber.String(), "error", err)
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkForLeafRemotelyRevoked checks a client certificate trusted on its own,
+// rather than through a verified chain, and the chain the client presented.
+// The issuer of the certificate is looked up among the presented and trusted
+// certificates; if it is not found, the status of a certificate listing an
+// enabled revocation source cannot be determined, which denies the login
+// unless the backend is configured to fail open.
+func (b *backend) checkForLeafRemotelyRevoked(ctx context.Context, cfg *config, presented []*x509.Certificate, candidates []*x509.Certificate) bool {
+	if !cfg.remoteRevocationEnabled() || len(presented) == 0 {
+		return false
+	}
+	if b.checkForChainRemotelyRevoked(ctx, cfg, presented) {
+		return true
+	}
+
+	leaf := presented[0]
+	if len(presented) > 1 && leaf.CheckSignatureFrom(presented[1]) == nil {
+		// Checked along with the presented chain
+		return false
+	}
+
+	issuers := append(append([]*x509.Certificate(nil), presented[1:]...), candidates...)
+	for _, issuer := range issuers {
+		if leaf.CheckSignatureFrom(issuer) == nil {
+			return b.checkForChainRemotelyRevoked(ctx, cfg, []*x509.Certificate{leaf, issuer})
+		}
+	}
+
+	if !hasRemoteRevocationSource(cfg, leaf) {
+		return false
+	}
+	if cfg.RevocationFailOpen {
+		b.Logger().Warn("unable to determine certificate revocation status without its issuer, allowing", "serial_number", leaf.SerialNumber.String())
+		return false
+	}
+	b.Logger().Warn("unable to determine certificate revocation status without its issuer, denying", "serial_number", leaf.SerialNumber.String())
+	return true
+}
+
+// hasRemoteRevocationSource returns whether the certificate lists any of the
+// enabled revocation sources
+func hasRemoteRevocationSource(cfg *config, cert *x509.Certificate) bool {
+	return (cfg.EnableOCSP && len(cert.OCSPServer) > 0) ||
+		(cfg.EnableCRLDistributionPoints && len(cert.CRLDistributionPoints) > 0)
+}
+
+// remoteRevocationStatus consults the enabled revocation sources for a
+// certificate in turn, returning the first definitive answer. Certificates
+// that do not list any enabled source are considered good.
+func (b *backend) remoteRevocationStatus(ctx context.Context, cfg *config, cert, issuer *x509.Certificate) (revocationStatus, error) {
+	var retErr *multierror.Error
+	consulted := false
+
+	if cfg.EnableOCSP && len(cert.OCSPServer) > 0 {
+		consulted = true
+		status, err := b.ocspStatus(ctx, cfg, cert, issuer)
+		if err == nil {
+			return status, nil
+		}
+		retErr = multierror.Append(retErr, err)
+	}
+
+	if cfg.EnableCRLDistributionPoints && len(cert.CRLDistributionPoints) > 0 {
+		consulted = true
+		status, err := b.crlDistributionPointStatus(ctx, cfg, cert, issuer)
+		if err == nil {
+			return status, nil
+		}
+		retErr = multierror.Append(retErr, err)
+	}
+
+	if !consulted {
+		return revocationStatusGood, nil
+	}
+	return revocationStatusUnknown, retErr.ErrorOrNil()
+}
+
+// ocspStatus returns the status of the certificate as reported by the first
+// of its OCSP responders to give a valid answer.
+func (b *backend) ocspStatus(ctx context.Context, cfg *config, cert, issuer *x509.Certificate) (revocationStatus, error) {
+	cacheKey := "ocsp/" + issuerHash(issuer) + "/" + cert.SerialNumber.String()
+	if cached, ok := b.revocationCache.Get(cacheKey); ok {
+		return cached.(revocationStatus), nil
+	}
+
+	ocspReq, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return revocationStatusUnknown, errwrap.Wrapf("error creating OCSP request: {{err}}", err)
+	}
+
+	var retErr *multierror.Error
+	for _, server := range cert.OCSPServer {
+		body, err := b.fetchRevocationData(ctx, cfg, http.MethodPost, server, "application/ocsp-request", ocspReq)
+		if err != nil {
+			retErr = multierror.Append(retErr, err)
+			continue
+		}
+
+		ocspResp, err := ocsp.ParseResponseForCert(body, cert, issuer)
+		if err != nil {
+			retErr = multierror.Append(retErr, errwrap.Wrapf(fmt.Sprintf("error parsing OCSP response from %q: {{err}}", server), err))
+			continue
+		}
+
+		var status revocationStatus
+		switch ocspResp.Status {
+		case ocsp.Good:
+			status = revocationStatusGood
+		case ocsp.Revoked:
+			status = revocationStatusRevoked
+		default:
+			retErr = multierror.Append(retErr, fmt.Errorf("OCSP responder %q does not know the certificate", server))
+			continue
+		}
+
+		ttl, err := revocationCacheTTL(cfg, ocspResp.ThisUpdate, ocspResp.NextUpdate)
+		if err != nil {
+			retErr = multierror.Append(retErr, errwrap.Wrapf(fmt.Sprintf("OCSP response from %q: {{err}}", server), err))
+			continue
+		}
+		if ttl > 0 {
+			b.revocationCache.Set(cacheKey, status, ttl)
+		}
+		return status, nil
+	}
+
+	return revocationStatusUnknown, retErr.ErrorOrNil()
+}
+
+// crlDistributionPointStatus returns the status of the certificate according
+// to the first of its CRL distribution points that serves a valid CRL signed
+// by the issuer.
+func (b *backend) crlDistributionPointStatus(ctx context.Context, cfg *config, cert, issuer *x509.Certificate) (revocationStatus, error) {
+	var retErr *multierror.Error
+	for _, dp := range cert.CRLDistributionPoints {
+		u, err := url.Parse(dp)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			retErr = multierror.Append(retErr, fmt.Errorf("unsupported CRL distribution point %q", dp))
+			continue
+		}
+
+		entry, err := b.crlDistributionPoint(ctx, cfg, dp, issuer)
+		if err != nil {
+			retErr = multierror.Append(retErr, err)
+			continue
+		}
+
+		if _, ok := entry.Serials[cert.SerialNumber.String()]; ok {
+			return revocationStatusRevoked, nil
+		}
+		return revocationStatusGood, nil
+	}
+
+	return revocationStatusUnknown, retErr.ErrorOrNil()
+}
+
+// crlDistributionPoint returns the CRL published at the given distribution
+// point, fetching it if there is no fresh copy in the cache.
+func (b *backend) crlDistributionPoint(ctx context.Context, cfg *config, dp string, issuer *x509.Certificate) (*crlDistributionPointEntry, error) {
+	cacheKey := "crl/" + issuerHash(issuer) + "/" + dp
+	if cached, ok := b.revocationCache.Get(cacheKey); ok {
+		return cached.(*crlDistributionPointEntry), nil
+	}
+
+	body, err := b.fetchRevocationData(ctx, cfg, http.MethodGet, dp, "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	certList, err := x509.ParseCRL(body)
+	if err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("error parsing CRL from %q: {{err}}", dp), err)
+	}
+	if err := issuer.CheckCRLSignature(certList); err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("error verifying CRL from %q: {{err}}", dp), err)
+	}
+
+	ttl, err := revocationCacheTTL(cfg, certList.TBSCertList.ThisUpdate, certList.TBSCertList.NextUpdate)
+	if err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("CRL from %q: {{err}}", dp), err)
+	}
+
+	entry := &crlDistributionPointEntry{
+		Serials: make(map[string]struct{}, len(certList.TBSCertList.RevokedCertificates)),
+	}
+	for _, revokedCert := range certList.TBSCertList.RevokedCertificates {
+		entry.Serials[revokedCert.SerialNumber.String()] = struct{}{}
+	}
+
+	if ttl > 0 {
+		b.revocationCache.Set(cacheKey, entry, ttl)
+	}
+	return entry, nil
+}
+
+func (b *backend) fetchRevocationData(ctx context.Context, cfg *config, method, target, contentType string, body []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.revocationRequestTimeout())
+	defer cancel()
+
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("error creating request for %q: {{err}}", target), err)
+	}
+	req = req.WithContext(ctx)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := cleanhttp.DefaultClient().Do(req)
+	if err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("error contacting %q: {{err}}", target), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d from %q", resp.StatusCode, target)
+	}
+
+	ret, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxRevocationResponseSize))
+	if err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("error reading response from %q: {{err}}", target), err)
+	}
+	return ret, nil
+}
+
+// revocationCacheTTL returns how long a revocation response issued at
+// thisUpdate may be relied on, taking into account both its own nextUpdate
+// and the configured max staleness. A zero duration means the response may be
+// used once but not cached.
+func revocationCacheTTL(cfg *config, thisUpdate, nextUpdate time.Time) (time.Duration, error) {
+	expiry := nextUpdate
+	if cfg.RevocationMaxStaleness > 0 {
+		limit := thisUpdate.Add(cfg.RevocationMaxStaleness)
+		if expiry.IsZero() || limit.Before(expiry) {
+			expiry = limit
+		}
+	}
+	if expiry.IsZero() {
+		return 0, nil
+	}
+
+	ttl := time.Until(expiry)
+	if ttl <= 0 {
+		return 0, errors.New("response is stale")
+	}
+	return ttl, nil
+}
+
+func issuerHash(issuer *x509.Certificate) string {
+	sum := sha256.Sum256(issuer.Raw)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,397 @@
+package cert
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/crypto/ocsp"
+)
+
+// testRevocationResponder is a local stand-in for an OCSP responder and a CRL
+// distribution point for certificates issued by a single CA.
+type testRevocationResponder struct {
+	caCert *x509.Certificate
+	caKey  crypto.Signer
+	server *httptest.Server
+
+	l          sync.Mutex
+	status     int
+	thisUpdate time.Time
+	nextUpdate time.Time
+	fail       bool
+	revoked    []*big.Int
+
+	ocspRequests uint32
+	crlRequests  uint32
+}
+
+func (r *testRevocationResponder) set(status int, thisUpdate, nextUpdate time.Time) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.status = status
+	r.thisUpdate = thisUpdate
+	r.nextUpdate = nextUpdate
+}
+
+func (r *testRevocationResponder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	if r.fail {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	switch req.URL.Path {
+	case "/ocsp":
+		atomic.AddUint32(&r.ocspRequests, 1)
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ocspReq, err := ocsp.ParseRequest(body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		template := ocsp.Response{
+			Status:       r.status,
+			SerialNumber: ocspReq.SerialNumber,
+			ThisUpdate:   r.thisUpdate,
+			NextUpdate:   r.nextUpdate,
+		}
+		if r.status == ocsp.Revoked {
+			template.RevokedAt = r.thisUpdate
+		}
+		resp, err := ocsp.CreateResponse(r.caCert, r.caCert, template, r.caKey)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		w.Write(resp)
+
+	case "/crl":
+		atomic.AddUint32(&r.crlRequests, 1)
+		var revoked []pkix.RevokedCertificate
+		for _, serial := range r.revoked {
+			revoked = append(revoked, pkix.RevokedCertificate{
+				SerialNumber:   serial,
+				RevocationTime: r.thisUpdate,
+			})
+		}
+		crl, err := r.caCert.CreateCRL(rand.Reader, r.caKey, revoked, r.thisUpdate, r.nextUpdate)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(crl)
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// testRevocationSetup creates a backend trusting a fresh CA, a responder for
+// that CA and a client certificate pointing at the responder. The caller is
+// responsible for closing the responder's server.
+func testRevocationSetup(t *testing.T) (logical.Backend, logical.Storage, *testRevocationResponder, *x509.Certificate, tls.ConnectionState) {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Revocation Test CA"},
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caBytes, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(caBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	responder := &testRevocationResponder{
+		caCert:     caCert,
+		caKey:      caKey,
+		status:     ocsp.Good,
+		thisUpdate: time.Now().Add(-time.Minute),
+	}
+	responder.server = httptest.NewServer(responder)
+	server := responder.server
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientTemplate := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "client.example.com"},
+		SerialNumber:          big.NewInt(2),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		OCSPServer:            []string{server.URL + "/ocsp"},
+		CRLDistributionPoints: []string{server.URL + "/crl"},
+	}
+	clientBytes, err := x509.CreateCertificate(rand.Reader, clientTemplate, caCert, clientKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCert, err := x509.ParseCertificate(clientBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage := &logical.InmemStorage{}
+	config := logical.TestBackendConfig()
+	config.StorageView = storage
+	b, err := Factory(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "certs/ca",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"certificate": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caBytes})),
+			"policies":    "foo",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+
+	connState := tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{clientCert, caCert},
+	}
+	return b, storage, responder, clientCert, connState
+}
+
+func testRevocationConfig(t *testing.T, b logical.Backend, storage logical.Storage, data map[string]interface{}) {
+	t.Helper()
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data:      data,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+}
+
+func testRevocationLogin(t *testing.T, b logical.Backend, storage logical.Storage, connState tls.ConnectionState, expectSuccess bool) {
+	t.Helper()
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "login",
+		Storage:   storage,
+		Connection: &logical.Connection{
+			ConnState: &connState,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	success := resp != nil && !resp.IsError() && resp.Auth != nil
+	if success != expectSuccess {
+		t.Fatalf("expected login success to be %t, got resp:%#v", expectSuccess, resp)
+	}
+}
+
+func TestBackend_OCSP(t *testing.T) {
+	b, storage, responder, _, connState := testRevocationSetup(t)
+	defer responder.server.Close()
+
+	// Without OCSP enabled the responder must not be consulted
+	responder.set(ocsp.Revoked, time.Now().Add(-time.Minute), time.Time{})
+	testRevocationLogin(t, b, storage, connState, true)
+	if n := atomic.LoadUint32(&responder.ocspRequests); n != 0 {
+		t.Fatalf("expected no OCSP requests, got %d", n)
+	}
+
+	testRevocationConfig(t, b, storage, map[string]interface{}{
+		"enable_ocsp": true,
+	})
+	testRevocationLogin(t, b, storage, connState, false)
+
+	// Responses without a next update are not cached
+	responder.set(ocsp.Good, time.Now().Add(-time.Minute), time.Time{})
+	testRevocationLogin(t, b, storage, connState, true)
+
+	// Responses with a next update are cached until then
+	responder.set(ocsp.Good, time.Now().Add(-time.Minute), time.Now().Add(time.Hour))
+	testRevocationLogin(t, b, storage, connState, true)
+	before := atomic.LoadUint32(&responder.ocspRequests)
+	responder.set(ocsp.Revoked, time.Now().Add(-time.Minute), time.Now().Add(time.Hour))
+	testRevocationLogin(t, b, storage, connState, true)
+	if n := atomic.LoadUint32(&responder.ocspRequests); n != before {
+		t.Fatalf("expected cached OCSP response to be used, got %d new requests", n-before)
+	}
+
+	// Writing the config flushes the cache
+	testRevocationConfig(t, b, storage, map[string]interface{}{
+		"enable_ocsp": true,
+	})
+	testRevocationLogin(t, b, storage, connState, false)
+}
+
+func TestBackend_OCSP_MaxStaleness(t *testing.T) {
+	b, storage, responder, _, connState := testRevocationSetup(t)
+	defer responder.server.Close()
+
+	testRevocationConfig(t, b, storage, map[string]interface{}{
+		"enable_ocsp":              true,
+		"revocation_max_staleness": "1h",
+	})
+
+	responder.set(ocsp.Good, time.Now().Add(-2*time.Hour), time.Now().Add(time.Hour))
+	testRevocationLogin(t, b, storage, connState, false)
+
+	responder.set(ocsp.Good, time.Now().Add(-time.Minute), time.Now().Add(time.Hour))
+	testRevocationLogin(t, b, storage, connState, true)
+}
+
+func TestBackend_OCSP_FailOpen(t *testing.T) {
+	b, storage, responder, _, connState := testRevocationSetup(t)
+	defer responder.server.Close()
+
+	responder.l.Lock()
+	responder.fail = true
+	responder.l.Unlock()
+
+	testRevocationConfig(t, b, storage, map[string]interface{}{
+		"enable_ocsp": true,
+	})
+	testRevocationLogin(t, b, storage, connState, false)
+
+	testRevocationConfig(t, b, storage, map[string]interface{}{
+		"enable_ocsp":          true,
+		"revocation_fail_open": true,
+	})
+	testRevocationLogin(t, b, storage, connState, true)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config",
+		Storage:   storage,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+	if !resp.Data["revocation_fail_open"].(bool) {
+		t.Fatalf("expected revocation_fail_open to be set, got %#v", resp.Data)
+	}
+}
+
+func TestBackend_CRLDistributionPoints(t *testing.T) {
+	b, storage, responder, clientCert, connState := testRevocationSetup(t)
+	defer responder.server.Close()
+
+	testRevocationConfig(t, b, storage, map[string]interface{}{
+		"enable_crl_distribution_points": true,
+	})
+
+	responder.set(ocsp.Good, time.Now().Add(-time.Minute), time.Now().Add(time.Hour))
+	testRevocationLogin(t, b, storage, connState, true)
+
+	// The CRL is cached until its next update
+	responder.l.Lock()
+	responder.revoked = []*big.Int{clientCert.SerialNumber}
+	responder.l.Unlock()
+	testRevocationLogin(t, b, storage, connState, true)
+	if n := atomic.LoadUint32(&responder.crlRequests); n != 1 {
+		t.Fatalf("expected a single CRL request, got %d", n)
+	}
+
+	testRevocationConfig(t, b, storage, map[string]interface{}{
+		"enable_crl_distribution_points": true,
+	})
+	testRevocationLogin(t, b, storage, connState, false)
+
+	// OCSP takes precedence when both sources are enabled
+	testRevocationConfig(t, b, storage, map[string]interface{}{
+		"enable_ocsp":                    true,
+		"enable_crl_distribution_points": true,
+	})
+	testRevocationLogin(t, b, storage, connState, true)
+}
+
+func TestBackend_OCSP_NonCALeafOnly(t *testing.T) {
+	b, storage, responder, clientCert, _ := testRevocationSetup(t)
+	defer responder.server.Close()
+
+	// Trust the client certificate on its own rather than through its CA
+	for _, req := range []*logical.Request{
+		{
+			Operation: logical.DeleteOperation,
+			Path:      "certs/ca",
+		},
+		{
+			Operation: logical.UpdateOperation,
+			Path:      "certs/leaf",
+			Data: map[string]interface{}{
+				"certificate": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: clientCert.Raw})),
+				"policies":    "foo",
+			},
+		},
+	} {
+		req.Storage = storage
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%v resp:%#v", err, resp)
+		}
+	}
+
+	testRevocationConfig(t, b, storage, map[string]interface{}{
+		"enable_ocsp": true,
+	})
+
+	// Without its issuer the status of the certificate can't be checked, so
+	// the login is denied unless the backend fails open
+	leafOnly := tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{clientCert},
+	}
+	testRevocationLogin(t, b, storage, leafOnly, false)
+
+	testRevocationConfig(t, b, storage, map[string]interface{}{
+		"enable_ocsp":          true,
+		"revocation_fail_open": true,
+	})
+	testRevocationLogin(t, b, storage, leafOnly, true)
+
+	// With its issuer, a revoked certificate is denied even when failing open
+	responder.set(ocsp.Revoked, time.Now().Add(-time.Minute), time.Time{})
+	testRevocationLogin(t, b, storage, tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{clientCert, responder.caCert},
+	}, false)
+}
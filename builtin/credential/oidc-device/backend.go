@@ -0,0 +1,179 @@
+package oidcdevice
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc"
+	"github.com/hashicorp/errwrap"
+	cleanhttp "github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	cache "github.com/patrickmn/go-cache"
+)
+
+func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend, error) {
+	b := Backend()
+	if err := b.Setup(ctx, conf); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func Backend() *backend {
+	var b backend
+	b.providerCtx, b.providerCtxCancel = context.WithCancel(context.Background())
+	b.deviceCodes = cache.New(defaultDeviceCodeTTL, time.Minute)
+
+	b.Backend = &framework.Backend{
+		Help: backendHelp,
+
+		PathsSpecial: &logical.Paths{
+			Unauthenticated: []string{
+				"login",
+				"device/code",
+			},
+			SealWrapStorage: []string{
+				"config",
+			},
+		},
+
+		Paths: []*framework.Path{
+			pathConfig(&b),
+			pathRoleList(&b),
+			pathRole(&b),
+			pathDeviceCode(&b),
+			pathLogin(&b),
+		},
+		AuthRenew:   b.pathLoginRenew,
+		Invalidate:  b.invalidate,
+		Clean:       b.cleanup,
+		BackendType: logical.TypeCredential,
+	}
+
+	return &b
+}
+
+type backend struct {
+	*framework.Backend
+
+	l        sync.RWMutex
+	provider *oidc.Provider
+	client   *http.Client
+
+	// providerCtx is used by the provider to fetch signing keys after it has
+	// been created, so it lives as long as the backend does
+	providerCtx       context.Context
+	providerCtxCancel context.CancelFunc
+
+	// deviceCodes maps the hash of each pending device code to the role it
+	// was requested for, until it expires or is used to log in
+	deviceCodes *cache.Cache
+}
+
+func (b *backend) cleanup(_ context.Context) {
+	b.l.Lock()
+	defer b.l.Unlock()
+	if b.providerCtxCancel != nil {
+		b.providerCtxCancel()
+	}
+}
+
+func (b *backend) invalidate(_ context.Context, key string) {
+	switch key {
+	case "config":
+		b.reset()
+	}
+}
+
+// reset drops the cached provider so that it is recreated from the current
+// configuration on next use.
+func (b *backend) reset() {
+	b.l.Lock()
+	defer b.l.Unlock()
+	b.provider = nil
+	b.client = nil
+}
+
+// getProvider returns the OIDC provider described by the configuration along
+// with the HTTP client to use when talking to it, creating them if needed.
+func (b *backend) getProvider(config *config) (*oidc.Provider, *http.Client, error) {
+	b.l.RLock()
+	unlockFunc := b.l.RUnlock
+	defer func() { unlockFunc() }()
+
+	if b.provider != nil {
+		return b.provider, b.client, nil
+	}
+
+	b.l.RUnlock()
+	b.l.Lock()
+	unlockFunc = b.l.Unlock
+
+	if b.provider != nil {
+		return b.provider, b.client, nil
+	}
+
+	provider, client, err := b.createProvider(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	b.provider = provider
+	b.client = client
+	return provider, client, nil
+}
+
+func (b *backend) createProvider(config *config) (*oidc.Provider, *http.Client, error) {
+	client, err := createHTTPClient(config.OIDCDiscoveryCAPEM)
+	if err != nil {
+		return nil, nil, errwrap.Wrapf("error creating provider: {{err}}", err)
+	}
+
+	ctx := oidc.ClientContext(b.providerCtx, client)
+	provider, err := oidc.NewProvider(ctx, config.OIDCDiscoveryURL)
+	if err != nil {
+		return nil, nil, errwrap.Wrapf("error creating provider with given values: {{err}}", err)
+	}
+
+	return provider, client, nil
+}
+
+// createHTTPClient returns an HTTP client trusting the root certificates in
+// caPEM, or the system roots if it is empty.
+func createHTTPClient(caPEM string) (*http.Client, error) {
+	tr := cleanhttp.DefaultPooledTransport()
+	if caPEM != "" {
+		certPool := x509.NewCertPool()
+		if ok := certPool.AppendCertsFromPEM([]byte(caPEM)); !ok {
+			return nil, errors.New("could not parse CA PEM value successfully")
+		}
+		tr.TLSClientConfig = &tls.Config{
+			RootCAs: certPool,
+		}
+	}
+
+	return &http.Client{
+		Transport: tr,
+	}, nil
+}
+
+const backendHelp = `
+The "oidc-device" credential provider allows authentication using the OAuth 2.0
+device authorization grant (RFC 8628) against an OpenID Connect provider.
+
+It is intended for users on machines without a browser, such as jump hosts.
+A client first requests a device code using the "device/code" endpoint, then
+asks the user to visit the returned verification URI on any device and enter
+the user code. Meanwhile the client polls the "login" endpoint with the device
+code until the user has approved the request, at which point the ID token
+issued by the provider is verified and mapped to a role.
+
+After enabling the credential provider, use the "config" route to configure
+the provider and the "role" routes to define how tokens are issued.
+`
@@ -0,0 +1,503 @@
+package oidcdevice
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	testClientID     = "vault-client"
+	testClientSecret = "vault-secret"
+	testDeviceCode   = "device-code-1234"
+	testUserCode     = "WDJB-MJHT"
+)
+
+// testProvider is a local OIDC provider implementing discovery, key
+// publication, device authorization and the device code grant.
+type testProvider struct {
+	t      *testing.T
+	key    *rsa.PrivateKey
+	server *httptest.Server
+
+	l sync.Mutex
+	// pending is the number of token requests that will be answered with
+	// authorization_pending before the request is approved
+	pending int
+	// tokenError, if set, is returned by the token endpoint instead
+	tokenError string
+	claims     map[string]interface{}
+	scopes     string
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &testProvider{
+		t:   t,
+		key: key,
+		claims: map[string]interface{}{
+			"sub":    "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6",
+			"email":  "bob@example.com",
+			"groups": []string{"engineering", "admins"},
+		},
+	}
+	p.server = httptest.NewServer(p)
+	return p
+}
+
+func (p *testProvider) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+
+	switch req.URL.Path {
+	case "/.well-known/openid-configuration":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                p.server.URL,
+			"authorization_endpoint":                p.server.URL + "/auth",
+			"token_endpoint":                        p.server.URL + "/token",
+			"jwks_uri":                              p.server.URL + "/certs",
+			"device_authorization_endpoint":         p.server.URL + "/device",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+
+	case "/certs":
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{
+			Keys: []jose.JSONWebKey{
+				{
+					Key:       &p.key.PublicKey,
+					KeyID:     "test-key",
+					Algorithm: string(jose.RS256),
+					Use:       "sig",
+				},
+			},
+		})
+
+	case "/device":
+		if !p.authenticated(w, req) {
+			return
+		}
+		p.scopes = req.PostForm.Get("scope")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"device_code":      testDeviceCode,
+			"user_code":        testUserCode,
+			"verification_uri": p.server.URL + "/activate",
+			"expires_in":       1800,
+		})
+
+	case "/token":
+		if !p.authenticated(w, req) {
+			return
+		}
+		if req.PostForm.Get("grant_type") != deviceCodeGrantType || req.PostForm.Get("device_code") != testDeviceCode {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		if p.tokenError != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": p.tokenError})
+			return
+		}
+		if p.pending > 0 {
+			p.pending--
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": errAuthorizationPending})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access-token",
+			"token_type":   "Bearer",
+			"id_token":     p.idToken(),
+		})
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (p *testProvider) authenticated(w http.ResponseWriter, req *http.Request) bool {
+	if err := req.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return false
+	}
+	if req.PostForm.Get("client_id") != testClientID || req.PostForm.Get("client_secret") != testClientSecret {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		return false
+	}
+	return true
+}
+
+func (p *testProvider) idToken() string {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: p.key}, (&jose.SignerOptions{}).WithHeader("kid", "test-key"))
+	if err != nil {
+		p.t.Fatal(err)
+	}
+
+	now := time.Now()
+	std := jwt.Claims{
+		Issuer:   p.server.URL,
+		Audience: jwt.Audience{testClientID},
+		IssuedAt: jwt.NewNumericDate(now),
+		Expiry:   jwt.NewNumericDate(now.Add(5 * time.Minute)),
+	}
+	token, err := jwt.Signed(signer).Claims(std).Claims(p.claims).CompactSerialize()
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	return token
+}
+
+func testBackendWithProvider(t *testing.T, p *testProvider) (logical.Backend, logical.Storage) {
+	t.Helper()
+
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+	b, err := Factory(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testRequest(t, b, config.StorageView, logical.UpdateOperation, "config", map[string]interface{}{
+		"oidc_discovery_url": p.server.URL,
+		"oidc_client_id":     testClientID,
+		"oidc_client_secret": testClientSecret,
+		"default_role":       "engineering",
+	})
+	testRequest(t, b, config.StorageView, logical.CreateOperation, "role/engineering", map[string]interface{}{
+		"user_claim":     "email",
+		"groups_claim":   "groups",
+		"oidc_scopes":    "email,groups",
+		"bound_claims":   map[string]interface{}{"groups": "engineering"},
+		"claim_mappings": map[string]string{"sub": "subject"},
+		"token_policies": "dev",
+		"token_ttl":      "1h",
+	})
+
+	return b, config.StorageView
+}
+
+func testRequest(t *testing.T, b logical.Backend, s logical.Storage, op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+	t.Helper()
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: op,
+		Path:      path,
+		Storage:   s,
+		Data:      data,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+	return resp
+}
+
+func testLogin(t *testing.T, b logical.Backend, s logical.Storage, data map[string]interface{}) *logical.Response {
+	t.Helper()
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "login",
+		Storage:   s,
+		Data:      data,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil {
+		t.Fatal("expected a response")
+	}
+	return resp
+}
+
+func TestConfig(t *testing.T) {
+	p := newTestProvider(t)
+	defer p.server.Close()
+
+	b, s := testBackendWithProvider(t, p)
+
+	resp := testRequest(t, b, s, logical.ReadOperation, "config", nil)
+	expected := map[string]interface{}{
+		"oidc_discovery_url":       p.server.URL,
+		"oidc_discovery_ca_pem":    "",
+		"oidc_client_id":           testClientID,
+		"device_authorization_url": "",
+		"default_role":             "engineering",
+	}
+	if !reflect.DeepEqual(resp.Data, expected) {
+		t.Fatalf("expected %#v, got %#v", expected, resp.Data)
+	}
+
+	// A provider that cannot be discovered is rejected
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"oidc_discovery_url": p.server.URL + "/missing",
+			"oidc_client_id":     testClientID,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected error, got %#v", resp)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	p := newTestProvider(t)
+	defer p.server.Close()
+
+	b, s := testBackendWithProvider(t, p)
+
+	resp := testRequest(t, b, s, logical.UpdateOperation, "device/code", nil)
+	if resp.Data["device_code"] != testDeviceCode || resp.Data["user_code"] != testUserCode {
+		t.Fatalf("unexpected device code response: %#v", resp.Data)
+	}
+	if resp.Data["verification_uri"] != p.server.URL+"/activate" {
+		t.Fatalf("unexpected verification URI: %#v", resp.Data)
+	}
+	if resp.Data["interval"] != 5 {
+		t.Fatalf("expected default interval, got %#v", resp.Data["interval"])
+	}
+	if p.scopes != "openid email groups" {
+		t.Fatalf("unexpected scopes requested: %q", p.scopes)
+	}
+
+	p.l.Lock()
+	p.pending = 1
+	p.l.Unlock()
+
+	data := map[string]interface{}{
+		"device_code": testDeviceCode,
+	}
+	resp = testLogin(t, b, s, data)
+	if !resp.IsError() || resp.Data["error"] != errAuthorizationPending {
+		t.Fatalf("expected authorization_pending, got %#v", resp)
+	}
+
+	resp = testLogin(t, b, s, data)
+	if resp.IsError() || resp.Auth == nil {
+		t.Fatalf("expected successful login, got %#v", resp)
+	}
+
+	auth := resp.Auth
+
+	// The device code can't be used again
+	resp = testLogin(t, b, s, data)
+	if !resp.IsError() || resp.Data["error"] != "device code not found or expired" {
+		t.Fatalf("expected the device code to be consumed, got %#v", resp)
+	}
+
+	if auth.Alias.Name != "bob@example.com" || auth.DisplayName != "bob@example.com" {
+		t.Fatalf("unexpected alias: %#v", auth.Alias)
+	}
+	if !reflect.DeepEqual(auth.Policies, []string{"dev"}) {
+		t.Fatalf("unexpected policies: %#v", auth.Policies)
+	}
+	if auth.TTL != time.Hour {
+		t.Fatalf("unexpected TTL: %v", auth.TTL)
+	}
+	expectedMetadata := map[string]string{
+		"role":    "engineering",
+		"subject": "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6",
+	}
+	if !reflect.DeepEqual(auth.Metadata, expectedMetadata) {
+		t.Fatalf("expected metadata %#v, got %#v", expectedMetadata, auth.Metadata)
+	}
+	var groups []string
+	for _, alias := range auth.GroupAliases {
+		groups = append(groups, alias.Name)
+	}
+	if !reflect.DeepEqual(groups, []string{"engineering", "admins"}) {
+		t.Fatalf("unexpected group aliases: %#v", groups)
+	}
+
+	// Renewal succeeds while the role is unchanged. Core populates the token
+	// policies before renewing.
+	auth.TokenPolicies = auth.Policies
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RenewOperation,
+		Path:      "login",
+		Storage:   s,
+		Auth:      auth,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+}
+
+func TestLogin_Errors(t *testing.T) {
+	p := newTestProvider(t)
+	defer p.server.Close()
+
+	b, s := testBackendWithProvider(t, p)
+
+	cases := map[string]struct {
+		setup    func()
+		data     map[string]interface{}
+		expected string
+	}{
+		"missing device code": {
+			data:     map[string]interface{}{},
+			expected: "missing device_code",
+		},
+		"unknown device code": {
+			data:     map[string]interface{}{"device_code": "unknown-device-code"},
+			expected: "device code not found or expired",
+		},
+		"different role": {
+			data:     map[string]interface{}{"device_code": testDeviceCode, "role": "missing"},
+			expected: `device code was not requested for role "missing"`,
+		},
+		"slow down": {
+			setup:    func() { p.tokenError = errSlowDown },
+			data:     map[string]interface{}{"device_code": testDeviceCode},
+			expected: errSlowDown,
+		},
+		"denied": {
+			setup:    func() { p.tokenError = "access_denied" },
+			data:     map[string]interface{}{"device_code": testDeviceCode},
+			expected: "device authorization failed: access_denied",
+		},
+		"bound claims": {
+			setup: func() {
+				p.claims["groups"] = []string{"sales"}
+			},
+			data:     map[string]interface{}{"device_code": testDeviceCode},
+			expected: `error validating claims: claim "groups" does not match any associated bound claim values`,
+		},
+		"missing user claim": {
+			setup: func() {
+				delete(p.claims, "email")
+			},
+			data:     map[string]interface{}{"device_code": testDeviceCode},
+			expected: `claim "email" not found in token or is not a string`,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			p.l.Lock()
+			p.tokenError = ""
+			p.claims = map[string]interface{}{
+				"sub":    "r3qXcK2bix9eFECzsU3Sbmh0K16fatW6",
+				"email":  "bob@example.com",
+				"groups": []string{"engineering"},
+			}
+			if tc.setup != nil {
+				tc.setup()
+			}
+			p.l.Unlock()
+
+			testRequest(t, b, s, logical.UpdateOperation, "device/code", nil)
+			resp := testLogin(t, b, s, tc.data)
+			if !resp.IsError() {
+				t.Fatalf("expected error, got %#v", resp)
+			}
+			if resp.Data["error"] != tc.expected {
+				t.Fatalf("expected error %q, got %q", tc.expected, resp.Data["error"])
+			}
+		})
+	}
+}
+
+func TestCLIHandler(t *testing.T) {
+	p := newTestProvider(t)
+	defer p.server.Close()
+	p.pending = 2
+
+	b, s := testBackendWithProvider(t, p)
+
+	// Serve the backend the way Vault would so that the handler can be
+	// exercised through the API client
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var data map[string]interface{}
+		if err := json.NewDecoder(req.Body).Decode(&data); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      strings.TrimPrefix(req.URL.Path, "/v1/auth/oidc-device/"),
+			Storage:   s,
+			Data:      data,
+		})
+		switch {
+		case err != nil:
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{err.Error()}})
+		case resp.IsError():
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{resp.Data["error"].(string)}})
+		case resp.Auth != nil:
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"auth": map[string]interface{}{
+					"client_token": "test-token",
+					"policies":     resp.Auth.Policies,
+				},
+			})
+		default:
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": resp.Data})
+		}
+	}))
+	defer vault.Close()
+
+	client, err := api.NewClient(&api.Config{Address: vault.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var output bytes.Buffer
+	var sleeps []time.Duration
+	h := &CLIHandler{
+		output: &output,
+		sleep:  func(d time.Duration) { sleeps = append(sleeps, d) },
+	}
+
+	secret, err := h.Auth(client, map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Auth == nil || secret.Auth.ClientToken != "test-token" {
+		t.Fatalf("unexpected secret: %#v", secret)
+	}
+
+	if !strings.Contains(output.String(), testUserCode) || !strings.Contains(output.String(), p.server.URL+"/activate") {
+		t.Fatalf("expected user code and verification URI in output, got %q", output.String())
+	}
+	expected := []time.Duration{5 * time.Second, 5 * time.Second, 5 * time.Second}
+	if !reflect.DeepEqual(sleeps, expected) {
+		t.Fatalf("expected polling intervals %v, got %v", expected, sleeps)
+	}
+
+	// Denied logins stop polling
+	p.l.Lock()
+	p.tokenError = "access_denied"
+	p.l.Unlock()
+	if _, err := h.Auth(client, map[string]string{"role": "engineering"}); err == nil || !strings.Contains(err.Error(), "access_denied") {
+		t.Fatalf("expected access_denied error, got %v", err)
+	}
+}
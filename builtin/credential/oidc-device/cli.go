@@ -0,0 +1,174 @@
+package oidcdevice
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/mitchellh/mapstructure"
+)
+
+const (
+	// defaultMount is the default mount path of the auth method
+	defaultMount = "oidc-device"
+
+	// defaultPollInterval is used when the provider does not specify how
+	// often the token endpoint may be polled
+	defaultPollInterval = 5 * time.Second
+
+	// slowDownIncrement is added to the polling interval every time the
+	// provider asks the client to slow down, per RFC 8628 section 3.5
+	slowDownIncrement = 5 * time.Second
+)
+
+// CLIHandler struct
+type CLIHandler struct {
+	// output is where the verification instructions are written; it
+	// defaults to stderr
+	output io.Writer
+
+	// sleep waits between polls; it is replaced in tests
+	sleep func(time.Duration)
+}
+
+type deviceCodeResponse struct {
+	DeviceCode              string `mapstructure:"device_code"`
+	UserCode                string `mapstructure:"user_code"`
+	VerificationURI         string `mapstructure:"verification_uri"`
+	VerificationURIComplete string `mapstructure:"verification_uri_complete"`
+	ExpiresIn               int    `mapstructure:"expires_in"`
+	Interval                int    `mapstructure:"interval"`
+}
+
+// Auth cli method
+func (h *CLIHandler) Auth(c *api.Client, m map[string]string) (*api.Secret, error) {
+	output := h.output
+	if output == nil {
+		output = os.Stderr
+	}
+	sleep := h.sleep
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+
+	mount, ok := m["mount"]
+	if !ok {
+		mount = defaultMount
+	}
+	role := m["role"]
+
+	secret, err := c.Logical().Write(fmt.Sprintf("auth/%s/device/code", mount), map[string]interface{}{
+		"role": role,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return nil, errors.New("empty response from credential provider")
+	}
+
+	var code deviceCodeResponse
+	if err := mapstructure.WeakDecode(secret.Data, &code); err != nil {
+		return nil, err
+	}
+	if code.DeviceCode == "" {
+		return nil, errors.New("credential provider did not return a device code")
+	}
+
+	if code.VerificationURIComplete != "" {
+		fmt.Fprintf(output, "Complete the login by visiting the URL below and confirming\nthe code %s:\n\n    %s\n\n", code.UserCode, code.VerificationURIComplete)
+	} else {
+		fmt.Fprintf(output, "Complete the login by visiting the URL below and entering\nthe code %s:\n\n    %s\n\n", code.UserCode, code.VerificationURI)
+	}
+	fmt.Fprintf(output, "Waiting for the login to be approved...\n\n")
+
+	interval := time.Duration(code.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+
+	var deadline time.Time
+	if code.ExpiresIn > 0 {
+		deadline = time.Now().Add(time.Duration(code.ExpiresIn) * time.Second)
+	}
+
+	data := map[string]interface{}{
+		"role":        role,
+		"device_code": code.DeviceCode,
+	}
+	path := fmt.Sprintf("auth/%s/login", mount)
+	for {
+		sleep(interval)
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return nil, errors.New("the device code expired before the login was approved")
+		}
+
+		secret, err := c.Logical().Write(path, data)
+		switch {
+		case err == nil:
+			if secret == nil {
+				return nil, errors.New("empty response from credential provider")
+			}
+			return secret, nil
+		case isResponseError(err, errAuthorizationPending):
+		case isResponseError(err, errSlowDown):
+			interval += slowDownIncrement
+		default:
+			return nil, err
+		}
+	}
+}
+
+// isResponseError returns whether err is an error response from Vault
+// consisting of the given message.
+func isResponseError(err error, msg string) bool {
+	respErr, ok := err.(*api.ResponseError)
+	if !ok {
+		return false
+	}
+	for _, e := range respErr.Errors {
+		if e == msg {
+			return true
+		}
+	}
+	return false
+}
+
+// Help method for oidc-device cli
+func (h *CLIHandler) Help() string {
+	help := `
+Usage: vault login -method=oidc-device [CONFIG K=V...]
+
+  The OIDC device auth method allows users to authenticate using an OIDC
+  provider's device authorization grant. This is useful on machines without a
+  browser, such as jump hosts: the CLI prints a URL and a code, which the user
+  enters in a browser on any other device. The CLI waits until the login has
+  been approved.
+
+  Authenticate using role "engineering":
+
+      $ vault login -method=oidc-device role=engineering
+      Complete the login by visiting the URL below and entering
+      the code WDJB-MJHT:
+
+          https://example.com/device
+
+Configuration:
+
+  mount=<string>
+      Path where the OIDC device auth method is mounted. This is usually
+      provided via the -path flag in the "vault login" command, but it can be
+      specified here as well. If specified here, it takes precedence over the
+      value for -path. The default value is "oidc-device".
+
+  role=<string>
+      Vault role to use for authentication. If not specified,
+      the default role configured on the auth method is used.
+`
+
+	return strings.TrimSpace(help)
+}
@@ -0,0 +1,195 @@
+package oidcdevice
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathConfig(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config",
+		Fields: map[string]*framework.FieldSchema{
+			"oidc_discovery_url": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: `OIDC Discovery URL, without any .well-known component (base path).`,
+			},
+			"oidc_discovery_ca_pem": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "The CA certificate or chain of certificates, in PEM format, to use to validate connections to the OIDC Discovery URL. If not set, system certificates are used.",
+			},
+			"oidc_client_id": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "The OAuth Client ID configured with your OIDC provider.",
+			},
+			"oidc_client_secret": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "The OAuth Client Secret configured with your OIDC provider, if it is a confidential client.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Sensitive: true,
+				},
+			},
+			"device_authorization_url": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "The device authorization endpoint of the provider. If not set, the device_authorization_endpoint advertised by OIDC Discovery is used.",
+			},
+			"default_role": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "The default role to use if none is provided during login.",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathConfigWrite,
+			logical.ReadOperation:   b.pathConfigRead,
+		},
+
+		HelpSynopsis:    pathConfigHelpSyn,
+		HelpDescription: pathConfigHelpDesc,
+	}
+}
+
+func (b *backend) pathConfigWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	c, err := b.Config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		c = &config{}
+	}
+
+	if discoveryURLRaw, ok := data.GetOk("oidc_discovery_url"); ok {
+		c.OIDCDiscoveryURL = discoveryURLRaw.(string)
+	}
+	if caPEMRaw, ok := data.GetOk("oidc_discovery_ca_pem"); ok {
+		c.OIDCDiscoveryCAPEM = caPEMRaw.(string)
+	}
+	if clientIDRaw, ok := data.GetOk("oidc_client_id"); ok {
+		c.OIDCClientID = clientIDRaw.(string)
+	}
+	if clientSecretRaw, ok := data.GetOk("oidc_client_secret"); ok {
+		c.OIDCClientSecret = clientSecretRaw.(string)
+	}
+	if deviceAuthURLRaw, ok := data.GetOk("device_authorization_url"); ok {
+		c.DeviceAuthorizationURL = deviceAuthURLRaw.(string)
+	}
+	if defaultRoleRaw, ok := data.GetOk("default_role"); ok {
+		c.DefaultRole = defaultRoleRaw.(string)
+	}
+
+	switch {
+	case c.OIDCDiscoveryURL == "":
+		return logical.ErrorResponse("missing oidc_discovery_url"), nil
+	case c.OIDCClientID == "":
+		return logical.ErrorResponse("missing oidc_client_id"), nil
+	}
+
+	// Validate the configuration by talking to the provider
+	provider, _, err := b.createProvider(c)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if c.DeviceAuthorizationURL == "" {
+		endpoint, err := discoveredDeviceAuthorizationURL(provider)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		if endpoint == "" {
+			return logical.ErrorResponse("provider does not advertise a device_authorization_endpoint; device_authorization_url must be set"), nil
+		}
+	}
+
+	entry, err := logical.StorageEntryJSON("config", c)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	b.reset()
+
+	return nil, nil
+}
+
+func (b *backend) pathConfigRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, err := b.Config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"oidc_discovery_url":       config.OIDCDiscoveryURL,
+			"oidc_discovery_ca_pem":    config.OIDCDiscoveryCAPEM,
+			"oidc_client_id":           config.OIDCClientID,
+			"device_authorization_url": config.DeviceAuthorizationURL,
+			"default_role":             config.DefaultRole,
+		},
+	}, nil
+}
+
+// Config returns the configuration for this backend.
+func (b *backend) Config(ctx context.Context, s logical.Storage) (*config, error) {
+	entry, err := s.Get(ctx, "config")
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var result config
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, errwrap.Wrapf("error reading configuration: {{err}}", err)
+	}
+	return &result, nil
+}
+
+// deviceAuthorizationURL returns the endpoint at which device codes are
+// requested, preferring the configured value over the discovered one.
+func (b *backend) deviceAuthorizationURL(config *config) (string, error) {
+	if config.DeviceAuthorizationURL != "" {
+		return config.DeviceAuthorizationURL, nil
+	}
+
+	provider, _, err := b.getProvider(config)
+	if err != nil {
+		return "", err
+	}
+	endpoint, err := discoveredDeviceAuthorizationURL(provider)
+	if err != nil {
+		return "", err
+	}
+	if endpoint == "" {
+		return "", fmt.Errorf("provider does not advertise a device_authorization_endpoint")
+	}
+	return endpoint, nil
+}
+
+type config struct {
+	OIDCDiscoveryURL       string `json:"oidc_discovery_url"`
+	OIDCDiscoveryCAPEM     string `json:"oidc_discovery_ca_pem"`
+	OIDCClientID           string `json:"oidc_client_id"`
+	OIDCClientSecret       string `json:"oidc_client_secret"`
+	DeviceAuthorizationURL string `json:"device_authorization_url"`
+	DefaultRole            string `json:"default_role"`
+}
+
+const pathConfigHelpSyn = `
+Configures the OIDC provider used for the device authorization grant.
+`
+
+const pathConfigHelpDesc = `
+The OIDC provider is located using OIDC Discovery. The provider must support
+the device authorization grant, either advertising its device authorization
+endpoint in its discovery document or having it set explicitly using
+"device_authorization_url". The configured client ID is used both to request
+device codes and as the expected audience of the ID tokens it issues.
+`
@@ -0,0 +1,518 @@
+package oidcdevice
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc"
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/policyutil"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// deviceCodeGrantType is the grant type used to exchange a device code
+	// for tokens, as defined by RFC 8628
+	deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+	// errAuthorizationPending and errSlowDown are the token endpoint errors
+	// that tell the client to keep polling. They are passed through to the
+	// client verbatim.
+	errAuthorizationPending = "authorization_pending"
+	errSlowDown             = "slow_down"
+
+	// maxProviderResponseSize caps the size of responses read from the
+	// provider's endpoints
+	maxProviderResponseSize = 1024 * 1024
+
+	// defaultDeviceCodeTTL is how long a device code is kept pending when
+	// the provider doesn't say when it expires
+	defaultDeviceCodeTTL = 15 * time.Minute
+)
+
+func pathDeviceCode(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "device/code",
+		Fields: map[string]*framework.FieldSchema{
+			"role": &framework.FieldSchema{
+				Type:        framework.TypeLowerCaseString,
+				Description: "The role to log in against.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathDeviceCode,
+		},
+
+		HelpSynopsis:    pathDeviceCodeHelpSyn,
+		HelpDescription: pathDeviceCodeHelpDesc,
+	}
+}
+
+func pathLogin(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "login",
+		Fields: map[string]*framework.FieldSchema{
+			"role": &framework.FieldSchema{
+				Type:        framework.TypeLowerCaseString,
+				Description: "The role to log in against.",
+			},
+			"device_code": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "The device code returned by the device/code endpoint.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathLogin,
+		},
+
+		HelpSynopsis:    pathLoginHelpSyn,
+		HelpDescription: pathLoginHelpDesc,
+	}
+}
+
+type deviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+
+	// Some providers predate RFC 8628 and use this name instead
+	VerificationURL string `json:"verification_url"`
+}
+
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// loginState is the configuration and role a device code request or login
+// is made against.
+type loginState struct {
+	config   *config
+	role     *roleEntry
+	roleName string
+}
+
+func (b *backend) loginState(ctx context.Context, req *logical.Request, roleName string) (*loginState, *logical.Response, error) {
+	config, err := b.Config(ctx, req.Storage)
+	if err != nil {
+		return nil, nil, err
+	}
+	if config == nil {
+		return nil, logical.ErrorResponse("could not load configuration"), nil
+	}
+
+	if roleName == "" {
+		roleName = config.DefaultRole
+	}
+	if roleName == "" {
+		return nil, logical.ErrorResponse("missing role"), nil
+	}
+
+	role, err := b.role(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if role == nil {
+		return nil, logical.ErrorResponse(fmt.Sprintf("role %q could not be found", roleName)), nil
+	}
+
+	return &loginState{
+		config:   config,
+		role:     role,
+		roleName: roleName,
+	}, nil, nil
+}
+
+// deviceCodeKey is the key a pending device code is cached under. The code
+// itself is a bearer credential, so only its hash is kept.
+func deviceCodeKey(deviceCode string) string {
+	sum := sha256.Sum256([]byte(deviceCode))
+	return hex.EncodeToString(sum[:])
+}
+
+func (b *backend) pathDeviceCode(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	state, errResp, err := b.loginState(ctx, req, data.Get("role").(string))
+	if errResp != nil || err != nil {
+		return errResp, err
+	}
+
+	endpoint, err := b.deviceAuthorizationURL(state.config)
+	if err != nil {
+		return nil, err
+	}
+	_, client, err := b.getProvider(state.config)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := append([]string{oidc.ScopeOpenID}, state.role.OIDCScopes...)
+	form := url.Values{
+		"scope": {strings.Join(strutil.RemoveDuplicatesStable(scopes, false), " ")},
+	}
+
+	var authzResp deviceAuthorizationResponse
+	var errorResp tokenResponse
+	ok, err := postForm(ctx, client, endpoint, state.config, form, &authzResp, &errorResp)
+	if err != nil {
+		return nil, errwrap.Wrapf("error requesting device code: {{err}}", err)
+	}
+	if !ok {
+		return logical.ErrorResponse(fmt.Sprintf("provider rejected device code request: %s", describeProviderError(&errorResp))), nil
+	}
+	if authzResp.DeviceCode == "" || authzResp.UserCode == "" {
+		return nil, errors.New("provider returned an incomplete device authorization response")
+	}
+
+	verificationURI := authzResp.VerificationURI
+	if verificationURI == "" {
+		verificationURI = authzResp.VerificationURL
+	}
+
+	// RFC 8628 section 3.2 defaults the polling interval to 5 seconds
+	interval := authzResp.Interval
+	if interval <= 0 {
+		interval = 5
+	}
+
+	// The device code may only be used to log in against the role whose
+	// scopes it was requested with
+	ttl := defaultDeviceCodeTTL
+	if authzResp.ExpiresIn > 0 {
+		ttl = time.Duration(authzResp.ExpiresIn) * time.Second
+	}
+	b.deviceCodes.Set(deviceCodeKey(authzResp.DeviceCode), state.roleName, ttl)
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"device_code":               authzResp.DeviceCode,
+			"user_code":                 authzResp.UserCode,
+			"verification_uri":          verificationURI,
+			"verification_uri_complete": authzResp.VerificationURIComplete,
+			"expires_in":                authzResp.ExpiresIn,
+			"interval":                  interval,
+		},
+	}, nil
+}
+
+func (b *backend) pathLogin(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	deviceCode := data.Get("device_code").(string)
+	if deviceCode == "" {
+		return logical.ErrorResponse("missing device_code"), nil
+	}
+
+	key := deviceCodeKey(deviceCode)
+	pending, found := b.deviceCodes.Get(key)
+	if !found {
+		return logical.ErrorResponse("device code not found or expired"), nil
+	}
+	roleName := data.Get("role").(string)
+	if roleName == "" {
+		roleName = pending.(string)
+	}
+	if roleName != pending.(string) {
+		return logical.ErrorResponse(fmt.Sprintf("device code was not requested for role %q", roleName)), nil
+	}
+
+	state, errResp, err := b.loginState(ctx, req, roleName)
+	if errResp != nil || err != nil {
+		return errResp, err
+	}
+
+	provider, client, err := b.getProvider(state.config)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":  {deviceCodeGrantType},
+		"device_code": {deviceCode},
+	}
+
+	var tokenResp, errorResp tokenResponse
+	ok, err := postForm(ctx, client, provider.Endpoint().TokenURL, state.config, form, &tokenResp, &errorResp)
+	if err != nil {
+		return nil, errwrap.Wrapf("error exchanging device code: {{err}}", err)
+	}
+	if !ok {
+		switch errorResp.Error {
+		case errAuthorizationPending, errSlowDown:
+			return logical.ErrorResponse(errorResp.Error), nil
+		}
+		return logical.ErrorResponse(fmt.Sprintf("device authorization failed: %s", describeProviderError(&errorResp))), nil
+	}
+	b.deviceCodes.Delete(key)
+	if tokenResp.IDToken == "" {
+		return logical.ErrorResponse("provider did not return an ID token"), nil
+	}
+
+	verifier := provider.Verifier(&oidc.Config{
+		ClientID: state.config.OIDCClientID,
+	})
+	idToken, err := verifier.Verify(oidc.ClientContext(ctx, client), tokenResp.IDToken)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("error validating ID token: %s", err.Error())), nil
+	}
+
+	allClaims := make(map[string]interface{})
+	if err := idToken.Claims(&allClaims); err != nil {
+		return nil, errwrap.Wrapf("error decoding ID token claims: {{err}}", err)
+	}
+
+	if err := validateBoundClaims(state.role.BoundClaims, allClaims); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("error validating claims: %s", err.Error())), nil
+	}
+
+	userName, ok := claimString(allClaims, state.role.UserClaim)
+	if !ok {
+		return logical.ErrorResponse(fmt.Sprintf("claim %q not found in token or is not a string", state.role.UserClaim)), nil
+	}
+
+	var groupAliases []*logical.Alias
+	if state.role.GroupsClaim != "" {
+		groups, err := claimStrings(allClaims, state.role.GroupsClaim)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		for _, group := range groups {
+			groupAliases = append(groupAliases, &logical.Alias{
+				Name: group,
+			})
+		}
+	}
+
+	metadata := map[string]string{
+		"role": state.roleName,
+	}
+	for claim, metadataKey := range state.role.ClaimMappings {
+		if value, ok := claimString(allClaims, claim); ok {
+			metadata[metadataKey] = value
+		}
+	}
+
+	auth := &logical.Auth{
+		InternalData: map[string]interface{}{
+			"role": state.roleName,
+		},
+		DisplayName: userName,
+		Metadata:    metadata,
+		Alias: &logical.Alias{
+			Name:     userName,
+			Metadata: metadata,
+		},
+		GroupAliases: groupAliases,
+	}
+	state.role.PopulateTokenAuth(auth)
+
+	return &logical.Response{
+		Auth: auth,
+	}, nil
+}
+
+func (b *backend) pathLoginRenew(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName, ok := req.Auth.InternalData["role"].(string)
+	if !ok || roleName == "" {
+		return nil, errors.New("failed to fetch role during renewal")
+	}
+
+	role, err := b.role(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, fmt.Errorf("role %q does not exist during renewal", roleName)
+	}
+
+	if !policyutil.EquivalentPolicies(role.TokenPolicies, req.Auth.TokenPolicies) {
+		return nil, errors.New("policies have changed, not renewing")
+	}
+
+	resp := &logical.Response{Auth: req.Auth}
+	resp.Auth.TTL = role.TokenTTL
+	resp.Auth.MaxTTL = role.TokenMaxTTL
+	resp.Auth.Period = role.TokenPeriod
+	return resp, nil
+}
+
+// postForm posts the form to the given provider endpoint, authenticating as
+// the configured client. On success the response is decoded into ret and true
+// is returned; if the provider answers with an OAuth error it is decoded into
+// errRet and false is returned.
+func postForm(ctx context.Context, client *http.Client, endpoint string, config *config, form url.Values, ret, errRet interface{}) (bool, error) {
+	form.Set("client_id", config.OIDCClientID)
+	if config.OIDCClientSecret != "" {
+		form.Set("client_secret", config.OIDCClientSecret)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxProviderResponseSize))
+	if err != nil {
+		return false, err
+	}
+
+	if resp.StatusCode == http.StatusOK {
+		if err := json.Unmarshal(body, ret); err != nil {
+			return false, errwrap.Wrapf("error decoding provider response: {{err}}", err)
+		}
+		return true, nil
+	}
+
+	if err := json.Unmarshal(body, errRet); err != nil {
+		return false, fmt.Errorf("unexpected status code %d from provider", resp.StatusCode)
+	}
+	return false, nil
+}
+
+func describeProviderError(resp *tokenResponse) string {
+	switch {
+	case resp.Error == "":
+		return "unknown error"
+	case resp.ErrorDescription == "":
+		return resp.Error
+	default:
+		return fmt.Sprintf("%s: %s", resp.Error, resp.ErrorDescription)
+	}
+}
+
+// discoveredDeviceAuthorizationURL returns the device authorization endpoint
+// advertised in the provider's discovery document, if any.
+func discoveredDeviceAuthorizationURL(provider *oidc.Provider) (string, error) {
+	var claims struct {
+		DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+	}
+	if err := provider.Claims(&claims); err != nil {
+		return "", errwrap.Wrapf("error reading provider discovery document: {{err}}", err)
+	}
+	return claims.DeviceAuthorizationEndpoint, nil
+}
+
+// validateBoundClaims checks that every bound claim is present in the token
+// with a matching value. A claim bound to a list matches any of its values.
+func validateBoundClaims(boundClaims, allClaims map[string]interface{}) error {
+	claims := make([]string, 0, len(boundClaims))
+	for claim := range boundClaims {
+		claims = append(claims, claim)
+	}
+	sort.Strings(claims)
+
+	for _, claim := range claims {
+		actual, ok := allClaims[claim]
+		if !ok {
+			return fmt.Errorf("claim %q is missing", claim)
+		}
+
+		var expected []interface{}
+		switch v := boundClaims[claim].(type) {
+		case []interface{}:
+			expected = v
+		default:
+			expected = []interface{}{v}
+		}
+
+		var actualValues []interface{}
+		switch v := actual.(type) {
+		case []interface{}:
+			actualValues = v
+		default:
+			actualValues = []interface{}{v}
+		}
+
+		if !anyMatch(expected, actualValues) {
+			return fmt.Errorf("claim %q does not match any associated bound claim values", claim)
+		}
+	}
+
+	return nil
+}
+
+func anyMatch(expected, actual []interface{}) bool {
+	for _, e := range expected {
+		for _, a := range actual {
+			if e == a {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// claimString returns the named claim if it is a string.
+func claimString(allClaims map[string]interface{}, claim string) (string, bool) {
+	value, ok := allClaims[claim].(string)
+	return value, ok && value != ""
+}
+
+// claimStrings returns the named claim as a list of strings. A missing claim
+// results in an empty list and a single string is treated as a list of one.
+func claimStrings(allClaims map[string]interface{}, claim string) ([]string, error) {
+	switch v := allClaims[claim].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		ret := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("claim %q contains a non-string value", claim)
+			}
+			ret = append(ret, s)
+		}
+		return ret, nil
+	default:
+		return nil, fmt.Errorf("claim %q is not a string or list of strings", claim)
+	}
+}
+
+const pathDeviceCodeHelpSyn = `
+Request a device code and user code from the provider.
+`
+
+const pathDeviceCodeHelpDesc = `
+Starts a device authorization grant against the configured provider, using
+the scopes of the given role. The user must visit the returned verification
+URI and enter the user code, while the client polls the "login" endpoint with
+the returned device code no more often than the returned interval. The device
+code can only be used to log in against the same role.
+`
+
+const pathLoginHelpSyn = `
+Authenticates using a device code once the user has approved it.
+`
+
+const pathLoginHelpDesc = `
+Exchanges the device code for an ID token, which is verified and mapped to a
+Vault token according to the given role. While the user has not yet approved
+the request, an error of "authorization_pending" is returned; "slow_down"
+means the client must increase its polling interval by 5 seconds.
+`
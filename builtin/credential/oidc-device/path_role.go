@@ -0,0 +1,255 @@
+package oidcdevice
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/helper/tokenutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const rolePrefix = "role/"
+
+func pathRoleList(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "role/?",
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: b.pathRoleList,
+		},
+
+		HelpSynopsis:    pathRoleListHelpSyn,
+		HelpDescription: pathRoleListHelpDesc,
+	}
+}
+
+func pathRole(b *backend) *framework.Path {
+	p := &framework.Path{
+		Pattern: "role/" + framework.GenericNameRegex("name"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Name of the role.",
+			},
+			"user_claim": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Default:     "sub",
+				Description: `The claim to use for the entity alias name. Defaults to "sub".`,
+			},
+			"groups_claim": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: `The claim to use for the group alias names, if any.`,
+			},
+			"bound_claims": &framework.FieldSchema{
+				Type:        framework.TypeMap,
+				Description: `Map of claims and values that must be present in the ID token. A claim may be bound to a single string or a list of strings, any of which must match.`,
+			},
+			"claim_mappings": &framework.FieldSchema{
+				Type:        framework.TypeKVPairs,
+				Description: `Mappings of claims (key) that will be copied to a metadata field (value).`,
+			},
+			"oidc_scopes": &framework.FieldSchema{
+				Type:        framework.TypeCommaStringSlice,
+				Description: `Comma-separated list of OIDC scopes to request in addition to "openid".`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.CreateOperation: b.pathRoleCreateUpdate,
+			logical.UpdateOperation: b.pathRoleCreateUpdate,
+			logical.ReadOperation:   b.pathRoleRead,
+			logical.DeleteOperation: b.pathRoleDelete,
+		},
+
+		ExistenceCheck: b.pathRoleExistenceCheck,
+
+		HelpSynopsis:    pathRoleHelpSyn,
+		HelpDescription: pathRoleHelpDesc,
+	}
+
+	tokenutil.AddTokenFields(p.Fields)
+	return p
+}
+
+type roleEntry struct {
+	tokenutil.TokenParams
+
+	UserClaim     string                 `json:"user_claim"`
+	GroupsClaim   string                 `json:"groups_claim"`
+	BoundClaims   map[string]interface{} `json:"bound_claims"`
+	ClaimMappings map[string]string      `json:"claim_mappings"`
+	OIDCScopes    []string               `json:"oidc_scopes"`
+}
+
+// role returns the named role, or nil if it does not exist.
+func (b *backend) role(ctx context.Context, s logical.Storage, name string) (*roleEntry, error) {
+	entry, err := s.Get(ctx, rolePrefix+strings.ToLower(name))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var role roleEntry
+	if err := entry.DecodeJSON(&role); err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("error reading role %q: {{err}}", name), err)
+	}
+	return &role, nil
+}
+
+func (b *backend) pathRoleExistenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
+	role, err := b.role(ctx, req.Storage, data.Get("name").(string))
+	if err != nil {
+		return false, err
+	}
+	return role != nil, nil
+}
+
+func (b *backend) pathRoleList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roles, err := req.Storage.List(ctx, rolePrefix)
+	if err != nil {
+		return nil, err
+	}
+	return logical.ListResponse(roles), nil
+}
+
+func (b *backend) pathRoleRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	role, err := b.role(ctx, req.Storage, data.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, nil
+	}
+
+	d := map[string]interface{}{
+		"user_claim":     role.UserClaim,
+		"groups_claim":   role.GroupsClaim,
+		"bound_claims":   role.BoundClaims,
+		"claim_mappings": role.ClaimMappings,
+		"oidc_scopes":    role.OIDCScopes,
+	}
+	role.PopulateTokenData(d)
+
+	return &logical.Response{
+		Data: d,
+	}, nil
+}
+
+func (b *backend) pathRoleDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	if name == "" {
+		return logical.ErrorResponse("missing name"), nil
+	}
+
+	if err := req.Storage.Delete(ctx, rolePrefix+strings.ToLower(name)); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (b *backend) pathRoleCreateUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	if name == "" {
+		return logical.ErrorResponse("missing name"), nil
+	}
+
+	role, err := b.role(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		if req.Operation == logical.UpdateOperation {
+			return nil, fmt.Errorf("role %q does not exist", name)
+		}
+		role = &roleEntry{
+			UserClaim: data.Get("user_claim").(string),
+		}
+	}
+
+	if err := role.ParseTokenFields(req, data); err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+
+	if userClaimRaw, ok := data.GetOk("user_claim"); ok {
+		role.UserClaim = userClaimRaw.(string)
+	}
+	if role.UserClaim == "" {
+		return logical.ErrorResponse("a user_claim is required"), nil
+	}
+
+	if groupsClaimRaw, ok := data.GetOk("groups_claim"); ok {
+		role.GroupsClaim = groupsClaimRaw.(string)
+	}
+
+	if boundClaimsRaw, ok := data.GetOk("bound_claims"); ok {
+		boundClaims := boundClaimsRaw.(map[string]interface{})
+		for claim, value := range boundClaims {
+			switch v := value.(type) {
+			case string:
+			case []interface{}:
+				for _, item := range v {
+					if _, ok := item.(string); !ok {
+						return logical.ErrorResponse(fmt.Sprintf("bound_claims value for %q must be a string or a list of strings", claim)), nil
+					}
+				}
+			default:
+				return logical.ErrorResponse(fmt.Sprintf("bound_claims value for %q must be a string or a list of strings", claim)), nil
+			}
+		}
+		role.BoundClaims = boundClaims
+	}
+
+	if claimMappingsRaw, ok := data.GetOk("claim_mappings"); ok {
+		claimMappings := claimMappingsRaw.(map[string]string)
+		targets := make(map[string]bool)
+		for _, metadataKey := range claimMappings {
+			if metadataKey == "role" {
+				return logical.ErrorResponse(`metadata key "role" is reserved and may not be a mapping destination`), nil
+			}
+			if targets[metadataKey] {
+				return logical.ErrorResponse(fmt.Sprintf("multiple keys are mapped to metadata key %q", metadataKey)), nil
+			}
+			targets[metadataKey] = true
+		}
+		role.ClaimMappings = claimMappings
+	}
+
+	if scopesRaw, ok := data.GetOk("oidc_scopes"); ok {
+		role.OIDCScopes = strutil.RemoveDuplicates(scopesRaw.([]string), false)
+	}
+
+	entry, err := logical.StorageEntryJSON(rolePrefix+strings.ToLower(name), role)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+const pathRoleListHelpSyn = `
+Lists all the roles registered with the backend.
+`
+
+const pathRoleListHelpDesc = `
+The list will contain the names of the roles.
+`
+
+const pathRoleHelpSyn = `
+Register a role with the backend.
+`
+
+const pathRoleHelpDesc = `
+A role defines the scopes requested from the provider when a device code is
+issued, the constraints the resulting ID token must satisfy, how its claims
+map to entity and group aliases and the properties of the Vault tokens
+issued on login.
+`
@@ -0,0 +1,72 @@
+package spiffe
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend, error) {
+	b := Backend()
+	if err := b.Setup(ctx, conf); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func Backend() *backend {
+	var b backend
+	b.bundles = make(map[string]*cachedBundle)
+
+	b.Backend = &framework.Backend{
+		Help: backendHelp,
+
+		PathsSpecial: &logical.Paths{
+			Unauthenticated: []string{
+				"login",
+			},
+		},
+
+		Paths: []*framework.Path{
+			pathTrustDomainList(&b),
+			pathTrustDomain(&b),
+			pathRoleList(&b),
+			pathRole(&b),
+			pathLogin(&b),
+		},
+		AuthRenew:   b.pathLoginRenew,
+		Invalidate:  b.invalidate,
+		BackendType: logical.TypeCredential,
+	}
+
+	return &b
+}
+
+type backend struct {
+	*framework.Backend
+
+	// bundles caches the parsed trust bundle of each trust domain, keyed by
+	// trust domain name
+	bundlesLock sync.RWMutex
+	bundles     map[string]*cachedBundle
+}
+
+func (b *backend) invalidate(_ context.Context, key string) {
+	if strings.HasPrefix(key, trustDomainPrefix) {
+		b.flushBundle(strings.TrimPrefix(key, trustDomainPrefix))
+	}
+}
+
+const backendHelp = `
+The "spiffe" credential provider allows workloads to authenticate using their
+SPIFFE identity, presented either as an X.509-SVID during the TLS handshake or
+as a JWT-SVID in the login request.
+
+SVIDs are validated against the trust bundle of their trust domain, which is
+configured using the "trust-domain" routes from inline data, a file on the
+Vault server or a SPIFFE bundle endpoint. Roles map SPIFFE ID globs to the
+properties of the issued tokens, and entity aliases are keyed by SPIFFE ID.
+`
@@ -0,0 +1,436 @@
+package spiffe
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// testAuthority issues X.509-SVIDs and JWT-SVIDs for a trust domain.
+type testAuthority struct {
+	t           *testing.T
+	trustDomain string
+	caCert      *x509.Certificate
+	caKey       crypto.Signer
+	jwtKey      crypto.Signer
+	jwtKeyID    string
+}
+
+func newTestAuthority(t *testing.T, trustDomain string) *testAuthority {
+	t.Helper()
+
+	caKey := testKey(t)
+	caTemplate := &x509.Certificate{
+		Subject:               pkix.Name{Organization: []string{"SPIFFE"}},
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		URIs:                  []*url.URL{{Scheme: "spiffe", Host: trustDomain}},
+	}
+	caBytes, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(caBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testAuthority{
+		t:           t,
+		trustDomain: trustDomain,
+		caCert:      caCert,
+		caKey:       caKey,
+		jwtKey:      testKey(t),
+		jwtKeyID:    "jwt-key-1",
+	}
+}
+
+func testKey(t *testing.T) crypto.Signer {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func (a *testAuthority) pemBundle() string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: a.caCert.Raw}))
+}
+
+func (a *testAuthority) spiffeBundle() []byte {
+	bundle, err := json.Marshal(jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{
+			{
+				Key:          a.caKey.Public(),
+				Certificates: []*x509.Certificate{a.caCert},
+				Use:          bundleUseX509SVID,
+			},
+			{
+				Key:   a.jwtKey.Public(),
+				KeyID: a.jwtKeyID,
+				Use:   bundleUseJWTSVID,
+			},
+		},
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return bundle
+}
+
+func (a *testAuthority) x509SVID(id string) *tls.ConnectionState {
+	a.t.Helper()
+
+	u, err := url.Parse(id)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	key := testKey(a.t)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		URIs:         []*url.URL{u},
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, a.caCert, key.Public(), a.caKey)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	return &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert},
+	}
+}
+
+func (a *testAuthority) jwtSVID(id string, audience []string, expiry time.Time) string {
+	a.t.Helper()
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: a.jwtKey}, (&jose.SignerOptions{}).WithHeader("kid", a.jwtKeyID))
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	token, err := jwt.Signed(signer).Claims(jwt.Claims{
+		Subject:  id,
+		Audience: audience,
+		IssuedAt: jwt.NewNumericDate(time.Now()),
+		Expiry:   jwt.NewNumericDate(expiry),
+	}).CompactSerialize()
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return token
+}
+
+func testBackend(t *testing.T) (*backend, logical.Storage) {
+	t.Helper()
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+	b := Backend()
+	if err := b.Setup(context.Background(), config); err != nil {
+		t.Fatal(err)
+	}
+	return b, config.StorageView
+}
+
+func testWrite(t *testing.T, b logical.Backend, s logical.Storage, path string, data map[string]interface{}) {
+	t.Helper()
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      path,
+		Storage:   s,
+		Data:      data,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+}
+
+func testLogin(t *testing.T, b logical.Backend, s logical.Storage, connState *tls.ConnectionState, data map[string]interface{}) *logical.Response {
+	t.Helper()
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "login",
+		Storage:   s,
+		Data:      data,
+		Connection: &logical.Connection{
+			ConnState: connState,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil {
+		t.Fatal("expected a response")
+	}
+	return resp
+}
+
+func testExpectLogin(t *testing.T, resp *logical.Response, role, spiffeID string) {
+	t.Helper()
+	if resp.IsError() || resp.Auth == nil {
+		t.Fatalf("expected successful login, got %#v", resp)
+	}
+	if resp.Auth.Alias.Name != spiffeID {
+		t.Fatalf("expected alias %q, got %q", spiffeID, resp.Auth.Alias.Name)
+	}
+	if resp.Auth.Metadata["role"] != role {
+		t.Fatalf("expected role %q, got %q", role, resp.Auth.Metadata["role"])
+	}
+}
+
+func testExpectError(t *testing.T, resp *logical.Response) {
+	t.Helper()
+	if !resp.IsError() {
+		t.Fatalf("expected error, got %#v", resp)
+	}
+}
+
+func TestLogin_X509SVID(t *testing.T) {
+	b, s := testBackend(t)
+	authority := newTestAuthority(t, "example.org")
+	other := newTestAuthority(t, "other.org")
+
+	testWrite(t, b, s, "trust-domain/example.org", map[string]interface{}{
+		"bundle": authority.pemBundle(),
+	})
+	testWrite(t, b, s, "role/prod", map[string]interface{}{
+		"spiffe_id_patterns": "spiffe://example.org/ns/prod/*",
+		"token_policies":     "prod",
+	})
+	testWrite(t, b, s, "role/web", map[string]interface{}{
+		"spiffe_id_patterns": "spiffe://example.org/ns/*/sa/web",
+		"token_policies":     "web",
+	})
+
+	webID := "spiffe://example.org/ns/prod/sa/web"
+	resp := testLogin(t, b, s, authority.x509SVID(webID), nil)
+	testExpectLogin(t, resp, "prod", webID)
+	if resp.Auth.Metadata["svid_type"] != svidTypeX509 || resp.Auth.Metadata["trust_domain"] != "example.org" {
+		t.Fatalf("unexpected metadata: %#v", resp.Auth.Metadata)
+	}
+
+	resp = testLogin(t, b, s, authority.x509SVID(webID), map[string]interface{}{"role": "web"})
+	testExpectLogin(t, resp, "web", webID)
+
+	// Renewal succeeds while the role still allows the SPIFFE ID
+	auth := resp.Auth
+	auth.TokenPolicies = auth.Policies
+	renewResp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RenewOperation,
+		Path:      "login",
+		Storage:   s,
+		Auth:      auth,
+	})
+	if err != nil || renewResp == nil || renewResp.IsError() {
+		t.Fatalf("err:%v resp:%#v", err, renewResp)
+	}
+
+	// No role allows the SPIFFE ID
+	testExpectError(t, testLogin(t, b, s, authority.x509SVID("spiffe://example.org/ns/dev/sa/db"), nil))
+
+	// The named role does not allow the SPIFFE ID
+	testExpectError(t, testLogin(t, b, s, authority.x509SVID("spiffe://example.org/ns/prod/sa/db"), map[string]interface{}{"role": "web"}))
+
+	// The trust domain is not registered
+	testExpectError(t, testLogin(t, b, s, other.x509SVID("spiffe://other.org/ns/prod/sa/web"), nil))
+
+	// The SVID claims a trust domain whose authorities did not sign it
+	testExpectError(t, testLogin(t, b, s, other.x509SVID(webID), nil))
+
+	// No client certificate
+	testExpectError(t, testLogin(t, b, s, &tls.ConnectionState{}, nil))
+}
+
+func TestLogin_JWTSVID(t *testing.T) {
+	b, s := testBackend(t)
+	authority := newTestAuthority(t, "example.org")
+
+	dir, err := ioutil.TempDir("", "spiffe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bundleFile := filepath.Join(dir, "bundle.json")
+	if err := ioutil.WriteFile(bundleFile, authority.spiffeBundle(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	testWrite(t, b, s, "trust-domain/example.org", map[string]interface{}{
+		"bundle_file": bundleFile,
+	})
+	testWrite(t, b, s, "role/prod", map[string]interface{}{
+		"spiffe_id_patterns": "spiffe://example.org/ns/prod/*",
+		"audiences":          "vault",
+	})
+	testWrite(t, b, s, "role/no-audience", map[string]interface{}{
+		"spiffe_id_patterns": "spiffe://example.org/*",
+	})
+
+	id := "spiffe://example.org/ns/prod/sa/web"
+	expiry := time.Now().Add(5 * time.Minute)
+
+	resp := testLogin(t, b, s, nil, map[string]interface{}{
+		"jwt_svid": authority.jwtSVID(id, []string{"vault", "other"}, expiry),
+	})
+	testExpectLogin(t, resp, "prod", id)
+	if resp.Auth.Metadata["svid_type"] != svidTypeJWT {
+		t.Fatalf("unexpected metadata: %#v", resp.Auth.Metadata)
+	}
+
+	// X.509-SVIDs are validated against the same bundle
+	resp = testLogin(t, b, s, authority.x509SVID(id), nil)
+	testExpectLogin(t, resp, "no-audience", id)
+
+	cases := map[string]string{
+		"wrong audience": authority.jwtSVID(id, []string{"other"}, expiry),
+		"expired":        authority.jwtSVID(id, []string{"vault"}, time.Now().Add(-5*time.Minute)),
+		"not allowed":    authority.jwtSVID("spiffe://example.org/ns/dev/sa/web", []string{"vault"}, expiry),
+		"untrusted":      newTestAuthority(t, "example.org").jwtSVID(id, []string{"vault"}, expiry),
+		"malformed":      "not-a-jwt",
+	}
+	for name, token := range cases {
+		t.Run(name, func(t *testing.T) {
+			testExpectError(t, testLogin(t, b, s, nil, map[string]interface{}{"jwt_svid": token}))
+		})
+	}
+}
+
+func TestTrustDomain_BundleEndpoint(t *testing.T) {
+	b, s := testBackend(t)
+	authority := newTestAuthority(t, "example.org")
+
+	var l sync.Mutex
+	bundle := authority.spiffeBundle()
+	fail := false
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		l.Lock()
+		defer l.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(bundle)
+	}))
+	defer server.Close()
+
+	testWrite(t, b, s, "trust-domain/example.org", map[string]interface{}{
+		"bundle_endpoint_url":    server.URL,
+		"bundle_endpoint_ca_pem": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})),
+	})
+	testWrite(t, b, s, "role/prod", map[string]interface{}{
+		"spiffe_id_patterns": "spiffe://example.org/*",
+		"audiences":          "vault",
+	})
+
+	id := "spiffe://example.org/ns/prod/sa/web"
+	expiry := time.Now().Add(5 * time.Minute)
+	testExpectLogin(t, testLogin(t, b, s, nil, map[string]interface{}{
+		"jwt_svid": authority.jwtSVID(id, []string{"vault"}, expiry),
+	}), "prod", id)
+
+	// Rotate the authorities; the new keys are picked up once the bundle is
+	// refreshed
+	rotated := newTestAuthority(t, "example.org")
+	l.Lock()
+	bundle = rotated.spiffeBundle()
+	l.Unlock()
+	rotatedToken := rotated.jwtSVID(id, []string{"vault"}, expiry)
+	testExpectError(t, testLogin(t, b, s, nil, map[string]interface{}{"jwt_svid": rotatedToken}))
+
+	expireBundle := func() {
+		b.bundlesLock.Lock()
+		b.bundles["example.org"].refreshAt = time.Now().Add(-time.Second)
+		b.bundlesLock.Unlock()
+	}
+	expireBundle()
+	testExpectLogin(t, testLogin(t, b, s, nil, map[string]interface{}{"jwt_svid": rotatedToken}), "prod", id)
+
+	// A failed refresh keeps the previous bundle in use
+	l.Lock()
+	fail = true
+	l.Unlock()
+	expireBundle()
+	testExpectLogin(t, testLogin(t, b, s, nil, map[string]interface{}{"jwt_svid": rotatedToken}), "prod", id)
+}
+
+func TestTrustDomain_Validation(t *testing.T) {
+	b, s := testBackend(t)
+	authority := newTestAuthority(t, "example.org")
+
+	cases := map[string]map[string]interface{}{
+		"no source": {},
+		"multiple sources": {
+			"bundle":      authority.pemBundle(),
+			"bundle_file": "/etc/spiffe/bundle.json",
+		},
+		"invalid bundle": {
+			"bundle": "{}",
+		},
+		"missing file": {
+			"bundle_file": "/nonexistent/bundle.json",
+		},
+	}
+	for name, data := range cases {
+		t.Run(name, func(t *testing.T) {
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      "trust-domain/example.org",
+				Storage:   s,
+				Data:      data,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp == nil || !resp.IsError() {
+				t.Fatalf("expected error, got %#v", resp)
+			}
+		})
+	}
+
+	testWrite(t, b, s, "trust-domain/example.org", map[string]interface{}{
+		"bundle": string(authority.spiffeBundle()),
+	})
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "trust-domain/example.org",
+		Storage:   s,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+	if resp.Data["bundle_refresh_interval"] != int64(300) {
+		t.Fatalf("unexpected refresh interval: %#v", resp.Data["bundle_refresh_interval"])
+	}
+}
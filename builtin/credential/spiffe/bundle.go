@@ -0,0 +1,222 @@
+package spiffe
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	cleanhttp "github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2"
+)
+
+const (
+	// Key uses defined by the SPIFFE trust domain and bundle specification
+	bundleUseX509SVID = "x509-svid"
+	bundleUseJWTSVID  = "jwt-svid"
+
+	// maxBundleSize caps the size of bundles read from files and endpoints
+	maxBundleSize = 4 * 1024 * 1024
+)
+
+// trustBundle holds the authorities of a trust domain.
+type trustBundle struct {
+	x509Authorities []*x509.Certificate
+	jwtAuthorities  map[string]crypto.PublicKey
+}
+
+type cachedBundle struct {
+	bundle *trustBundle
+
+	// refreshAt is when the bundle should be reloaded from its source; it is
+	// zero for bundles configured inline
+	refreshAt time.Time
+}
+
+// parseBundle parses a trust bundle in either the SPIFFE bundle format, a
+// JWK set whose keys are marked with their SVID type, or as a list of PEM
+// encoded X.509 authorities.
+func parseBundle(data []byte) (*trustBundle, error) {
+	data = bytes.TrimSpace(data)
+	bundle := &trustBundle{
+		jwtAuthorities: make(map[string]crypto.PublicKey),
+	}
+
+	if bytes.HasPrefix(data, []byte("-----BEGIN")) {
+		for len(data) > 0 {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				break
+			}
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, errwrap.Wrapf("error parsing X.509 authority: {{err}}", err)
+			}
+			bundle.x509Authorities = append(bundle.x509Authorities, cert)
+		}
+		if len(bundle.x509Authorities) == 0 {
+			return nil, errors.New("no certificates found in PEM bundle")
+		}
+		return bundle, nil
+	}
+
+	var keySet jose.JSONWebKeySet
+	if err := json.Unmarshal(data, &keySet); err != nil {
+		return nil, errwrap.Wrapf("error parsing SPIFFE bundle: {{err}}", err)
+	}
+	for i, key := range keySet.Keys {
+		switch key.Use {
+		case bundleUseX509SVID:
+			if len(key.Certificates) != 1 {
+				return nil, fmt.Errorf("x509-svid key %d must contain exactly one certificate", i)
+			}
+			bundle.x509Authorities = append(bundle.x509Authorities, key.Certificates[0])
+		case bundleUseJWTSVID:
+			if key.KeyID == "" {
+				return nil, fmt.Errorf("jwt-svid key %d is missing a key ID", i)
+			}
+			if _, ok := bundle.jwtAuthorities[key.KeyID]; ok {
+				return nil, fmt.Errorf("duplicate jwt-svid key ID %q", key.KeyID)
+			}
+			bundle.jwtAuthorities[key.KeyID] = key.Key
+		}
+	}
+	if len(bundle.x509Authorities) == 0 && len(bundle.jwtAuthorities) == 0 {
+		return nil, errors.New("no x509-svid or jwt-svid authorities found in SPIFFE bundle")
+	}
+	return bundle, nil
+}
+
+// trustBundle returns the bundle of the named trust domain, loading it from
+// its source when it is not cached or due for a refresh. If a refresh fails
+// the previously loaded bundle continues to be used.
+func (b *backend) trustBundle(ctx context.Context, s logical.Storage, name string) (*trustBundle, error) {
+	b.bundlesLock.RLock()
+	cached, ok := b.bundles[name]
+	b.bundlesLock.RUnlock()
+	if ok && (cached.refreshAt.IsZero() || time.Now().Before(cached.refreshAt)) {
+		return cached.bundle, nil
+	}
+
+	td, err := b.trustDomain(ctx, s, name)
+	if err != nil {
+		return nil, err
+	}
+	if td == nil {
+		return nil, nil
+	}
+
+	bundle, err := b.loadBundle(ctx, td)
+	if err != nil {
+		if ok {
+			b.Logger().Warn("failed to refresh trust bundle, using previous bundle", "trust_domain", name, "error", err)
+			return cached.bundle, nil
+		}
+		return nil, err
+	}
+
+	entry := &cachedBundle{
+		bundle: bundle,
+	}
+	if td.Bundle == "" {
+		entry.refreshAt = time.Now().Add(td.refreshInterval())
+	}
+
+	b.bundlesLock.Lock()
+	b.bundles[name] = entry
+	b.bundlesLock.Unlock()
+
+	return bundle, nil
+}
+
+func (b *backend) flushBundle(name string) {
+	b.bundlesLock.Lock()
+	defer b.bundlesLock.Unlock()
+	delete(b.bundles, name)
+}
+
+// loadBundle reads and parses the trust domain's bundle from its source.
+func (b *backend) loadBundle(ctx context.Context, td *trustDomainEntry) (*trustBundle, error) {
+	var data []byte
+	var err error
+	switch {
+	case td.Bundle != "":
+		data = []byte(td.Bundle)
+	case td.BundleFile != "":
+		data, err = readBundleFile(td.BundleFile)
+	case td.BundleEndpointURL != "":
+		data, err = fetchBundle(ctx, td)
+	default:
+		return nil, errors.New("trust domain has no bundle source")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return parseBundle(data)
+}
+
+func readBundleFile(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("error reading bundle file %q: {{err}}", path), err)
+	}
+	return data, nil
+}
+
+// fetchBundle retrieves the bundle from a SPIFFE bundle endpoint using the
+// https_web profile, authenticating the endpoint with the configured CA or
+// the system roots.
+func fetchBundle(ctx context.Context, td *trustDomainEntry) ([]byte, error) {
+	tr := cleanhttp.DefaultTransport()
+	if td.BundleEndpointCAPEM != "" {
+		certPool := x509.NewCertPool()
+		if ok := certPool.AppendCertsFromPEM([]byte(td.BundleEndpointCAPEM)); !ok {
+			return nil, errors.New("could not parse bundle endpoint CA PEM")
+		}
+		tr.TLSClientConfig = &tls.Config{
+			RootCAs: certPool,
+		}
+	}
+	client := &http.Client{
+		Transport: tr,
+		Timeout:   30 * time.Second,
+	}
+
+	req, err := http.NewRequest(http.MethodGet, td.BundleEndpointURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("error fetching bundle from %q: {{err}}", td.BundleEndpointURL), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d from bundle endpoint %q", resp.StatusCode, td.BundleEndpointURL)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBundleSize))
+	if err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("error reading bundle from %q: {{err}}", td.BundleEndpointURL), err)
+	}
+	return data, nil
+}
@@ -0,0 +1,74 @@
+package spiffe
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// CLIHandler struct
+type CLIHandler struct{}
+
+// Auth cli method
+func (h *CLIHandler) Auth(c *api.Client, m map[string]string) (*api.Secret, error) {
+	mount, ok := m["mount"]
+	if !ok {
+		mount = "spiffe"
+	}
+
+	data := map[string]interface{}{
+		"role":     m["role"],
+		"jwt_svid": m["jwt_svid"],
+	}
+
+	path := fmt.Sprintf("auth/%s/login", mount)
+	secret, err := c.Logical().Write(path, data)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return nil, errors.New("empty response from credential provider")
+	}
+
+	return secret, nil
+}
+
+// Help method for spiffe cli
+func (h *CLIHandler) Help() string {
+	help := `
+Usage: vault login -method=spiffe [CONFIG K=V...]
+
+  The SPIFFE auth method allows workloads to authenticate using an X.509-SVID
+  or a JWT-SVID.
+
+  Authenticate using the X.509-SVID as the TLS client certificate:
+
+      $ vault login -method=spiffe \
+          -client-cert=svid.pem \
+          -client-key=svid.key
+
+  Authenticate using a JWT-SVID:
+
+      $ vault login -method=spiffe jwt_svid=eyJhbGciOiJFUzI1NiIsImtpZCI6...
+
+Configuration:
+
+  jwt_svid=<string>
+      JWT-SVID to authenticate with. If not given, the X.509-SVID presented as
+      the TLS client certificate is used.
+
+  mount=<string>
+      Path where the SPIFFE auth method is mounted. This is usually provided
+      via the -path flag in the "vault login" command, but it can be specified
+      here as well. If specified here, it takes precedence over the value for
+      -path. The default value is "spiffe".
+
+  role=<string>
+      Role to log in with. If not given, the first role that allows the
+      SPIFFE ID is used.
+`
+
+	return strings.TrimSpace(help)
+}
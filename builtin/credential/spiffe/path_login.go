@@ -0,0 +1,184 @@
+package spiffe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/policyutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathLogin(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "login",
+		Fields: map[string]*framework.FieldSchema{
+			"role": &framework.FieldSchema{
+				Type:        framework.TypeLowerCaseString,
+				Description: "The role to log in against. If not set, the first role matching the SVID is used.",
+			},
+			"jwt_svid": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "A JWT-SVID to authenticate with. If not set, the X.509-SVID presented as the TLS client certificate is used.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation:         b.pathLogin,
+			logical.AliasLookaheadOperation: b.pathLoginAliasLookahead,
+		},
+
+		HelpSynopsis:    pathLoginHelpSyn,
+		HelpDescription: pathLoginHelpDesc,
+	}
+}
+
+// verifySVID validates the SVID presented with the login request.
+func (b *backend) verifySVID(ctx context.Context, req *logical.Request, data *framework.FieldData) (*svid, *logical.Response, error) {
+	if raw := data.Get("jwt_svid").(string); raw != "" {
+		return b.verifyJWTSVID(ctx, req.Storage, raw)
+	}
+
+	if req.Connection == nil || req.Connection.ConnState == nil {
+		return nil, logical.ErrorResponse("tls connection required"), nil
+	}
+	return b.verifyX509SVID(ctx, req.Storage, req.Connection.ConnState.PeerCertificates)
+}
+
+// matchRole returns the role the SVID logs in with: the named role if it
+// allows the SVID, otherwise the first role in name order that does.
+func (b *backend) matchRole(ctx context.Context, s logical.Storage, roleName string, id *svid) (string, *roleEntry, *logical.Response, error) {
+	allows := func(role *roleEntry) bool {
+		if !role.matchesSPIFFEID(id.spiffeID) {
+			return false
+		}
+		return id.svidType != svidTypeJWT || role.matchesAudience(id.audiences)
+	}
+
+	if roleName != "" {
+		role, err := b.role(ctx, s, roleName)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		if role == nil {
+			return "", nil, logical.ErrorResponse(fmt.Sprintf("role %q could not be found", roleName)), nil
+		}
+		if !allows(role) {
+			return "", nil, logical.ErrorResponse(fmt.Sprintf("SPIFFE ID %q is not allowed to log in with role %q", id.spiffeID, roleName)), nil
+		}
+		return roleName, role, nil, nil
+	}
+
+	roleNames, err := s.List(ctx, rolePrefix)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	sort.Strings(roleNames)
+	for _, name := range roleNames {
+		role, err := b.role(ctx, s, name)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		if role != nil && allows(role) {
+			return name, role, nil, nil
+		}
+	}
+
+	return "", nil, logical.ErrorResponse(fmt.Sprintf("no role allows SPIFFE ID %q to log in", id.spiffeID)), nil
+}
+
+func (b *backend) pathLoginAliasLookahead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	id, errResp, err := b.verifySVID(ctx, req, data)
+	if errResp != nil || err != nil {
+		return errResp, err
+	}
+
+	return &logical.Response{
+		Auth: &logical.Auth{
+			Alias: &logical.Alias{
+				Name: id.spiffeID,
+			},
+		},
+	}, nil
+}
+
+func (b *backend) pathLogin(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	id, errResp, err := b.verifySVID(ctx, req, data)
+	if errResp != nil || err != nil {
+		return errResp, err
+	}
+
+	roleName, role, errResp, err := b.matchRole(ctx, req.Storage, data.Get("role").(string), id)
+	if errResp != nil || err != nil {
+		return errResp, err
+	}
+
+	metadata := map[string]string{
+		"role":         roleName,
+		"spiffe_id":    id.spiffeID,
+		"trust_domain": id.trustDomain,
+		"svid_type":    id.svidType,
+	}
+
+	auth := &logical.Auth{
+		InternalData: map[string]interface{}{
+			"role":      roleName,
+			"spiffe_id": id.spiffeID,
+		},
+		DisplayName: id.spiffeID,
+		Metadata:    metadata,
+		Alias: &logical.Alias{
+			Name:     id.spiffeID,
+			Metadata: metadata,
+		},
+	}
+	role.PopulateTokenAuth(auth)
+
+	return &logical.Response{
+		Auth: auth,
+	}, nil
+}
+
+func (b *backend) pathLoginRenew(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName, ok := req.Auth.InternalData["role"].(string)
+	if !ok || roleName == "" {
+		return nil, errors.New("failed to fetch role during renewal")
+	}
+	spiffeID, ok := req.Auth.InternalData["spiffe_id"].(string)
+	if !ok || spiffeID == "" {
+		return nil, errors.New("failed to fetch SPIFFE ID during renewal")
+	}
+
+	role, err := b.role(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, fmt.Errorf("role %q does not exist during renewal", roleName)
+	}
+	if !role.matchesSPIFFEID(spiffeID) {
+		return nil, fmt.Errorf("SPIFFE ID %q is no longer allowed by role %q", spiffeID, roleName)
+	}
+
+	if !policyutil.EquivalentPolicies(role.TokenPolicies, req.Auth.TokenPolicies) {
+		return nil, errors.New("policies have changed, not renewing")
+	}
+
+	resp := &logical.Response{Auth: req.Auth}
+	resp.Auth.TTL = role.TokenTTL
+	resp.Auth.MaxTTL = role.TokenMaxTTL
+	resp.Auth.Period = role.TokenPeriod
+	return resp, nil
+}
+
+const pathLoginHelpSyn = `
+Authenticates a workload using its SPIFFE SVID.
+`
+
+const pathLoginHelpDesc = `
+A JWT-SVID may be given in the "jwt_svid" field; otherwise the X.509-SVID
+presented as the TLS client certificate is used. The SVID must be valid
+according to the bundle of its trust domain. The entity alias created for the
+workload is named after its SPIFFE ID.
+`
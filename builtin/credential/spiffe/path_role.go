@@ -0,0 +1,220 @@
+package spiffe
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/helper/tokenutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	glob "github.com/ryanuber/go-glob"
+)
+
+const rolePrefix = "role/"
+
+func pathRoleList(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "role/?",
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: b.pathRoleList,
+		},
+
+		HelpSynopsis:    pathRoleListHelpSyn,
+		HelpDescription: pathRoleListHelpDesc,
+	}
+}
+
+func pathRole(b *backend) *framework.Path {
+	p := &framework.Path{
+		Pattern: "role/" + framework.GenericNameRegex("name"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": &framework.FieldSchema{
+				Type:        framework.TypeLowerCaseString,
+				Description: "Name of the role.",
+			},
+			"spiffe_id_patterns": &framework.FieldSchema{
+				Type:        framework.TypeCommaStringSlice,
+				Description: `Comma-separated list of SPIFFE IDs allowed to log in with this role, e.g. "spiffe://example.org/ns/prod/*". Supports globbing.`,
+			},
+			"audiences": &framework.FieldSchema{
+				Type:        framework.TypeCommaStringSlice,
+				Description: `Comma-separated list of audiences, one of which a JWT-SVID must be issued for. JWT-SVIDs are not accepted by roles without audiences.`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.CreateOperation: b.pathRoleCreateUpdate,
+			logical.UpdateOperation: b.pathRoleCreateUpdate,
+			logical.ReadOperation:   b.pathRoleRead,
+			logical.DeleteOperation: b.pathRoleDelete,
+		},
+
+		ExistenceCheck: b.pathRoleExistenceCheck,
+
+		HelpSynopsis:    pathRoleHelpSyn,
+		HelpDescription: pathRoleHelpDesc,
+	}
+
+	tokenutil.AddTokenFields(p.Fields)
+	return p
+}
+
+type roleEntry struct {
+	tokenutil.TokenParams
+
+	SPIFFEIDPatterns []string `json:"spiffe_id_patterns"`
+	Audiences        []string `json:"audiences"`
+}
+
+// matchesSPIFFEID returns whether the SPIFFE ID matches one of the role's
+// patterns.
+func (r *roleEntry) matchesSPIFFEID(id string) bool {
+	for _, pattern := range r.SPIFFEIDPatterns {
+		if glob.Glob(pattern, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAudience returns whether any of the audiences is allowed by the role.
+func (r *roleEntry) matchesAudience(audiences []string) bool {
+	for _, aud := range audiences {
+		if strutil.StrListContains(r.Audiences, aud) {
+			return true
+		}
+	}
+	return false
+}
+
+// role returns the named role, or nil if it does not exist.
+func (b *backend) role(ctx context.Context, s logical.Storage, name string) (*roleEntry, error) {
+	entry, err := s.Get(ctx, rolePrefix+strings.ToLower(name))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var role roleEntry
+	if err := entry.DecodeJSON(&role); err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("error reading role %q: {{err}}", name), err)
+	}
+	return &role, nil
+}
+
+func (b *backend) pathRoleExistenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
+	role, err := b.role(ctx, req.Storage, data.Get("name").(string))
+	if err != nil {
+		return false, err
+	}
+	return role != nil, nil
+}
+
+func (b *backend) pathRoleList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roles, err := req.Storage.List(ctx, rolePrefix)
+	if err != nil {
+		return nil, err
+	}
+	return logical.ListResponse(roles), nil
+}
+
+func (b *backend) pathRoleRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	role, err := b.role(ctx, req.Storage, data.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, nil
+	}
+
+	d := map[string]interface{}{
+		"spiffe_id_patterns": role.SPIFFEIDPatterns,
+		"audiences":          role.Audiences,
+	}
+	role.PopulateTokenData(d)
+
+	return &logical.Response{
+		Data: d,
+	}, nil
+}
+
+func (b *backend) pathRoleDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if err := req.Storage.Delete(ctx, rolePrefix+data.Get("name").(string)); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (b *backend) pathRoleCreateUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	if name == "" {
+		return logical.ErrorResponse("missing name"), nil
+	}
+
+	role, err := b.role(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		if req.Operation == logical.UpdateOperation {
+			return nil, fmt.Errorf("role %q does not exist", name)
+		}
+		role = &roleEntry{}
+	}
+
+	if err := role.ParseTokenFields(req, data); err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+
+	if raw, ok := data.GetOk("spiffe_id_patterns"); ok {
+		role.SPIFFEIDPatterns = strutil.RemoveDuplicates(raw.([]string), false)
+	}
+	if len(role.SPIFFEIDPatterns) == 0 {
+		return logical.ErrorResponse("at least one spiffe_id_patterns entry is required"), nil
+	}
+	for _, pattern := range role.SPIFFEIDPatterns {
+		if !strings.HasPrefix(pattern, spiffeScheme+"://") {
+			return logical.ErrorResponse(fmt.Sprintf("spiffe_id_patterns entry %q must start with %q", pattern, spiffeScheme+"://")), nil
+		}
+	}
+
+	if raw, ok := data.GetOk("audiences"); ok {
+		role.Audiences = strutil.RemoveDuplicates(raw.([]string), false)
+	}
+
+	entry, err := logical.StorageEntryJSON(rolePrefix+name, role)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+const pathRoleListHelpSyn = `
+Lists all the roles registered with the backend.
+`
+
+const pathRoleListHelpDesc = `
+The list will contain the names of the roles.
+`
+
+const pathRoleHelpSyn = `
+Register a role with the backend.
+`
+
+const pathRoleHelpDesc = `
+A role grants the workloads whose SPIFFE IDs match one of its patterns tokens
+with the configured properties. Patterns are full SPIFFE IDs, including the
+trust domain, and may contain "*" to match any sequence of characters, e.g.
+"spiffe://example.org/ns/prod/*". Roles accepting JWT-SVIDs must list the
+audiences the SVIDs are issued for.
+`
@@ -0,0 +1,241 @@
+package spiffe
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	trustDomainPrefix = "trust-domain/"
+
+	defaultBundleRefreshInterval = 5 * time.Minute
+)
+
+func pathTrustDomainList(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "trust-domain/?",
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: b.pathTrustDomainList,
+		},
+
+		HelpSynopsis:    pathTrustDomainListHelpSyn,
+		HelpDescription: pathTrustDomainListHelpDesc,
+	}
+}
+
+func pathTrustDomain(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "trust-domain/" + framework.GenericNameRegex("name"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": &framework.FieldSchema{
+				Type:        framework.TypeLowerCaseString,
+				Description: "Name of the trust domain, e.g. example.org.",
+			},
+			"bundle": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "The trust bundle, in SPIFFE bundle format or as PEM encoded X.509 authorities.",
+			},
+			"bundle_file": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Path to a file on the Vault server containing the trust bundle, in SPIFFE bundle format or as PEM encoded X.509 authorities.",
+			},
+			"bundle_endpoint_url": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "URL of a SPIFFE bundle endpoint serving the trust bundle using the https_web profile.",
+			},
+			"bundle_endpoint_ca_pem": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "PEM encoded CA certificates used to authenticate the bundle endpoint. If not set, the system roots are used.",
+			},
+			"bundle_refresh_interval": &framework.FieldSchema{
+				Type:        framework.TypeDurationSecond,
+				Default:     int(defaultBundleRefreshInterval.Seconds()),
+				Description: "How often a bundle loaded from a file or bundle endpoint is reloaded. Defaults to 5 minutes.",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.CreateOperation: b.pathTrustDomainWrite,
+			logical.UpdateOperation: b.pathTrustDomainWrite,
+			logical.ReadOperation:   b.pathTrustDomainRead,
+			logical.DeleteOperation: b.pathTrustDomainDelete,
+		},
+
+		ExistenceCheck: b.pathTrustDomainExistenceCheck,
+
+		HelpSynopsis:    pathTrustDomainHelpSyn,
+		HelpDescription: pathTrustDomainHelpDesc,
+	}
+}
+
+type trustDomainEntry struct {
+	Bundle                string        `json:"bundle"`
+	BundleFile            string        `json:"bundle_file"`
+	BundleEndpointURL     string        `json:"bundle_endpoint_url"`
+	BundleEndpointCAPEM   string        `json:"bundle_endpoint_ca_pem"`
+	BundleRefreshInterval time.Duration `json:"bundle_refresh_interval"`
+}
+
+func (td *trustDomainEntry) refreshInterval() time.Duration {
+	if td.BundleRefreshInterval <= 0 {
+		return defaultBundleRefreshInterval
+	}
+	return td.BundleRefreshInterval
+}
+
+// trustDomain returns the named trust domain, or nil if it does not exist.
+func (b *backend) trustDomain(ctx context.Context, s logical.Storage, name string) (*trustDomainEntry, error) {
+	entry, err := s.Get(ctx, trustDomainPrefix+strings.ToLower(name))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var td trustDomainEntry
+	if err := entry.DecodeJSON(&td); err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("error reading trust domain %q: {{err}}", name), err)
+	}
+	return &td, nil
+}
+
+func (b *backend) pathTrustDomainExistenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
+	td, err := b.trustDomain(ctx, req.Storage, data.Get("name").(string))
+	if err != nil {
+		return false, err
+	}
+	return td != nil, nil
+}
+
+func (b *backend) pathTrustDomainList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	names, err := req.Storage.List(ctx, trustDomainPrefix)
+	if err != nil {
+		return nil, err
+	}
+	return logical.ListResponse(names), nil
+}
+
+func (b *backend) pathTrustDomainRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	td, err := b.trustDomain(ctx, req.Storage, data.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+	if td == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"bundle":                  td.Bundle,
+			"bundle_file":             td.BundleFile,
+			"bundle_endpoint_url":     td.BundleEndpointURL,
+			"bundle_endpoint_ca_pem":  td.BundleEndpointCAPEM,
+			"bundle_refresh_interval": int64(td.refreshInterval().Seconds()),
+		},
+	}, nil
+}
+
+func (b *backend) pathTrustDomainDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	if err := req.Storage.Delete(ctx, trustDomainPrefix+name); err != nil {
+		return nil, err
+	}
+	b.flushBundle(name)
+	return nil, nil
+}
+
+func (b *backend) pathTrustDomainWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	if name == "" {
+		return logical.ErrorResponse("missing name"), nil
+	}
+
+	td, err := b.trustDomain(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if td == nil {
+		td = &trustDomainEntry{}
+	}
+
+	if raw, ok := data.GetOk("bundle"); ok {
+		td.Bundle = raw.(string)
+	}
+	if raw, ok := data.GetOk("bundle_file"); ok {
+		td.BundleFile = raw.(string)
+	}
+	if raw, ok := data.GetOk("bundle_endpoint_url"); ok {
+		td.BundleEndpointURL = raw.(string)
+	}
+	if raw, ok := data.GetOk("bundle_endpoint_ca_pem"); ok {
+		td.BundleEndpointCAPEM = raw.(string)
+	}
+	if raw, ok := data.GetOk("bundle_refresh_interval"); ok {
+		td.BundleRefreshInterval = time.Duration(raw.(int)) * time.Second
+	}
+
+	sources := 0
+	for _, source := range []string{td.Bundle, td.BundleFile, td.BundleEndpointURL} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return logical.ErrorResponse("exactly one of bundle, bundle_file or bundle_endpoint_url must be set"), nil
+	}
+	if td.BundleEndpointURL != "" {
+		u, err := url.Parse(td.BundleEndpointURL)
+		if err != nil || u.Scheme != "https" && u.Scheme != "http" {
+			return logical.ErrorResponse("bundle_endpoint_url must be an http or https URL"), nil
+		}
+	}
+
+	// Make sure the bundle can be loaded before accepting the configuration
+	if _, err := b.loadBundle(ctx, td); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	entry, err := logical.StorageEntryJSON(trustDomainPrefix+name, td)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	b.flushBundle(name)
+	return nil, nil
+}
+
+const pathTrustDomainListHelpSyn = `
+Lists the trust domains registered with the backend.
+`
+
+const pathTrustDomainListHelpDesc = `
+The list will contain the names of the trust domains.
+`
+
+const pathTrustDomainHelpSyn = `
+Register the trust bundle of a SPIFFE trust domain.
+`
+
+const pathTrustDomainHelpDesc = `
+SVIDs are only accepted from trust domains registered here, and are validated
+against the authorities in the trust domain's bundle. Exactly one source must
+be given for the bundle: inline data, a file on the Vault server or a SPIFFE
+bundle endpoint. Bundles read from files and endpoints are reloaded every
+bundle_refresh_interval; if reloading fails the previous bundle stays in use.
+
+Bundles may be given in the SPIFFE bundle format, a JWK set whose keys have a
+"use" of "x509-svid" or "jwt-svid", or as PEM encoded X.509 authorities. The
+latter only allow X.509-SVIDs to be validated.
+`
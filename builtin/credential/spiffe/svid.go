@@ -0,0 +1,194 @@
+package spiffe
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	spiffeScheme = "spiffe"
+
+	svidTypeX509 = "x509"
+	svidTypeJWT  = "jwt"
+)
+
+// jwtSVIDAlgorithms are the signature algorithms a JWT-SVID may use
+var jwtSVIDAlgorithms = map[string]bool{
+	string(jose.RS256): true,
+	string(jose.RS384): true,
+	string(jose.RS512): true,
+	string(jose.ES256): true,
+	string(jose.ES384): true,
+	string(jose.ES512): true,
+	string(jose.PS256): true,
+	string(jose.PS384): true,
+	string(jose.PS512): true,
+}
+
+// svid describes a validated SVID.
+type svid struct {
+	spiffeID    string
+	trustDomain string
+	svidType    string
+
+	// audiences is only set for JWT-SVIDs
+	audiences []string
+}
+
+// parseSPIFFEID parses and validates a SPIFFE ID, returning its trust domain.
+func parseSPIFFEID(id string) (string, error) {
+	u, err := url.Parse(id)
+	if err != nil {
+		return "", fmt.Errorf("invalid SPIFFE ID %q", id)
+	}
+
+	switch {
+	case u.Scheme != spiffeScheme:
+		return "", fmt.Errorf("invalid SPIFFE ID %q: scheme must be %q", id, spiffeScheme)
+	case u.Host == "":
+		return "", fmt.Errorf("invalid SPIFFE ID %q: missing trust domain", id)
+	case u.Port() != "" || u.User != nil:
+		return "", fmt.Errorf("invalid SPIFFE ID %q: trust domain must not contain a port or user info", id)
+	case u.RawQuery != "" || u.Fragment != "":
+		return "", fmt.Errorf("invalid SPIFFE ID %q: must not contain a query or fragment", id)
+	case u.Host != strings.ToLower(u.Host):
+		return "", fmt.Errorf("invalid SPIFFE ID %q: trust domain must be lowercase", id)
+	}
+
+	return u.Host, nil
+}
+
+// verifyX509SVID validates the peer certificate chain presented during the
+// TLS handshake as an X.509-SVID, returning the SVID it describes.
+func (b *backend) verifyX509SVID(ctx context.Context, s logical.Storage, certs []*x509.Certificate) (*svid, *logical.Response, error) {
+	if len(certs) == 0 {
+		return nil, logical.ErrorResponse("no JWT-SVID or X.509-SVID client certificate supplied"), nil
+	}
+
+	leaf := certs[0]
+	if leaf.IsCA {
+		return nil, logical.ErrorResponse("X.509-SVID must not be a CA certificate"), nil
+	}
+	if len(leaf.URIs) != 1 {
+		return nil, logical.ErrorResponse("X.509-SVID must contain exactly one URI SAN"), nil
+	}
+
+	id := leaf.URIs[0].String()
+	trustDomain, err := parseSPIFFEID(id)
+	if err != nil {
+		return nil, logical.ErrorResponse(err.Error()), nil
+	}
+
+	bundle, errResp, err := b.bundleForSVID(ctx, s, trustDomain)
+	if errResp != nil || err != nil {
+		return nil, errResp, err
+	}
+	if len(bundle.x509Authorities) == 0 {
+		return nil, logical.ErrorResponse(fmt.Sprintf("trust domain %q has no X.509 authorities", trustDomain)), nil
+	}
+
+	roots := x509.NewCertPool()
+	for _, cert := range bundle.x509Authorities {
+		roots.AddCert(cert)
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return nil, logical.ErrorResponse(fmt.Sprintf("X.509-SVID could not be verified: %s", err.Error())), nil
+	}
+
+	return &svid{
+		spiffeID:    id,
+		trustDomain: trustDomain,
+		svidType:    svidTypeX509,
+	}, nil, nil
+}
+
+// verifyJWTSVID validates the signature and lifetime of a JWT-SVID, returning
+// the SVID it describes. Audiences are checked against the role later.
+func (b *backend) verifyJWTSVID(ctx context.Context, s logical.Storage, raw string) (*svid, *logical.Response, error) {
+	token, err := jwt.ParseSigned(raw)
+	if err != nil {
+		return nil, logical.ErrorResponse(fmt.Sprintf("error parsing JWT-SVID: %s", err.Error())), nil
+	}
+	if len(token.Headers) != 1 {
+		return nil, logical.ErrorResponse("JWT-SVID must have exactly one signature"), nil
+	}
+	header := token.Headers[0]
+	if !jwtSVIDAlgorithms[header.Algorithm] {
+		return nil, logical.ErrorResponse(fmt.Sprintf("JWT-SVID signature algorithm %q is not allowed", header.Algorithm)), nil
+	}
+	if header.KeyID == "" {
+		return nil, logical.ErrorResponse("JWT-SVID is missing a key ID"), nil
+	}
+
+	// The subject selects the trust bundle to verify the signature with, so
+	// it has to be read before the token is verified
+	var unverified jwt.Claims
+	if err := token.UnsafeClaimsWithoutVerification(&unverified); err != nil {
+		return nil, logical.ErrorResponse(fmt.Sprintf("error parsing JWT-SVID claims: %s", err.Error())), nil
+	}
+	trustDomain, err := parseSPIFFEID(unverified.Subject)
+	if err != nil {
+		return nil, logical.ErrorResponse(err.Error()), nil
+	}
+
+	bundle, errResp, err := b.bundleForSVID(ctx, s, trustDomain)
+	if errResp != nil || err != nil {
+		return nil, errResp, err
+	}
+	key, ok := bundle.jwtAuthorities[header.KeyID]
+	if !ok {
+		return nil, logical.ErrorResponse(fmt.Sprintf("key ID %q not found in the bundle of trust domain %q", header.KeyID, trustDomain)), nil
+	}
+
+	var claims jwt.Claims
+	if err := token.Claims(key, &claims); err != nil {
+		return nil, logical.ErrorResponse(fmt.Sprintf("JWT-SVID could not be verified: %s", err.Error())), nil
+	}
+	if claims.Expiry == nil {
+		return nil, logical.ErrorResponse("JWT-SVID is missing an expiry"), nil
+	}
+	if len(claims.Audience) == 0 {
+		return nil, logical.ErrorResponse("JWT-SVID is missing an audience"), nil
+	}
+	if err := claims.ValidateWithLeeway(jwt.Expected{
+		Subject: unverified.Subject,
+		Time:    time.Now(),
+	}, jwt.DefaultLeeway); err != nil {
+		return nil, logical.ErrorResponse(fmt.Sprintf("JWT-SVID is not valid: %s", err.Error())), nil
+	}
+
+	return &svid{
+		spiffeID:    claims.Subject,
+		trustDomain: trustDomain,
+		svidType:    svidTypeJWT,
+		audiences:   claims.Audience,
+	}, nil, nil
+}
+
+func (b *backend) bundleForSVID(ctx context.Context, s logical.Storage, trustDomain string) (*trustBundle, *logical.Response, error) {
+	bundle, err := b.trustBundle(ctx, s, trustDomain)
+	if err != nil {
+		return nil, nil, err
+	}
+	if bundle == nil {
+		return nil, logical.ErrorResponse(fmt.Sprintf("trust domain %q is not trusted", trustDomain)), nil
+	}
+	return bundle, nil, nil
+}
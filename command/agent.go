@@ -2,6 +2,7 @@ package command
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -10,13 +11,22 @@ import (
 	"net/http"
 	"os"
 	"path"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/hashicorp/errwrap"
 	log "github.com/hashicorp/go-hclog"
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/alicloudkms"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/awskms"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/azurekeyvault"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/gcpckms"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/ocikms"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/transit"
 	"github.com/hashicorp/vault/api"
 	"github.com/hashicorp/vault/command/agent/auth"
 	"github.com/hashicorp/vault/command/agent/auth/alicloud"
@@ -30,12 +40,18 @@ import (
 	"github.com/hashicorp/vault/command/agent/auth/kerberos"
 	"github.com/hashicorp/vault/command/agent/auth/kubernetes"
 	"github.com/hashicorp/vault/command/agent/cache"
+	"github.com/hashicorp/vault/command/agent/cache/cacheboltdb"
 	agentConfig "github.com/hashicorp/vault/command/agent/config"
+	"github.com/hashicorp/vault/command/agent/exec"
+	"github.com/hashicorp/vault/command/agent/failover"
 	"github.com/hashicorp/vault/command/agent/sink"
+	"github.com/hashicorp/vault/command/agent/sink/fifo"
 	"github.com/hashicorp/vault/command/agent/sink/file"
 	"github.com/hashicorp/vault/command/agent/sink/inmem"
+	sinkKubernetes "github.com/hashicorp/vault/command/agent/sink/kubernetes"
 	"github.com/hashicorp/vault/command/agent/template"
 	gatedwriter "github.com/hashicorp/vault/helper/gated-writer"
+	"github.com/hashicorp/vault/helper/metricsutil"
 	"github.com/hashicorp/vault/sdk/helper/consts"
 	"github.com/hashicorp/vault/sdk/helper/logging"
 	"github.com/hashicorp/vault/sdk/logical"
@@ -314,6 +330,45 @@ func (c *AgentCommand) Run(args []string) int {
 		return 0
 	}
 
+	// Set up telemetry, which is exposed on the agent's listeners, only if it
+	// is configured: this also installs the SIGUSR1 handler dumping metrics
+	var metricsHelper *metricsutil.MetricsHelper
+	if config.Telemetry != nil {
+		metricsHelper, err = setupTelemetry(c.UI, config.Telemetry)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing telemetry: %s", err))
+			return 1
+		}
+	}
+
+	// Fail over between several servers, if configured. The requests of the
+	// agent's client are sent to the selected server through its transport.
+	var failoverManager *failover.Manager
+	if config.Vault.Failover() {
+		var addresses []string
+		if config.Vault.Address != "" {
+			addresses = append(addresses, config.Vault.Address)
+		}
+		addresses = append(addresses, config.Vault.Addresses...)
+
+		failoverManager, err = failover.NewManager(&failover.Config{
+			Logger:              c.logger.Named("failover"),
+			Addresses:           addresses,
+			SRVRecord:           config.Vault.SRVRecord,
+			SRVScheme:           config.Vault.SRVScheme,
+			HealthCheckInterval: config.Vault.HealthCheckInterval,
+		})
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error creating failover manager: %v", err))
+			return 1
+		}
+		c.clientConfigFunc = func(clientConfig *api.Config) error {
+			var err error
+			clientConfig.HttpClient.Transport, err = failoverManager.Transport(clientConfig.Address, clientConfig.HttpClient.Transport)
+			return err
+		}
+	}
+
 	// Ignore any setting of agent's address. This client is used by the agent
 	// to reach out to Vault. This should never loop back to agent.
 	c.flagAgentAddress = ""
@@ -330,6 +385,20 @@ func (c *AgentCommand) Run(args []string) int {
 	// down accordingly.
 	ctx, cancelFunc := context.WithCancel(context.Background())
 
+	// Select a healthy server before anything uses the client. The exec
+	// server is given the selected server's address, and follows it when it
+	// changes, while templates are rendered through the client.
+	var execAddressCh <-chan string
+	if failoverManager != nil {
+		execAddressCh = failoverManager.AddressCh()
+		failoverManager.Check(ctx)
+		go failoverManager.Run(ctx)
+
+		if address := failoverManager.Address(); address != "" {
+			config.Vault.Address = address
+		}
+	}
+
 	var method auth.AuthMethod
 	var sinks []*sink.SinkConfig
 	var namespace string
@@ -353,6 +422,40 @@ func (c *AgentCommand) Run(args []string) int {
 				}
 				config.Sink = s
 				sinks = append(sinks, config)
+			case "kubernetes":
+				config := &sink.SinkConfig{
+					Logger:  c.logger.Named("sink.kubernetes"),
+					Config:  sc.Config,
+					Client:  client,
+					WrapTTL: sc.WrapTTL,
+					DHType:  sc.DHType,
+					DHPath:  sc.DHPath,
+					AAD:     sc.AAD,
+				}
+				s, err := sinkKubernetes.NewKubernetesSink(config)
+				if err != nil {
+					c.UI.Error(errwrap.Wrapf("Error creating kubernetes sink: {{err}}", err).Error())
+					return 1
+				}
+				config.Sink = s
+				sinks = append(sinks, config)
+			case "fifo":
+				config := &sink.SinkConfig{
+					Logger:  c.logger.Named("sink.fifo"),
+					Config:  sc.Config,
+					Client:  client,
+					WrapTTL: sc.WrapTTL,
+					DHType:  sc.DHType,
+					DHPath:  sc.DHPath,
+					AAD:     sc.AAD,
+				}
+				s, err := fifo.NewFIFOSink(config)
+				if err != nil {
+					c.UI.Error(errwrap.Wrapf("Error creating fifo sink: {{err}}", err).Error())
+					return 1
+				}
+				config.Sink = s
+				sinks = append(sinks, config)
 			default:
 				c.UI.Error(fmt.Sprintf("Unknown sink type %q", sc.Type))
 				return 1
@@ -404,6 +507,32 @@ func (c *AgentCommand) Run(args []string) int {
 		}
 	}
 
+	// Create the auth handler up front so that its status can be reported by
+	// the listeners
+	var ah *auth.AuthHandler
+	var leaseCache *cache.LeaseCache
+	if method != nil {
+		enableTokenCh := len(config.Templates) > 0 || len(config.EnvTemplates) > 0 || len(config.Certificates) > 0
+		ah = auth.NewAuthHandler(&auth.AuthHandlerConfig{
+			Logger:                       c.logger.Named("auth.handler"),
+			Client:                       c.client,
+			WrapTTL:                      config.AutoAuth.Method.WrapTTL,
+			EnableReauthOnNewCredentials: config.AutoAuth.EnableReauthOnNewCredentials,
+			EnableTemplateTokenCh:        enableTokenCh,
+			ReauthGracePeriod:            config.AutoAuth.ReauthGracePeriod,
+			RevokeDelay:                  config.AutoAuth.RevokeDelay,
+			TokenRevokedFunc: func(token string) {
+				// Evict what the cache holds for the revoked token
+				if leaseCache == nil {
+					return
+				}
+				if err := leaseCache.EvictToken(ctx, token); err != nil {
+					c.logger.Error("error evicting revoked auto-auth token from the cache", "error", err)
+				}
+			},
+		})
+	}
+
 	// Output the header that the server has started
 	if !c.flagCombineLogs {
 		c.UI.Output("==> Vault server started! Log data will stream in below:\n")
@@ -420,28 +549,67 @@ func (c *AgentCommand) Run(args []string) int {
 		cacheLogger := c.logger.Named("cache")
 
 		// Create the API proxier
-		apiProxy, err := cache.NewAPIProxy(&cache.APIProxyConfig{
+		apiProxyConfig := &cache.APIProxyConfig{
 			Client: client,
 			Logger: cacheLogger.Named("apiproxy"),
-		})
+		}
+		if config.Vault.Retry != nil {
+			apiProxyConfig.NumRetries = config.Vault.Retry.NumRetries
+			apiProxyConfig.MinBackoff = config.Vault.Retry.MinBackoff
+			apiProxyConfig.MaxBackoff = config.Vault.Retry.MaxBackoff
+		}
+		if failoverManager != nil {
+			apiProxyConfig.AddressFunc = failoverManager.Address
+			apiProxyConfig.FailoverFunc = failoverManager.Failover
+		}
+		apiProxy, err := cache.NewAPIProxy(apiProxyConfig)
 		if err != nil {
 			c.UI.Error(fmt.Sprintf("Error creating API proxy: %v", err))
 			return 1
 		}
 
+		// Open the persistent storage of the cache, if configured
+		var persistentStorage *cacheboltdb.BoltStorage
+		if config.Cache.Persist != nil {
+			persistentStorage, err = c.newPersistentStorage(config.Cache.Persist, client, cacheLogger.Named("persist"))
+			if err != nil {
+				c.UI.Error(fmt.Sprintf("Error opening persistent cache: %v", err))
+				return 1
+			}
+			defer persistentStorage.Close()
+		}
+
 		// Create the lease cache proxier and set its underlying proxier to
 		// the API proxier.
-		leaseCache, err := cache.NewLeaseCache(&cache.LeaseCacheConfig{
+		leaseCacheConfig := &cache.LeaseCacheConfig{
 			Client:      client,
 			BaseContext: ctx,
 			Proxier:     apiProxy,
 			Logger:      cacheLogger.Named("leasecache"),
-		})
+			Storage:     persistentStorage,
+		}
+		if config.Cache.StaticSecrets != nil {
+			leaseCacheConfig.CacheStaticSecrets = true
+			leaseCacheConfig.StaticSecretRefreshInterval = config.Cache.StaticSecrets.RefreshInterval
+		}
+		leaseCache, err = cache.NewLeaseCache(leaseCacheConfig)
 		if err != nil {
 			c.UI.Error(fmt.Sprintf("Error creating lease cache: %v", err))
 			return 1
 		}
 
+		// Restore the entries of the persistent storage, resuming their
+		// renewal
+		if persistentStorage != nil {
+			if err := leaseCache.Restore(ctx); err != nil {
+				if config.Cache.Persist.ExitOnErr {
+					c.UI.Error(fmt.Sprintf("Error restoring persistent cache: %v", err))
+					return 1
+				}
+				c.logger.Warn("failed to restore some persistent cache entries", "error", err)
+			}
+		}
+
 		var inmemSink sink.Sink
 		if config.Cache.UseAutoAuthToken {
 			cacheLogger.Debug("auto-auth token is allowed to be used; configuring inmem sink")
@@ -458,9 +626,6 @@ func (c *AgentCommand) Run(args []string) int {
 			})
 		}
 
-		// Create the request handler
-		cacheHandler := cache.Handler(ctx, cacheLogger, leaseCache, inmemSink)
-
 		var listeners []net.Listener
 		for i, lnConfig := range config.Listeners {
 			ln, tlsConf, err := cache.StartListener(lnConfig)
@@ -471,9 +636,12 @@ func (c *AgentCommand) Run(args []string) int {
 
 			listeners = append(listeners, ln)
 
+			// Create the request handler, restricting the use of the
+			// auto-auth token to the paths allowed for the listener
+			muxHandler := cache.Handler(ctx, cacheLogger, leaseCache, inmemSink, lnConfig.AutoAuthTokenPaths)
+
 			// Parse 'require_request_header' listener config option, and wrap
 			// the request handler if necessary
-			muxHandler := cacheHandler
 			if v, ok := lnConfig.Config[agentConfig.RequireRequestHeader]; ok {
 				switch v {
 				case true:
@@ -488,6 +656,17 @@ func (c *AgentCommand) Run(args []string) int {
 			// Create a muxer and add paths relevant for the lease cache layer
 			mux := http.NewServeMux()
 			mux.Handle(consts.AgentPathCacheClear, leaseCache.HandleCacheClear(ctx))
+			mux.Handle(consts.AgentPathMetrics, handleAgentMetrics(metricsHelper))
+			mux.Handle(consts.AgentPathHealth, handleAgentHealth(ah))
+			if config.TokenExchange != nil && ln.Addr().Network() == "unix" {
+				mux.Handle(consts.AgentPathTokenExchange, cache.HandleTokenExchange(&cache.TokenExchangeConfig{
+					Logger:    cacheLogger.Named("token_exchange"),
+					Client:    client,
+					Namespace: namespace,
+					InmemSink: inmemSink,
+					Clients:   config.TokenExchange.Clients,
+				}))
+			}
 			mux.Handle("/", muxHandler)
 
 			scheme := "https://"
@@ -510,6 +689,7 @@ func (c *AgentCommand) Run(args []string) int {
 				ReadTimeout:       30 * time.Second,
 				IdleTimeout:       5 * time.Minute,
 				ErrorLog:          cacheLogger.StandardLogger(nil),
+				ConnContext:       cache.ConnContext(cacheLogger),
 			}
 
 			go server.Serve(ln)
@@ -528,17 +708,30 @@ func (c *AgentCommand) Run(args []string) int {
 	// TODO: implement support for SIGHUP reloading of configuration
 	// signal.Notify(c.signalCh)
 
-	var ssDoneCh, ahDoneCh, tsDoneCh chan struct{}
+	// Create the exec server, which runs the configured process
+	var es *exec.Server
+	var execTokenCh chan string
+	if config.Exec != nil {
+		es, err = exec.NewServer(&exec.ServerConfig{
+			Logger:       c.logger.Named("exec.server"),
+			LogLevel:     level,
+			LogWriter:    c.logWriter,
+			VaultConf:    config.Vault,
+			Namespace:    namespace,
+			Exec:         config.Exec,
+			EnvTemplates: config.EnvTemplates,
+			AddressCh:    execAddressCh,
+		})
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error creating exec server: %v", err))
+			return 1
+		}
+	}
+
+	var ssDoneCh, ahDoneCh, tsDoneCh, csDoneCh, esDoneCh chan struct{}
+	var cs *template.CertServer
 	// Start auto-auth and sink servers
 	if method != nil {
-		enableTokenCh := len(config.Templates) > 0
-		ah := auth.NewAuthHandler(&auth.AuthHandlerConfig{
-			Logger:                       c.logger.Named("auth.handler"),
-			Client:                       c.client,
-			WrapTTL:                      config.AutoAuth.Method.WrapTTL,
-			EnableReauthOnNewCredentials: config.AutoAuth.EnableReauthOnNewCredentials,
-			EnableTemplateTokenCh:        enableTokenCh,
-		})
 		ahDoneCh = ah.DoneCh
 
 		ss := sink.NewSinkServer(&sink.SinkServerConfig{
@@ -548,19 +741,59 @@ func (c *AgentCommand) Run(args []string) int {
 		})
 		ssDoneCh = ss.DoneCh
 
-		ts := template.NewServer(&template.ServerConfig{
+		templateServerConfig := &template.ServerConfig{
 			Logger:        c.logger.Named("template.server"),
 			LogLevel:      level,
 			LogWriter:     c.logWriter,
 			VaultConf:     config.Vault,
 			Namespace:     namespace,
 			ExitAfterAuth: exitAfterAuth,
-		})
+			Client:        client,
+		}
+		if config.TemplateConfig != nil {
+			templateServerConfig.PKIRenewFraction = config.TemplateConfig.PKIRenewFraction
+		}
+		ts := template.NewServer(templateServerConfig)
 		tsDoneCh = ts.DoneCh
 
+		// The template server shares the auto-auth token with the exec server
+		// if the exec server has templates to render, and with the
+		// certificate server if certificates are configured
+		templateTokenCh := ah.TemplateTokenCh
+		var certTokenCh chan string
+		if len(config.EnvTemplates) > 0 || len(config.Certificates) > 0 {
+			templateTokenCh = make(chan string, 1)
+			outs := []chan string{templateTokenCh}
+			if len(config.EnvTemplates) > 0 {
+				execTokenCh = make(chan string, 1)
+				outs = append(outs, execTokenCh)
+			}
+			if len(config.Certificates) > 0 {
+				certTokenCh = make(chan string, 1)
+				outs = append(outs, certTokenCh)
+			}
+			go fanOutTokens(ah.TemplateTokenCh, outs...)
+		}
+
 		go ah.Run(ctx, method)
 		go ss.Run(ctx, ah.OutputCh, sinks)
-		go ts.Run(ctx, ah.TemplateTokenCh, config.Templates)
+		go ts.Run(ctx, templateTokenCh, config.Templates)
+
+		if len(config.Certificates) > 0 {
+			cs = template.NewCertServer(&template.CertServerConfig{
+				Logger:        c.logger.Named("template.certificate"),
+				Client:        client,
+				Namespace:     namespace,
+				ExitAfterAuth: exitAfterAuth,
+			})
+			csDoneCh = cs.DoneCh
+			go cs.Run(ctx, certTokenCh, config.Certificates)
+		}
+	}
+
+	if es != nil {
+		esDoneCh = es.DoneCh
+		go es.Run(ctx, execTokenCh)
 	}
 
 	// Server configuration output
@@ -595,10 +828,17 @@ func (c *AgentCommand) Run(args []string) int {
 	case <-ssDoneCh:
 		// This will happen if we exit-on-auth
 		c.logger.Info("sinks finished, exiting")
-		// allow any templates to be rendered
+		// allow any templates to be rendered and certificates to be issued
 		if tsDoneCh != nil {
 			<-tsDoneCh
 		}
+		if csDoneCh != nil {
+			<-csDoneCh
+			if err := cs.Err(); err != nil {
+				c.UI.Error(err.Error())
+				return 1
+			}
+		}
 	case <-c.ShutdownCh:
 		c.UI.Output("==> Vault agent shutdown triggered")
 		cancelFunc()
@@ -612,11 +852,57 @@ func (c *AgentCommand) Run(args []string) int {
 		if tsDoneCh != nil {
 			<-tsDoneCh
 		}
+
+		if csDoneCh != nil {
+			<-csDoneCh
+		}
+
+		if esDoneCh != nil {
+			<-esDoneCh
+		}
+	case <-esDoneCh:
+		// The process run by the exec server exited on its own, so exit with
+		// its exit code
+		c.logger.Info("exec process exited, shutting down", "exit_code", es.ExitCode())
+		cancelFunc()
+		if ahDoneCh != nil {
+			<-ahDoneCh
+		}
+		if ssDoneCh != nil {
+			<-ssDoneCh
+		}
+		if tsDoneCh != nil {
+			<-tsDoneCh
+		}
+		if csDoneCh != nil {
+			<-csDoneCh
+		}
+		return es.ExitCode()
 	}
 
 	return 0
 }
 
+// fanOutTokens forwards the tokens received on in to each of outs, replacing
+// any token that has not been consumed yet. The outs are closed once in is.
+func fanOutTokens(in chan string, outs ...chan string) {
+	defer func() {
+		for _, out := range outs {
+			close(out)
+		}
+	}()
+
+	for token := range in {
+		for _, out := range outs {
+			select {
+			case <-out:
+			default:
+			}
+			out <- token
+		}
+	}
+}
+
 // verifyRequestHeader wraps an http.Handler inside a Handler that checks for
 // the request header that is used for SSRF protection.
 func verifyRequestHeader(handler http.Handler) http.Handler {
@@ -633,6 +919,95 @@ func verifyRequestHeader(handler http.Handler) http.Handler {
 	})
 }
 
+// handleAgentMetrics returns the metrics collected by the agent, in the format
+// requested by the "format" query parameter or the Accept header. If telemetry
+// is not configured, metricsHelper is nil and no metrics are served.
+func handleAgentMetrics(metricsHelper *metricsutil.MetricsHelper) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			logical.RespondError(w, http.StatusMethodNotAllowed, nil)
+			return
+		}
+		if metricsHelper == nil {
+			logical.RespondError(w, http.StatusNotFound, errors.New("telemetry is not configured"))
+			return
+		}
+
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = metricsutil.FormatFromRequest(&logical.Request{Headers: r.Header})
+		}
+
+		resp := metricsHelper.ResponseForFormat(format)
+
+		w.Header().Set("Content-Type", resp.Data[logical.HTTPContentType].(string))
+		w.WriteHeader(resp.Data[logical.HTTPStatusCode].(int))
+		switch v := resp.Data[logical.HTTPRawBody].(type) {
+		case string:
+			w.Write([]byte(v))
+		case []byte:
+			w.Write(v)
+		}
+	})
+}
+
+// AgentHealthResponse is the response of the agent's health endpoint.
+type AgentHealthResponse struct {
+	AutoAuth *AgentHealthAutoAuth `json:"auto_auth,omitempty"`
+}
+
+// AgentHealthAutoAuth reports the status of the auto-auth token.
+type AgentHealthAutoAuth struct {
+	Authenticated bool  `json:"authenticated"`
+	TokenTTL      int64 `json:"token_ttl"`
+}
+
+// handleAgentHealth reports the health of the agent. If auto-auth is
+// configured and the agent does not currently hold a valid token, it responds
+// with a 503, or with the code given by the "unauthenticatedcode" query
+// parameter.
+func handleAgentHealth(ah *auth.AuthHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		default:
+			logical.RespondError(w, http.StatusMethodNotAllowed, nil)
+			return
+		}
+
+		unauthenticatedCode := http.StatusServiceUnavailable
+		if v := r.URL.Query().Get("unauthenticatedcode"); v != "" {
+			code, err := strconv.Atoi(v)
+			if err != nil || code < 100 || code > 599 {
+				logical.RespondError(w, http.StatusBadRequest, fmt.Errorf("invalid value for unauthenticatedcode: %q", v))
+				return
+			}
+			unauthenticatedCode = code
+		}
+
+		code := http.StatusOK
+		var body AgentHealthResponse
+		if ah != nil {
+			authenticated, expiry := ah.Status()
+			body.AutoAuth = &AgentHealthAutoAuth{
+				Authenticated: authenticated,
+			}
+			if !expiry.IsZero() {
+				body.AutoAuth.TokenTTL = int64(time.Until(expiry).Seconds())
+			}
+			if !authenticated {
+				code = unauthenticatedCode
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		if r.Method == http.MethodGet {
+			json.NewEncoder(w).Encode(body)
+		}
+	})
+}
+
 func (c *AgentCommand) setStringFlag(f *FlagSets, configVal string, fVar *StringVar) {
 	var isFlagSet bool
 	f.Visit(func(f *flag.Flag) {
@@ -681,6 +1056,98 @@ func (c *AgentCommand) setBoolFlag(f *FlagSets, configVal bool, fVar *BoolVar) {
 	}
 }
 
+// newPersistentStorage opens the persistent storage of the cache. If the
+// existing storage cannot be opened, e.g. because its key cannot be recovered,
+// it is discarded and replaced unless exit_on_err is set.
+func (c *AgentCommand) newPersistentStorage(persist *agentConfig.Persist, client *api.Client, logger log.Logger) (*cacheboltdb.BoltStorage, error) {
+	var protector cacheboltdb.KeyProtector
+	switch persist.KeyType {
+	case agentConfig.PersistKeyTypeAutoAuth:
+		protector = &cacheboltdb.TokenKeyProtector{
+			Client:  client,
+			WrapTTL: persist.KeyWrapTTL,
+		}
+	case agentConfig.PersistKeyTypeKMS:
+		wrapper, err := newKMSWrapper(persist.KMS, logger)
+		if err != nil {
+			return nil, errwrap.Wrapf("error configuring kms: {{err}}", err)
+		}
+		protector = &cacheboltdb.KMSKeyProtector{
+			Wrapper: wrapper,
+		}
+	default:
+		return nil, fmt.Errorf("unknown key type %q", persist.KeyType)
+	}
+
+	storageConfig := &cacheboltdb.BoltStorageConfig{
+		Path:         persist.Path,
+		Logger:       logger,
+		KeyProtector: protector,
+	}
+
+	storage, err := cacheboltdb.NewBoltStorage(storageConfig)
+	if err == nil || persist.ExitOnErr {
+		return storage, err
+	}
+
+	exists, statErr := cacheboltdb.DBFileExists(persist.Path)
+	if statErr != nil || !exists {
+		return nil, err
+	}
+
+	logger.Warn("unable to open persistent cache; discarding it", "error", err)
+	if err := os.Remove(filepath.Join(persist.Path, cacheboltdb.DatabaseFileName)); err != nil {
+		return nil, err
+	}
+	return cacheboltdb.NewBoltStorage(storageConfig)
+}
+
+// newKMSWrapper returns the KMS wrapper described by the given configuration.
+func newKMSWrapper(kms *agentConfig.KMS, logger log.Logger) (wrapping.Wrapper, error) {
+	opts := &wrapping.WrapperOptions{
+		Logger: logger.Named(kms.Type),
+	}
+
+	var wrapper wrapping.Wrapper
+	var err error
+	switch kms.Type {
+	case wrapping.AliCloudKMS:
+		w := alicloudkms.NewWrapper(opts)
+		_, err = w.SetConfig(kms.Config)
+		wrapper = w
+	case wrapping.AWSKMS:
+		w := awskms.NewWrapper(opts)
+		_, err = w.SetConfig(kms.Config)
+		wrapper = w
+	case wrapping.AzureKeyVault:
+		w := azurekeyvault.NewWrapper(opts)
+		_, err = w.SetConfig(kms.Config)
+		wrapper = w
+	case wrapping.GCPCKMS:
+		w := gcpckms.NewWrapper(opts)
+		_, err = w.SetConfig(kms.Config)
+		wrapper = w
+	case wrapping.OCIKMS:
+		w := ocikms.NewWrapper(opts)
+		_, err = w.SetConfig(kms.Config)
+		wrapper = w
+	case wrapping.Transit:
+		w := transit.NewWrapper(opts)
+		_, err = w.SetConfig(kms.Config)
+		wrapper = w
+	default:
+		return nil, fmt.Errorf("unknown kms type %q", kms.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := wrapper.Init(context.Background()); err != nil {
+		return nil, err
+	}
+	return wrapper, nil
+}
+
 // storePidFile is used to write out our PID to a file if necessary
 func (c *AgentCommand) storePidFile(pidPath string) error {
 	// Quit fast if no pidfile
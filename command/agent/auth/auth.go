@@ -4,8 +4,10 @@ import (
 	"context"
 	"math/rand"
 	"net/http"
+	"sync"
 	"time"
 
+	metrics "github.com/armon/go-metrics"
 	hclog "github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/vault/api"
 	"github.com/hashicorp/vault/sdk/helper/jsonutil"
@@ -39,6 +41,14 @@ type AuthHandler struct {
 	wrapTTL                      time.Duration
 	enableReauthOnNewCredentials bool
 	enableTemplateTokenCh        bool
+	reauthGracePeriod            time.Duration
+	revokeDelay                  time.Duration
+	tokenRevokedFunc             func(string)
+
+	// l protects the status of the auto-auth token
+	l             sync.RWMutex
+	authenticated bool
+	tokenExpiry   time.Time
 }
 
 type AuthHandlerConfig struct {
@@ -47,6 +57,20 @@ type AuthHandlerConfig struct {
 	WrapTTL                      time.Duration
 	EnableReauthOnNewCredentials bool
 	EnableTemplateTokenCh        bool
+
+	// ReauthGracePeriod, if set, is how long before the expiry of a token
+	// that can no longer be renewed, e.g. because it reached its max TTL, the
+	// handler re-authenticates
+	ReauthGracePeriod time.Duration
+
+	// RevokeDelay, if set, is how long after re-authenticating ahead of the
+	// expiry of a token the handler revokes the previous token, giving its
+	// users time to switch to the new token
+	RevokeDelay time.Duration
+
+	// TokenRevokedFunc, if set, is called with the previous token once it
+	// has been revoked
+	TokenRevokedFunc func(string)
 }
 
 func NewAuthHandler(conf *AuthHandlerConfig) *AuthHandler {
@@ -62,11 +86,67 @@ func NewAuthHandler(conf *AuthHandlerConfig) *AuthHandler {
 		wrapTTL:                      conf.WrapTTL,
 		enableReauthOnNewCredentials: conf.EnableReauthOnNewCredentials,
 		enableTemplateTokenCh:        conf.EnableTemplateTokenCh,
+		reauthGracePeriod:            conf.ReauthGracePeriod,
+		revokeDelay:                  conf.RevokeDelay,
+		tokenRevokedFunc:             conf.TokenRevokedFunc,
 	}
 
 	return ah
 }
 
+// Status reports whether the handler holds a valid token, and the time at
+// which it expires. The expiry is zero if the token does not expire, or if it
+// is wrapped.
+func (ah *AuthHandler) Status() (bool, time.Time) {
+	ah.l.RLock()
+	defer ah.l.RUnlock()
+	return ah.authenticated, ah.tokenExpiry
+}
+
+func (ah *AuthHandler) setStatus(authenticated bool, secret *api.Secret) {
+	ah.l.Lock()
+	defer ah.l.Unlock()
+
+	ah.authenticated = authenticated
+	ah.tokenExpiry = time.Time{}
+	if authenticated && secret != nil && secret.Auth != nil && secret.Auth.LeaseDuration > 0 {
+		ah.tokenExpiry = time.Now().Add(time.Duration(secret.Auth.LeaseDuration) * time.Second)
+	}
+}
+
+// emitTokenTTL emits the remaining TTL of the token, if it expires.
+func (ah *AuthHandler) emitTokenTTL() {
+	if _, expiry := ah.Status(); !expiry.IsZero() {
+		metrics.SetGauge([]string{"agent", "auth", "token", "ttl"}, float32(time.Until(expiry).Seconds()))
+	}
+}
+
+// revokeAfterDelay revokes a token that has been replaced once the revoke
+// delay has passed, unless the handler is shutting down.
+func (ah *AuthHandler) revokeAfterDelay(ctx context.Context, token string) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(ah.revokeDelay):
+	}
+
+	client, err := ah.client.Clone()
+	if err != nil {
+		ah.logger.Error("error creating client to revoke previous token", "error", err)
+		return
+	}
+	client.SetToken(token)
+	if err := client.Auth().Token().RevokeSelf(""); err != nil {
+		ah.logger.Error("error revoking previous token", "error", err)
+		return
+	}
+	ah.logger.Info("revoked previous token")
+
+	if ah.tokenRevokedFunc != nil {
+		ah.tokenRevokedFunc(token)
+	}
+}
+
 func backoffOrQuit(ctx context.Context, backoff time.Duration) {
 	select {
 	case <-time.After(backoff):
@@ -110,6 +190,10 @@ func (ah *AuthHandler) Run(ctx context.Context, am AuthMethod) {
 
 	var watcher *api.LifetimeWatcher
 
+	// revokeToken is the token to revoke once the handler has re-authenticated
+	// ahead of its expiry
+	var revokeToken string
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -125,6 +209,7 @@ func (ah *AuthHandler) Run(ctx context.Context, am AuthMethod) {
 		path, header, data, err := am.Authenticate(ctx, ah.client)
 		if err != nil {
 			ah.logger.Error("error getting path or data from method", "error", err, "backoff", backoff.Seconds())
+			metrics.IncrCounter([]string{"agent", "auth", "failure"}, 1)
 			backoffOrQuit(ctx, backoff)
 			continue
 		}
@@ -152,6 +237,7 @@ func (ah *AuthHandler) Run(ctx context.Context, am AuthMethod) {
 		// Check errors/sanity
 		if err != nil {
 			ah.logger.Error("error authenticating", "error", err, "backoff", backoff.Seconds())
+			metrics.IncrCounter([]string{"agent", "auth", "failure"}, 1)
 			backoffOrQuit(ctx, backoff)
 			continue
 		}
@@ -160,11 +246,13 @@ func (ah *AuthHandler) Run(ctx context.Context, am AuthMethod) {
 		case ah.wrapTTL > 0:
 			if secret.WrapInfo == nil {
 				ah.logger.Error("authentication returned nil wrap info", "backoff", backoff.Seconds())
+				metrics.IncrCounter([]string{"agent", "auth", "failure"}, 1)
 				backoffOrQuit(ctx, backoff)
 				continue
 			}
 			if secret.WrapInfo.Token == "" {
 				ah.logger.Error("authentication returned empty wrapped client token", "backoff", backoff.Seconds())
+				metrics.IncrCounter([]string{"agent", "auth", "failure"}, 1)
 				backoffOrQuit(ctx, backoff)
 				continue
 			}
@@ -175,6 +263,8 @@ func (ah *AuthHandler) Run(ctx context.Context, am AuthMethod) {
 				continue
 			}
 			ah.logger.Info("authentication successful, sending wrapped token to sinks and pausing")
+			metrics.IncrCounter([]string{"agent", "auth", "success"}, 1)
+			ah.setStatus(true, nil)
 			ah.OutputCh <- string(wrappedResp)
 			if ah.enableTemplateTokenCh {
 				ah.TemplateTokenCh <- string(wrappedResp)
@@ -195,21 +285,31 @@ func (ah *AuthHandler) Run(ctx context.Context, am AuthMethod) {
 		default:
 			if secret == nil || secret.Auth == nil {
 				ah.logger.Error("authentication returned nil auth info", "backoff", backoff.Seconds())
+				metrics.IncrCounter([]string{"agent", "auth", "failure"}, 1)
 				backoffOrQuit(ctx, backoff)
 				continue
 			}
 			if secret.Auth.ClientToken == "" {
 				ah.logger.Error("authentication returned empty client token", "backoff", backoff.Seconds())
+				metrics.IncrCounter([]string{"agent", "auth", "failure"}, 1)
 				backoffOrQuit(ctx, backoff)
 				continue
 			}
 			ah.logger.Info("authentication successful, sending token to sinks")
+			metrics.IncrCounter([]string{"agent", "auth", "success"}, 1)
+			ah.setStatus(true, secret)
+			ah.emitTokenTTL()
 			ah.OutputCh <- secret.Auth.ClientToken
 			if ah.enableTemplateTokenCh {
 				ah.TemplateTokenCh <- secret.Auth.ClientToken
 			}
 
 			am.CredSuccess()
+
+			if revokeToken != "" {
+				go ah.revokeAfterDelay(ctx, revokeToken)
+				revokeToken = ""
+			}
 		}
 
 		if watcher != nil {
@@ -229,6 +329,35 @@ func (ah *AuthHandler) Run(ctx context.Context, am AuthMethod) {
 		ah.logger.Info("starting renewal process")
 		go watcher.Renew()
 
+		// Periodically emit the remaining TTL of the token
+		ttlTicker := time.NewTicker(10 * time.Second)
+
+		// Once the token can no longer be extended, re-authenticate within the
+		// grace period ahead of its expiry
+		var reauthTimer *time.Timer
+		var reauthCh <-chan time.Time
+		priorDuration := secret.Auth.LeaseDuration
+		scheduleReauth := func(tokenAuth *api.SecretAuth) {
+			defer func() {
+				priorDuration = tokenAuth.LeaseDuration
+			}()
+			if ah.reauthGracePeriod <= 0 || reauthTimer != nil || tokenAuth.LeaseDuration <= 0 {
+				return
+			}
+			if tokenAuth.Renewable && tokenAuth.LeaseDuration >= priorDuration {
+				return
+			}
+
+			delay := time.Duration(tokenAuth.LeaseDuration)*time.Second - ah.reauthGracePeriod
+			if delay < 0 {
+				delay = 0
+			}
+			ah.logger.Info("token can no longer be extended, scheduling re-authentication", "delay", delay.String())
+			reauthTimer = time.NewTimer(delay)
+			reauthCh = reauthTimer.C
+		}
+		scheduleReauth(secret.Auth)
+
 	LifetimeWatcherLoop:
 		for {
 			select {
@@ -241,16 +370,53 @@ func (ah *AuthHandler) Run(ctx context.Context, am AuthMethod) {
 				ah.logger.Info("lifetime watcher done channel triggered")
 				if err != nil {
 					ah.logger.Error("error renewing token", "error", err)
+					metrics.IncrCounter([]string{"agent", "auth", "renewal", "failure"}, 1)
 				}
+
+				// Handle a last renewal that raced with the watcher finishing
+				select {
+				case renewal := <-watcher.RenewCh():
+					if renewal.Secret != nil && renewal.Secret.Auth != nil {
+						scheduleReauth(renewal.Secret.Auth)
+					}
+				default:
+				}
+
+				// If the token was due to be replaced ahead of its expiry,
+				// it is still valid and is revoked once replaced
+				if err == nil && reauthTimer != nil && ah.revokeDelay > 0 {
+					revokeToken = secret.Auth.ClientToken
+					break LifetimeWatcherLoop
+				}
+				ah.setStatus(false, nil)
 				break LifetimeWatcherLoop
 
-			case <-watcher.RenewCh():
+			case renewal := <-watcher.RenewCh():
 				ah.logger.Info("renewed auth token")
+				ah.setStatus(true, renewal.Secret)
+				ah.emitTokenTTL()
+				if renewal.Secret != nil && renewal.Secret.Auth != nil {
+					scheduleReauth(renewal.Secret.Auth)
+				}
+
+			case <-reauthCh:
+				ah.logger.Info("token is approaching its expiry, re-authenticating", "grace_period", ah.reauthGracePeriod.String())
+				if ah.revokeDelay > 0 {
+					revokeToken = secret.Auth.ClientToken
+				}
+				break LifetimeWatcherLoop
+
+			case <-ttlTicker.C:
+				ah.emitTokenTTL()
 
 			case <-credCh:
 				ah.logger.Info("auth method found new credentials, re-authenticating")
 				break LifetimeWatcherLoop
 			}
 		}
+		ttlTicker.Stop()
+		if reauthTimer != nil {
+			reauthTimer.Stop()
+		}
 	}
 }
@@ -100,3 +100,84 @@ consumption:
 		}
 	}
 }
+
+func TestAuthHandler_ReauthGracePeriod(t *testing.T) {
+	logger := logging.NewVaultLogger(hclog.Trace)
+	coreConfig := &vault.CoreConfig{
+		Logger: logger,
+		CredentialBackends: map[string]logical.Factory{
+			"userpass": userpass.Factory,
+		},
+	}
+	cluster := vault.NewTestCluster(t, coreConfig, &vault.TestClusterOptions{
+		HandlerFunc: vaulthttp.Handler,
+	})
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	vault.TestWaitActive(t, cluster.Cores[0].Core)
+	client := cluster.Cores[0].Client
+
+	// Tokens can be renewed once before reaching their max TTL
+	err := client.Sys().EnableAuthWithOptions("userpass", &api.EnableAuthOptions{
+		Type: "userpass",
+		Config: api.AuthConfigInput{
+			DefaultLeaseTTL: "4s",
+			MaxLeaseTTL:     "6s",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	revokedCh := make(chan string, 1)
+	ah := NewAuthHandler(&AuthHandlerConfig{
+		Logger:            logger.Named("auth.handler"),
+		Client:            client,
+		ReauthGracePeriod: 3 * time.Second,
+		RevokeDelay:       time.Second,
+		TokenRevokedFunc: func(token string) {
+			revokedCh <- token
+		},
+	})
+	go ah.Run(ctx, &userpassTestMethod{})
+
+	var tokens []string
+	timeout := time.After(5 * time.Second)
+	for len(tokens) < 2 {
+		select {
+		case token := <-ah.OutputCh:
+			tokens = append(tokens, token)
+		case <-timeout:
+			t.Fatalf("expected a new token ahead of the max TTL, got %d tokens", len(tokens))
+		}
+	}
+	if tokens[0] == tokens[1] {
+		t.Fatal("expected a different token")
+	}
+
+	// The previous token is revoked after the revoke delay, before it expires
+	select {
+	case token := <-revokedCh:
+		if token != tokens[0] {
+			t.Fatal("expected the previous token to be revoked")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the previous token to be revoked")
+	}
+
+	lookupClient, err := client.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookupClient.SetToken(tokens[1])
+	if _, err := lookupClient.Auth().Token().LookupSelf(); err != nil {
+		t.Fatalf("expected the new token to be valid: %v", err)
+	}
+
+	cancelFunc()
+	<-ah.DoneCh
+}
@@ -3,6 +3,8 @@ package cache
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"time"
 
 	hclog "github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/vault/api"
@@ -11,13 +13,33 @@ import (
 // APIProxy is an implementation of the proxier interface that is used to
 // forward the request to Vault and get the response.
 type APIProxy struct {
-	client *api.Client
-	logger hclog.Logger
+	client       *api.Client
+	logger       hclog.Logger
+	numRetries   int
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+	addressFunc  func() string
+	failoverFunc func(context.Context, string)
 }
 
 type APIProxyConfig struct {
 	Client *api.Client
 	Logger hclog.Logger
+
+	// NumRetries, MinBackoff and MaxBackoff configure the retries of requests
+	// failing because the server could not be reached or is unavailable.
+	// Retries are disabled if NumRetries is zero.
+	NumRetries int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// AddressFunc, if set, returns the address of the server the client's
+	// requests are sent to, when it differs from the client's address
+	AddressFunc func() string
+
+	// FailoverFunc, if set, is called with the address of the server before
+	// retrying a request that failed because of it
+	FailoverFunc func(context.Context, string)
 }
 
 func NewAPIProxy(config *APIProxyConfig) (Proxier, error) {
@@ -25,48 +47,117 @@ func NewAPIProxy(config *APIProxyConfig) (Proxier, error) {
 		return nil, fmt.Errorf("nil API client")
 	}
 	return &APIProxy{
-		client: config.Client,
-		logger: config.Logger,
+		client:       config.Client,
+		logger:       config.Logger,
+		numRetries:   config.NumRetries,
+		minBackoff:   config.MinBackoff,
+		maxBackoff:   config.MaxBackoff,
+		addressFunc:  config.AddressFunc,
+		failoverFunc: config.FailoverFunc,
 	}, nil
 }
 
 func (ap *APIProxy) Send(ctx context.Context, req *SendRequest) (*SendResponse, error) {
-	client, err := ap.client.Clone()
-	if err != nil {
-		return nil, err
-	}
-	client.SetToken(req.Token)
-
 	// http.Transport will transparently request gzip and decompress the response, but only if
 	// the client doesn't manually set the header. Removing any Accept-Encoding header allows the
 	// transparent compression to occur.
 	req.Request.Header.Del("Accept-Encoding")
-	client.SetHeaders(req.Request.Header)
 
-	fwReq := client.NewRequest(req.Request.Method, req.Request.URL.Path)
-	fwReq.BodyBytes = req.RequestBody
+	var resp *api.Response
+	for attempt := 0; ; attempt++ {
+		client, err := ap.client.Clone()
+		if err != nil {
+			return nil, err
+		}
+		client.SetToken(req.Token)
+		client.SetHeaders(req.Request.Header)
+		if ap.numRetries > 0 {
+			// Retries are handled here, so that they can fail over to
+			// another server
+			client.SetMaxRetries(0)
+		}
 
-	query := req.Request.URL.Query()
-	if len(query) != 0 {
-		fwReq.Params = query
-	}
+		fwReq := client.NewRequest(req.Request.Method, req.Request.URL.Path)
+		fwReq.BodyBytes = req.RequestBody
+
+		query := req.Request.URL.Query()
+		if len(query) != 0 {
+			fwReq.Params = query
+		}
+
+		// Make the request to Vault and get the response
+		ap.logger.Info("forwarding request", "method", req.Request.Method, "path", req.Request.URL.Path)
+
+		address := ap.address(client)
+		resp, err = client.RawRequestWithContext(ctx, fwReq)
+		if attempt < ap.numRetries && ctx.Err() == nil && isServerFailure(resp, err) {
+			if resp != nil {
+				resp.Body.Close()
+			}
+
+			backoff := ap.backoff(attempt)
+			ap.logger.Warn("request to server failed, retrying", "address", address, "error", err, "backoff", backoff.String())
+			if ap.failoverFunc != nil {
+				ap.failoverFunc(ctx, address)
+			}
 
-	// Make the request to Vault and get the response
-	ap.logger.Info("forwarding request", "method", req.Request.Method, "path", req.Request.URL.Path)
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(backoff):
+			}
+			continue
+		}
 
-	resp, err := client.RawRequestWithContext(ctx, fwReq)
-	if resp == nil && err != nil {
-		// We don't want to cache nil responses, so we simply return the error
-		return nil, err
+		if resp == nil && err != nil {
+			// We don't want to cache nil responses, so we simply return the error
+			return nil, err
+		}
+
+		// Before error checking from the request call, we'd want to initialize a SendResponse to
+		// potentially return
+		sendResponse, newErr := NewSendResponse(resp, nil)
+		if newErr != nil {
+			return nil, newErr
+		}
+
+		// Bubble back the api.Response as well for error checking/handling at the handler layer.
+		return sendResponse, err
+	}
+}
+
+// address returns the address of the server the client's requests are sent to
+func (ap *APIProxy) address(client *api.Client) string {
+	if ap.addressFunc != nil {
+		if address := ap.addressFunc(); address != "" {
+			return address
+		}
 	}
+	return client.Address()
+}
 
-	// Before error checking from the request call, we'd want to initialize a SendResponse to
-	// potentially return
-	sendResponse, newErr := NewSendResponse(resp, nil)
-	if newErr != nil {
-		return nil, newErr
+// backoff returns the exponential backoff before the retry following the
+// given attempt
+func (ap *APIProxy) backoff(attempt int) time.Duration {
+	backoff := ap.minBackoff
+	for i := 0; i < attempt && backoff < ap.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > ap.maxBackoff {
+		backoff = ap.maxBackoff
 	}
+	return backoff
+}
 
-	// Bubble back the api.Response as well for error checking/handling at the handler layer.
-	return sendResponse, err
+// isServerFailure reports whether a request failed because the server could
+// not be reached or is unavailable, as opposed to an error returned by Vault
+func isServerFailure(resp *api.Response, err error) bool {
+	if resp == nil {
+		return err != nil
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
 }
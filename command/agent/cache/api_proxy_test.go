@@ -1,8 +1,14 @@
 package cache
 
 import (
+	"context"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/vault/api"
@@ -93,3 +99,110 @@ func TestAPIProxy_queryParams(t *testing.T) {
 		t.Fatalf("exptected standby to return 200, got: %v", resp.Response.StatusCode)
 	}
 }
+
+func TestAPIProxy_Retry(t *testing.T) {
+	var failedRequests, requests int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&failedRequests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte(`{"data":{"foo":"bar"}}`))
+	}))
+	defer healthy.Close()
+
+	// The client's requests are sent to the current address, as done by the
+	// agent's failover transport
+	var addressLock sync.Mutex
+	address := failing.URL
+	currentAddress := func() string {
+		addressLock.Lock()
+		defer addressLock.Unlock()
+		return address
+	}
+	config := api.DefaultConfig()
+	config.Address = failing.URL
+	config.HttpClient.Transport = roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		u, err := url.Parse(currentAddress())
+		if err != nil {
+			return nil, err
+		}
+		r = r.Clone(r.Context())
+		r.URL.Host = u.Host
+		r.Host = ""
+		return http.DefaultTransport.RoundTrip(r)
+	})
+	client, err := api.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var failedOver []string
+	proxier, err := NewAPIProxy(&APIProxyConfig{
+		Client:      client,
+		Logger:      logging.NewVaultLogger(hclog.Trace),
+		NumRetries:  2,
+		MinBackoff:  10 * time.Millisecond,
+		MaxBackoff:  20 * time.Millisecond,
+		AddressFunc: currentAddress,
+		FailoverFunc: func(_ context.Context, failedAddress string) {
+			failedOver = append(failedOver, failedAddress)
+			addressLock.Lock()
+			address = healthy.URL
+			addressLock.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/v1/secret/foo", nil)
+	resp, err := proxier.Send(context.Background(), &SendRequest{
+		Request: req,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Response.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.Response.StatusCode)
+	}
+	if len(failedOver) != 1 || failedOver[0] != failing.URL {
+		t.Fatalf("expected a failover from %q, got %v", failing.URL, failedOver)
+	}
+	if atomic.LoadInt32(&failedRequests) != 1 || atomic.LoadInt32(&requests) != 1 {
+		t.Fatalf("expected a single request to each server, got %d and %d", failedRequests, requests)
+	}
+
+	// Requests are retried up to the number of retries
+	addressLock.Lock()
+	address = failing.URL
+	addressLock.Unlock()
+	proxier, err = NewAPIProxy(&APIProxyConfig{
+		Client:     client,
+		Logger:     logging.NewVaultLogger(hclog.Trace),
+		NumRetries: 2,
+		MinBackoff: 10 * time.Millisecond,
+		MaxBackoff: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&failedRequests, 0)
+	_, err = proxier.Send(context.Background(), &SendRequest{
+		Request: httptest.NewRequest("GET", "/v1/secret/foo", nil),
+	})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if n := atomic.LoadInt32(&failedRequests); n != 3 {
+		t.Fatalf("expected 3 attempts, got %d", n)
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
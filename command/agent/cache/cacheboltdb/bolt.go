@@ -0,0 +1,372 @@
+package cacheboltdb
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/errwrap"
+	hclog "github.com/hashicorp/go-hclog"
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/aead"
+	"github.com/hashicorp/go-multierror"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// DatabaseFileName is the name of the file holding the persistent cache
+	// within the configured directory.
+	DatabaseFileName = "vault-agent-cache.db"
+
+	// TokenType is the type of the persisted indexes holding tokens.
+	TokenType = "token"
+
+	// LeaseType is the type of the persisted indexes holding leases.
+	LeaseType = "lease"
+
+	metaBucketName = "meta"
+
+	// protectedKeyName is the key in the meta bucket under which the data
+	// key, as protected by the configured KeyProtector, is stored.
+	protectedKeyName = "protected-key"
+
+	dataKeySize = 32
+)
+
+// BoltStorage is a persistent cache for the agent's lease cache, backed by a
+// bolt database. Every entry is encrypted with a data key generated when the
+// database is created. The data key is only ever stored in the form returned
+// by the configured KeyProtector.
+type BoltStorage struct {
+	db        *bolt.DB
+	logger    hclog.Logger
+	protector KeyProtector
+	wrapper   *aead.Wrapper
+
+	l       sync.Mutex
+	key     []byte
+	token   string
+	stopCh  chan struct{}
+	closed  bool
+	refresh *time.Ticker
+}
+
+// BoltStorageConfig is the configuration for creating a BoltStorage.
+type BoltStorageConfig struct {
+	// Path is the directory in which the database file is kept.
+	Path string
+
+	Logger       hclog.Logger
+	KeyProtector KeyProtector
+}
+
+// NewBoltStorage opens the persistent cache in the configured directory,
+// creating it if it does not exist. If the database already holds a protected
+// data key, the key is recovered so that existing entries can be read;
+// otherwise a new data key is generated and any existing entries, which can no
+// longer be decrypted, are discarded.
+func NewBoltStorage(config *BoltStorageConfig) (*BoltStorage, error) {
+	if config == nil {
+		return nil, errors.New("nil configuration provided")
+	}
+	if config.Path == "" {
+		return nil, errors.New("missing path")
+	}
+	if config.Logger == nil {
+		return nil, errors.New("nil logger provided")
+	}
+	if config.KeyProtector == nil {
+		return nil, errors.New("nil key protector provided")
+	}
+
+	dbPath := filepath.Join(config.Path, DatabaseFileName)
+	db, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, errwrap.Wrapf("error opening persistent cache: {{err}}", err)
+	}
+
+	b := &BoltStorage{
+		db:        db,
+		logger:    config.Logger,
+		protector: config.KeyProtector,
+		stopCh:    make(chan struct{}),
+	}
+
+	if err := b.setup(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (b *BoltStorage) setup() error {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{metaBucketName, TokenType, LeaseType} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errwrap.Wrapf("error creating persistent cache buckets: {{err}}", err)
+	}
+
+	var protected []byte
+	err = b.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte(metaBucketName)).Get([]byte(protectedKeyName)); v != nil {
+			protected = make([]byte, len(v))
+			copy(protected, v)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	if protected != nil {
+		key, err := b.protector.Recover(ctx, protected)
+		if err != nil {
+			return errwrap.Wrapf("error recovering persistent cache key: {{err}}", err)
+		}
+		if err := b.setKey(key); err != nil {
+			return err
+		}
+
+		// Protectors that need a token may not be able to recover the key
+		// again from the same protected form, so drop it until the key is
+		// protected anew.
+		if b.protector.NeedsToken() {
+			return b.storeProtectedKey(nil)
+		}
+		return nil
+	}
+
+	b.logger.Debug("no persistent cache key found; discarding existing entries")
+	if err := b.clear(); err != nil {
+		return err
+	}
+
+	key := make([]byte, dataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return errwrap.Wrapf("error generating persistent cache key: {{err}}", err)
+	}
+	if err := b.setKey(key); err != nil {
+		return err
+	}
+
+	if !b.protector.NeedsToken() {
+		return b.protectKey(ctx, "")
+	}
+	return nil
+}
+
+func (b *BoltStorage) setKey(key []byte) error {
+	wrapper := aead.NewWrapper(nil)
+	if err := wrapper.SetAESGCMKeyBytes(key); err != nil {
+		return errwrap.Wrapf("error setting persistent cache key: {{err}}", err)
+	}
+	b.key = key
+	b.wrapper = wrapper
+	return nil
+}
+
+// protectKey protects the data key using the configured KeyProtector and
+// stores the result.
+func (b *BoltStorage) protectKey(ctx context.Context, token string) error {
+	protected, err := b.protector.Protect(ctx, b.key, token)
+	if err != nil {
+		return errwrap.Wrapf("error protecting persistent cache key: {{err}}", err)
+	}
+	return b.storeProtectedKey(protected)
+}
+
+func (b *BoltStorage) storeProtectedKey(protected []byte) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(metaBucketName))
+		if protected == nil {
+			return bucket.Delete([]byte(protectedKeyName))
+		}
+		return bucket.Put([]byte(protectedKeyName), protected)
+	})
+}
+
+// UpdateAutoAuthToken protects the data key using the given auto-auth token,
+// for KeyProtectors that need one. If the KeyProtector asks for the key to be
+// refreshed periodically, the most recent token is used to do so until the
+// storage is closed.
+func (b *BoltStorage) UpdateAutoAuthToken(ctx context.Context, token string) error {
+	if !b.protector.NeedsToken() {
+		return nil
+	}
+
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	if b.closed {
+		return errors.New("persistent cache is closed")
+	}
+
+	if err := b.protectKey(ctx, token); err != nil {
+		return err
+	}
+	b.token = token
+
+	if interval := b.protector.RefreshInterval(); interval > 0 && b.refresh == nil {
+		b.refresh = time.NewTicker(interval)
+		go b.runRefresh(b.refresh.C)
+	}
+
+	return nil
+}
+
+func (b *BoltStorage) runRefresh(tickCh <-chan time.Time) {
+	for {
+		select {
+		case <-b.stopCh:
+			return
+		case <-tickCh:
+			b.l.Lock()
+			if !b.closed {
+				if err := b.protectKey(context.Background(), b.token); err != nil {
+					b.logger.Error("failed to refresh persistent cache key", "error", err)
+				}
+			}
+			b.l.Unlock()
+		}
+	}
+}
+
+// Set encrypts and stores the serialized index with the given ID and type.
+func (b *BoltStorage) Set(ctx context.Context, id string, plaintext []byte, indexType string) error {
+	if err := validateType(indexType); err != nil {
+		return err
+	}
+
+	blob, err := b.wrapper.Encrypt(ctx, plaintext, []byte(id))
+	if err != nil {
+		return errwrap.Wrapf("error encrypting index: {{err}}", err)
+	}
+	value, err := proto.Marshal(blob)
+	if err != nil {
+		return errwrap.Wrapf("error marshaling index: {{err}}", err)
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(indexType)).Put([]byte(id), value)
+	})
+}
+
+// Delete removes the index with the given ID, whatever its type.
+func (b *BoltStorage) Delete(id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{TokenType, LeaseType} {
+			if err := tx.Bucket([]byte(name)).Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetByType returns the decrypted serialized indexes of the given type.
+// Entries that fail to decrypt are skipped and reported in the returned error
+// along with the entries that could be read.
+func (b *BoltStorage) GetByType(ctx context.Context, indexType string) ([][]byte, error) {
+	if err := validateType(indexType); err != nil {
+		return nil, err
+	}
+
+	var values [][]byte
+	var errs *multierror.Error
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(indexType)).ForEach(func(id, value []byte) error {
+			blob := new(wrapping.EncryptedBlobInfo)
+			if err := proto.Unmarshal(value, blob); err != nil {
+				errs = multierror.Append(errs, errwrap.Wrapf(fmt.Sprintf("error unmarshaling index %q: {{err}}", id), err))
+				return nil
+			}
+			plaintext, err := b.wrapper.Decrypt(ctx, blob, id)
+			if err != nil {
+				errs = multierror.Append(errs, errwrap.Wrapf(fmt.Sprintf("error decrypting index %q: {{err}}", id), err))
+				return nil
+			}
+			values = append(values, plaintext)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return values, errs.ErrorOrNil()
+}
+
+// Clear removes all the persisted indexes. The data key is kept.
+func (b *BoltStorage) Clear() error {
+	return b.clear()
+}
+
+func (b *BoltStorage) clear() error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{TokenType, LeaseType} {
+			if err := tx.DeleteBucket([]byte(name)); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Close stops refreshing the protected data key and closes the database.
+func (b *BoltStorage) Close() error {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+
+	close(b.stopCh)
+	if b.refresh != nil {
+		b.refresh.Stop()
+	}
+
+	return b.db.Close()
+}
+
+// DBFileExists reports whether a persistent cache database exists in the
+// given directory.
+func DBFileExists(path string) (bool, error) {
+	_, err := os.Stat(filepath.Join(path, DatabaseFileName))
+	switch {
+	case err == nil:
+		return true, nil
+	case os.IsNotExist(err):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+func validateType(indexType string) error {
+	switch indexType {
+	case TokenType, LeaseType:
+		return nil
+	default:
+		return fmt.Errorf("unknown index type %q", indexType)
+	}
+}
@@ -0,0 +1,258 @@
+package cacheboltdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/aead"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/helper/logging"
+)
+
+func testKMSKeyProtector(t *testing.T, key []byte) *KMSKeyProtector {
+	t.Helper()
+
+	wrapper := aead.NewWrapper(nil)
+	if err := wrapper.SetAESGCMKeyBytes(key); err != nil {
+		t.Fatal(err)
+	}
+	return &KMSKeyProtector{
+		Wrapper: wrapper,
+	}
+}
+
+func testBoltStorage(t *testing.T, path string, protector KeyProtector) *BoltStorage {
+	t.Helper()
+
+	b, err := NewBoltStorage(&BoltStorageConfig{
+		Path:         path,
+		Logger:       logging.NewVaultLogger(hclog.Trace),
+		KeyProtector: protector,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestBoltStorage(t *testing.T) {
+	path, err := ioutil.TempDir("", "agent-cache-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+
+	ctx := context.Background()
+	kmsKey := []byte(strings.Repeat("k", 32))
+
+	b := testBoltStorage(t, path, testKMSKeyProtector(t, kmsKey))
+
+	if err := b.Set(ctx, "token-id", []byte("token-value"), TokenType); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Set(ctx, "lease-id", []byte("lease-value"), LeaseType); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Set(ctx, "other-id", []byte("other-value"), LeaseType); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Set(ctx, "id", []byte("value"), "unknown"); err == nil {
+		t.Fatal("expected an error setting an index of an unknown type")
+	}
+	if err := b.Delete("other-id"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	exists, err := DBFileExists(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Fatal("expected the database file to exist")
+	}
+
+	// Reopening the storage recovers the data key
+	b = testBoltStorage(t, path, testKMSKeyProtector(t, kmsKey))
+
+	tokens, err := b.GetByType(ctx, TokenType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(tokens, [][]byte{[]byte("token-value")}); diff != nil {
+		t.Fatal(diff)
+	}
+	leases, err := b.GetByType(ctx, LeaseType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(leases, [][]byte{[]byte("lease-value")}); diff != nil {
+		t.Fatal(diff)
+	}
+
+	if err := b.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err = b.GetByType(ctx, TokenType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 0 {
+		t.Fatalf("expected no tokens after clearing, got %d", len(tokens))
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The data key cannot be recovered with another KMS key
+	_, err = NewBoltStorage(&BoltStorageConfig{
+		Path:         path,
+		Logger:       logging.NewVaultLogger(hclog.Trace),
+		KeyProtector: testKMSKeyProtector(t, []byte(strings.Repeat("x", 32))),
+	})
+	if err == nil {
+		t.Fatal("expected an error recovering the key with the wrong KMS key")
+	}
+}
+
+// testWrappingServer emulates the response-wrapping endpoints of Vault.
+type testWrappingServer struct {
+	l       sync.Mutex
+	wrapped map[string]map[string]interface{}
+	ttls    map[string]string
+	count   int
+}
+
+func (s *testWrappingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	switch r.URL.Path {
+	case "/v1/sys/wrapping/wrap":
+		if r.Header.Get("X-Vault-Token") != "auto-auth-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var data map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.count++
+		token := fmt.Sprintf("wrapping-token-%d", s.count)
+		s.wrapped[token] = data
+		s.ttls[token] = r.Header.Get("X-Vault-Wrap-TTL")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"wrap_info": map[string]interface{}{
+				"token": token,
+			},
+		})
+
+	case "/v1/sys/wrapping/unwrap":
+		token := r.Header.Get("X-Vault-Token")
+		data, ok := s.wrapped[token]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		delete(s.wrapped, token)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": data,
+		})
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestBoltStorage_TokenKeyProtector(t *testing.T) {
+	path, err := ioutil.TempDir("", "agent-cache-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+
+	server := &testWrappingServer{
+		wrapped: make(map[string]map[string]interface{}),
+		ttls:    make(map[string]string),
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	config := api.DefaultConfig()
+	config.Address = ts.URL
+	client, err := api.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	protector := &TokenKeyProtector{
+		Client:  client,
+		WrapTTL: time.Hour,
+	}
+
+	ctx := context.Background()
+
+	b := testBoltStorage(t, path, protector)
+	if err := b.Set(ctx, "token-id", []byte("token-value"), TokenType); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.UpdateAutoAuthToken(ctx, "bad-token"); err == nil {
+		t.Fatal("expected an error protecting the key with a bad token")
+	}
+	if err := b.UpdateAutoAuthToken(ctx, "auto-auth-token"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(server.wrapped) != 1 {
+		t.Fatalf("expected a single wrapped key, got %d", len(server.wrapped))
+	}
+	for _, ttl := range server.ttls {
+		if ttl != "3600s" {
+			t.Fatalf("bad: wrap ttl: %q", ttl)
+		}
+	}
+
+	// Reopening the storage unwraps the key
+	b = testBoltStorage(t, path, protector)
+	tokens, err := b.GetByType(ctx, TokenType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(tokens, [][]byte{[]byte("token-value")}); diff != nil {
+		t.Fatal(diff)
+	}
+	if len(server.wrapped) != 0 {
+		t.Fatalf("expected the wrapped key to be consumed, got %d", len(server.wrapped))
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Since the key was not wrapped again before closing, the entries are
+	// discarded on the next start
+	b = testBoltStorage(t, path, protector)
+	defer b.Close()
+	tokens, err = b.GetByType(ctx, TokenType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 0 {
+		t.Fatalf("expected no tokens, got %d", len(tokens))
+	}
+}
@@ -0,0 +1,135 @@
+package cacheboltdb
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/errwrap"
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/vault/api"
+)
+
+// KeyProtector protects the data key that encrypts the entries of the
+// persistent cache, so that it can be stored next to them.
+type KeyProtector interface {
+	// Protect returns the protected form of the given key. The token is the
+	// current auto-auth token, and is only set if NeedsToken returns true.
+	Protect(ctx context.Context, key []byte, token string) ([]byte, error)
+
+	// Recover returns the key from its protected form.
+	Recover(ctx context.Context, protected []byte) ([]byte, error)
+
+	// NeedsToken reports whether an auto-auth token is required to protect
+	// the key.
+	NeedsToken() bool
+
+	// RefreshInterval is the interval at which the key should be protected
+	// anew, or zero if the protected form does not expire.
+	RefreshInterval() time.Duration
+}
+
+// KMSKeyProtector protects the data key by encrypting it with a KMS wrapper,
+// such as the ones used to auto-unseal Vault.
+type KMSKeyProtector struct {
+	Wrapper wrapping.Wrapper
+}
+
+var _ KeyProtector = (*KMSKeyProtector)(nil)
+
+func (p *KMSKeyProtector) Protect(ctx context.Context, key []byte, _ string) ([]byte, error) {
+	blob, err := p.Wrapper.Encrypt(ctx, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(blob)
+}
+
+func (p *KMSKeyProtector) Recover(ctx context.Context, protected []byte) ([]byte, error) {
+	blob := new(wrapping.EncryptedBlobInfo)
+	if err := proto.Unmarshal(protected, blob); err != nil {
+		return nil, err
+	}
+	return p.Wrapper.Decrypt(ctx, blob, nil)
+}
+
+func (p *KMSKeyProtector) NeedsToken() bool {
+	return false
+}
+
+func (p *KMSKeyProtector) RefreshInterval() time.Duration {
+	return 0
+}
+
+// TokenKeyProtector protects the data key by response-wrapping it in Vault
+// using the auto-auth token. The protected form is the wrapping token, which
+// is only valid for WrapTTL and can only be unwrapped once, so the key is
+// wrapped again whenever a new auto-auth token is obtained and at half of
+// WrapTTL.
+type TokenKeyProtector struct {
+	Client  *api.Client
+	WrapTTL time.Duration
+}
+
+var _ KeyProtector = (*TokenKeyProtector)(nil)
+
+func (p *TokenKeyProtector) Protect(ctx context.Context, key []byte, token string) ([]byte, error) {
+	if token == "" {
+		return nil, errors.New("no auto-auth token available")
+	}
+
+	client, err := p.Client.Clone()
+	if err != nil {
+		return nil, err
+	}
+	client.SetToken(token)
+	wrapTTL := fmt.Sprintf("%ds", int64(p.WrapTTL.Seconds()))
+	client.SetWrappingLookupFunc(func(string, string) string {
+		return wrapTTL
+	})
+
+	secret, err := client.Logical().Write("sys/wrapping/wrap", map[string]interface{}{
+		"key": base64.StdEncoding.EncodeToString(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.WrapInfo == nil || secret.WrapInfo.Token == "" {
+		return nil, errors.New("no wrapping token returned")
+	}
+
+	return []byte(secret.WrapInfo.Token), nil
+}
+
+func (p *TokenKeyProtector) Recover(ctx context.Context, protected []byte) ([]byte, error) {
+	client, err := p.Client.Clone()
+	if err != nil {
+		return nil, err
+	}
+	client.SetToken("")
+
+	secret, err := client.Logical().Unwrap(string(protected))
+	if err != nil {
+		return nil, errwrap.Wrapf("error unwrapping key: {{err}}", err)
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, errors.New("no key found in wrapped response")
+	}
+	keyRaw, ok := secret.Data["key"].(string)
+	if !ok {
+		return nil, errors.New("no key found in wrapped response")
+	}
+
+	return base64.StdEncoding.DecodeString(keyRaw)
+}
+
+func (p *TokenKeyProtector) NeedsToken() bool {
+	return true
+}
+
+func (p *TokenKeyProtector) RefreshInterval() time.Duration {
+	return p.WrapTTL / 2
+}
@@ -1,6 +1,12 @@
 package cachememdb
 
-import "context"
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/jsonutil"
+)
 
 // Index holds the response to be cached along with multiple other values that
 // serve as pointers to refer back to this index.
@@ -47,7 +53,41 @@ type Index struct {
 	// RenewCtxInfo holds the context and the corresponding cancel func for the
 	// goroutine that manages the renewal of the secret belonging to the
 	// response in this index.
-	RenewCtxInfo *ContextInfo
+	RenewCtxInfo *ContextInfo `json:"-"`
+
+	// RequestMethod is the HTTP method of the request that resulted in the
+	// response held by this index.
+	RequestMethod string
+
+	// RequestToken is the token used in the request that resulted in the
+	// response held by this index. It is used to renew the secret in the
+	// response.
+	RequestToken string
+
+	// RequestHeader is the header of the request that resulted in the
+	// response held by this index. It is used to renew the secret in the
+	// response.
+	RequestHeader http.Header
+
+	// LastRenewed is the time at which the secret in the response held by this
+	// index was last renewed, or cached if it has not been renewed yet.
+	LastRenewed time.Time
+}
+
+// Serialize returns a byte representation of the index, for storing it
+// outside of memory. The renewal context is not included.
+func (i *Index) Serialize() ([]byte, error) {
+	return jsonutil.EncodeJSON(i)
+}
+
+// Deserialize returns the index represented by the given bytes, as created by
+// Serialize.
+func Deserialize(indexBytes []byte) (*Index, error) {
+	index := new(Index)
+	if err := jsonutil.DecodeJSON(indexBytes, index); err != nil {
+		return nil, err
+	}
+	return index, nil
 }
 
 type IndexName uint32
@@ -10,24 +10,49 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"path"
+	"strings"
 	"time"
 
+	metrics "github.com/armon/go-metrics"
 	"github.com/hashicorp/errwrap"
 	hclog "github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/vault/api"
 	"github.com/hashicorp/vault/command/agent/sink"
 	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
-func Handler(ctx context.Context, logger hclog.Logger, proxier Proxier, inmemSink sink.Sink) http.Handler {
+// autoAuthTokenPath returns the path of the request matched against the
+// auto_auth_token_paths of its listener: the cleaned path relative to /v1/,
+// prefixed with the namespace of the request, if any
+func autoAuthTokenPath(r *http.Request) string {
+	reqPath := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/v1/")
+
+	ns := strings.Trim(path.Clean("/"+r.Header.Get(consts.NamespaceHeaderName)), "/")
+	if ns == "" {
+		return reqPath
+	}
+	return ns + "/" + reqPath
+}
+
+// Handler returns the handler of the requests proxied by the agent. If
+// inmemSink is set, the auto-auth token it holds is used for requests made
+// without a token. If autoAuthTokenPaths is also set, it is only used for the
+// paths matching one of its entries, which may end with a glob.
+func Handler(ctx context.Context, logger hclog.Logger, proxier Proxier, inmemSink sink.Sink, autoAuthTokenPaths []string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		logger.Info("received request", "method", r.Method, "path", r.URL.Path)
 
 		token := r.Header.Get(consts.AuthHeaderName)
 		if token == "" && inmemSink != nil {
-			logger.Debug("using auto auth token", "method", r.Method, "path", r.URL.Path)
-			token = inmemSink.(sink.SinkReader).Token()
+			if len(autoAuthTokenPaths) == 0 || strutil.StrListContainsGlob(autoAuthTokenPaths, autoAuthTokenPath(r)) {
+				logger.Debug("using auto auth token", "method", r.Method, "path", r.URL.Path)
+				token = inmemSink.(sink.SinkReader).Token()
+			} else {
+				logger.Debug("auto auth token not allowed for path; forwarding request without a token", "method", r.Method, "path", r.URL.Path)
+			}
 		}
 
 		// Parse and reset body.
@@ -87,6 +112,7 @@ func setHeaders(w http.ResponseWriter, resp *SendResponse) {
 
 		if resp.CacheMeta.Hit {
 			xCacheVal = "HIT"
+			metrics.IncrCounter([]string{"agent", "cache", "hit"}, 1)
 
 			// If this is a cache hit, we also set the Age header
 			age := fmt.Sprintf("%.0f", resp.CacheMeta.Age.Seconds())
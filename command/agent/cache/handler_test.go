@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/command/agent/sink/mock"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/helper/logging"
+)
+
+// tokenProxier records the token of the requests it receives.
+type tokenProxier struct {
+	token string
+}
+
+func (p *tokenProxier) Send(ctx context.Context, req *SendRequest) (*SendResponse, error) {
+	p.token = req.Token
+	return newTestSendResponse(http.StatusOK, `{"data": {}}`), nil
+}
+
+func TestHandler_AutoAuthTokenPaths(t *testing.T) {
+	testCases := map[string]struct {
+		paths     []string
+		path      string
+		namespace string
+		token     string
+		expected  string
+	}{
+		"no restriction": {
+			path:     "/v1/secret/foo",
+			expected: "auto-auth-token",
+		},
+		"allowed path": {
+			paths:    []string{"secret/foo"},
+			path:     "/v1/secret/foo",
+			expected: "auto-auth-token",
+		},
+		"allowed glob": {
+			paths:    []string{"auth/token/lookup-self", "secret/*"},
+			path:     "/v1/secret/foo/bar",
+			expected: "auto-auth-token",
+		},
+		"disallowed path": {
+			paths:    []string{"secret/foo"},
+			path:     "/v1/secret/bar",
+			expected: "",
+		},
+		"unclean path": {
+			paths:    []string{"secret/foo"},
+			path:     "/v1//secret/./bar/../foo",
+			expected: "auto-auth-token",
+		},
+		"unclean disallowed path": {
+			paths:    []string{"auth/*"},
+			path:     "/v1/auth/../secret/foo",
+			expected: "",
+		},
+		"namespace": {
+			paths:     []string{"ns1/secret/*"},
+			path:      "/v1/secret/foo",
+			namespace: "/ns1/",
+			expected:  "auto-auth-token",
+		},
+		"disallowed namespace": {
+			paths:     []string{"secret/*"},
+			path:      "/v1/secret/foo",
+			namespace: "ns1",
+			expected:  "",
+		},
+		"request token": {
+			paths:    []string{"secret/foo"},
+			path:     "/v1/secret/bar",
+			token:    "request-token",
+			expected: "request-token",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			proxier := new(tokenProxier)
+			handler := Handler(context.Background(), logging.NewVaultLogger(hclog.Trace), proxier, mock.NewSink("auto-auth-token"), tc.paths)
+
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.token != "" {
+				req.Header.Set(consts.AuthHeaderName, tc.token)
+			}
+			if tc.namespace != "" {
+				req.Header.Set(consts.NamespaceHeaderName, tc.namespace)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("bad: status: %d", w.Code)
+			}
+			if proxier.token != tc.expected {
+				t.Fatalf("bad: token: expected %q, got %q", tc.expected, proxier.token)
+			}
+		})
+	}
+}
@@ -11,13 +11,17 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strings"
 	"sync"
 	"time"
 
+	metrics "github.com/armon/go-metrics"
 	"github.com/hashicorp/errwrap"
 	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/command/agent/cache/cacheboltdb"
 	cachememdb "github.com/hashicorp/vault/command/agent/cache/cachememdb"
 	"github.com/hashicorp/vault/helper/namespace"
 	nshelper "github.com/hashicorp/vault/helper/namespace"
@@ -75,6 +79,17 @@ type LeaseCache struct {
 	baseCtxInfo *cachememdb.ContextInfo
 	l           *sync.RWMutex
 
+	// shutdownCtx is the context the lease cache was created with. Entries
+	// evicted because it is done are kept in the persistent storage, so that
+	// they can be restored on the next start.
+	shutdownCtx context.Context
+
+	// ps is the persistent storage for the cached entries, if any.
+	ps *cacheboltdb.BoltStorage
+
+	// staticSecrets caches the responses to KV secret reads, if enabled.
+	staticSecrets *staticSecretCache
+
 	// idLocks is used during cache lookup to ensure that identical requests made
 	// in parallel won't trigger multiple renewal goroutines.
 	idLocks []*locksutil.LockEntry
@@ -87,6 +102,15 @@ type LeaseCacheConfig struct {
 	BaseContext context.Context
 	Proxier     Proxier
 	Logger      hclog.Logger
+
+	// Storage, if set, persists the cached entries so that they can be
+	// restored using Restore.
+	Storage *cacheboltdb.BoltStorage
+
+	// CacheStaticSecrets enables caching the responses to KV secret reads,
+	// which are fetched again from Vault after StaticSecretRefreshInterval.
+	CacheStaticSecrets          bool
+	StaticSecretRefreshInterval time.Duration
 }
 
 // NewLeaseCache creates a new instance of a LeaseCache.
@@ -111,14 +135,25 @@ func NewLeaseCache(conf *LeaseCacheConfig) (*LeaseCache, error) {
 	// Create a base context for the lease cache layer
 	baseCtxInfo := cachememdb.NewContextInfo(conf.BaseContext)
 
+	var staticSecrets *staticSecretCache
+	if conf.CacheStaticSecrets {
+		if conf.StaticSecretRefreshInterval <= 0 {
+			return nil, errors.New("static secret refresh interval must be positive")
+		}
+		staticSecrets = newStaticSecretCache(conf.Client, conf.Proxier, conf.Logger.Named("staticsecrets"), conf.StaticSecretRefreshInterval)
+	}
+
 	return &LeaseCache{
-		client:      conf.Client,
-		proxier:     conf.Proxier,
-		logger:      conf.Logger,
-		db:          db,
-		baseCtxInfo: baseCtxInfo,
-		l:           &sync.RWMutex{},
-		idLocks:     locksutil.CreateLocks(),
+		client:        conf.Client,
+		proxier:       conf.Proxier,
+		logger:        conf.Logger,
+		db:            db,
+		baseCtxInfo:   baseCtxInfo,
+		l:             &sync.RWMutex{},
+		shutdownCtx:   conf.BaseContext,
+		ps:            conf.Storage,
+		staticSecrets: staticSecrets,
+		idLocks:       locksutil.CreateLocks(),
 	}, nil
 }
 
@@ -135,24 +170,32 @@ func (c *LeaseCache) checkCacheForRequest(id string) (*SendResponse, error) {
 	}
 
 	// Cached request is found, deserialize the response
-	reader := bufio.NewReader(bytes.NewReader(index.Response))
-	resp, err := http.ReadResponse(reader, nil)
+	sendResp, err := cachedSendResponse(index.Response)
 	if err != nil {
-		c.logger.Error("failed to deserialize response", "error", err)
+		c.logger.Error("failed to read cached response", "error", err)
 		return nil, err
 	}
 
-	sendResp, err := NewSendResponse(&api.Response{Response: resp}, index.Response)
+	return sendResp, nil
+}
+
+// cachedSendResponse returns a SendResponse from the given serialized
+// response, marked as a cache hit.
+func cachedSendResponse(raw []byte) (*SendResponse, error) {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(raw)), nil)
 	if err != nil {
-		c.logger.Error("failed to create new send response", "error", err)
-		return nil, err
+		return nil, errwrap.Wrapf("failed to deserialize response: {{err}}", err)
+	}
+
+	sendResp, err := NewSendResponse(&api.Response{Response: resp}, raw)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to create new send response: {{err}}", err)
 	}
 	sendResp.CacheMeta.Hit = true
 
 	respTime, err := http.ParseTime(resp.Header.Get("Date"))
 	if err != nil {
-		c.logger.Error("failed to parse cached response date", "error", err)
-		return nil, err
+		return nil, errwrap.Wrapf("failed to parse cached response date: {{err}}", err)
 	}
 	sendResp.CacheMeta.Age = time.Now().Sub(respTime)
 
@@ -163,6 +206,14 @@ func (c *LeaseCache) checkCacheForRequest(id string) (*SendResponse, error) {
 // it will return the cached response, otherwise it will delegate to the
 // underlying Proxier and cache the received response.
 func (c *LeaseCache) Send(ctx context.Context, req *SendRequest) (*SendResponse, error) {
+	// Static secrets are cached separately, since their responses carry no
+	// lease and can be shared by tokens
+	if c.staticSecrets != nil {
+		if resp, handled, err := c.staticSecrets.Send(ctx, req); handled {
+			return resp, err
+		}
+	}
+
 	// Compute the index ID
 	id, err := computeIndexID(req)
 	if err != nil {
@@ -214,6 +265,10 @@ func (c *LeaseCache) Send(ctx context.Context, req *SendRequest) (*SendResponse,
 		return resp, err
 	}
 
+	if c.staticSecrets != nil && resp.Response.StatusCode < 300 {
+		c.staticSecrets.HandleWrite(req)
+	}
+
 	// If this is a non-2xx or if the returned response does not contain JSON payload,
 	// we skip caching
 	if resp.Response.StatusCode >= 300 || resp.Response.Header.Get("Content-Type") != "application/json" {
@@ -230,9 +285,13 @@ func (c *LeaseCache) Send(ctx context.Context, req *SendRequest) (*SendResponse,
 
 	// Build the index to cache based on the response received
 	index := &cachememdb.Index{
-		ID:          id,
-		Namespace:   namespace,
-		RequestPath: req.Request.URL.Path,
+		ID:            id,
+		Namespace:     namespace,
+		RequestPath:   req.Request.URL.Path,
+		RequestMethod: req.Request.Method,
+		RequestToken:  req.Token,
+		RequestHeader: req.Request.Header,
+		LastRenewed:   time.Now().UTC(),
 	}
 
 	secret, err := api.ParseSecret(bytes.NewReader(resp.ResponseBody))
@@ -360,6 +419,11 @@ func (c *LeaseCache) Send(ctx context.Context, req *SendRequest) (*SendResponse,
 		return nil, err
 	}
 
+	// Only requests whose response could be cached count as misses, so that
+	// the hit ratio is not skewed by requests the cache can't serve
+	metrics.IncrCounter([]string{"agent", "cache", "miss"}, 1)
+	c.persist(ctx, index)
+
 	// Start renewing the secret in the response
 	go c.startRenewing(renewCtx, index, req, secret)
 
@@ -375,6 +439,28 @@ func (c *LeaseCache) createCtxInfo(ctx context.Context) *cachememdb.ContextInfo
 	return cachememdb.NewContextInfo(ctx)
 }
 
+// persist writes the index to the persistent storage, if any. Failures are
+// only logged, since the index is still cached in memory.
+func (c *LeaseCache) persist(ctx context.Context, index *cachememdb.Index) {
+	if c.ps == nil {
+		return
+	}
+
+	indexType := cacheboltdb.LeaseType
+	if index.Token != "" {
+		indexType = cacheboltdb.TokenType
+	}
+
+	indexBytes, err := index.Serialize()
+	if err != nil {
+		c.logger.Error("failed to serialize index", "id", index.ID, "error", err)
+		return
+	}
+	if err := c.ps.Set(ctx, index.ID, indexBytes, indexType); err != nil {
+		c.logger.Error("failed to persist index", "id", index.ID, "error", err)
+	}
+}
+
 func (c *LeaseCache) startRenewing(ctx context.Context, index *cachememdb.Index, req *SendRequest, secret *api.Secret) {
 	defer func() {
 		id := ctx.Value(contextIndexID).(string)
@@ -384,6 +470,14 @@ func (c *LeaseCache) startRenewing(ctx context.Context, index *cachememdb.Index,
 			c.logger.Error("failed to evict index", "id", id, "error", err)
 			return
 		}
+
+		// Keep the entry in the persistent storage if the agent is shutting
+		// down, so that it can be restored on the next start
+		if c.ps != nil && c.shutdownCtx.Err() == nil {
+			if err := c.ps.Delete(id); err != nil {
+				c.logger.Error("failed to delete index from persistent storage", "id", id, "error", err)
+			}
+		}
 	}()
 
 	client, err := c.client.Clone()
@@ -418,12 +512,17 @@ func (c *LeaseCache) startRenewing(ctx context.Context, index *cachememdb.Index,
 			// This case covers renewal completion and renewal errors
 			if err != nil {
 				c.logger.Error("failed to renew secret", "error", err)
+				metrics.IncrCounter([]string{"agent", "cache", "renewal", "failure"}, 1)
 				return
 			}
 			c.logger.Debug("renewal halted; evicting from cache", "path", req.Request.URL.Path)
 			return
 		case <-watcher.RenewCh():
 			c.logger.Debug("secret renewed", "path", req.Request.URL.Path)
+			if c.ps != nil {
+				index.LastRenewed = time.Now().UTC()
+				c.persist(ctx, index)
+			}
 		case <-index.RenewCtxInfo.DoneCh:
 			// This case indicates the renewal process to shutdown and evict
 			// the cache entry. This is triggered when a specific secret
@@ -530,6 +629,10 @@ func (c *LeaseCache) handleCacheClear(ctx context.Context, in *cacheClearInput)
 			index.RenewCtxInfo.CancelFunc()
 		}
 
+		if c.staticSecrets != nil {
+			c.staticSecrets.EvictRequestPath(in.Namespace, in.RequestPath)
+		}
+
 	case "token":
 		if in.Token == "" {
 			return errors.New("token not provided")
@@ -605,6 +708,10 @@ func (c *LeaseCache) handleCacheClear(ctx context.Context, in *cacheClearInput)
 			return err
 		}
 
+		if c.staticSecrets != nil {
+			c.staticSecrets.Clear()
+		}
+
 	default:
 		return errInvalidType
 	}
@@ -736,6 +843,7 @@ func (c *LeaseCache) handleRevocationRequest(ctx context.Context, req *SendReque
 				c.logger.Error("failed to persist index", "error", err)
 				return false, err
 			}
+			c.persist(ctx, index)
 		}
 
 	case path == vaultPathLeaseRevoke:
@@ -860,6 +968,15 @@ func deriveNamespaceAndRevocationPath(req *SendRequest) (string, string) {
 	return namespace, fmt.Sprintf("/v1%s", nonVersionedPath)
 }
 
+// EvictToken evicts a token that has been revoked outside of the cache from
+// the cache, along with the tokens and leases derived from it.
+func (c *LeaseCache) EvictToken(ctx context.Context, token string) error {
+	return c.handleCacheClear(ctx, &cacheClearInput{
+		Type:  "token",
+		Token: token,
+	})
+}
+
 // RegisterAutoAuthToken adds the provided auto-token into the cache. This is
 // primarily used to register the auto-auth token and should only be called
 // within a sink's WriteToken func.
@@ -915,9 +1032,181 @@ func (c *LeaseCache) RegisterAutoAuthToken(token string) error {
 		return err
 	}
 
+	// Protect the persistent storage's key with the new token, if needed
+	if c.ps != nil {
+		if err := c.ps.UpdateAutoAuthToken(c.shutdownCtx, token); err != nil {
+			c.logger.Error("failed to protect persistent cache key with the auto-auth token", "error", err)
+			return err
+		}
+	}
+
 	return nil
 }
 
+// Restore loads the entries held in the persistent storage into the cache and
+// resumes their renewal. Tokens are restored before the tokens and leases
+// derived from them, so that revoking a token still evicts everything that
+// belongs to it. Entries that have expired since they were last renewed are
+// dropped. Entries that cannot be restored are reported in the returned error
+// without preventing the others from being restored.
+func (c *LeaseCache) Restore(ctx context.Context) error {
+	if c.ps == nil {
+		return errors.New("no persistent storage configured")
+	}
+
+	var errs *multierror.Error
+
+	tokens, err := c.ps.GetByType(ctx, cacheboltdb.TokenType)
+	if err != nil {
+		errs = multierror.Append(errs, err)
+	}
+
+	pending := make(map[string]*cachememdb.Index, len(tokens))
+	for _, tokenBytes := range tokens {
+		index, err := cachememdb.Deserialize(tokenBytes)
+		if err != nil {
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		pending[index.Token] = index
+	}
+
+	var restoreToken func(index *cachememdb.Index) error
+	restoreToken = func(index *cachememdb.Index) error {
+		delete(pending, index.Token)
+
+		secret, err := restoredSecret(index)
+		if err != nil {
+			return err
+		}
+		if secret.Auth == nil {
+			return fmt.Errorf("no token found in the response of index %q", index.ID)
+		}
+
+		// Restore the parent token first so that the token's context
+		// derives from it, as it would have when the token was created
+		var parentCtx context.Context
+		if !secret.Auth.Orphan {
+			if parent, ok := pending[index.RequestToken]; ok {
+				if err := restoreToken(parent); err != nil {
+					errs = multierror.Append(errs, err)
+				}
+			}
+			entry, err := c.db.Get(cachememdb.IndexNameToken, index.RequestToken)
+			if err != nil {
+				return err
+			}
+			if entry != nil {
+				parentCtx = entry.RenewCtxInfo.Ctx
+			}
+		}
+
+		return c.restoreIndex(index, secret, c.createCtxInfo(parentCtx), secret.Auth.LeaseDuration)
+	}
+
+	for len(pending) > 0 {
+		for _, index := range pending {
+			if err := restoreToken(index); err != nil {
+				errs = multierror.Append(errs, err)
+			}
+			break
+		}
+	}
+
+	leases, err := c.ps.GetByType(ctx, cacheboltdb.LeaseType)
+	if err != nil {
+		errs = multierror.Append(errs, err)
+	}
+
+	for _, leaseBytes := range leases {
+		index, err := cachememdb.Deserialize(leaseBytes)
+		if err != nil {
+			errs = multierror.Append(errs, err)
+			continue
+		}
+
+		secret, err := restoredSecret(index)
+		if err != nil {
+			errs = multierror.Append(errs, err)
+			continue
+		}
+
+		// Derive the lease's context from its token's if the token was
+		// restored, e.g. unless it was the previous auto-auth token
+		ctxInfo := c.createCtxInfo(nil)
+		entry, err := c.db.Get(cachememdb.IndexNameToken, index.LeaseToken)
+		if err != nil {
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		if entry != nil {
+			ctxInfo = cachememdb.NewContextInfo(entry.RenewCtxInfo.Ctx)
+		}
+
+		if err := c.restoreIndex(index, secret, ctxInfo, secret.LeaseDuration); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+
+	return errs.ErrorOrNil()
+}
+
+// restoreIndex caches a restored index and starts renewing its secret, unless
+// the secret has expired since the index was last renewed.
+func (c *LeaseCache) restoreIndex(index *cachememdb.Index, secret *api.Secret, ctxInfo *cachememdb.ContextInfo, ttl int) error {
+	if time.Now().After(index.LastRenewed.Add(time.Duration(ttl) * time.Second)) {
+		c.logger.Debug("dropping expired index from persistent storage", "id", index.ID, "path", index.RequestPath)
+		ctxInfo.CancelFunc()
+		return c.ps.Delete(index.ID)
+	}
+
+	renewCtx := context.WithValue(ctxInfo.Ctx, contextIndexID, index.ID)
+	index.RenewCtxInfo = &cachememdb.ContextInfo{
+		Ctx:        renewCtx,
+		CancelFunc: ctxInfo.CancelFunc,
+		DoneCh:     ctxInfo.DoneCh,
+	}
+
+	c.logger.Debug("restoring index from persistent storage", "id", index.ID, "method", index.RequestMethod, "path", index.RequestPath)
+	if err := c.db.Set(index); err != nil {
+		ctxInfo.CancelFunc()
+		return err
+	}
+
+	req := &SendRequest{
+		Token: index.RequestToken,
+		Request: &http.Request{
+			Method: index.RequestMethod,
+			URL: &url.URL{
+				Path: index.RequestPath,
+			},
+			Header: index.RequestHeader,
+		},
+	}
+	go c.startRenewing(renewCtx, index, req, secret)
+
+	return nil
+}
+
+// restoredSecret parses the secret out of the cached response of a restored
+// index.
+func restoredSecret(index *cachememdb.Index) (*api.Secret, error) {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(index.Response)), nil)
+	if err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("failed to deserialize response of index %q: {{err}}", index.ID), err)
+	}
+	defer resp.Body.Close()
+
+	secret, err := api.ParseSecret(resp.Body)
+	if err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("failed to parse secret of index %q: {{err}}", index.ID), err)
+	}
+	if secret == nil {
+		return nil, fmt.Errorf("no secret found in the response of index %q", index.ID)
+	}
+	return secret, nil
+}
+
 type cacheClearInput struct {
 	Type string
 
@@ -3,17 +3,22 @@ package cache
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/vault/command/agent/cache/cacheboltdb"
 	"github.com/hashicorp/vault/command/agent/cache/cachememdb"
 
 	"github.com/go-test/deep"
 	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/aead"
 	"github.com/hashicorp/vault/api"
 	"github.com/hashicorp/vault/sdk/helper/consts"
 	"github.com/hashicorp/vault/sdk/helper/logging"
@@ -363,6 +368,145 @@ func TestLeaseCache_HandleCacheClear(t *testing.T) {
 	}
 }
 
+func TestLeaseCache_PersistAndRestore(t *testing.T) {
+	path, err := ioutil.TempDir("", "agent-cache-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+
+	wrapper := aead.NewWrapper(nil)
+	if err := wrapper.SetAESGCMKeyBytes([]byte(strings.Repeat("k", 32))); err != nil {
+		t.Fatal(err)
+	}
+	storageConfig := &cacheboltdb.BoltStorageConfig{
+		Path:   path,
+		Logger: logging.NewVaultLogger(hclog.Trace).Named("cache.persist"),
+		KeyProtector: &cacheboltdb.KMSKeyProtector{
+			Wrapper: wrapper,
+		},
+	}
+
+	client, err := api.NewClient(api.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newLeaseCache := func(ctx context.Context, responses []*SendResponse) (*LeaseCache, *cacheboltdb.BoltStorage) {
+		storage, err := cacheboltdb.NewBoltStorage(storageConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lc, err := NewLeaseCache(&LeaseCacheConfig{
+			Client:      client,
+			BaseContext: ctx,
+			Proxier:     newMockProxier(responses),
+			Logger:      logging.NewVaultLogger(hclog.Trace).Named("cache.leasecache"),
+			Storage:     storage,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return lc, storage
+	}
+
+	tokenRequest := func() *SendRequest {
+		return &SendRequest{
+			Token:   "autoauthtoken",
+			Request: httptest.NewRequest("GET", "http://example.com/v1/auth/token/create", strings.NewReader(`{"policies": ["default"]}`)),
+		}
+	}
+	leaseRequest := func() *SendRequest {
+		return &SendRequest{
+			Token:   "testtoken",
+			Request: httptest.NewRequest("GET", "http://example.com/v1/sample/api", strings.NewReader(`{"value": "input"}`)),
+		}
+	}
+
+	// Cache a token derived from the auto-auth token and a lease belonging to
+	// that token
+	ctx, cancel := context.WithCancel(context.Background())
+	lc, storage := newLeaseCache(ctx, []*SendResponse{
+		newTestSendResponse(http.StatusOK, `{"auth": {"client_token": "testtoken", "accessor": "testaccessor", "renewable": true, "lease_duration": 3600}}`),
+		newTestSendResponse(http.StatusOK, `{"lease_id": "foo", "renewable": true, "lease_duration": 3600, "data": {"value": "foo"}}`),
+	})
+	if err := lc.RegisterAutoAuthToken("autoauthtoken"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lc.Send(context.Background(), tokenRequest()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lc.Send(context.Background(), leaseRequest()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Shutting down keeps the entries in the persistent storage
+	cancel()
+	time.Sleep(100 * time.Millisecond)
+	if err := storage.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restore the entries into a new cache, which must not proxy any request
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	lc, storage = newLeaseCache(ctx, nil)
+	defer storage.Close()
+	if err := lc.Restore(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	tokenIndex, err := lc.db.Get(cachememdb.IndexNameToken, "testtoken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokenIndex == nil {
+		t.Fatal("expected the token to be restored")
+	}
+	if tokenIndex.TokenAccessor != "testaccessor" || tokenIndex.RequestToken != "autoauthtoken" {
+		t.Fatalf("bad: restored token index: %#v", tokenIndex)
+	}
+	leaseIndex, err := lc.db.Get(cachememdb.IndexNameLease, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaseIndex == nil {
+		t.Fatal("expected the lease to be restored")
+	}
+
+	for _, req := range []*SendRequest{tokenRequest(), leaseRequest()} {
+		resp, err := lc.Send(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !resp.CacheMeta.Hit {
+			t.Fatalf("expected a cache hit for %q", req.Request.URL.Path)
+		}
+	}
+
+	// The lease is evicted along with the token it belongs to, both from
+	// memory and from the persistent storage
+	if err := lc.handleCacheClear(ctx, &cacheClearInput{Type: "token", Token: "testtoken"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	leaseIndex, err = lc.db.Get(cachememdb.IndexNameLease, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaseIndex != nil {
+		t.Fatal("expected the lease to be evicted")
+	}
+	leases, err := storage.GetByType(ctx, cacheboltdb.LeaseType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leases) != 0 {
+		t.Fatalf("expected the lease to be deleted from the persistent storage, got %d leases", len(leases))
+	}
+}
+
 func TestCache_DeriveNamespaceAndRevocationPath(t *testing.T) {
 	tests := []struct {
 		name             string
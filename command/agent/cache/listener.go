@@ -40,14 +40,28 @@ func StartListener(lnConfig *config.Listener) (net.Listener, *tls.Config, error)
 		ln = &server.TCPKeepAliveListener{ln.(*net.TCPListener)}
 
 	case "unix":
+		// Each of the socket file's mode, owner and group is only changed
+		// if it is set
 		var uConfig *listenerutil.UnixSocketsConfig
-		if lnConfig.Config["socket_mode"] != nil &&
-			lnConfig.Config["socket_user"] != nil &&
-			lnConfig.Config["socket_group"] != nil {
-			uConfig = &listenerutil.UnixSocketsConfig{
-				Mode:  lnConfig.Config["socket_mode"].(string),
-				User:  lnConfig.Config["socket_user"].(string),
-				Group: lnConfig.Config["socket_group"].(string),
+		for _, key := range []string{"socket_mode", "socket_user", "socket_group"} {
+			v, ok := lnConfig.Config[key]
+			if !ok {
+				continue
+			}
+			value, ok := v.(string)
+			if !ok {
+				return nil, nil, fmt.Errorf("invalid %s", key)
+			}
+			if uConfig == nil {
+				uConfig = new(listenerutil.UnixSocketsConfig)
+			}
+			switch key {
+			case "socket_mode":
+				uConfig.Mode = value
+			case "socket_user":
+				uConfig.User = value
+			case "socket_group":
+				uConfig.Group = value
 			}
 		}
 		ln, err = listenerutil.UnixSocketListener(addr, uConfig)
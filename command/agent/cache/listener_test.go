@@ -0,0 +1,65 @@
+// +build !windows
+
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/vault/command/agent/config"
+)
+
+func TestStartListener_Unix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent-listener-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The mode is applied even if the owner and group are not set
+	path := filepath.Join(dir, "agent.sock")
+	ln, _, err := StartListener(&config.Listener{
+		Type: "unix",
+		Config: map[string]interface{}{
+			"address":     path,
+			"tls_disable": true,
+			"socket_mode": "0600",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		t.Fatalf("expected a socket, got mode %v", info.Mode())
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Fatalf("bad: permissions: %o", perm)
+	}
+
+	// The socket file is removed when the listener is closed
+	if err := ln.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected the socket file to be removed, got %v", err)
+	}
+
+	_, _, err = StartListener(&config.Listener{
+		Type: "unix",
+		Config: map[string]interface{}{
+			"address":     path,
+			"tls_disable": true,
+			"socket_mode": 600,
+		},
+	})
+	if err == nil {
+		t.Fatal("expected an error with a non-string socket mode")
+	}
+}
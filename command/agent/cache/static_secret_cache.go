@@ -0,0 +1,443 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/helper/cryptoutil"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+)
+
+// kvV2Operations are the path segments following the mount path of a KV v2
+// secrets engine that operate on a secret, whose path follows them.
+var kvV2Operations = []string{"data", "metadata", "delete", "undelete", "destroy"}
+
+// nonKVPrefixes are the prefixes of the paths that never belong to a KV
+// secrets engine, which are not looked up.
+var nonKVPrefixes = []string{"sys/", "auth/", "identity/", "cubbyhole/"}
+
+// staticSecretCache caches the responses to KV secret reads, which carry no
+// lease and are therefore not cached by the LeaseCache. Since these responses
+// do not depend on the token that read them, a cached response is shared by
+// all the tokens that are allowed to read it. Each token's capabilities on the
+// path are verified the first time it reads a cached response, and again once
+// the refresh interval has passed.
+//
+// Cached responses are fetched again from Vault once they are older than the
+// refresh interval, or as soon as a write to the same secret is proxied by the
+// agent.
+type staticSecretCache struct {
+	client          *api.Client
+	proxier         Proxier
+	logger          hclog.Logger
+	refreshInterval time.Duration
+
+	l sync.RWMutex
+
+	// mounts holds the mounts that requests were made to, by namespace.
+	mounts map[string][]*staticSecretMount
+
+	// entries holds the cached responses, by namespace and request URI.
+	entries map[string]*staticSecretEntry
+
+	// idLocks ensures that identical requests made in parallel fetch the
+	// secret or verify the token's capabilities only once.
+	idLocks []*locksutil.LockEntry
+}
+
+// staticSecretMount is a mount that requests were made to, as described by
+// Vault's sys/internal/ui/mounts endpoint.
+type staticSecretMount struct {
+	path      string
+	kvVersion int
+	fetched   time.Time
+}
+
+// staticSecretEntry is a cached response to a KV secret read.
+type staticSecretEntry struct {
+	namespace   string
+	requestPath string
+
+	// secretPath is the path of the secret that was read, relative to the
+	// namespace. It does not include the operation of KV v2 paths, so that
+	// writes to any of them invalidate the entry.
+	secretPath string
+
+	response []byte
+	fetched  time.Time
+
+	// tokens holds the time at which the capabilities of each token that
+	// read the entry were verified, by token hash.
+	tokens map[string]time.Time
+}
+
+func newStaticSecretCache(client *api.Client, proxier Proxier, logger hclog.Logger, refreshInterval time.Duration) *staticSecretCache {
+	return &staticSecretCache{
+		client:          client,
+		proxier:         proxier,
+		logger:          logger,
+		refreshInterval: refreshInterval,
+		mounts:          make(map[string][]*staticSecretMount),
+		entries:         make(map[string]*staticSecretEntry),
+		idLocks:         locksutil.CreateLocks(),
+	}
+}
+
+// Send serves the request from the cache if it reads a KV secret, fetching
+// the secret from Vault if it is not cached yet. It returns false if the
+// request is not a KV secret read the cache can serve, in which case it is
+// left to the caller.
+func (s *staticSecretCache) Send(ctx context.Context, req *SendRequest) (*SendResponse, bool, error) {
+	if req.Token == "" || req.Request.Method != http.MethodGet {
+		return nil, false, nil
+	}
+	// Wrapped responses can only be unwrapped once, and lists are not reads
+	if req.Request.Header.Get("X-Vault-Wrap-TTL") != "" || req.Request.URL.Query().Get("list") != "" {
+		return nil, false, nil
+	}
+
+	namespace := requestNamespace(req)
+	path := strings.TrimPrefix(req.Request.URL.Path, "/v1/")
+	for _, prefix := range nonKVPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return nil, false, nil
+		}
+	}
+
+	mount, err := s.mount(req, namespace, path)
+	if err != nil {
+		s.logger.Debug("failed to look up mount; not caching", "path", req.Request.URL.Path, "error", err)
+		return nil, false, nil
+	}
+	secretPath, read := mount.secretPath(path)
+	if !read {
+		return nil, false, nil
+	}
+
+	id := namespace + req.Request.URL.RequestURI()
+	tokenHash := hashToken(req.Token)
+
+	idLock := locksutil.LockForKey(s.idLocks, id)
+
+	// Fast path for tokens that recently read the cached response
+	idLock.RLock()
+	resp, err := s.cachedResponse(id, tokenHash)
+	idLock.RUnlock()
+	if resp != nil || err != nil {
+		return resp, true, err
+	}
+
+	idLock.Lock()
+	defer idLock.Unlock()
+
+	// Check once more, since a parallel request may have fetched the secret
+	// or verified the token in the meantime
+	resp, err = s.cachedResponse(id, tokenHash)
+	if resp != nil || err != nil {
+		return resp, true, err
+	}
+
+	s.l.RLock()
+	entry := s.entries[id]
+	s.l.RUnlock()
+
+	if entry != nil && time.Since(entry.fetched) < s.refreshInterval {
+		allowed, err := s.canRead(req, path)
+		if err != nil {
+			s.logger.Error("failed to verify token capabilities", "path", req.Request.URL.Path, "error", err)
+			return nil, false, nil
+		}
+		if !allowed {
+			// Let Vault respond to the request, which it will deny
+			s.logger.Debug("token cannot read cached secret; forwarding request", "path", req.Request.URL.Path)
+			return nil, false, nil
+		}
+
+		s.l.Lock()
+		entry.tokens[tokenHash] = time.Now()
+		s.l.Unlock()
+
+		s.logger.Debug("returning cached static secret", "path", req.Request.URL.Path)
+		resp, err := cachedSendResponse(entry.response)
+		return resp, true, err
+	}
+
+	s.logger.Debug("fetching static secret", "path", req.Request.URL.Path)
+
+	resp, err = s.proxier.Send(ctx, req)
+	if err != nil || resp.Response.StatusCode != http.StatusOK || resp.Response.Header.Get("Content-Type") != "application/json" {
+		s.evict(id)
+		return resp, true, err
+	}
+
+	var respBytes bytes.Buffer
+	if err := resp.Response.Write(&respBytes); err != nil {
+		s.logger.Error("failed to serialize response", "error", err)
+		return nil, true, err
+	}
+
+	// Reset the response body for upper layers to read
+	if resp.Response.Body != nil {
+		resp.Response.Body.Close()
+	}
+	resp.Response.Body = ioutil.NopCloser(bytes.NewReader(resp.ResponseBody))
+
+	// The token was able to read the secret, so its capabilities need not be
+	// verified
+	now := time.Now()
+	s.l.Lock()
+	s.pruneLocked()
+	s.entries[id] = &staticSecretEntry{
+		namespace:   namespace,
+		requestPath: req.Request.URL.Path,
+		secretPath:  secretPath,
+		response:    respBytes.Bytes(),
+		fetched:     now,
+		tokens: map[string]time.Time{
+			tokenHash: now,
+		},
+	}
+	s.l.Unlock()
+
+	metrics.IncrCounter([]string{"agent", "cache", "miss"}, 1)
+
+	return resp, true, nil
+}
+
+// cachedResponse returns the cached response with the given ID if it is fresh
+// and the token's capabilities were recently verified.
+func (s *staticSecretCache) cachedResponse(id, tokenHash string) (*SendResponse, error) {
+	s.l.RLock()
+	defer s.l.RUnlock()
+
+	entry, ok := s.entries[id]
+	if !ok || time.Since(entry.fetched) >= s.refreshInterval {
+		return nil, nil
+	}
+	verified, ok := entry.tokens[tokenHash]
+	if !ok || time.Since(verified) >= s.refreshInterval {
+		return nil, nil
+	}
+
+	s.logger.Debug("returning cached static secret", "path", entry.requestPath)
+	return cachedSendResponse(entry.response)
+}
+
+// HandleWrite evicts the cached responses for the secret written to by the
+// given request, once it has been successfully proxied.
+func (s *staticSecretCache) HandleWrite(req *SendRequest) {
+	switch req.Request.Method {
+	case http.MethodGet, http.MethodHead, "LIST":
+		return
+	}
+
+	namespace := requestNamespace(req)
+	path := strings.TrimPrefix(req.Request.URL.Path, "/v1/")
+
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	// Mount changes may affect any of the cached secrets
+	if strings.HasPrefix(path, "sys/mounts") || strings.HasPrefix(path, "sys/remount") {
+		s.logger.Debug("mounts changed; evicting static secrets", "namespace", namespace)
+		delete(s.mounts, namespace)
+		for id, entry := range s.entries {
+			if entry.namespace == namespace {
+				delete(s.entries, id)
+			}
+		}
+		return
+	}
+
+	mount := s.findMountLocked(namespace, path, false)
+	if mount == nil || mount.kvVersion == 0 {
+		return
+	}
+	secretPath, _ := mount.secretPath(path)
+	if secretPath == "" {
+		return
+	}
+
+	for id, entry := range s.entries {
+		if entry.namespace == namespace && entry.secretPath == secretPath {
+			s.logger.Debug("secret written; evicting static secret", "path", entry.requestPath)
+			delete(s.entries, id)
+		}
+	}
+}
+
+// EvictRequestPath evicts the cached responses whose request path starts with
+// the given prefix.
+func (s *staticSecretCache) EvictRequestPath(namespace, prefix string) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	for id, entry := range s.entries {
+		if entry.namespace == namespace && strings.HasPrefix(entry.requestPath, prefix) {
+			delete(s.entries, id)
+		}
+	}
+}
+
+// Clear evicts all the cached responses and mounts.
+func (s *staticSecretCache) Clear() {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	s.mounts = make(map[string][]*staticSecretMount)
+	s.entries = make(map[string]*staticSecretEntry)
+}
+
+func (s *staticSecretCache) evict(id string) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	delete(s.entries, id)
+}
+
+// pruneLocked evicts the responses that are too old to be served. It must be
+// called with the lock held.
+func (s *staticSecretCache) pruneLocked() {
+	for id, entry := range s.entries {
+		if time.Since(entry.fetched) >= s.refreshInterval {
+			delete(s.entries, id)
+		}
+	}
+}
+
+// mount returns the mount the given path belongs to, looking it up in Vault
+// using the request's token if it is not known yet.
+func (s *staticSecretCache) mount(req *SendRequest, namespace, path string) (*staticSecretMount, error) {
+	s.l.RLock()
+	mount := s.findMountLocked(namespace, path, true)
+	s.l.RUnlock()
+	if mount != nil {
+		return mount, nil
+	}
+
+	client, err := s.requestClient(req)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := client.Logical().Read("sys/internal/ui/mounts/" + path)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, errors.New("no mount information returned")
+	}
+
+	mountPath, ok := secret.Data["path"].(string)
+	if !ok || mountPath == "" {
+		return nil, errors.New("no mount path returned")
+	}
+	mount = &staticSecretMount{
+		path:    mountPath,
+		fetched: time.Now(),
+	}
+	if mountType, _ := secret.Data["type"].(string); mountType == "kv" {
+		mount.kvVersion = 1
+		if options, ok := secret.Data["options"].(map[string]interface{}); ok && options["version"] == "2" {
+			mount.kvVersion = 2
+		}
+	}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+	mounts := []*staticSecretMount{mount}
+	for _, m := range s.mounts[namespace] {
+		if m.path != mount.path {
+			mounts = append(mounts, m)
+		}
+	}
+	s.mounts[namespace] = mounts
+
+	return mount, nil
+}
+
+// findMountLocked returns the known mount with the longest path the given path
+// belongs to. If fresh is true, mounts older than the refresh interval are
+// ignored. It must be called with the lock held.
+func (s *staticSecretCache) findMountLocked(namespace, path string, fresh bool) *staticSecretMount {
+	var found *staticSecretMount
+	for _, m := range s.mounts[namespace] {
+		if !strings.HasPrefix(path, m.path) {
+			continue
+		}
+		if fresh && time.Since(m.fetched) >= s.refreshInterval {
+			continue
+		}
+		if found == nil || len(m.path) > len(found.path) {
+			found = m
+		}
+	}
+	return found
+}
+
+// canRead reports whether the request's token is allowed to read the given
+// path.
+func (s *staticSecretCache) canRead(req *SendRequest, path string) (bool, error) {
+	client, err := s.requestClient(req)
+	if err != nil {
+		return false, err
+	}
+	capabilities, err := client.Sys().CapabilitiesSelf(path)
+	if err != nil {
+		return false, err
+	}
+	return strutil.StrListContains(capabilities, "read") || strutil.StrListContains(capabilities, "root"), nil
+}
+
+// requestClient returns a client making requests on behalf of the given
+// request.
+func (s *staticSecretCache) requestClient(req *SendRequest) (*api.Client, error) {
+	client, err := s.client.Clone()
+	if err != nil {
+		return nil, err
+	}
+	client.SetToken(req.Token)
+	client.SetHeaders(req.Request.Header)
+	return client, nil
+}
+
+// secretPath returns the path of the secret the given path, which belongs to
+// the mount, operates on, and whether the path reads the secret.
+func (m *staticSecretMount) secretPath(path string) (string, bool) {
+	switch m.kvVersion {
+	case 1:
+		return path, true
+	case 2:
+		rest := strings.TrimPrefix(path, m.path)
+		for _, op := range kvV2Operations {
+			if strings.HasPrefix(rest, op+"/") {
+				return m.path + strings.TrimPrefix(rest, op+"/"), op == "data"
+			}
+		}
+	}
+	return "", false
+}
+
+// requestNamespace returns the namespace of the request, defaulting to the
+// root namespace.
+func requestNamespace(req *SendRequest) string {
+	namespace := req.Request.Header.Get(consts.NamespaceHeaderName)
+	if namespace == "" {
+		namespace = "root/"
+	}
+	return namespace
+}
+
+func hashToken(token string) string {
+	return hex.EncodeToString(cryptoutil.Blake2b256Hash(token))
+}
@@ -0,0 +1,315 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/helper/logging"
+)
+
+// testKVServer emulates a Vault server with a KV v1 secrets engine mounted at
+// kv1/, a KV v2 secrets engine mounted at kv2/, and another secrets engine
+// mounted at other/. The "denied" token cannot read any secret.
+type testKVServer struct {
+	l            sync.Mutex
+	values       map[string]string
+	reads        map[string]int
+	capabilities int
+}
+
+func newTestKVServer() *testKVServer {
+	return &testKVServer{
+		values: make(map[string]string),
+		reads:  make(map[string]int),
+	}
+}
+
+func (s *testKVServer) respond(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func (s *testKVServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	token := r.Header.Get("X-Vault-Token")
+	if token == "" {
+		s.respond(w, http.StatusForbidden, map[string]interface{}{"errors": []string{"permission denied"}})
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+
+	switch {
+	case strings.HasPrefix(path, "sys/internal/ui/mounts/"):
+		path = strings.TrimPrefix(path, "sys/internal/ui/mounts/")
+		data := map[string]interface{}{
+			"path": "other/",
+			"type": "pki",
+		}
+		switch {
+		case strings.HasPrefix(path, "kv1/"):
+			data = map[string]interface{}{
+				"path": "kv1/",
+				"type": "kv",
+			}
+		case strings.HasPrefix(path, "kv2/"):
+			data = map[string]interface{}{
+				"path":    "kv2/",
+				"type":    "kv",
+				"options": map[string]interface{}{"version": "2"},
+			}
+		}
+		s.respond(w, http.StatusOK, map[string]interface{}{"data": data})
+
+	case path == "sys/capabilities-self":
+		s.capabilities++
+		capabilities := []string{"read"}
+		if token == "denied" {
+			capabilities = []string{"deny"}
+		}
+		s.respond(w, http.StatusOK, map[string]interface{}{
+			"data": map[string]interface{}{"capabilities": capabilities},
+		})
+
+	case token == "denied":
+		s.respond(w, http.StatusForbidden, map[string]interface{}{"errors": []string{"permission denied"}})
+
+	case r.Method == http.MethodGet:
+		s.reads[path]++
+		s.respond(w, http.StatusOK, map[string]interface{}{
+			"data": map[string]interface{}{"value": s.values[path]},
+		})
+
+	default:
+		// Writes to KV v2 paths apply to the data path
+		if strings.HasPrefix(path, "kv2/") {
+			path = "kv2/data/" + strings.SplitN(path, "/", 3)[2]
+		}
+		if r.Method == http.MethodDelete {
+			delete(s.values, path)
+		} else {
+			var body map[string]string
+			json.NewDecoder(r.Body).Decode(&body)
+			s.values[path] = body["value"]
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *testKVServer) readCount(path string) int {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.reads[path]
+}
+
+func (s *testKVServer) capabilityChecks() int {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.capabilities
+}
+
+func testNewStaticSecretLeaseCache(t *testing.T, addr string, refreshInterval time.Duration) *LeaseCache {
+	t.Helper()
+
+	config := api.DefaultConfig()
+	config.Address = addr
+	client, err := api.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetToken("")
+
+	logger := logging.NewVaultLogger(hclog.Trace)
+	proxier, err := NewAPIProxy(&APIProxyConfig{
+		Client: client,
+		Logger: logger.Named("cache.apiproxy"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lc, err := NewLeaseCache(&LeaseCacheConfig{
+		Client:                      client,
+		BaseContext:                 context.Background(),
+		Proxier:                     proxier,
+		Logger:                      logger.Named("cache.leasecache"),
+		CacheStaticSecrets:          true,
+		StaticSecretRefreshInterval: refreshInterval,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lc
+}
+
+// testStaticSecretSend sends a request through the lease cache and returns
+// the value of the secret in the response, and whether it was a cache hit.
+func testStaticSecretSend(t *testing.T, lc *LeaseCache, method, path, token, value string) (string, bool, error) {
+	t.Helper()
+
+	var body []byte
+	if value != "" {
+		body = []byte(fmt.Sprintf(`{"value": %q}`, value))
+	}
+	req := &SendRequest{
+		Token:       token,
+		Request:     httptest.NewRequest(method, path, bytes.NewReader(body)),
+		RequestBody: body,
+	}
+
+	resp, err := lc.Send(context.Background(), req)
+	if err != nil {
+		return "", false, err
+	}
+	if method != http.MethodGet {
+		return "", false, nil
+	}
+
+	respBody, err := ioutil.ReadAll(resp.Response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := api.ParseSecret(bytes.NewReader(respBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secret.Data["value"].(string), resp.CacheMeta != nil && resp.CacheMeta.Hit, nil
+}
+
+func TestLeaseCache_StaticSecrets(t *testing.T) {
+	server := newTestKVServer()
+	server.values["kv1/foo"] = "bar"
+	server.values["kv2/data/foo"] = "bar"
+	server.values["other/foo"] = "bar"
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	lc := testNewStaticSecretLeaseCache(t, ts.URL, time.Hour)
+
+	read := func(path, token string) (string, bool) {
+		t.Helper()
+		value, hit, err := testStaticSecretSend(t, lc, http.MethodGet, path, token, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return value, hit
+	}
+	write := func(method, path, value string) {
+		t.Helper()
+		if _, _, err := testStaticSecretSend(t, lc, method, path, "allowed", value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, path := range []string{"kv1/foo", "kv2/data/foo"} {
+		t.Run(path, func(t *testing.T) {
+			requestPath := "/v1/" + path
+
+			if value, hit := read(requestPath, "allowed"); value != "bar" || hit {
+				t.Fatalf("bad: value: %q, hit: %t", value, hit)
+			}
+			if value, hit := read(requestPath, "allowed"); value != "bar" || !hit {
+				t.Fatalf("bad: value: %q, hit: %t", value, hit)
+			}
+
+			// Another token is served from the cache once its capabilities
+			// are verified
+			capabilities := server.capabilityChecks()
+			if value, hit := read(requestPath, "other-allowed"); value != "bar" || !hit {
+				t.Fatalf("bad: value: %q, hit: %t", value, hit)
+			}
+			if server.capabilityChecks() != capabilities+1 {
+				t.Fatalf("expected the capabilities of the token to be verified")
+			}
+			if value, hit := read(requestPath, "other-allowed"); value != "bar" || !hit {
+				t.Fatalf("bad: value: %q, hit: %t", value, hit)
+			}
+			if server.capabilityChecks() != capabilities+1 {
+				t.Fatalf("expected the capabilities of the token to be verified only once")
+			}
+
+			// A token that cannot read the secret is not served from the
+			// cache
+			if _, _, err := testStaticSecretSend(t, lc, http.MethodGet, requestPath, "denied", ""); err == nil {
+				t.Fatal("expected an error reading the secret with a denied token")
+			}
+
+			if reads := server.readCount(path); reads != 1 {
+				t.Fatalf("expected a single read, got %d", reads)
+			}
+		})
+	}
+
+	// Writes through the agent invalidate the cached secret
+	write(http.MethodPut, "/v1/kv1/foo", "baz")
+	if value, hit := read("/v1/kv1/foo", "allowed"); value != "baz" || hit {
+		t.Fatalf("bad: value: %q, hit: %t", value, hit)
+	}
+	write(http.MethodPut, "/v1/kv2/data/foo", "baz")
+	if value, hit := read("/v1/kv2/data/foo", "allowed"); value != "baz" || hit {
+		t.Fatalf("bad: value: %q, hit: %t", value, hit)
+	}
+	write(http.MethodDelete, "/v1/kv2/metadata/foo", "")
+	if value, hit := read("/v1/kv2/data/foo", "allowed"); value != "" || hit {
+		t.Fatalf("bad: value: %q, hit: %t", value, hit)
+	}
+
+	// Secrets outside of KV mounts are not cached
+	read("/v1/other/foo", "allowed")
+	if _, hit := read("/v1/other/foo", "allowed"); hit {
+		t.Fatal("expected secrets outside of KV mounts not to be cached")
+	}
+
+	// Clearing the cache evicts the cached secrets
+	read("/v1/kv1/foo", "allowed")
+	if err := lc.handleCacheClear(context.Background(), &cacheClearInput{Type: "all"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, hit := read("/v1/kv1/foo", "allowed"); hit {
+		t.Fatal("expected the cache to be cleared")
+	}
+}
+
+func TestLeaseCache_StaticSecrets_RefreshInterval(t *testing.T) {
+	server := newTestKVServer()
+	server.values["kv1/foo"] = "bar"
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	lc := testNewStaticSecretLeaseCache(t, ts.URL, 500*time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := testStaticSecretSend(t, lc, http.MethodGet, "/v1/kv1/foo", "allowed", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reads := server.readCount("kv1/foo"); reads != 1 {
+		t.Fatalf("expected a single read, got %d", reads)
+	}
+
+	time.Sleep(time.Second)
+
+	value, hit, err := testStaticSecretSend(t, lc, http.MethodGet, "/v1/kv1/foo", "allowed", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "bar" || hit {
+		t.Fatalf("bad: value: %q, hit: %t", value, hit)
+	}
+	if reads := server.readCount("kv1/foo"); reads != 2 {
+		t.Fatalf("expected the secret to be read again, got %d reads", reads)
+	}
+}
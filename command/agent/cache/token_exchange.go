@@ -0,0 +1,242 @@
+package cache
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/errwrap"
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/command/agent/config"
+	"github.com/hashicorp/vault/command/agent/sink"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/helper/parseutil"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// PeerCredentials are the credentials of the process at the other end of a
+// unix socket connection
+type PeerCredentials struct {
+	PID int
+	UID int
+	GID int
+}
+
+type contextKeyPeerCredentials struct{}
+
+// ConnContext is used as the ConnContext of the HTTP servers of the
+// listeners. It stores the credentials of the peer of unix socket
+// connections in the context of their requests, including those of
+// listeners using TLS.
+func ConnContext(logger hclog.Logger) func(context.Context, net.Conn) context.Context {
+	return func(ctx context.Context, conn net.Conn) context.Context {
+		if tlsConn, ok := conn.(*tls.Conn); ok {
+			conn = tlsConn.NetConn()
+		}
+		cred, err := peerCredentials(conn)
+		if err != nil {
+			logger.Warn("failed to read the credentials of the peer", "error", err)
+			return ctx
+		}
+		if cred == nil {
+			return ctx
+		}
+		return context.WithValue(ctx, contextKeyPeerCredentials{}, cred)
+	}
+}
+
+// PeerCredentialsFromContext returns the credentials of the peer of the unix
+// socket connection a request was received on, or nil
+func PeerCredentialsFromContext(ctx context.Context) *PeerCredentials {
+	cred, _ := ctx.Value(contextKeyPeerCredentials{}).(*PeerCredentials)
+	return cred
+}
+
+// TokenExchangeConfig is the configuration of the token exchange endpoint
+type TokenExchangeConfig struct {
+	Logger    hclog.Logger
+	Client    *api.Client
+	Namespace string
+
+	// InmemSink holds the auto-auth token, whose child tokens are issued
+	InmemSink sink.Sink
+
+	Clients []*config.TokenExchangeClient
+}
+
+// TokenExchangeRequest is the optional body of a request to the token
+// exchange endpoint, further restricting the issued token
+type TokenExchangeRequest struct {
+	Policies []string    `json:"policies"`
+	TTL      interface{} `json:"ttl"`
+}
+
+// HandleTokenExchange returns the handler of the token exchange endpoint. It
+// identifies the local client making the request, and issues it a child
+// token of the auto-auth token with a subset of the client's policies and at
+// most the client's TTL. A compromised client therefore cannot use more than
+// its own policies, while the auto-auth token itself is never handed out.
+func HandleTokenExchange(conf *TokenExchangeConfig) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost, http.MethodPut:
+		default:
+			logical.RespondError(w, http.StatusMethodNotAllowed, nil)
+			return
+		}
+
+		client := identifyClient(r, conf.Clients)
+		if client == nil {
+			conf.Logger.Warn("token exchange request from unknown client", "remote_addr", r.RemoteAddr)
+			metrics.IncrCounter([]string{"agent", "token_exchange", "denied"}, 1)
+			logical.RespondError(w, http.StatusForbidden, errors.New("permission denied"))
+			return
+		}
+
+		policies, ttl, err := parseTokenExchangeRequest(r.Body, client)
+		if err != nil {
+			logical.RespondError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		token := conf.InmemSink.(sink.SinkReader).Token()
+		if token == "" {
+			logical.RespondError(w, http.StatusServiceUnavailable, errors.New("auto-auth token is not available"))
+			return
+		}
+
+		secret, err := createChildToken(conf, token, client.Name, policies, ttl)
+		if err != nil {
+			conf.Logger.Error("failed to issue token", "client", client.Name, "error", err)
+			metrics.IncrCounter([]string{"agent", "token_exchange", "failure"}, 1)
+			code := http.StatusInternalServerError
+			if respErr, ok := err.(*api.ResponseError); ok {
+				code = respErr.StatusCode
+			}
+			logical.RespondError(w, code, errwrap.Wrapf("failed to issue token: {{err}}", err))
+			return
+		}
+
+		conf.Logger.Info("issued token", "client", client.Name, "policies", policies, "ttl", ttl.String())
+		metrics.IncrCounter([]string{"agent", "token_exchange", "success"}, 1)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(secret)
+	})
+}
+
+// identifyClient returns the first configured client whose every identifying
+// criterion matches the request
+func identifyClient(r *http.Request, clients []*config.TokenExchangeClient) *config.TokenExchangeClient {
+	cred := PeerCredentialsFromContext(r.Context())
+	secret := r.Header.Get(consts.AgentClientSecretHeaderName)
+
+	for _, client := range clients {
+		if (client.UnixUID != nil || client.UnixGID != nil) && cred == nil {
+			continue
+		}
+		if client.UnixUID != nil && *client.UnixUID != cred.UID {
+			continue
+		}
+		if client.UnixGID != nil && *client.UnixGID != cred.GID {
+			continue
+		}
+		if client.ClientSecret != "" && subtle.ConstantTimeCompare([]byte(client.ClientSecret), []byte(secret)) != 1 {
+			continue
+		}
+		return client
+	}
+
+	return nil
+}
+
+// parseTokenExchangeRequest returns the policies and TTL of the token to
+// issue, which default to the ones of the client
+func parseTokenExchangeRequest(body io.Reader, client *config.TokenExchangeClient) ([]string, time.Duration, error) {
+	var req TokenExchangeRequest
+	if err := json.NewDecoder(body).Decode(&req); err != nil && err != io.EOF {
+		return nil, 0, errwrap.Wrapf("failed to parse request body: {{err}}", err)
+	}
+
+	policies := client.Policies
+	if len(req.Policies) > 0 {
+		for _, policy := range req.Policies {
+			if !strutil.StrListContains(client.Policies, policy) {
+				return nil, 0, fmt.Errorf("policy %q is not allowed", policy)
+			}
+		}
+		policies = req.Policies
+	}
+
+	ttl := client.TTL
+	if req.TTL != nil {
+		requested, err := parseutil.ParseDurationSecond(req.TTL)
+		if err != nil {
+			return nil, 0, errwrap.Wrapf("failed to parse ttl: {{err}}", err)
+		}
+		if requested <= 0 || requested > client.TTL {
+			return nil, 0, fmt.Errorf("ttl must be positive and at most %s", client.TTL)
+		}
+		ttl = requested
+	}
+
+	return policies, ttl, nil
+}
+
+// createChildToken creates a non-renewable child token of the auto-auth token
+func createChildToken(conf *TokenExchangeConfig, token, name string, policies []string, ttl time.Duration) (*api.Secret, error) {
+	client, err := conf.Client.Clone()
+	if err != nil {
+		return nil, err
+	}
+	client.SetToken(token)
+	if conf.Namespace != "" {
+		client.SetNamespace(conf.Namespace)
+	}
+
+	// Vault allows tokens with sudo on the create endpoint to create tokens
+	// with any policy, so the policies are checked against the token policies
+	// of the auto-auth token. Its identity policies are not considered, as
+	// Vault does not let tokens without sudo grant them either.
+	lookup, err := client.Auth().Token().LookupSelf()
+	if err != nil {
+		return nil, err
+	}
+	parentPolicies, err := lookup.TokenPolicies()
+	if err != nil {
+		return nil, err
+	}
+	if !strutil.StrListContains(parentPolicies, "root") {
+		for _, policy := range policies {
+			if !strutil.StrListContains(parentPolicies, policy) {
+				return nil, &api.ResponseError{
+					StatusCode: http.StatusForbidden,
+					Errors:     []string{fmt.Sprintf("policy %q is not a policy of the auto-auth token", policy)},
+				}
+			}
+		}
+	}
+
+	renewable := false
+	return client.Auth().Token().Create(&api.TokenCreateRequest{
+		Policies:        policies,
+		NoDefaultPolicy: !strutil.StrListContains(policies, "default"),
+		TTL:             ttl.String(),
+		ExplicitMaxTTL:  ttl.String(),
+		Renewable:       &renewable,
+		DisplayName:     "agent-" + name,
+		Metadata: map[string]string{
+			"agent_client": name,
+		},
+	})
+}
@@ -0,0 +1,299 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/command/agent/config"
+	"github.com/hashicorp/vault/command/agent/sink/mock"
+	vaulthttp "github.com/hashicorp/vault/http"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/helper/logging"
+	"github.com/hashicorp/vault/vault"
+)
+
+const policyTokenCreator = `
+path "auth/token/create" {
+	capabilities = ["update", "sudo"]
+}
+`
+
+// setupTokenExchange returns a client of a test cluster, along with a token
+// exchange handler issuing child tokens of a token with the creator, web-read
+// and web-write policies
+func setupTokenExchange(t *testing.T, clients []*config.TokenExchangeClient) (*api.Client, http.Handler, func()) {
+	t.Helper()
+
+	cluster := vault.NewTestCluster(t, &vault.CoreConfig{
+		DisableMlock: true,
+		DisableCache: true,
+		Logger:       logging.NewVaultLogger(hclog.Trace),
+	}, &vault.TestClusterOptions{
+		HandlerFunc: vaulthttp.Handler,
+	})
+	cluster.Start()
+	vault.TestWaitActive(t, cluster.Cores[0].Core)
+
+	client := cluster.Cores[0].Client
+	for _, policy := range []string{"creator", "web-read", "web-write", "other"} {
+		rules := `path "secret/*" { capabilities = ["read"] }`
+		if policy == "creator" {
+			rules = policyTokenCreator
+		}
+		if err := client.Sys().PutPolicy(policy, rules); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	secret, err := client.Auth().Token().Create(&api.TokenCreateRequest{
+		Policies: []string{"creator", "web-read", "web-write"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := HandleTokenExchange(&TokenExchangeConfig{
+		Logger:    logging.NewVaultLogger(hclog.Trace),
+		Client:    client,
+		InmemSink: mock.NewSink(secret.Auth.ClientToken),
+		Clients:   clients,
+	})
+
+	return client, handler, cluster.Cleanup
+}
+
+func TestTokenExchange(t *testing.T) {
+	client, handler, cleanup := setupTokenExchange(t, []*config.TokenExchangeClient{
+		&config.TokenExchangeClient{
+			Name:         "web",
+			Policies:     []string{"web-read", "web-write"},
+			TTL:          2 * time.Minute,
+			ClientSecret: "web-secret",
+		},
+		&config.TokenExchangeClient{
+			Name:         "other",
+			Policies:     []string{"other"},
+			TTL:          time.Minute,
+			ClientSecret: "other-secret",
+		},
+	})
+	defer cleanup()
+
+	testCases := map[string]struct {
+		secret           string
+		body             string
+		expectedCode     int
+		expectedPolicies []string
+		expectedTTL      time.Duration
+	}{
+		"client policies": {
+			secret:           "web-secret",
+			expectedCode:     http.StatusOK,
+			expectedPolicies: []string{"web-read", "web-write"},
+			expectedTTL:      2 * time.Minute,
+		},
+		"subset": {
+			secret:           "web-secret",
+			body:             `{"policies": ["web-read"], "ttl": "30s"}`,
+			expectedCode:     http.StatusOK,
+			expectedPolicies: []string{"web-read"},
+			expectedTTL:      30 * time.Second,
+		},
+		"policy not allowed": {
+			secret:       "web-secret",
+			body:         `{"policies": ["creator"]}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		"ttl too long": {
+			secret:       "web-secret",
+			body:         `{"ttl": "1h"}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		"unknown client": {
+			secret:       "bad-secret",
+			expectedCode: http.StatusForbidden,
+		},
+		"no secret": {
+			expectedCode: http.StatusForbidden,
+		},
+		"policy of another token": {
+			secret:       "other-secret",
+			expectedCode: http.StatusForbidden,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, consts.AgentPathTokenExchange, bytes.NewBufferString(tc.body))
+			if tc.secret != "" {
+				req.Header.Set(consts.AgentClientSecretHeaderName, tc.secret)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tc.expectedCode {
+				t.Fatalf("expected code %d, got %d: %s", tc.expectedCode, rr.Code, rr.Body.String())
+			}
+			if tc.expectedCode != http.StatusOK {
+				return
+			}
+
+			secret, err := api.ParseSecret(rr.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if secret.Auth == nil || secret.Auth.ClientToken == "" {
+				t.Fatal("expected a token")
+			}
+			if secret.Auth.Renewable {
+				t.Fatal("expected the token not to be renewable")
+			}
+			if ttl := time.Duration(secret.Auth.LeaseDuration) * time.Second; ttl != tc.expectedTTL {
+				t.Fatalf("expected ttl %s, got %s", tc.expectedTTL, ttl)
+			}
+
+			lookup, err := client.Auth().Token().Lookup(secret.Auth.ClientToken)
+			if err != nil {
+				t.Fatal(err)
+			}
+			policies, err := lookup.TokenPolicies()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(policies) != len(tc.expectedPolicies) {
+				t.Fatalf("expected policies %v, got %v", tc.expectedPolicies, policies)
+			}
+			for i, policy := range tc.expectedPolicies {
+				if policies[i] != policy {
+					t.Fatalf("expected policies %v, got %v", tc.expectedPolicies, policies)
+				}
+			}
+		})
+	}
+
+	// Only POST and PUT are allowed
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, consts.AgentPathTokenExchange, nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected code %d, got %d", http.StatusMethodNotAllowed, rr.Code)
+	}
+}
+
+func TestTokenExchange_PeerCredentials(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are only supported on linux")
+	}
+
+	uid, otherUID := os.Getuid(), os.Getuid()+1
+	_, handler, cleanup := setupTokenExchange(t, []*config.TokenExchangeClient{
+		&config.TokenExchangeClient{
+			Name:     "other",
+			Policies: []string{"web-write"},
+			TTL:      time.Minute,
+			UnixUID:  &otherUID,
+		},
+		&config.TokenExchangeClient{
+			Name:     "web",
+			Policies: []string{"web-read"},
+			TTL:      time.Minute,
+			UnixUID:  &uid,
+		},
+	})
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "agent-token-exchange")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fixtures, err := filepath.Abs("../../server/test-fixtures/reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The peer credentials are read whether or not the listener uses TLS
+	cases := map[string]map[string]interface{}{
+		"tls disabled": {
+			"tls_disable": true,
+		},
+		"tls enabled": {
+			"tls_cert_file": filepath.Join(fixtures, "reload_foo.pem"),
+			"tls_key_file":  filepath.Join(fixtures, "reload_foo.key"),
+		},
+	}
+	for name, lnConfig := range cases {
+		t.Run(name, func(t *testing.T) {
+			socket := filepath.Join(dir, strings.Replace(name, " ", "-", -1)+".sock")
+			lnConfig["address"] = socket
+			ln, tlsConf, err := StartListener(&config.Listener{
+				Type:   "unix",
+				Config: lnConfig,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			server := &http.Server{
+				Handler:     handler,
+				TLSConfig:   tlsConf,
+				ConnContext: ConnContext(logging.NewVaultLogger(hclog.Trace)),
+			}
+			go server.Serve(ln)
+			defer server.Close()
+
+			scheme := "http"
+			if tlsConf != nil {
+				scheme = "https"
+			}
+			httpClient := &http.Client{
+				Transport: &http.Transport{
+					DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+						var d net.Dialer
+						return d.DialContext(ctx, "unix", socket)
+					},
+					TLSClientConfig: &tls.Config{
+						InsecureSkipVerify: true,
+					},
+				},
+			}
+
+			resp, err := httpClient.Post(scheme+"://agent"+consts.AgentPathTokenExchange, "application/json", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("expected code %d, got %d", http.StatusOK, resp.StatusCode)
+			}
+
+			var secret api.Secret
+			if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+				t.Fatal(err)
+			}
+			if secret.Auth == nil || len(secret.Auth.Policies) != 1 || secret.Auth.Policies[0] != "web-read" {
+				t.Fatalf("expected a token for the client identified by its uid, got %#v", secret.Auth)
+			}
+		})
+	}
+
+	// Requests over TCP have no peer credentials
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, consts.AgentPathTokenExchange, nil))
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected code %d, got %d", http.StatusForbidden, rr.Code)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,6 +15,7 @@ import (
 	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/hcl"
 	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/hashicorp/vault/command/server"
 	"github.com/hashicorp/vault/helper/namespace"
 	"github.com/hashicorp/vault/sdk/helper/parseutil"
 	"github.com/mitchellh/mapstructure"
@@ -20,15 +23,93 @@ import (
 
 // Config is the configuration for the vault server.
 type Config struct {
-	AutoAuth      *AutoAuth                  `hcl:"auto_auth"`
-	ExitAfterAuth bool                       `hcl:"exit_after_auth"`
-	PidFile       string                     `hcl:"pid_file"`
-	Listeners     []*Listener                `hcl:"listeners"`
-	Cache         *Cache                     `hcl:"cache"`
-	Vault         *Vault                     `hcl:"vault"`
-	Templates     []*ctconfig.TemplateConfig `hcl:"templates"`
+	AutoAuth       *AutoAuth                  `hcl:"auto_auth"`
+	ExitAfterAuth  bool                       `hcl:"exit_after_auth"`
+	PidFile        string                     `hcl:"pid_file"`
+	Listeners      []*Listener                `hcl:"listeners"`
+	Cache          *Cache                     `hcl:"cache"`
+	Vault          *Vault                     `hcl:"vault"`
+	Templates      []*ctconfig.TemplateConfig `hcl:"templates"`
+	TemplateConfig *TemplateConfig            `hcl:"-"`
+	EnvTemplates   []*EnvTemplate             `hcl:"-"`
+	Certificates   []*PKICertificate          `hcl:"-"`
+	TokenExchange  *TokenExchange             `hcl:"-"`
+	Exec           *Exec                      `hcl:"-"`
+	Telemetry      *server.Telemetry          `hcl:"-"`
 }
 
+// TemplateConfig configures the rendering of the templates
+type TemplateConfig struct {
+	// PKIRenewFraction is the fraction of the lifetime of a certificate
+	// issued by a template after which it is issued again. Until then, the
+	// certificate is reused each time the template is rendered.
+	PKIRenewFraction float64 `hcl:"pki_renew_fraction"`
+}
+
+// EnvTemplate is a template rendered into an environment variable of the
+// process run by the exec stanza
+type EnvTemplate struct {
+	Name     string
+	Template *ctconfig.TemplateConfig
+}
+
+// PKICertificate is a certificate issued by a PKI secrets engine and written
+// to disk by the agent. The certificate is reused until the renew fraction of
+// its lifetime has elapsed, and is then issued again.
+type PKICertificate struct {
+	Path             string                 `hcl:"path"`
+	Parameters       map[string]interface{} `hcl:"parameters"`
+	RenewFraction    float64                `hcl:"renew_fraction"`
+	CertDestination  string                 `hcl:"cert_destination"`
+	KeyDestination   string                 `hcl:"key_destination"`
+	ChainDestination string                 `hcl:"chain_destination"`
+	PermsRaw         interface{}            `hcl:"perms"`
+	Perms            os.FileMode            `hcl:"-"`
+	KeyPermsRaw      interface{}            `hcl:"key_perms"`
+	KeyPerms         os.FileMode            `hcl:"-"`
+}
+
+// TokenExchange configures the local clients allowed to exchange a request
+// to the agent for a child token of the auto-auth token
+type TokenExchange struct {
+	Clients []*TokenExchangeClient
+}
+
+// TokenExchangeClient is a local client of the token exchange endpoint. It is
+// identified by the credentials of the peer of a unix socket connection, or by
+// a secret sent in a request header. The child tokens it receives have a
+// subset of its policies.
+type TokenExchangeClient struct {
+	Name         string        `hcl:"-"`
+	Policies     []string      `hcl:"policies"`
+	TTLRaw       interface{}   `hcl:"ttl"`
+	TTL          time.Duration `hcl:"-"`
+	UnixUID      *int          `hcl:"unix_uid"`
+	UnixGID      *int          `hcl:"unix_gid"`
+	ClientSecret string        `hcl:"client_secret"`
+}
+
+// Exec contains the configuration of the process run by the agent
+type Exec struct {
+	Command        []string      `hcl:"command"`
+	OnSecretChange string        `hcl:"on_secret_change"`
+	ChangeSignal   string        `hcl:"change_signal"`
+	StopSignal     string        `hcl:"stop_signal"`
+	KillTimeoutRaw interface{}   `hcl:"kill_timeout"`
+	KillTimeout    time.Duration `hcl:"-"`
+}
+
+const (
+	// ExecOnSecretChangeRestart restarts the process run by the exec stanza
+	// when the value of one of its environment variables changes.
+	ExecOnSecretChangeRestart = "restart"
+
+	// ExecOnSecretChangeSignal sends the change signal to the process run by
+	// the exec stanza when the value of one of its environment variables
+	// changes.
+	ExecOnSecretChangeSignal = "signal"
+)
+
 // Vault contains configuration for connnecting to Vault servers
 type Vault struct {
 	Address          string      `hcl:"address"`
@@ -39,27 +120,144 @@ type Vault struct {
 	ClientCert       string      `hcl:"client_cert"`
 	ClientKey        string      `hcl:"client_key"`
 	TLSServerName    string      `hcl:"tls_server_name"`
+
+	// Addresses, SRVRecord and SRVScheme configure the servers the agent
+	// fails over between, in addition to Address
+	Addresses []string `hcl:"addresses"`
+	SRVRecord string   `hcl:"srv_record"`
+	SRVScheme string   `hcl:"srv_scheme"`
+
+	HealthCheckIntervalRaw interface{}   `hcl:"health_check_interval"`
+	HealthCheckInterval    time.Duration `hcl:"-"`
+
+	Retry *Retry `hcl:"-"`
+}
+
+// Retry configures the retries of requests proxied to Vault
+type Retry struct {
+	NumRetries    int           `hcl:"num_retries"`
+	MinBackoffRaw interface{}   `hcl:"min_backoff"`
+	MinBackoff    time.Duration `hcl:"-"`
+	MaxBackoffRaw interface{}   `hcl:"max_backoff"`
+	MaxBackoff    time.Duration `hcl:"-"`
+}
+
+// Failover reports whether the agent fails over between several servers
+func (v *Vault) Failover() bool {
+	return len(v.Addresses) > 0 || v.SRVRecord != ""
 }
 
 // Cache contains any configuration needed for Cache mode
 type Cache struct {
-	UseAutoAuthToken bool `hcl:"use_auto_auth_token"`
+	UseAutoAuthToken bool           `hcl:"use_auto_auth_token"`
+	Persist          *Persist       `hcl:"-"`
+	StaticSecrets    *StaticSecrets `hcl:"-"`
+}
+
+// StaticSecrets contains configuration needed for caching static secrets
+type StaticSecrets struct {
+	RefreshIntervalRaw interface{}   `hcl:"refresh_interval"`
+	RefreshInterval    time.Duration `hcl:"-"`
+}
+
+// Persist contains configuration needed for persisting the cache
+type Persist struct {
+	Path          string        `hcl:"path"`
+	KeyType       string        `hcl:"key_type"`
+	KeyWrapTTLRaw interface{}   `hcl:"key_wrap_ttl"`
+	KeyWrapTTL    time.Duration `hcl:"-"`
+	ExitOnErr     bool          `hcl:"exit_on_err"`
+	KMS           *KMS          `hcl:"-"`
+}
+
+// KMS is the configuration of the KMS wrapper used to protect the key of the
+// persistent cache
+type KMS struct {
+	Type   string
+	Config map[string]string
 }
 
+const (
+	// PersistKeyTypeAutoAuth protects the key of the persistent cache by
+	// response-wrapping it using the auto-auth token.
+	PersistKeyTypeAutoAuth = "auto_auth"
+
+	// PersistKeyTypeKMS protects the key of the persistent cache using a KMS
+	// wrapper.
+	PersistKeyTypeKMS = "kms"
+
+	// DefaultPersistKeyWrapTTL is the default lifetime of the wrapping token
+	// protecting the key of the persistent cache.
+	DefaultPersistKeyWrapTTL = 24 * time.Hour
+
+	// DefaultStaticSecretRefreshInterval is the default interval after which
+	// cached static secrets are fetched again, and the capabilities of the
+	// tokens reading them verified again.
+	DefaultStaticSecretRefreshInterval = 5 * time.Minute
+
+	// DefaultPrometheusRetentionTime is the default retention time of the
+	// Prometheus metrics when the telemetry stanza is set.
+	DefaultPrometheusRetentionTime = 24 * time.Hour
+
+	// DefaultHealthCheckInterval is the default interval at which the
+	// servers are checked when failing over between several servers.
+	DefaultHealthCheckInterval = 10 * time.Second
+
+	// DefaultPKIRenewFraction is the default fraction of the lifetime of a
+	// PKI certificate after which it is issued again.
+	DefaultPKIRenewFraction = 0.67
+
+	// DefaultPKIPerms is the default mode of the files a PKI certificate and
+	// its chain are written to.
+	DefaultPKIPerms os.FileMode = 0600
+
+	// DefaultPKIKeyPerms is the default mode of the file the private key of a
+	// PKI certificate is written to.
+	DefaultPKIKeyPerms os.FileMode = 0600
+
+	// DefaultTokenExchangeTTL is the default TTL of the tokens issued by the
+	// token exchange endpoint.
+	DefaultTokenExchangeTTL = 5 * time.Minute
+
+	// DefaultNumRetries, DefaultMinBackoff and DefaultMaxBackoff are the
+	// defaults of the retries of proxied requests.
+	DefaultNumRetries = 2
+	DefaultMinBackoff = time.Second
+	DefaultMaxBackoff = 5 * time.Second
+)
+
 // Listener contains configuration for any Vault Agent listeners
 type Listener struct {
 	Type   string
 	Config map[string]interface{}
+
+	// AutoAuthTokenPaths restricts the paths for which requests received by
+	// the listener without a token are made with the auto-auth token. If it
+	// is empty, the auto-auth token is used for all paths.
+	AutoAuthTokenPaths []string
 }
 
 // RequireRequestHeader is a listener configuration option
 const RequireRequestHeader = "require_request_header"
 
+// AutoAuthTokenPaths is a listener configuration option
+const AutoAuthTokenPaths = "auto_auth_token_paths"
+
 // AutoAuth is the configured authentication method and sinks
 type AutoAuth struct {
 	Method *Method `hcl:"-"`
 	Sinks  []*Sink `hcl:"sinks"`
 
+	// ReauthGracePeriod is how long before the expiry of a token that can no
+	// longer be renewed the agent re-authenticates
+	ReauthGracePeriodRaw interface{}   `hcl:"reauth_grace_period"`
+	ReauthGracePeriod    time.Duration `hcl:"-"`
+
+	// RevokeDelay is how long after re-authenticating ahead of the expiry of
+	// a token the agent revokes the previous token
+	RevokeDelayRaw interface{}   `hcl:"revoke_delay"`
+	RevokeDelay    time.Duration `hcl:"-"`
+
 	// NOTE: This is unsupported outside of testing and may disappear at any
 	// time.
 	EnableReauthOnNewCredentials bool `hcl:"enable_reauth_on_new_credentials"`
@@ -138,6 +336,30 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, errwrap.Wrapf("error parsing 'template': {{err}}", err)
 	}
 
+	if err := parseTemplateConfig(&result, list); err != nil {
+		return nil, errwrap.Wrapf("error parsing 'template_config': {{err}}", err)
+	}
+
+	if err := parseEnvTemplates(&result, list); err != nil {
+		return nil, errwrap.Wrapf("error parsing 'env_template': {{err}}", err)
+	}
+
+	if err := parsePKICertificates(&result, list); err != nil {
+		return nil, errwrap.Wrapf("error parsing 'pki_certificate': {{err}}", err)
+	}
+
+	if err := parseTokenExchange(&result, list); err != nil {
+		return nil, errwrap.Wrapf("error parsing 'token_exchange': {{err}}", err)
+	}
+
+	if err := parseExec(&result, list); err != nil {
+		return nil, errwrap.Wrapf("error parsing 'exec': {{err}}", err)
+	}
+
+	if err := parseTelemetry(&result, list); err != nil {
+		return nil, errwrap.Wrapf("error parsing 'telemetry': {{err}}", err)
+	}
+
 	if result.Cache != nil {
 		if len(result.Listeners) < 1 {
 			return nil, fmt.Errorf("at least one listener required when cache enabled")
@@ -151,6 +373,51 @@ func LoadConfig(path string) (*Config, error) {
 				return nil, fmt.Errorf("cache.use_auto_auth_token is true and auto_auth uses wrapping")
 			}
 		}
+
+		if result.Cache.Persist != nil && result.Cache.Persist.KeyType == PersistKeyTypeAutoAuth && !result.Cache.UseAutoAuthToken {
+			return nil, fmt.Errorf("cache.persist.key_type is %q but cache.use_auto_auth_token is not true", PersistKeyTypeAutoAuth)
+		}
+
+		for _, ln := range result.Listeners {
+			if len(ln.AutoAuthTokenPaths) > 0 && !result.Cache.UseAutoAuthToken {
+				return nil, fmt.Errorf("listener.%s is set but cache.use_auto_auth_token is not true", AutoAuthTokenPaths)
+			}
+		}
+	}
+
+	if len(result.EnvTemplates) > 0 {
+		if result.Exec == nil {
+			return nil, fmt.Errorf("env_template requires an exec stanza")
+		}
+		if result.AutoAuth == nil {
+			return nil, fmt.Errorf("env_template requires auto_auth")
+		}
+	}
+
+	if len(result.Certificates) > 0 && result.AutoAuth == nil {
+		return nil, fmt.Errorf("pki_certificate requires auto_auth")
+	}
+
+	if result.TokenExchange != nil {
+		if result.Cache == nil || !result.Cache.UseAutoAuthToken {
+			return nil, fmt.Errorf("token_exchange requires cache.use_auto_auth_token to be true")
+		}
+
+		// The endpoint is only served on unix listeners, so that client
+		// secrets are never sent over the network
+		var unixListener bool
+		for _, ln := range result.Listeners {
+			if ln.Type == "unix" {
+				unixListener = true
+			}
+		}
+		if !unixListener {
+			return nil, fmt.Errorf("token_exchange requires a unix listener")
+		}
+	}
+
+	if result.Exec != nil && result.ExitAfterAuth {
+		return nil, fmt.Errorf("exec cannot be used with exit_after_auth")
 	}
 
 	if result.AutoAuth != nil {
@@ -194,11 +461,106 @@ func parseVault(result *Config, list *ast.ObjectList) error {
 		}
 	}
 
+	for _, addr := range v.Addresses {
+		if addr == "" {
+			return errors.New("'addresses' must not contain empty addresses")
+		}
+	}
+
+	if v.SRVRecord != "" {
+		if v.SRVScheme == "" {
+			v.SRVScheme = "https"
+		}
+		if v.SRVScheme != "https" && v.SRVScheme != "http" {
+			return fmt.Errorf("invalid 'srv_scheme' %q", v.SRVScheme)
+		}
+	} else if v.SRVScheme != "" {
+		return errors.New("'srv_scheme' requires 'srv_record'")
+	}
+
+	if v.HealthCheckIntervalRaw != nil {
+		if v.HealthCheckInterval, err = parseutil.ParseDurationSecond(v.HealthCheckIntervalRaw); err != nil {
+			return errwrap.Wrapf("error parsing 'health_check_interval': {{err}}", err)
+		}
+		v.HealthCheckIntervalRaw = nil
+		if v.HealthCheckInterval <= 0 {
+			return errors.New("'health_check_interval' must be positive")
+		}
+	}
+	if v.Failover() && v.HealthCheckInterval == 0 {
+		v.HealthCheckInterval = DefaultHealthCheckInterval
+	}
+
+	subs, ok := item.Val.(*ast.ObjectType)
+	if !ok {
+		return fmt.Errorf("could not parse %q as an object", name)
+	}
+	if err := parseRetry(&v, subs.List); err != nil {
+		return errwrap.Wrapf("error parsing 'retry': {{err}}", err)
+	}
+
 	result.Vault = &v
 
 	return nil
 }
 
+func parseRetry(v *Vault, list *ast.ObjectList) error {
+	name := "retry"
+
+	retryList := list.Filter(name)
+	if len(retryList.Items) == 0 {
+		// Retry the requests failing because of a server failure on another
+		// server by default
+		if v.Failover() {
+			v.Retry = &Retry{
+				NumRetries: DefaultNumRetries,
+				MinBackoff: DefaultMinBackoff,
+				MaxBackoff: DefaultMaxBackoff,
+			}
+		}
+		return nil
+	}
+	if len(retryList.Items) > 1 {
+		return fmt.Errorf("at most one %q block is allowed", name)
+	}
+
+	r := Retry{
+		NumRetries: DefaultNumRetries,
+	}
+	if err := hcl.DecodeObject(&r, retryList.Items[0].Val); err != nil {
+		return err
+	}
+
+	r.MinBackoff = DefaultMinBackoff
+	if r.MinBackoffRaw != nil {
+		var err error
+		if r.MinBackoff, err = parseutil.ParseDurationSecond(r.MinBackoffRaw); err != nil {
+			return errwrap.Wrapf("error parsing 'min_backoff': {{err}}", err)
+		}
+		r.MinBackoffRaw = nil
+	}
+	r.MaxBackoff = DefaultMaxBackoff
+	if r.MaxBackoffRaw != nil {
+		var err error
+		if r.MaxBackoff, err = parseutil.ParseDurationSecond(r.MaxBackoffRaw); err != nil {
+			return errwrap.Wrapf("error parsing 'max_backoff': {{err}}", err)
+		}
+		r.MaxBackoffRaw = nil
+	}
+
+	switch {
+	case r.NumRetries < 0:
+		return errors.New("'num_retries' must not be negative")
+	case r.MinBackoff <= 0:
+		return errors.New("'min_backoff' must be positive")
+	case r.MaxBackoff < r.MinBackoff:
+		return errors.New("'max_backoff' must not be less than 'min_backoff'")
+	}
+
+	v.Retry = &r
+	return nil
+}
+
 func parseCache(result *Config, list *ast.ObjectList) error {
 	name := "cache"
 
@@ -220,6 +582,140 @@ func parseCache(result *Config, list *ast.ObjectList) error {
 	}
 
 	result.Cache = &c
+
+	subs, ok := item.Val.(*ast.ObjectType)
+	if !ok {
+		return fmt.Errorf("could not parse %q as an object", name)
+	}
+
+	if err := parsePersist(result, subs.List); err != nil {
+		return errwrap.Wrapf("error parsing 'persist': {{err}}", err)
+	}
+
+	if err := parseStaticSecrets(result, subs.List); err != nil {
+		return errwrap.Wrapf("error parsing 'static_secrets': {{err}}", err)
+	}
+
+	return nil
+}
+
+func parseStaticSecrets(result *Config, list *ast.ObjectList) error {
+	name := "static_secrets"
+
+	staticSecretsList := list.Filter(name)
+	if len(staticSecretsList.Items) == 0 {
+		return nil
+	}
+
+	if len(staticSecretsList.Items) > 1 {
+		return fmt.Errorf("only one %q block is permitted", name)
+	}
+
+	item := staticSecretsList.Items[0]
+
+	var s StaticSecrets
+	if err := hcl.DecodeObject(&s, item.Val); err != nil {
+		return err
+	}
+
+	s.RefreshInterval = DefaultStaticSecretRefreshInterval
+	if s.RefreshIntervalRaw != nil {
+		var err error
+		if s.RefreshInterval, err = parseutil.ParseDurationSecond(s.RefreshIntervalRaw); err != nil {
+			return err
+		}
+		s.RefreshIntervalRaw = nil
+	}
+	if s.RefreshInterval <= 0 {
+		return errors.New("refresh_interval must be positive")
+	}
+
+	result.Cache.StaticSecrets = &s
+	return nil
+}
+
+func parsePersist(result *Config, list *ast.ObjectList) error {
+	name := "persist"
+
+	persistList := list.Filter(name)
+	if len(persistList.Items) == 0 {
+		return nil
+	}
+
+	if len(persistList.Items) > 1 {
+		return fmt.Errorf("only one %q block is permitted", name)
+	}
+
+	item := persistList.Items[0]
+
+	var p Persist
+	if err := hcl.DecodeObject(&p, item.Val); err != nil {
+		return err
+	}
+
+	if p.Path == "" {
+		return errors.New("persist path must be specified")
+	}
+
+	subs, ok := item.Val.(*ast.ObjectType)
+	if !ok {
+		return fmt.Errorf("could not parse %q as an object", name)
+	}
+
+	kmsList := subs.List.Filter("kms")
+	switch len(kmsList.Items) {
+	case 0:
+	case 1:
+		kmsItem := kmsList.Items[0]
+		if len(kmsItem.Keys) != 1 {
+			return errors.New("kms type must be specified")
+		}
+
+		var m map[string]string
+		if err := hcl.DecodeObject(&m, kmsItem.Val); err != nil {
+			return multierror.Prefix(err, "kms:")
+		}
+		p.KMS = &KMS{
+			Type:   strings.ToLower(kmsItem.Keys[0].Token.Value().(string)),
+			Config: m,
+		}
+	default:
+		return errors.New("only one \"kms\" block is permitted")
+	}
+
+	if p.KeyType == "" {
+		p.KeyType = PersistKeyTypeAutoAuth
+		if p.KMS != nil {
+			p.KeyType = PersistKeyTypeKMS
+		}
+	}
+
+	switch p.KeyType {
+	case PersistKeyTypeAutoAuth:
+		if p.KMS != nil {
+			return fmt.Errorf("a \"kms\" block cannot be used with key_type %q", p.KeyType)
+		}
+	case PersistKeyTypeKMS:
+		if p.KMS == nil {
+			return fmt.Errorf("a \"kms\" block is required with key_type %q", p.KeyType)
+		}
+	default:
+		return fmt.Errorf("invalid key_type %q", p.KeyType)
+	}
+
+	p.KeyWrapTTL = DefaultPersistKeyWrapTTL
+	if p.KeyWrapTTLRaw != nil {
+		var err error
+		if p.KeyWrapTTL, err = parseutil.ParseDurationSecond(p.KeyWrapTTLRaw); err != nil {
+			return err
+		}
+		p.KeyWrapTTLRaw = nil
+	}
+	if p.KeyWrapTTL <= 0 {
+		return errors.New("key_wrap_ttl must be positive")
+	}
+
+	result.Cache.Persist = &p
 	return nil
 }
 
@@ -253,9 +749,36 @@ func parseListeners(result *Config, list *ast.ObjectList) error {
 			return fmt.Errorf("invalid listener type %q", lnType)
 		}
 
+		var paths []string
+		if v, ok := lnConfig[AutoAuthTokenPaths]; ok {
+			raw, ok := v.([]interface{})
+			if !ok {
+				return fmt.Errorf("%q must be a list of paths", AutoAuthTokenPaths)
+			}
+			for _, p := range raw {
+				path, ok := p.(string)
+				if !ok || path == "" {
+					return fmt.Errorf("%q must be a list of paths", AutoAuthTokenPaths)
+				}
+				paths = append(paths, strings.TrimPrefix(path, "/"))
+			}
+			delete(lnConfig, AutoAuthTokenPaths)
+		}
+
+		if lnType == "unix" {
+			for _, key := range []string{"socket_mode", "socket_user", "socket_group"} {
+				if v, ok := lnConfig[key]; ok {
+					if _, ok := v.(string); !ok {
+						return fmt.Errorf("%q must be a string", key)
+					}
+				}
+			}
+		}
+
 		listeners = append(listeners, &Listener{
-			Type:   lnType,
-			Config: lnConfig,
+			Type:               lnType,
+			Config:             lnConfig,
+			AutoAuthTokenPaths: paths,
 		})
 	}
 
@@ -302,6 +825,32 @@ func parseAutoAuth(result *Config, list *ast.ObjectList) error {
 		return errwrap.Wrapf("error parsing 'sink' stanzas: {{err}}", err)
 	}
 
+	if a.ReauthGracePeriodRaw != nil {
+		var err error
+		if a.ReauthGracePeriod, err = parseutil.ParseDurationSecond(a.ReauthGracePeriodRaw); err != nil {
+			return errwrap.Wrapf("error parsing 'reauth_grace_period': {{err}}", err)
+		}
+		a.ReauthGracePeriodRaw = nil
+	}
+	if a.RevokeDelayRaw != nil {
+		var err error
+		if a.RevokeDelay, err = parseutil.ParseDurationSecond(a.RevokeDelayRaw); err != nil {
+			return errwrap.Wrapf("error parsing 'revoke_delay': {{err}}", err)
+		}
+		a.RevokeDelayRaw = nil
+	}
+	if a.RevokeDelay > 0 {
+		if a.ReauthGracePeriod <= 0 {
+			return errors.New("error parsing auto_auth: 'revoke_delay' requires 'reauth_grace_period'")
+		}
+		if a.RevokeDelay >= a.ReauthGracePeriod {
+			return errors.New("error parsing auto_auth: 'revoke_delay' must be shorter than 'reauth_grace_period'")
+		}
+	}
+	if a.ReauthGracePeriod > 0 && result.AutoAuth.Method.WrapTTL > 0 {
+		return errors.New("error parsing auto_auth: 'reauth_grace_period' cannot be used with wrapping on the auth method")
+	}
+
 	if result.AutoAuth.Method.WrapTTL > 0 {
 		if len(result.AutoAuth.Sinks) != 1 {
 			return fmt.Errorf("error parsing auto_auth: wrapping enabled on auth method and 0 or many sinks defined")
@@ -485,3 +1034,344 @@ func parseTemplates(result *Config, list *ast.ObjectList) error {
 	result.Templates = tcs
 	return nil
 }
+
+func parseTemplateConfig(result *Config, list *ast.ObjectList) error {
+	name := "template_config"
+
+	templateConfigList := list.Filter(name)
+	if len(templateConfigList.Items) == 0 {
+		return nil
+	}
+
+	if len(templateConfigList.Items) > 1 {
+		return fmt.Errorf("only one %q block is permitted", name)
+	}
+
+	tc := TemplateConfig{
+		PKIRenewFraction: DefaultPKIRenewFraction,
+	}
+	if err := hcl.DecodeObject(&tc, templateConfigList.Items[0].Val); err != nil {
+		return err
+	}
+
+	if tc.PKIRenewFraction <= 0 || tc.PKIRenewFraction >= 1 {
+		return errors.New("'pki_renew_fraction' must be between 0 and 1")
+	}
+
+	result.TemplateConfig = &tc
+	return nil
+}
+
+func parseEnvTemplates(result *Config, list *ast.ObjectList) error {
+	name := "env_template"
+
+	templateList := list.Filter(name)
+	if len(templateList.Items) < 1 {
+		return nil
+	}
+
+	seen := make(map[string]bool, len(templateList.Items))
+	var ets []*EnvTemplate
+
+	for _, item := range templateList.Items {
+		if len(item.Keys) != 1 {
+			return errors.New("env_template name must be specified")
+		}
+		envName := item.Keys[0].Token.Value().(string)
+		if envName == "" || strings.ContainsAny(envName, "= ") {
+			return fmt.Errorf("invalid environment variable name %q", envName)
+		}
+		if seen[envName] {
+			return fmt.Errorf("duplicate env_template %q", envName)
+		}
+		seen[envName] = true
+
+		var shadow interface{}
+		if err := hcl.DecodeObject(&shadow, item.Val); err != nil {
+			return fmt.Errorf("error decoding config: %s", err)
+		}
+
+		parsed, ok := shadow.(map[string]interface{})
+		if !ok {
+			return errors.New("error converting config")
+		}
+
+		// These only make sense for templates rendered to disk
+		for _, key := range []string{"destination", "create_dest_dirs", "command", "command_timeout", "perms", "backup", "wait"} {
+			if _, ok := parsed[key]; ok {
+				return multierror.Prefix(fmt.Errorf("%q is not supported", key), fmt.Sprintf("env_template.%s:", envName))
+			}
+		}
+
+		var tc ctconfig.TemplateConfig
+		decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+			ErrorUnused: true,
+			Result:      &tc,
+		})
+		if err != nil {
+			return errors.New("mapstructure decoder creation failed")
+		}
+		if err := decoder.Decode(parsed); err != nil {
+			return multierror.Prefix(err, fmt.Sprintf("env_template.%s:", envName))
+		}
+
+		ets = append(ets, &EnvTemplate{
+			Name:     envName,
+			Template: &tc,
+		})
+	}
+
+	result.EnvTemplates = ets
+	return nil
+}
+
+func parsePKICertificates(result *Config, list *ast.ObjectList) error {
+	name := "pki_certificate"
+
+	certList := list.Filter(name)
+	if len(certList.Items) < 1 {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	var certs []*PKICertificate
+
+	for _, item := range certList.Items {
+		c := PKICertificate{
+			RenewFraction: DefaultPKIRenewFraction,
+			Perms:         DefaultPKIPerms,
+			KeyPerms:      DefaultPKIKeyPerms,
+		}
+		if err := hcl.DecodeObject(&c, item.Val); err != nil {
+			return err
+		}
+
+		if c.Path == "" {
+			return errors.New("pki_certificate path must be specified")
+		}
+		prefix := fmt.Sprintf("pki_certificate.%s:", c.Path)
+
+		if c.CertDestination == "" || c.KeyDestination == "" {
+			return multierror.Prefix(errors.New("'cert_destination' and 'key_destination' must be specified"), prefix)
+		}
+		for _, dest := range []string{c.CertDestination, c.KeyDestination, c.ChainDestination} {
+			if dest == "" {
+				continue
+			}
+			if seen[dest] {
+				return multierror.Prefix(fmt.Errorf("destination %q is used more than once", dest), prefix)
+			}
+			seen[dest] = true
+		}
+
+		if c.RenewFraction <= 0 || c.RenewFraction >= 1 {
+			return multierror.Prefix(errors.New("'renew_fraction' must be between 0 and 1"), prefix)
+		}
+
+		if c.PermsRaw != nil {
+			mode, err := parseFileMode("perms", c.PermsRaw)
+			if err != nil {
+				return multierror.Prefix(err, prefix)
+			}
+			c.Perms = mode
+			c.PermsRaw = nil
+		}
+		if c.KeyPermsRaw != nil {
+			mode, err := parseFileMode("key_perms", c.KeyPermsRaw)
+			if err != nil {
+				return multierror.Prefix(err, prefix)
+			}
+			c.KeyPerms = mode
+			c.KeyPermsRaw = nil
+		}
+
+		certs = append(certs, &c)
+	}
+
+	result.Certificates = certs
+	return nil
+}
+
+func parseTokenExchange(result *Config, list *ast.ObjectList) error {
+	name := "token_exchange"
+
+	exchangeList := list.Filter(name)
+	if len(exchangeList.Items) == 0 {
+		return nil
+	}
+
+	if len(exchangeList.Items) > 1 {
+		return fmt.Errorf("only one %q block is permitted", name)
+	}
+
+	item := exchangeList.Items[0]
+
+	subs, ok := item.Val.(*ast.ObjectType)
+	if !ok {
+		return fmt.Errorf("could not parse %q as an object", name)
+	}
+
+	clientList := subs.List.Filter("client")
+	if len(clientList.Items) == 0 {
+		return errors.New("at least one client must be specified")
+	}
+
+	seen := make(map[string]bool, len(clientList.Items))
+	var e TokenExchange
+
+	for _, clientItem := range clientList.Items {
+		if len(clientItem.Keys) != 1 {
+			return errors.New("client name must be specified")
+		}
+
+		var c TokenExchangeClient
+		if err := hcl.DecodeObject(&c, clientItem.Val); err != nil {
+			return err
+		}
+		c.Name = clientItem.Keys[0].Token.Value().(string)
+		if c.Name == "" {
+			return errors.New("client name must be specified")
+		}
+		if seen[c.Name] {
+			return fmt.Errorf("duplicate client %q", c.Name)
+		}
+		seen[c.Name] = true
+		prefix := fmt.Sprintf("client.%s:", c.Name)
+
+		if len(c.Policies) == 0 {
+			return multierror.Prefix(errors.New("'policies' must be specified"), prefix)
+		}
+		if c.UnixUID == nil && c.UnixGID == nil && c.ClientSecret == "" {
+			return multierror.Prefix(errors.New("one of 'unix_uid', 'unix_gid' or 'client_secret' must be specified"), prefix)
+		}
+		// The credentials of the peer of a unix socket are only read on
+		// Linux; elsewhere, such a client would never match
+		if (c.UnixUID != nil || c.UnixGID != nil) && runtime.GOOS != "linux" {
+			return multierror.Prefix(fmt.Errorf("'unix_uid' and 'unix_gid' are not supported on %s", runtime.GOOS), prefix)
+		}
+
+		c.TTL = DefaultTokenExchangeTTL
+		if c.TTLRaw != nil {
+			var err error
+			if c.TTL, err = parseutil.ParseDurationSecond(c.TTLRaw); err != nil {
+				return multierror.Prefix(err, prefix)
+			}
+			c.TTLRaw = nil
+			if c.TTL <= 0 {
+				return multierror.Prefix(errors.New("'ttl' must be positive"), prefix)
+			}
+		}
+
+		e.Clients = append(e.Clients, &c)
+	}
+
+	result.TokenExchange = &e
+	return nil
+}
+
+// parseFileMode parses the file mode set in the given field, either as a
+// string holding an octal number or as a number
+func parseFileMode(field string, raw interface{}) (os.FileMode, error) {
+	// An unquoted number such as 0640 is already parsed as octal
+	var mode uint64
+	switch raw := raw.(type) {
+	case int:
+		mode = uint64(raw)
+	case string:
+		var err error
+		if mode, err = strconv.ParseUint(raw, 8, 32); err != nil {
+			return 0, fmt.Errorf("invalid '%s' %q", field, raw)
+		}
+	default:
+		return 0, fmt.Errorf("could not parse '%s'", field)
+	}
+	if os.FileMode(mode)&^os.ModePerm != 0 {
+		return 0, fmt.Errorf("invalid '%s' %o", field, mode)
+	}
+	return os.FileMode(mode), nil
+}
+
+func parseExec(result *Config, list *ast.ObjectList) error {
+	name := "exec"
+
+	execList := list.Filter(name)
+	if len(execList.Items) == 0 {
+		return nil
+	}
+
+	if len(execList.Items) > 1 {
+		return fmt.Errorf("only one %q block is permitted", name)
+	}
+
+	item := execList.Items[0]
+
+	var e Exec
+	if err := hcl.DecodeObject(&e, item.Val); err != nil {
+		return err
+	}
+
+	if len(e.Command) == 0 {
+		return errors.New("exec command must be specified")
+	}
+
+	switch e.OnSecretChange {
+	case "":
+		e.OnSecretChange = ExecOnSecretChangeRestart
+	case ExecOnSecretChangeRestart, ExecOnSecretChangeSignal:
+	default:
+		return fmt.Errorf("invalid on_secret_change %q", e.OnSecretChange)
+	}
+
+	if e.ChangeSignal == "" {
+		e.ChangeSignal = "SIGHUP"
+	}
+	if e.StopSignal == "" {
+		e.StopSignal = "SIGTERM"
+	}
+	e.ChangeSignal = strings.ToUpper(e.ChangeSignal)
+	e.StopSignal = strings.ToUpper(e.StopSignal)
+
+	e.KillTimeout = 30 * time.Second
+	if e.KillTimeoutRaw != nil {
+		var err error
+		if e.KillTimeout, err = parseutil.ParseDurationSecond(e.KillTimeoutRaw); err != nil {
+			return err
+		}
+		e.KillTimeoutRaw = nil
+	}
+
+	result.Exec = &e
+	return nil
+}
+
+func parseTelemetry(result *Config, list *ast.ObjectList) error {
+	name := "telemetry"
+
+	telemetryList := list.Filter(name)
+	if len(telemetryList.Items) == 0 {
+		return nil
+	}
+
+	if len(telemetryList.Items) > 1 {
+		return fmt.Errorf("only one %q block is permitted", name)
+	}
+
+	item := telemetryList.Items[0]
+
+	var t server.Telemetry
+	if err := hcl.DecodeObject(&t, item.Val); err != nil {
+		return err
+	}
+
+	t.PrometheusRetentionTime = DefaultPrometheusRetentionTime
+	if t.PrometheusRetentionTimeRaw != nil {
+		var err error
+		if t.PrometheusRetentionTime, err = parseutil.ParseDurationSecond(t.PrometheusRetentionTimeRaw); err != nil {
+			return err
+		}
+		t.PrometheusRetentionTimeRaw = nil
+	}
+
+	result.Telemetry = &t
+	return nil
+}
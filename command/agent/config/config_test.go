@@ -7,6 +7,7 @@ import (
 
 	"github.com/go-test/deep"
 	ctconfig "github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/vault/command/server"
 	"github.com/hashicorp/vault/sdk/helper/pointerutil"
 )
 
@@ -191,6 +192,101 @@ func TestLoadConfigFile_Method_Wrapping(t *testing.T) {
 	}
 }
 
+func TestLoadConfigFile_AutoAuth_ReauthGracePeriod(t *testing.T) {
+	config, err := LoadConfig("./test-fixtures/config-auto_auth-reauth-grace-period.hcl")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := &Config{
+		AutoAuth: &AutoAuth{
+			Method: &Method{
+				Type:      "aws",
+				MountPath: "auth/aws",
+				Config: map[string]interface{}{
+					"role": "foobar",
+				},
+			},
+			Sinks: []*Sink{
+				&Sink{
+					Type: "file",
+					Config: map[string]interface{}{
+						"path": "/tmp/file-foo",
+					},
+				},
+			},
+			ReauthGracePeriod: 5 * time.Minute,
+			RevokeDelay:       30 * time.Second,
+		},
+		PidFile: "./pidfile",
+	}
+
+	if diff := deep.Equal(config, expected); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func TestLoadConfigFile_Bad_AutoAuth_RevokeDelay(t *testing.T) {
+	_, err := LoadConfig("./test-fixtures/bad-config-auto_auth-revoke-delay.hcl")
+	if err == nil {
+		t.Fatal("LoadConfig should return an error when auto_auth.revoke_delay is not shorter than auto_auth.reauth_grace_period")
+	}
+}
+
+func TestLoadConfigFile_Vault_Failover(t *testing.T) {
+	config, err := LoadConfig("./test-fixtures/config-vault-failover.hcl")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := &Config{
+		Vault: &Vault{
+			Address:             "https://vault-0.example.com:8200",
+			Addresses:           []string{"https://vault-1.example.com:8200", "https://vault-2.example.com:8200"},
+			SRVRecord:           "_vault._tcp.example.com",
+			SRVScheme:           "https",
+			HealthCheckInterval: 30 * time.Second,
+			Retry: &Retry{
+				NumRetries: 5,
+				MinBackoff: 500 * time.Millisecond,
+				MaxBackoff: DefaultMaxBackoff,
+			},
+		},
+		PidFile: "./pidfile",
+	}
+	if diff := deep.Equal(config, expected); diff != nil {
+		t.Fatal(diff)
+	}
+
+	config, err = LoadConfig("./test-fixtures/config-vault-failover-defaults.hcl")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected = &Config{
+		Vault: &Vault{
+			Addresses:           []string{"https://vault-1.example.com:8200", "https://vault-2.example.com:8200"},
+			HealthCheckInterval: DefaultHealthCheckInterval,
+			Retry: &Retry{
+				NumRetries: DefaultNumRetries,
+				MinBackoff: DefaultMinBackoff,
+				MaxBackoff: DefaultMaxBackoff,
+			},
+		},
+		PidFile: "./pidfile",
+	}
+	if diff := deep.Equal(config, expected); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func TestLoadConfigFile_Bad_Vault_RetryBackoff(t *testing.T) {
+	_, err := LoadConfig("./test-fixtures/bad-config-vault-retry-backoff.hcl")
+	if err == nil {
+		t.Fatal("LoadConfig should return an error when vault.retry.max_backoff is less than vault.retry.min_backoff")
+	}
+}
+
 func TestLoadConfigFile_AgentCache_NoAutoAuth(t *testing.T) {
 	config, err := LoadConfig("./test-fixtures/config-cache-no-auto_auth.hcl")
 	if err != nil {
@@ -216,6 +312,119 @@ func TestLoadConfigFile_AgentCache_NoAutoAuth(t *testing.T) {
 	}
 }
 
+func TestLoadConfigFile_AgentCache_Persist(t *testing.T) {
+	config, err := LoadConfig("./test-fixtures/config-cache-persist.hcl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &Cache{
+		UseAutoAuthToken: true,
+		Persist: &Persist{
+			Path:       "/vault/agent-cache",
+			KeyType:    PersistKeyTypeAutoAuth,
+			KeyWrapTTL: 12 * time.Hour,
+			ExitOnErr:  true,
+		},
+	}
+	if diff := deep.Equal(config.Cache, expected); diff != nil {
+		t.Fatal(diff)
+	}
+
+	config, err = LoadConfig("./test-fixtures/config-cache-persist-kms.hcl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = &Cache{
+		Persist: &Persist{
+			Path:       "/vault/agent-cache",
+			KeyType:    PersistKeyTypeKMS,
+			KeyWrapTTL: DefaultPersistKeyWrapTTL,
+			KMS: &KMS{
+				Type: "transit",
+				Config: map[string]string{
+					"address":    "https://vault:8200",
+					"key_name":   "agent-cache",
+					"mount_path": "transit/",
+				},
+			},
+		},
+	}
+	if diff := deep.Equal(config.Cache, expected); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func TestLoadConfigFile_AgentCache_StaticSecrets(t *testing.T) {
+	config, err := LoadConfig("./test-fixtures/config-cache-static-secrets.hcl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &Cache{
+		UseAutoAuthToken: true,
+		StaticSecrets: &StaticSecrets{
+			RefreshInterval: 10 * time.Minute,
+		},
+	}
+	if diff := deep.Equal(config.Cache, expected); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func TestLoadConfigFile_Bad_AgentCache_StaticSecrets_RefreshInterval(t *testing.T) {
+	_, err := LoadConfig("./test-fixtures/bad-config-cache-static-secrets-refresh-interval.hcl")
+	if err == nil {
+		t.Fatal("LoadConfig should return an error when cache.static_secrets.refresh_interval is not positive")
+	}
+}
+
+func TestLoadConfigFile_AgentCache_Listener_AutoAuthTokenPaths(t *testing.T) {
+	config, err := LoadConfig("./test-fixtures/config-cache-listener-auto_auth_token_paths.hcl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []*Listener{
+		&Listener{
+			Type: "unix",
+			Config: map[string]interface{}{
+				"address":                "/path/to/socket",
+				"tls_disable":            true,
+				"socket_mode":            "0660",
+				"socket_group":           "app",
+				"require_request_header": true,
+			},
+			AutoAuthTokenPaths: []string{"secret/data/app/*", "auth/token/lookup-self"},
+		},
+		&Listener{
+			Type: "tcp",
+			Config: map[string]interface{}{
+				"address":     "127.0.0.1:8300",
+				"tls_disable": true,
+			},
+		},
+	}
+	if diff := deep.Equal(config.Listeners, expected); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func TestLoadConfigFile_Bad_AgentCache_Listener_AutoAuthTokenPaths_NoToken(t *testing.T) {
+	_, err := LoadConfig("./test-fixtures/bad-config-cache-listener-auto_auth_token_paths-no-token.hcl")
+	if err == nil {
+		t.Fatal("LoadConfig should return an error when listener.auto_auth_token_paths is set and cache.use_auto_auth_token is not true")
+	}
+}
+
+func TestLoadConfigFile_Bad_AgentCache_Persist_AutoAuth_NoToken(t *testing.T) {
+	_, err := LoadConfig("./test-fixtures/bad-config-cache-persist-auto_auth-no-token.hcl")
+	if err == nil {
+		t.Fatal("LoadConfig should return an error when cache.persist.key_type=auto_auth and cache.use_auto_auth_token is not true")
+	}
+}
+
 func TestLoadConfigFile_Bad_AgentCache_InconsisentAutoAuth(t *testing.T) {
 	_, err := LoadConfig("./test-fixtures/bad-config-cache-inconsistent-auto_auth.hcl")
 	if err == nil {
@@ -389,3 +598,225 @@ func TestLoadConfigFile_Template(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadConfigFile_Exec(t *testing.T) {
+	config, err := LoadConfig("./test-fixtures/config-exec.hcl")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := &Config{
+		AutoAuth: &AutoAuth{
+			Method: &Method{
+				Type:      "aws",
+				MountPath: "auth/aws",
+				Namespace: "my-namespace/",
+				Config: map[string]interface{}{
+					"role": "foobar",
+				},
+			},
+			Sinks: []*Sink{
+				&Sink{
+					Type:   "file",
+					DHType: "curve25519",
+					DHPath: "/tmp/file-foo-dhpath",
+					AAD:    "foobar",
+					Config: map[string]interface{}{
+						"path": "/tmp/file-foo",
+					},
+				},
+			},
+		},
+		EnvTemplates: []*EnvTemplate{
+			&EnvTemplate{
+				Name: "DB_USERNAME",
+				Template: &ctconfig.TemplateConfig{
+					Contents: pointerutil.StringPtr(`{{ with secret "database/creds/app" }}{{ .Data.username }}{{ end }}`),
+				},
+			},
+			&EnvTemplate{
+				Name: "DB_PASSWORD",
+				Template: &ctconfig.TemplateConfig{
+					Contents:      pointerutil.StringPtr(`{{ with secret "database/creds/app" }}{{ .Data.password }}{{ end }}`),
+					ErrMissingKey: pointerutil.BoolPtr(true),
+				},
+			},
+		},
+		Exec: &Exec{
+			Command:        []string{"/usr/bin/app", "--verbose"},
+			OnSecretChange: ExecOnSecretChangeSignal,
+			ChangeSignal:   "SIGUSR1",
+			StopSignal:     "SIGTERM",
+			KillTimeout:    10 * time.Second,
+		},
+		PidFile: "./pidfile",
+	}
+
+	if diff := deep.Equal(config, expected); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func TestLoadConfigFile_Bad_EnvTemplate_NoExec(t *testing.T) {
+	_, err := LoadConfig("./test-fixtures/bad-config-env_template-no-exec.hcl")
+	if err == nil {
+		t.Fatal("LoadConfig should return an error when env_template is set without exec")
+	}
+}
+
+func TestLoadConfigFile_Bad_EnvTemplate_Destination(t *testing.T) {
+	_, err := LoadConfig("./test-fixtures/bad-config-env_template-destination.hcl")
+	if err == nil {
+		t.Fatal("LoadConfig should return an error when env_template sets a destination")
+	}
+}
+
+func TestLoadConfigFile_PKICertificate(t *testing.T) {
+	config, err := LoadConfig("./test-fixtures/config-pki_certificate.hcl")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := &Config{
+		AutoAuth: &AutoAuth{
+			Method: &Method{
+				Type:      "aws",
+				MountPath: "auth/aws",
+				Config: map[string]interface{}{
+					"role": "foobar",
+				},
+			},
+			Sinks: []*Sink{
+				&Sink{
+					Type: "file",
+					Config: map[string]interface{}{
+						"path": "/tmp/file-foo",
+					},
+				},
+			},
+		},
+		Certificates: []*PKICertificate{
+			&PKICertificate{
+				Path: "pki/issue/web",
+				Parameters: map[string]interface{}{
+					"common_name": "web.example.com",
+					"ttl":         "24h",
+				},
+				RenewFraction:    0.5,
+				CertDestination:  "/etc/web/cert.pem",
+				KeyDestination:   "/etc/web/key.pem",
+				ChainDestination: "/etc/web/chain.pem",
+				Perms:            0644,
+				KeyPerms:         0640,
+			},
+			&PKICertificate{
+				Path:            "pki/issue/client",
+				RenewFraction:   DefaultPKIRenewFraction,
+				CertDestination: "/etc/client/cert.pem",
+				KeyDestination:  "/etc/client/key.pem",
+				Perms:           DefaultPKIPerms,
+				KeyPerms:        DefaultPKIKeyPerms,
+			},
+		},
+		PidFile: "./pidfile",
+	}
+
+	if diff := deep.Equal(config, expected); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func TestLoadConfigFile_Bad_PKICertificate_RenewFraction(t *testing.T) {
+	_, err := LoadConfig("./test-fixtures/bad-config-pki_certificate-renew-fraction.hcl")
+	if err == nil {
+		t.Fatal("LoadConfig should return an error when renew_fraction is not between 0 and 1")
+	}
+}
+
+func TestLoadConfigFile_TemplateConfig(t *testing.T) {
+	config, err := LoadConfig("./test-fixtures/config-template_config.hcl")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := &TemplateConfig{
+		PKIRenewFraction: 0.5,
+	}
+	if diff := deep.Equal(config.TemplateConfig, expected); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func TestLoadConfigFile_Bad_TemplateConfig_PKIRenewFraction(t *testing.T) {
+	_, err := LoadConfig("./test-fixtures/bad-config-template_config-pki-renew-fraction.hcl")
+	if err == nil {
+		t.Fatal("LoadConfig should return an error when pki_renew_fraction is not between 0 and 1")
+	}
+}
+
+func TestLoadConfigFile_TokenExchange(t *testing.T) {
+	config, err := LoadConfig("./test-fixtures/config-token_exchange.hcl")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	uid, gid := 1000, 0
+	expected := &TokenExchange{
+		Clients: []*TokenExchangeClient{
+			&TokenExchangeClient{
+				Name:     "web",
+				Policies: []string{"web-read", "web-write"},
+				TTL:      2 * time.Minute,
+				UnixUID:  &uid,
+			},
+			&TokenExchangeClient{
+				Name:         "batch",
+				Policies:     []string{"batch"},
+				TTL:          DefaultTokenExchangeTTL,
+				UnixGID:      &gid,
+				ClientSecret: "s3cr3t",
+			},
+		},
+	}
+
+	if diff := deep.Equal(config.TokenExchange, expected); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func TestLoadConfigFile_Bad_TokenExchange_NoIdentity(t *testing.T) {
+	_, err := LoadConfig("./test-fixtures/bad-config-token_exchange-no-identity.hcl")
+	if err == nil {
+		t.Fatal("LoadConfig should return an error when a token_exchange client cannot be identified")
+	}
+}
+
+func TestLoadConfigFile_Bad_TokenExchange_NoUnixListener(t *testing.T) {
+	_, err := LoadConfig("./test-fixtures/bad-config-token_exchange-no-unix-listener.hcl")
+	if err == nil {
+		t.Fatal("LoadConfig should return an error when token_exchange is set without a unix listener")
+	}
+}
+
+func TestLoadConfigFile_Bad_Exec_ExitAfterAuth(t *testing.T) {
+	_, err := LoadConfig("./test-fixtures/bad-config-exec-exit_after_auth.hcl")
+	if err == nil {
+		t.Fatal("LoadConfig should return an error when exec is set with exit_after_auth")
+	}
+}
+
+func TestLoadConfigFile_Telemetry(t *testing.T) {
+	config, err := LoadConfig("./test-fixtures/config-telemetry.hcl")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := &server.Telemetry{
+		PrometheusRetentionTime: 30 * time.Second,
+		DisableHostname:         true,
+		StatsdAddr:              "127.0.0.1:8125",
+	}
+	if diff := deep.Equal(config.Telemetry, expected); diff != nil {
+		t.Fatal(diff)
+	}
+}
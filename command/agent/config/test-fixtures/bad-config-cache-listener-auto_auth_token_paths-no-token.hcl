@@ -0,0 +1,26 @@
+pid_file = "./pidfile"
+
+auto_auth {
+	method {
+		type = "aws"
+		config = {
+			role = "foobar"
+		}
+	}
+
+	sink {
+		type = "file"
+		config = {
+			path = "/tmp/file-foo"
+		}
+	}
+}
+
+cache {
+}
+
+listener "unix" {
+    address = "/path/to/socket"
+    tls_disable = true
+    auto_auth_token_paths = ["secret/data/app/*"]
+}
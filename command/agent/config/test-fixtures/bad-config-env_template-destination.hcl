@@ -0,0 +1,33 @@
+pid_file = "./pidfile"
+
+auto_auth {
+  method {
+    type      = "aws"
+    namespace = "/my-namespace"
+
+    config = {
+      role = "foobar"
+    }
+  }
+
+  sink {
+    type = "file"
+
+    config = {
+      path = "/tmp/file-foo"
+    }
+
+    aad     = "foobar"
+    dh_type = "curve25519"
+    dh_path = "/tmp/file-foo-dhpath"
+  }
+}
+
+env_template "DB_PASSWORD" {
+  contents    = "{{ with secret \"database/creds/app\" }}{{ .Data.password }}{{ end }}"
+  destination = "/path/on/disk/where/template/will/render.txt"
+}
+
+exec {
+  command = ["/usr/bin/app"]
+}
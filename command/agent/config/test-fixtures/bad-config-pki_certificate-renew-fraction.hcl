@@ -0,0 +1,24 @@
+auto_auth {
+  method {
+    type = "aws"
+
+    config = {
+      role = "foobar"
+    }
+  }
+
+  sink {
+    type = "file"
+
+    config = {
+      path = "/tmp/file-foo"
+    }
+  }
+}
+
+pki_certificate {
+  path             = "pki/issue/web"
+  renew_fraction   = 1.5
+  cert_destination = "/etc/web/cert.pem"
+  key_destination  = "/etc/web/key.pem"
+}
@@ -0,0 +1,10 @@
+pid_file = "./pidfile"
+
+template_config {
+  pki_renew_fraction = 1.5
+}
+
+template {
+  source      = "/path/on/disk/to/template.ctmpl"
+  destination = "/path/on/disk/where/template/will/render.txt"
+}
@@ -0,0 +1,24 @@
+auto_auth {
+  method {
+    type = "aws"
+
+    config = {
+      role = "foobar"
+    }
+  }
+}
+
+cache {
+  use_auto_auth_token = true
+}
+
+listener "unix" {
+  address     = "/path/to/socket"
+  tls_disable = true
+}
+
+token_exchange {
+  client "web" {
+    policies = ["web-read"]
+  }
+}
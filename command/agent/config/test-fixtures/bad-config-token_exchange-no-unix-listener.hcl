@@ -0,0 +1,28 @@
+pid_file = "./pidfile"
+
+auto_auth {
+  method {
+    type = "aws"
+
+    config = {
+      role = "foobar"
+    }
+  }
+}
+
+cache {
+  use_auto_auth_token = true
+}
+
+listener "tcp" {
+  address     = "127.0.0.1:8300"
+  tls_disable = true
+}
+
+token_exchange {
+  client "web" {
+    policies = ["web-read", "web-write"]
+    ttl      = "2m"
+    unix_uid = 1000
+  }
+}
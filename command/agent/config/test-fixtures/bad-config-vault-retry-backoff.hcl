@@ -0,0 +1,10 @@
+pid_file = "./pidfile"
+
+vault {
+	address = "https://vault-0.example.com:8200"
+
+	retry {
+		min_backoff = "10s"
+		max_backoff = "1s"
+	}
+}
@@ -0,0 +1,20 @@
+pid_file = "./pidfile"
+
+auto_auth {
+	reauth_grace_period = "5m"
+	revoke_delay = 30
+
+	method {
+		type = "aws"
+		config = {
+			role = "foobar"
+		}
+	}
+
+	sink {
+		type = "file"
+		config = {
+			path = "/tmp/file-foo"
+		}
+	}
+}
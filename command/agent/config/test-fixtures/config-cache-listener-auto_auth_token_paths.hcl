@@ -0,0 +1,28 @@
+pid_file = "./pidfile"
+
+auto_auth {
+	method {
+		type = "aws"
+		config = {
+			role = "foobar"
+		}
+	}
+}
+
+cache {
+	use_auto_auth_token = true
+}
+
+listener "unix" {
+    address = "/path/to/socket"
+    tls_disable = true
+    socket_mode = "0660"
+    socket_group = "app"
+    require_request_header = true
+    auto_auth_token_paths = ["/secret/data/app/*", "auth/token/lookup-self"]
+}
+
+listener "tcp" {
+    address = "127.0.0.1:8300"
+    tls_disable = true
+}
@@ -0,0 +1,18 @@
+pid_file = "./pidfile"
+
+cache {
+	persist {
+		path = "/vault/agent-cache"
+
+		kms "transit" {
+			address = "https://vault:8200"
+			key_name = "agent-cache"
+			mount_path = "transit/"
+		}
+	}
+}
+
+listener "tcp" {
+    address = "127.0.0.1:8300"
+    tls_disable = true
+}
@@ -0,0 +1,25 @@
+pid_file = "./pidfile"
+
+auto_auth {
+	method {
+		type = "aws"
+		config = {
+			role = "foobar"
+		}
+	}
+}
+
+cache {
+	use_auto_auth_token = true
+
+	persist {
+		path = "/vault/agent-cache"
+		key_wrap_ttl = "12h"
+		exit_on_err = true
+	}
+}
+
+listener "tcp" {
+    address = "127.0.0.1:8300"
+    tls_disable = true
+}
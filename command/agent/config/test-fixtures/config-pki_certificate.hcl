@@ -0,0 +1,41 @@
+pid_file = "./pidfile"
+
+auto_auth {
+  method {
+    type = "aws"
+
+    config = {
+      role = "foobar"
+    }
+  }
+
+  sink {
+    type = "file"
+
+    config = {
+      path = "/tmp/file-foo"
+    }
+  }
+}
+
+pki_certificate {
+  path = "pki/issue/web"
+
+  parameters = {
+    common_name = "web.example.com"
+    ttl         = "24h"
+  }
+
+  renew_fraction    = 0.5
+  cert_destination  = "/etc/web/cert.pem"
+  key_destination   = "/etc/web/key.pem"
+  chain_destination = "/etc/web/chain.pem"
+  perms             = "0644"
+  key_perms         = "0640"
+}
+
+pki_certificate {
+  path             = "pki/issue/client"
+  cert_destination = "/etc/client/cert.pem"
+  key_destination  = "/etc/client/key.pem"
+}
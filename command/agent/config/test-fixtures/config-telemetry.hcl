@@ -0,0 +1,23 @@
+pid_file = "./pidfile"
+
+auto_auth {
+	method {
+		type = "aws"
+		config = {
+			role = "foobar"
+		}
+	}
+
+	sink {
+		type = "file"
+		config = {
+			path = "/tmp/file-foo"
+		}
+	}
+}
+
+telemetry {
+	prometheus_retention_time = "30s"
+	disable_hostname = true
+	statsd_address = "127.0.0.1:8125"
+}
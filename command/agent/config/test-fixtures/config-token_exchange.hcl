@@ -0,0 +1,34 @@
+pid_file = "./pidfile"
+
+auto_auth {
+  method {
+    type = "aws"
+
+    config = {
+      role = "foobar"
+    }
+  }
+}
+
+cache {
+  use_auto_auth_token = true
+}
+
+listener "unix" {
+  address     = "/path/to/socket"
+  tls_disable = true
+}
+
+token_exchange {
+  client "web" {
+    policies = ["web-read", "web-write"]
+    ttl      = "2m"
+    unix_uid = 1000
+  }
+
+  client "batch" {
+    policies      = ["batch"]
+    unix_gid      = 0
+    client_secret = "s3cr3t"
+  }
+}
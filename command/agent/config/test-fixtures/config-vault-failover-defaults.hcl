@@ -0,0 +1,5 @@
+pid_file = "./pidfile"
+
+vault {
+	addresses = ["https://vault-1.example.com:8200", "https://vault-2.example.com:8200"]
+}
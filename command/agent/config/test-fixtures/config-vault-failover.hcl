@@ -0,0 +1,13 @@
+pid_file = "./pidfile"
+
+vault {
+	address = "https://vault-0.example.com:8200"
+	addresses = ["https://vault-1.example.com:8200", "https://vault-2.example.com:8200"]
+	srv_record = "_vault._tcp.example.com"
+	health_check_interval = "30s"
+
+	retry {
+		num_retries = 5
+		min_backoff = "500ms"
+	}
+}
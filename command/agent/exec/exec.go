@@ -0,0 +1,428 @@
+// Package exec is responsible for running the process configured by the exec
+// stanza. The Server type renders the configured environment templates using a
+// Consul Template Runner in dry mode, starts the process with the rendered
+// values in its environment, and restarts or signals it whenever they change.
+package exec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	osexec "os/exec"
+	"reflect"
+	"sort"
+	"time"
+
+	ctconfig "github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul-template/manager"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/command/agent/config"
+	"github.com/hashicorp/vault/command/agent/template"
+	"github.com/hashicorp/vault/sdk/helper/pointerutil"
+)
+
+// ServerConfig is a config struct for setting up the Server
+type ServerConfig struct {
+	Logger    hclog.Logger
+	VaultConf *config.Vault
+	Namespace string
+
+	// LogLevel and LogWriter are used to set up the internal Consul Template
+	// Runner's logging, see template.ServerConfig.
+	LogLevel  hclog.Level
+	LogWriter io.Writer
+
+	Exec         *config.Exec
+	EnvTemplates []*config.EnvTemplate
+
+	// AddressCh, if set, receives the address of the Vault server to render
+	// the environment templates against each time the agent fails over to
+	// another server
+	AddressCh <-chan string
+
+	// Stdin, Stdout and Stderr are the standard streams of the process. They
+	// default to the agent's own.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Server runs the process configured by the exec stanza
+type Server struct {
+	config *ServerConfig
+	logger hclog.Logger
+
+	changeSignal os.Signal
+	stopSignal   os.Signal
+
+	// envNames maps the destination given to each environment template to
+	// the name of its environment variable.
+	envNames  map[string]string
+	templates []*ctconfig.TemplateConfig
+
+	runner *manager.Runner
+	child  *child
+
+	exitCode int
+
+	DoneCh chan struct{}
+}
+
+// child is a running instance of the process
+type child struct {
+	cmd    *osexec.Cmd
+	env    map[string]string
+	exitCh chan error
+}
+
+// NewServer returns a new configured server
+func NewServer(conf *ServerConfig) (*Server, error) {
+	if conf.Exec == nil || len(conf.Exec.Command) == 0 {
+		return nil, errors.New("no command provided")
+	}
+
+	changeSignal, err := parseSignal(conf.Exec.ChangeSignal)
+	if err != nil {
+		return nil, err
+	}
+	stopSignal, err := parseSignal(conf.Exec.StopSignal)
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.Stdin == nil {
+		conf.Stdin = os.Stdin
+	}
+	if conf.Stdout == nil {
+		conf.Stdout = os.Stdout
+	}
+	if conf.Stderr == nil {
+		conf.Stderr = os.Stderr
+	}
+
+	// Consul Template identifies rendered templates by their destination, so
+	// give each environment template a unique one. Nothing is written to it
+	// since the runner is used in dry mode.
+	envNames := make(map[string]string, len(conf.EnvTemplates))
+	templates := make([]*ctconfig.TemplateConfig, 0, len(conf.EnvTemplates))
+	for _, et := range conf.EnvTemplates {
+		tc := et.Template.Copy()
+		destination := fmt.Sprintf("vault-agent-env-template:%s", et.Name)
+		tc.Destination = pointerutil.StringPtr(destination)
+		envNames[destination] = et.Name
+		templates = append(templates, tc)
+	}
+
+	return &Server{
+		config:       conf,
+		logger:       conf.Logger,
+		changeSignal: changeSignal,
+		stopSignal:   stopSignal,
+		envNames:     envNames,
+		templates:    templates,
+		DoneCh:       make(chan struct{}),
+	}, nil
+}
+
+// ExitCode returns the exit code of the process. It is only meaningful once
+// DoneCh is closed.
+func (s *Server) ExitCode() int {
+	return s.exitCode
+}
+
+// Run renders the environment templates using the tokens received on
+// incoming, then starts the process. If the rendered values change, the
+// process is restarted or signaled. Run returns when the process exits on its
+// own, or once it has been stopped after Done() is called on the context.
+func (s *Server) Run(ctx context.Context, incoming chan string) {
+	s.logger.Info("starting exec server")
+	defer func() {
+		s.logger.Info("exec server stopped")
+		close(s.DoneCh)
+	}()
+
+	// Without templates, there is nothing to wait for
+	if len(s.templates) == 0 {
+		if err := s.start(nil); err != nil {
+			s.logger.Error("failed to start process", "error", err)
+			s.exitCode = 1
+			return
+		}
+	}
+
+	var runnerConfig *ctconfig.Config
+	if len(s.templates) > 0 {
+		var err error
+		runnerConfig, err = template.NewRunnerConfig(&template.ServerConfig{
+			Logger:    s.logger,
+			VaultConf: s.config.VaultConf,
+			Namespace: s.config.Namespace,
+			LogLevel:  s.config.LogLevel,
+			LogWriter: s.config.LogWriter,
+		}, s.templates)
+		if err != nil {
+			s.logger.Error("exec server failed to generate runner config", "error", err)
+			s.exitCode = 1
+			return
+		}
+	}
+
+	latestToken := new(string)
+	var runnerErrCh <-chan error
+	var renderedCh <-chan struct{}
+	defer func() {
+		if s.runner != nil {
+			s.runner.Stop()
+		}
+	}()
+
+	for {
+		var exitCh chan error
+		if s.child != nil {
+			exitCh = s.child.exitCh
+		}
+
+		select {
+		case <-ctx.Done():
+			if err := s.stop(); err != nil {
+				s.logger.Error("failed to stop process", "error", err)
+			}
+			return
+
+		case token, ok := <-incoming:
+			if !ok {
+				incoming = nil
+				continue
+			}
+			if runnerConfig == nil || token == *latestToken {
+				continue
+			}
+
+			s.logger.Info("exec server received new token")
+			*latestToken = token
+			runnerConfig = runnerConfig.Merge(&ctconfig.Config{
+				Vault: &ctconfig.VaultConfig{
+					Token: latestToken,
+				},
+			})
+
+			runner, err := s.restartRunner(runnerConfig)
+			if err != nil {
+				s.logger.Error("exec server failed with new Vault token", "error", err)
+				continue
+			}
+			runnerErrCh = runner.ErrCh
+			renderedCh = runner.TemplateRenderedCh()
+
+		case address := <-s.config.AddressCh:
+			if runnerConfig == nil || address == *runnerConfig.Vault.Address {
+				continue
+			}
+
+			s.logger.Info("exec server received new Vault address", "address", address)
+			runnerConfig = runnerConfig.Merge(&ctconfig.Config{
+				Vault: &ctconfig.VaultConfig{
+					Address: pointerutil.StringPtr(address),
+				},
+			})
+
+			// The runner is only started once a token is received
+			if s.runner == nil {
+				continue
+			}
+			runner, err := s.restartRunner(runnerConfig)
+			if err != nil {
+				s.logger.Error("exec server failed with new Vault address", "error", err)
+				continue
+			}
+			runnerErrCh = runner.ErrCh
+			renderedCh = runner.TemplateRenderedCh()
+
+		case err := <-runnerErrCh:
+			s.logger.Error("exec server error", "error", err.Error())
+			if err := s.stop(); err != nil {
+				s.logger.Error("failed to stop process", "error", err)
+			}
+			s.exitCode = 1
+			return
+
+		case <-renderedCh:
+			env, ok := s.renderedEnv()
+			if !ok {
+				// Not all templates have been rendered yet
+				continue
+			}
+			if err := s.update(env); err != nil {
+				s.logger.Error("failed to update process", "error", err)
+				if err := s.stop(); err != nil {
+					s.logger.Error("failed to stop process", "error", err)
+				}
+				s.exitCode = 1
+				return
+			}
+
+		case err := <-exitCh:
+			s.child = nil
+			s.exitCode = exitCode(err)
+			s.logger.Info("process exited", "exit_code", s.exitCode)
+			return
+		}
+	}
+}
+
+// restartRunner stops the current runner, if any, and starts a new one with
+// the given configuration.
+func (s *Server) restartRunner(runnerConfig *ctconfig.Config) (*manager.Runner, error) {
+	if s.runner != nil {
+		s.runner.Stop()
+	}
+
+	runner, err := manager.NewRunner(runnerConfig, true)
+	if err != nil {
+		return nil, err
+	}
+	runner.SetOutStream(ioutil.Discard)
+	s.runner = runner
+	go runner.Start()
+	return runner, nil
+}
+
+// renderedEnv returns the values of the environment variables rendered by the
+// runner, and whether all of them have been rendered.
+func (s *Server) renderedEnv() (map[string]string, bool) {
+	env := make(map[string]string, len(s.envNames))
+	for _, event := range s.runner.RenderEvents() {
+		if !event.WouldRender {
+			continue
+		}
+		for _, tc := range event.TemplateConfigs {
+			if name, ok := s.envNames[ctconfig.StringVal(tc.Destination)]; ok {
+				env[name] = string(event.Contents)
+			}
+		}
+	}
+	return env, len(env) == len(s.envNames)
+}
+
+// update starts the process with the given environment, or applies the
+// configured change behavior if it is already running with another one.
+func (s *Server) update(env map[string]string) error {
+	if s.child == nil {
+		return s.start(env)
+	}
+	if reflect.DeepEqual(env, s.child.env) {
+		return nil
+	}
+
+	switch s.config.Exec.OnSecretChange {
+	case config.ExecOnSecretChangeSignal:
+		s.logger.Info("environment changed; signaling process", "signal", s.config.Exec.ChangeSignal)
+		if err := s.child.cmd.Process.Signal(s.changeSignal); err != nil {
+			return err
+		}
+		s.child.env = env
+		return nil
+
+	default:
+		s.logger.Info("environment changed; restarting process")
+		if err := s.stop(); err != nil {
+			return err
+		}
+		return s.start(env)
+	}
+}
+
+// start starts the process with the given values added to the agent's
+// environment.
+func (s *Server) start(env map[string]string) error {
+	command := s.config.Exec.Command
+	cmd := osexec.Command(command[0], command[1:]...)
+	cmd.Env = os.Environ()
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", name, env[name]))
+	}
+	cmd.Stdin = s.config.Stdin
+	cmd.Stdout = s.config.Stdout
+	cmd.Stderr = s.config.Stderr
+
+	s.logger.Info("starting process", "command", command)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	exitCh := make(chan error, 1)
+	go func() {
+		exitCh <- cmd.Wait()
+	}()
+
+	s.child = &child{
+		cmd:    cmd,
+		env:    env,
+		exitCh: exitCh,
+	}
+	return nil
+}
+
+// stop sends the stop signal to the process, if it is running, and kills it
+// if it has not exited within the kill timeout.
+func (s *Server) stop() error {
+	if s.child == nil {
+		return nil
+	}
+	c := s.child
+	s.child = nil
+
+	s.logger.Info("stopping process", "signal", s.config.Exec.StopSignal)
+	if err := c.cmd.Process.Signal(s.stopSignal); err != nil {
+		// The process may have already exited
+		select {
+		case <-c.exitCh:
+			return nil
+		default:
+		}
+		return err
+	}
+
+	select {
+	case <-c.exitCh:
+		return nil
+	case <-time.After(s.config.Exec.KillTimeout):
+	}
+
+	s.logger.Warn("process did not exit in time; killing it", "kill_timeout", s.config.Exec.KillTimeout)
+	if err := c.cmd.Process.Kill(); err != nil {
+		return err
+	}
+	<-c.exitCh
+	return nil
+}
+
+// exitCode returns the exit code corresponding to the error returned when
+// waiting for the process.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*osexec.ExitError); ok {
+		if code := exitErr.ExitCode(); code >= 0 {
+			return code
+		}
+	}
+	return 1
+}
+
+func parseSignal(name string) (os.Signal, error) {
+	sig, ok := signals[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported signal %q", name)
+	}
+	return sig, nil
+}
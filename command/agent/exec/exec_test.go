@@ -0,0 +1,183 @@
+// +build !windows
+
+package exec
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	ctconfig "github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/command/agent/config"
+	"github.com/hashicorp/vault/sdk/helper/logging"
+	"github.com/hashicorp/vault/sdk/helper/pointerutil"
+)
+
+// testVault serves a non-renewable secret with a short lease, so that the
+// runner fetches it again quickly.
+type testVault struct {
+	l     sync.Mutex
+	value string
+}
+
+func (v *testVault) setValue(value string) {
+	v.l.Lock()
+	defer v.l.Unlock()
+	v.value = value
+}
+
+func (v *testVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/secret/foo" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	v.l.Lock()
+	defer v.l.Unlock()
+	fmt.Fprintf(w, `{"lease_duration": 1, "renewable": false, "data": {"value": %q}}`, v.value)
+}
+
+func testServer(t *testing.T, vaultAddr string, exec *config.Exec) *Server {
+	t.Helper()
+
+	if exec.ChangeSignal == "" {
+		exec.ChangeSignal = "SIGHUP"
+	}
+	if exec.StopSignal == "" {
+		exec.StopSignal = "SIGTERM"
+	}
+	if exec.KillTimeout == 0 {
+		exec.KillTimeout = 5 * time.Second
+	}
+
+	s, err := NewServer(&ServerConfig{
+		Logger: logging.NewVaultLogger(hclog.Trace),
+		VaultConf: &config.Vault{
+			Address: vaultAddr,
+		},
+		LogLevel:  hclog.Trace,
+		LogWriter: hclog.DefaultOutput,
+		Exec:      exec,
+		EnvTemplates: []*config.EnvTemplate{
+			&config.EnvTemplate{
+				Name: "FOO",
+				Template: &ctconfig.TemplateConfig{
+					Contents: pointerutil.StringPtr(`{{ with secret "secret/foo" }}{{ .Data.value }}{{ end }}`),
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// waitForFile waits until the file at path has the expected contents.
+func waitForFile(t *testing.T, path, expected string) {
+	t.Helper()
+
+	var contents []byte
+	for i := 0; i < 100; i++ {
+		contents, _ = ioutil.ReadFile(path)
+		if string(contents) == expected {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("bad: file contents: expected %q, got %q", expected, contents)
+}
+
+func TestServer_ExitCode(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "agent-exec-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	out := filepath.Join(tmpDir, "out")
+
+	vault := &testVault{value: "bar"}
+	ts := httptest.NewServer(vault)
+	defer ts.Close()
+
+	s := testServer(t, ts.URL, &config.Exec{
+		Command: []string{"sh", "-c", fmt.Sprintf(`printf "%%s" "$FOO" > %s; exit 3`, out)},
+	})
+
+	incoming := make(chan string, 1)
+	go s.Run(context.Background(), incoming)
+	incoming <- "test"
+
+	select {
+	case <-s.DoneCh:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the process to exit")
+	}
+
+	if s.ExitCode() != 3 {
+		t.Fatalf("bad: exit code: %d", s.ExitCode())
+	}
+	waitForFile(t, out, "bar")
+}
+
+func TestServer_OnSecretChange(t *testing.T) {
+	testCases := map[string]struct {
+		onSecretChange string
+		expected       string
+	}{
+		"restart": {
+			config.ExecOnSecretChangeRestart,
+			"bar\nbaz\n",
+		},
+		"signal": {
+			config.ExecOnSecretChangeSignal,
+			"bar\nsignaled\n",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			tmpDir, err := ioutil.TempDir("", "agent-exec-tests")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tmpDir)
+			out := filepath.Join(tmpDir, "out")
+
+			vault := &testVault{value: "bar"}
+			ts := httptest.NewServer(vault)
+			defer ts.Close()
+
+			script := fmt.Sprintf(`trap 'echo signaled >> %[1]s' USR1; echo "$FOO" >> %[1]s; while true; do sleep 0.1; done`, out)
+			s := testServer(t, ts.URL, &config.Exec{
+				Command:        []string{"sh", "-c", script},
+				OnSecretChange: tc.onSecretChange,
+				ChangeSignal:   "SIGUSR1",
+			})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			incoming := make(chan string, 1)
+			go s.Run(ctx, incoming)
+			incoming <- "test"
+
+			waitForFile(t, out, "bar\n")
+			vault.setValue("baz")
+			waitForFile(t, out, tc.expected)
+
+			cancel()
+			select {
+			case <-s.DoneCh:
+			case <-time.After(10 * time.Second):
+				t.Fatal("timed out waiting for the process to be stopped")
+			}
+		})
+	}
+}
@@ -0,0 +1,16 @@
+package exec
+
+import (
+	"os"
+	"syscall"
+)
+
+// signals are the signals that can be sent to the process, by name. Note that
+// Windows only supports sending SIGKILL; other signals fail to be delivered.
+var signals = map[string]os.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGKILL": syscall.SIGKILL,
+	"SIGTERM": syscall.SIGTERM,
+}
@@ -0,0 +1,369 @@
+// Package failover keeps the agent's requests going to a healthy Vault server
+// among several configured or discovered ones. Requests stick to the active
+// node of the cluster, as reported by sys/leader, for as long as it is
+// healthy.
+package failover
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cleanhttp "github.com/hashicorp/go-cleanhttp"
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/api"
+)
+
+// healthCheckTimeout bounds the time spent checking a single server
+const healthCheckTimeout = 5 * time.Second
+
+// Config is the configuration of the failover Manager
+type Config struct {
+	Logger hclog.Logger
+
+	// Addresses are the addresses of the servers, in order of preference
+	Addresses []string
+
+	// SRVRecord, if set, is the DNS SRV record listing additional servers,
+	// which are reached with SRVScheme
+	SRVRecord string
+	SRVScheme string
+
+	// HealthCheckInterval is the interval at which the servers are checked
+	HealthCheckInterval time.Duration
+}
+
+// Manager selects a healthy server, preferring the active node. The requests
+// of the agent's client are sent to it through the transport returned by
+// Transport, so that the client and all of its clones follow the selected
+// server without their address changing.
+type Manager struct {
+	logger              hclog.Logger
+	addresses           []string
+	srvRecord           string
+	srvScheme           string
+	healthCheckInterval time.Duration
+
+	// lookupSRV resolves SRV records, and is replaced in tests
+	lookupSRV func(name string) ([]*net.SRV, error)
+
+	// checkLock serializes the checks of the servers, which are reached
+	// through base
+	checkLock sync.Mutex
+	base      http.RoundTripper
+
+	// addressLock protects the address of the selected server and the
+	// channels notified when it changes
+	addressLock sync.RWMutex
+	address     string
+	addressChs  []chan string
+}
+
+// serverStatus is the result of checking a server
+type serverStatus struct {
+	address string
+	healthy bool
+	active  bool
+}
+
+// NewManager creates a new failover Manager
+func NewManager(conf *Config) (*Manager, error) {
+	if len(conf.Addresses) == 0 && conf.SRVRecord == "" {
+		return nil, errors.New("no addresses or SRV record provided")
+	}
+
+	m := &Manager{
+		logger:              conf.Logger,
+		addresses:           conf.Addresses,
+		srvRecord:           conf.SRVRecord,
+		srvScheme:           conf.SRVScheme,
+		healthCheckInterval: conf.HealthCheckInterval,
+		lookupSRV: func(name string) ([]*net.SRV, error) {
+			_, addrs, err := net.LookupSRV("", "", name)
+			return addrs, err
+		},
+		base: cleanhttp.DefaultPooledTransport(),
+	}
+	if m.srvScheme == "" {
+		m.srvScheme = "https"
+	}
+	if m.healthCheckInterval <= 0 {
+		m.healthCheckInterval = 10 * time.Second
+	}
+
+	return m, nil
+}
+
+// Transport returns a transport sending the requests made to clientAddress,
+// the address the client is configured with, to the selected server instead,
+// through base. Requests to other addresses, such as the active node a
+// standby redirects to, are sent unchanged, as are all requests until a
+// server is selected. The servers are also checked through base, so that they
+// are reached with the client's TLS configuration.
+func (m *Manager) Transport(clientAddress string, base http.RoundTripper) (http.RoundTripper, error) {
+	u, err := url.Parse(clientAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	m.checkLock.Lock()
+	m.base = base
+	m.checkLock.Unlock()
+
+	return &transport{
+		manager: m,
+		scheme:  u.Scheme,
+		host:    u.Host,
+		base:    base,
+	}, nil
+}
+
+type transport struct {
+	manager *Manager
+	scheme  string
+	host    string
+	base    http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	address := t.manager.Address()
+	if address == "" || req.URL.Scheme != t.scheme || req.URL.Host != t.host {
+		return t.base.RoundTrip(req)
+	}
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+
+	// The request must not be modified
+	req = req.Clone(req.Context())
+	req.URL.Scheme = u.Scheme
+	req.URL.Host = u.Host
+	req.Host = ""
+	return t.base.RoundTrip(req)
+}
+
+// Address returns the address of the selected server, or an empty string
+// until one is selected
+func (m *Manager) Address() string {
+	m.addressLock.RLock()
+	defer m.addressLock.RUnlock()
+	return m.address
+}
+
+// AddressCh returns a channel receiving the address of the selected server
+// each time it changes. If it isn't read in time, only the latest address is
+// kept.
+func (m *Manager) AddressCh() <-chan string {
+	m.addressLock.Lock()
+	defer m.addressLock.Unlock()
+
+	ch := make(chan string, 1)
+	m.addressChs = append(m.addressChs, ch)
+	return ch
+}
+
+func (m *Manager) setAddress(address string) {
+	m.addressLock.Lock()
+	defer m.addressLock.Unlock()
+
+	m.address = address
+	for _, ch := range m.addressChs {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- address
+	}
+}
+
+// Run periodically checks the servers until the context is cancelled
+func (m *Manager) Run(ctx context.Context) {
+	m.logger.Info("starting failover manager", "interval", m.healthCheckInterval.String())
+	defer m.logger.Info("failover manager stopped")
+
+	ticker := time.NewTicker(m.healthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Check(ctx)
+		}
+	}
+}
+
+// Failover is called when a request to the given address failed because of a
+// server failure. Unless another server was selected since, the servers are
+// checked and another healthy one is selected.
+func (m *Manager) Failover(ctx context.Context, failedAddress string) {
+	m.checkLock.Lock()
+	defer m.checkLock.Unlock()
+
+	if m.Address() != failedAddress {
+		return
+	}
+	m.logger.Warn("request to server failed, checking servers", "address", failedAddress)
+	m.check(ctx, failedAddress)
+}
+
+// Check checks the servers and selects the best one.
+func (m *Manager) Check(ctx context.Context) {
+	m.checkLock.Lock()
+	defer m.checkLock.Unlock()
+
+	m.check(ctx, "")
+}
+
+func (m *Manager) check(ctx context.Context, failedAddress string) {
+	current := m.Address()
+
+	var statuses []*serverStatus
+	var failed *serverStatus
+	for _, addr := range m.candidates() {
+		status := m.checkServer(ctx, addr)
+		if addr == failedAddress {
+			failed = status
+			continue
+		}
+		statuses = append(statuses, status)
+	}
+
+	// A server that just failed a request is only used as a last resort
+	preferred := current
+	if failed != nil {
+		failed.active = false
+		statuses = append(statuses, failed)
+		preferred = ""
+	}
+
+	selected := selectServer(preferred, statuses)
+	if selected == "" {
+		m.logger.Error("no healthy server found", "address", current)
+		return
+	}
+	if selected == current {
+		return
+	}
+
+	m.logger.Info("switching server", "from", current, "to", selected)
+	m.setAddress(selected)
+}
+
+// selectServer returns the address to use. The current server is kept while
+// it is the healthy active node. Otherwise, the first healthy active node is
+// preferred over the first healthy standby, which forwards requests to the
+// active node.
+func selectServer(current string, statuses []*serverStatus) string {
+	var firstActive, firstHealthy string
+	for _, status := range statuses {
+		if !status.healthy {
+			continue
+		}
+		if status.active {
+			if status.address == current {
+				return current
+			}
+			if firstActive == "" {
+				firstActive = status.address
+			}
+		}
+		if firstHealthy == "" {
+			firstHealthy = status.address
+		}
+	}
+
+	if firstActive != "" {
+		return firstActive
+	}
+	for _, status := range statuses {
+		if status.healthy && status.address == current {
+			return current
+		}
+	}
+	return firstHealthy
+}
+
+// candidates returns the configured addresses, followed by the ones
+// discovered through the SRV record
+func (m *Manager) candidates() []string {
+	candidates := make([]string, 0, len(m.addresses))
+	seen := make(map[string]bool)
+	add := func(addr string) {
+		addr = strings.TrimSuffix(addr, "/")
+		if !seen[addr] {
+			seen[addr] = true
+			candidates = append(candidates, addr)
+		}
+	}
+
+	for _, addr := range m.addresses {
+		add(addr)
+	}
+
+	if m.srvRecord != "" {
+		records, err := m.lookupSRV(m.srvRecord)
+		if err != nil {
+			m.logger.Error("error resolving SRV record", "record", m.srvRecord, "error", err)
+		}
+		for _, record := range records {
+			host := strings.TrimSuffix(record.Target, ".")
+			add(fmt.Sprintf("%s://%s", m.srvScheme, net.JoinHostPort(host, strconv.Itoa(int(record.Port)))))
+		}
+	}
+
+	return candidates
+}
+
+// checkServer reports whether the server is healthy, and whether it is the
+// active node of its cluster
+func (m *Manager) checkServer(ctx context.Context, addr string) *serverStatus {
+	status := &serverStatus{
+		address: addr,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	req, err := http.NewRequest("GET", addr+"/v1/sys/leader", nil)
+	if err != nil {
+		m.logger.Error("invalid server address", "address", addr, "error", err)
+		return status
+	}
+	client := &http.Client{
+		Transport: m.base,
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		m.logger.Debug("server is unhealthy", "address", addr, "error", err)
+		return status
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		m.logger.Debug("server is unhealthy", "address", addr, "status", resp.StatusCode)
+		return status
+	}
+
+	var leader api.LeaderResponse
+	if err := json.NewDecoder(resp.Body).Decode(&leader); err != nil {
+		m.logger.Debug("server returned an invalid response", "address", addr, "error", err)
+		return status
+	}
+
+	status.healthy = true
+	status.active = !leader.HAEnabled || leader.IsSelf
+	m.logger.Trace("server is healthy", "address", addr, "active", status.active)
+
+	return status
+}
@@ -0,0 +1,284 @@
+package failover
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/helper/logging"
+)
+
+// testServer emulates the sys/leader endpoint of a Vault server
+type testServer struct {
+	*httptest.Server
+
+	l         sync.Mutex
+	haEnabled bool
+	active    bool
+	sealed    bool
+}
+
+func newTestServer(t *testing.T, haEnabled, active bool) *testServer {
+	t.Helper()
+
+	s := &testServer{
+		haEnabled: haEnabled,
+		active:    active,
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.l.Lock()
+		defer s.l.Unlock()
+
+		if r.URL.Path != "/v1/sys/leader" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if s.sealed {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"errors":["Vault is sealed"]}`))
+			return
+		}
+		json.NewEncoder(w).Encode(&api.LeaderResponse{
+			HAEnabled: s.haEnabled,
+			IsSelf:    s.active,
+		})
+	}))
+	return s
+}
+
+func (s *testServer) set(active, sealed bool) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.active = active
+	s.sealed = sealed
+}
+
+func testManager(t *testing.T, address string, conf *Config) (*Manager, *api.Client) {
+	t.Helper()
+
+	conf.Logger = logging.NewVaultLogger(hclog.Trace)
+	m, err := NewManager(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := api.DefaultConfig()
+	config.Address = address
+	if config.HttpClient.Transport, err = m.Transport(address, config.HttpClient.Transport); err != nil {
+		t.Fatal(err)
+	}
+	client, err := api.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, client
+}
+
+func TestManager_StickyActive(t *testing.T) {
+	standby := newTestServer(t, true, false)
+	defer standby.Close()
+	active := newTestServer(t, true, true)
+	defer active.Close()
+
+	m, client := testManager(t, standby.URL, &Config{
+		Addresses: []string{standby.URL, active.URL},
+	})
+
+	// The active node is selected
+	m.Check(context.Background())
+	if m.Address() != active.URL {
+		t.Fatalf("expected the active node %q, got %q", active.URL, m.Address())
+	}
+
+	// The requests of the client and its clones are sent to the selected
+	// server, while their address is unchanged
+	clone, err := client.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clone.Address() != standby.URL {
+		t.Fatalf("expected the clone to keep %q, got %q", standby.URL, clone.Address())
+	}
+	leader, err := clone.Sys().Leader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !leader.IsSelf {
+		t.Fatal("expected the request to be sent to the active node")
+	}
+
+	// Leadership changes are followed
+	active.set(false, false)
+	standby.set(true, false)
+	m.Check(context.Background())
+	if m.Address() != standby.URL {
+		t.Fatalf("expected the new active node %q, got %q", standby.URL, m.Address())
+	}
+
+	// Without an active node, the client stays on a healthy standby
+	standby.set(false, false)
+	m.Check(context.Background())
+	if m.Address() != standby.URL {
+		t.Fatalf("expected to stay on %q, got %q", standby.URL, m.Address())
+	}
+}
+
+func TestManager_Failover(t *testing.T) {
+	first := newTestServer(t, false, false)
+	defer first.Close()
+	second := newTestServer(t, false, false)
+	defer second.Close()
+
+	m, _ := testManager(t, first.URL, &Config{
+		Addresses: []string{first.URL, second.URL},
+	})
+
+	// Without HA, all healthy servers are active and the current one is kept
+	m.Check(context.Background())
+	if m.Address() != first.URL {
+		t.Fatalf("expected to stay on %q, got %q", first.URL, m.Address())
+	}
+
+	// A failed request moves the client to another healthy server, even if
+	// the failed server responds to the health check
+	m.Failover(context.Background(), first.URL)
+	if m.Address() != second.URL {
+		t.Fatalf("expected to fail over to %q, got %q", second.URL, m.Address())
+	}
+
+	// A failure reported for a server the client already moved away from is
+	// ignored
+	m.Failover(context.Background(), first.URL)
+	if m.Address() != second.URL {
+		t.Fatalf("expected to stay on %q, got %q", second.URL, m.Address())
+	}
+
+	// Sealed and unreachable servers are not used
+	second.set(false, true)
+	m.Failover(context.Background(), second.URL)
+	if m.Address() != first.URL {
+		t.Fatalf("expected to fail over to %q, got %q", first.URL, m.Address())
+	}
+	first.Close()
+	m.Check(context.Background())
+	if m.Address() != first.URL {
+		t.Fatalf("expected to stay on %q without any healthy server, got %q", first.URL, m.Address())
+	}
+	second.set(false, false)
+	m.Check(context.Background())
+	if m.Address() != second.URL {
+		t.Fatalf("expected to fail over to %q, got %q", second.URL, m.Address())
+	}
+}
+
+func TestManager_SRV(t *testing.T) {
+	standby := newTestServer(t, true, false)
+	defer standby.Close()
+	active := newTestServer(t, true, true)
+	defer active.Close()
+
+	m, _ := testManager(t, standby.URL, &Config{
+		Addresses: []string{standby.URL},
+		SRVRecord: "_vault._tcp.example.com",
+		SRVScheme: "http",
+	})
+
+	var requested string
+	m.lookupSRV = func(name string) ([]*net.SRV, error) {
+		requested = name
+
+		var records []*net.SRV
+		for _, s := range []*testServer{standby, active} {
+			u, err := url.Parse(s.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			host, port, err := net.SplitHostPort(u.Host)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err := strconv.Atoi(port)
+			if err != nil {
+				t.Fatal(err)
+			}
+			records = append(records, &net.SRV{Target: host + ".", Port: uint16(p)})
+		}
+		return records, nil
+	}
+
+	if candidates := m.candidates(); len(candidates) != 2 {
+		t.Fatalf("expected the discovered servers to be deduplicated, got %v", candidates)
+	}
+
+	m.Check(context.Background())
+	if requested != "_vault._tcp.example.com" {
+		t.Fatalf("expected the SRV record to be resolved, got %q", requested)
+	}
+	if m.Address() != active.URL {
+		t.Fatalf("expected the discovered active node %q, got %q", active.URL, m.Address())
+	}
+}
+
+func TestManager_Transport(t *testing.T) {
+	first := newTestServer(t, true, false)
+	defer first.Close()
+	second := newTestServer(t, true, true)
+	defer second.Close()
+	other := newTestServer(t, true, false)
+	defer other.Close()
+
+	m, err := NewManager(&Config{
+		Logger:    logging.NewVaultLogger(hclog.Trace),
+		Addresses: []string{first.URL, second.URL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt, err := m.Transport(first.URL, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: rt}
+
+	isActive := func(addr string) bool {
+		t.Helper()
+		resp, err := client.Get(addr + "/v1/sys/leader")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var leader api.LeaderResponse
+		if err := json.NewDecoder(resp.Body).Decode(&leader); err != nil {
+			t.Fatal(err)
+		}
+		return leader.IsSelf
+	}
+
+	// Requests are sent unchanged until a server is selected
+	if isActive(first.URL) {
+		t.Fatal("expected the request to be sent to the configured address")
+	}
+
+	addressCh := m.AddressCh()
+	m.Check(context.Background())
+	if address := <-addressCh; address != second.URL {
+		t.Fatalf("expected to be notified of %q, got %q", second.URL, address)
+	}
+
+	// Requests to the client's address are sent to the selected server,
+	// while others, such as redirects, are not
+	if !isActive(first.URL) {
+		t.Fatal("expected the request to be sent to the selected server")
+	}
+	if isActive(other.URL) {
+		t.Fatal("expected the request to another server to be sent unchanged")
+	}
+}
@@ -0,0 +1,156 @@
+// +build !windows
+
+package fifo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/hashicorp/errwrap"
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/command/agent/sink"
+	"golang.org/x/sys/unix"
+)
+
+// fifoSink is a Sink implementation that hands a token to a single reader of
+// a named pipe. Each token is delivered exactly once; readers opening the pipe
+// after the token has been delivered block until a new token is written.
+type fifoSink struct {
+	path   string
+	mode   os.FileMode
+	logger hclog.Logger
+
+	l       sync.Mutex
+	pending string
+	notify  chan struct{}
+}
+
+// NewFIFOSink creates a new FIFO sink with the given configuration
+func NewFIFOSink(conf *sink.SinkConfig) (sink.Sink, error) {
+	if conf.Logger == nil {
+		return nil, errors.New("nil logger provided")
+	}
+
+	conf.Logger.Info("creating fifo sink")
+
+	f := &fifoSink{
+		logger: conf.Logger,
+		mode:   0600,
+		notify: make(chan struct{}, 1),
+	}
+
+	pathRaw, ok := conf.Config["path"]
+	if !ok {
+		return nil, errors.New("'path' not specified for fifo sink")
+	}
+	path, ok := pathRaw.(string)
+	if !ok {
+		return nil, errors.New("could not parse 'path' as string")
+	}
+	f.path = path
+
+	if modeRaw, ok := conf.Config["mode"]; ok {
+		mode, typeOK := modeRaw.(int)
+		if !typeOK {
+			return nil, errors.New("could not parse 'mode' as integer")
+		}
+		if !os.FileMode(mode).IsRegular() {
+			return nil, fmt.Errorf("fifo mode does not represent a regular file")
+		}
+		f.logger.Debug("overriding default fifo sink", "mode", mode)
+		f.mode = os.FileMode(mode)
+	}
+
+	// Create the named pipe, or verify that the existing file is one
+	info, err := os.Lstat(f.path)
+	switch {
+	case os.IsNotExist(err):
+		if err := unix.Mkfifo(f.path, uint32(f.mode)); err != nil {
+			return nil, errwrap.Wrapf(fmt.Sprintf("error creating fifo %s: {{err}}", f.path), err)
+		}
+		// Mkfifo is subject to the umask
+		if err := os.Chmod(f.path, f.mode); err != nil {
+			return nil, errwrap.Wrapf(fmt.Sprintf("error setting the mode of fifo %s: {{err}}", f.path), err)
+		}
+	case err != nil:
+		return nil, errwrap.Wrapf(fmt.Sprintf("error stat-ing %s: {{err}}", f.path), err)
+	case info.Mode()&os.ModeNamedPipe == 0:
+		return nil, fmt.Errorf("%s exists and is not a fifo", f.path)
+	}
+
+	go f.run()
+
+	f.logger.Info("fifo sink configured", "path", f.path, "mode", f.mode)
+
+	return f, nil
+}
+
+// WriteToken implements the Server interface and makes the token available to
+// the next reader of the pipe, replacing any token that has not been read yet.
+// It does not block waiting for a reader. A blank token is not written.
+func (f *fifoSink) WriteToken(token string) error {
+	f.logger.Trace("enter write_token", "path", f.path)
+	defer f.logger.Trace("exit write_token", "path", f.path)
+
+	if token == "" {
+		return nil
+	}
+
+	f.l.Lock()
+	f.pending = token
+	f.l.Unlock()
+
+	select {
+	case f.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// run delivers pending tokens to readers of the pipe. Opening the pipe for
+// writing blocks until a reader opens it.
+func (f *fifoSink) run() {
+	for range f.notify {
+		if err := f.deliver(); err != nil {
+			f.logger.Error("error delivering token", "path", f.path, "error", err)
+		}
+	}
+}
+
+func (f *fifoSink) deliver() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Take the latest token, which may have changed while waiting for a
+	// reader
+	f.l.Lock()
+	token := f.pending
+	f.pending = ""
+	f.l.Unlock()
+
+	if token == "" {
+		return nil
+	}
+
+	if _, err := file.WriteString(token); err != nil {
+		// Make the token available to the next reader
+		f.l.Lock()
+		if f.pending == "" {
+			f.pending = token
+		}
+		f.l.Unlock()
+		select {
+		case f.notify <- struct{}{}:
+		default:
+		}
+		return err
+	}
+
+	f.logger.Info("token delivered", "path", f.path)
+	return nil
+}
@@ -0,0 +1,123 @@
+// +build !windows
+
+package fifo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/command/agent/sink"
+	"github.com/hashicorp/vault/sdk/helper/logging"
+)
+
+func testFIFOSink(t *testing.T) (sink.Sink, string) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "vault-agent-fifo-sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "token")
+
+	s, err := NewFIFOSink(&sink.SinkConfig{
+		Logger: logging.NewVaultLogger(hclog.Trace),
+		Config: map[string]interface{}{
+			"path": path,
+		},
+	})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return s, path
+}
+
+// readFIFO reads the pipe, returning false if no token was delivered within
+// the timeout
+func readFIFO(t *testing.T, path string, timeout time.Duration) (string, bool) {
+	t.Helper()
+
+	// Open the pipe without blocking, so that the read can time out
+	file, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var data []byte
+	deadline := time.Now().Add(timeout)
+	buf := make([]byte, 1024)
+	for time.Now().Before(deadline) {
+		n, _ := file.Read(buf)
+		data = append(data, buf[:n]...)
+		if len(data) > 0 && n == 0 {
+			return string(data), true
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return string(data), len(data) > 0
+}
+
+func TestFIFOSink(t *testing.T) {
+	s, path := testFIFOSink(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeNamedPipe == 0 {
+		t.Fatal("expected a named pipe")
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("expected mode 0600, got %o", info.Mode().Perm())
+	}
+
+	// The latest token is delivered to the reader
+	if err := s.WriteToken("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteToken("bar"); err != nil {
+		t.Fatal(err)
+	}
+	if token, ok := readFIFO(t, path, 2*time.Second); !ok || token != "bar" {
+		t.Fatalf("expected %q, got %q", "bar", token)
+	}
+
+	// The token is delivered only once
+	if token, ok := readFIFO(t, path, time.Second); ok {
+		t.Fatalf("expected no token, got %q", token)
+	}
+
+	// A new token is delivered to the next reader
+	if err := s.WriteToken("baz"); err != nil {
+		t.Fatal(err)
+	}
+	if token, ok := readFIFO(t, path, 2*time.Second); !ok || token != "baz" {
+		t.Fatalf("expected %q, got %q", "baz", token)
+	}
+}
+
+func TestFIFOSink_NotFIFO(t *testing.T) {
+	f, err := ioutil.TempFile("", "vault-agent-fifo-sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	_, err = NewFIFOSink(&sink.SinkConfig{
+		Logger: logging.NewVaultLogger(hclog.Trace),
+		Config: map[string]interface{}{
+			"path": f.Name(),
+		},
+	})
+	if err == nil {
+		t.Fatal("expected an error for a regular file")
+	}
+}
@@ -0,0 +1,12 @@
+package fifo
+
+import (
+	"errors"
+
+	"github.com/hashicorp/vault/command/agent/sink"
+)
+
+// NewFIFOSink returns an error, as named pipes are not supported on Windows
+func NewFIFOSink(conf *sink.SinkConfig) (sink.Sink, error) {
+	return nil, errors.New("fifo sink is not supported on windows")
+}
@@ -31,12 +31,14 @@ func New(conf *sink.SinkConfig, leaseCache *cache.LeaseCache) (sink.Sink, error)
 }
 
 func (s *inmemSink) WriteToken(token string) error {
-	s.token.Store(token)
-
+	// Register the token with the cache before swapping it in, so that
+	// requests made with it are cached as belonging to it
 	if s.leaseCache != nil {
 		s.leaseCache.RegisterAutoAuthToken(token)
 	}
 
+	s.token.Store(token)
+
 	return nil
 }
 
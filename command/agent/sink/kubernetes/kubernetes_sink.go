@@ -0,0 +1,249 @@
+package kubernetes
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	cleanhttp "github.com/hashicorp/go-cleanhttp"
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/command/agent/sink"
+)
+
+const (
+	serviceAccountTokenFile     = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	serviceAccountCACertFile    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+	defaultKey = "token"
+)
+
+// kubernetesSink is a Sink implementation that writes a token to a key of a
+// Kubernetes Secret through the Kubernetes API server
+type kubernetesSink struct {
+	logger hclog.Logger
+	client *http.Client
+
+	address   string
+	namespace string
+	name      string
+	key       string
+
+	// tokenPath is the path to the service account token used to
+	// authenticate to the API server. It is read on every write, as projected
+	// service account tokens are rotated.
+	tokenPath string
+}
+
+// NewKubernetesSink creates a new Kubernetes Secret sink with the given
+// configuration
+func NewKubernetesSink(conf *sink.SinkConfig) (sink.Sink, error) {
+	if conf.Logger == nil {
+		return nil, errors.New("nil logger provided")
+	}
+
+	conf.Logger.Info("creating kubernetes sink")
+
+	k := &kubernetesSink{
+		logger:    conf.Logger,
+		key:       defaultKey,
+		tokenPath: serviceAccountTokenFile,
+	}
+
+	getString := func(name string) (string, error) {
+		raw, ok := conf.Config[name]
+		if !ok {
+			return "", nil
+		}
+		v, ok := raw.(string)
+		if !ok {
+			return "", fmt.Errorf("could not parse '%s' as string", name)
+		}
+		return v, nil
+	}
+
+	var err error
+	if k.name, err = getString("name"); err != nil {
+		return nil, err
+	}
+	if k.name == "" {
+		return nil, errors.New("'name' not specified for kubernetes sink")
+	}
+
+	if key, err := getString("key"); err != nil {
+		return nil, err
+	} else if key != "" {
+		k.key = key
+	}
+
+	if tokenPath, err := getString("token_path"); err != nil {
+		return nil, err
+	} else if tokenPath != "" {
+		k.tokenPath = tokenPath
+	}
+
+	if k.namespace, err = getString("namespace"); err != nil {
+		return nil, err
+	}
+	if k.namespace == "" {
+		namespace, err := ioutil.ReadFile(serviceAccountNamespaceFile)
+		if err != nil {
+			return nil, errwrap.Wrapf("'namespace' not specified and could not be read from the service account: {{err}}", err)
+		}
+		k.namespace = strings.TrimSpace(string(namespace))
+	}
+
+	if k.address, err = getString("address"); err != nil {
+		return nil, err
+	}
+	if k.address == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, errors.New("'address' not specified and not running in a Kubernetes pod")
+		}
+		k.address = "https://" + net.JoinHostPort(host, port)
+	}
+	k.address = strings.TrimSuffix(k.address, "/")
+
+	caCert, err := getString("ca_cert")
+	if err != nil {
+		return nil, err
+	}
+	if caCert == "" && strings.HasPrefix(k.address, "https://") {
+		if _, err := os.Stat(serviceAccountCACertFile); err == nil {
+			caCert = serviceAccountCACertFile
+		}
+	}
+
+	transport := cleanhttp.DefaultPooledTransport()
+	if caCert != "" {
+		pem, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, errwrap.Wrapf("error reading 'ca_cert': {{err}}", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("could not parse any certificates from 'ca_cert'")
+		}
+		transport.TLSClientConfig = &tls.Config{
+			RootCAs:    pool,
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+	k.client = &http.Client{
+		Transport: transport,
+		Timeout:   30 * time.Second,
+	}
+
+	k.logger.Info("kubernetes sink configured", "address", k.address, "namespace", k.namespace, "name", k.name, "key", k.key)
+
+	return k, nil
+}
+
+// WriteToken implements the Server interface and writes the token to the key
+// of the Secret, creating the Secret if it does not exist. Other keys of an
+// existing Secret are left untouched. A blank token is not written.
+func (k *kubernetesSink) WriteToken(token string) error {
+	k.logger.Trace("enter write_token", "name", k.name)
+	defer k.logger.Trace("exit write_token", "name", k.name)
+
+	if token == "" {
+		return nil
+	}
+
+	data := map[string]string{
+		k.key: base64.StdEncoding.EncodeToString([]byte(token)),
+	}
+
+	// Update the key of the existing Secret, and fall back to creating the
+	// Secret if it does not exist
+	status, err := k.patchSecret(data)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusNotFound {
+		status, err = k.createSecret(data)
+		if err != nil {
+			return err
+		}
+		// The Secret was created concurrently
+		if status == http.StatusConflict {
+			if _, err := k.patchSecret(data); err != nil {
+				return err
+			}
+		}
+	}
+
+	k.logger.Info("token written", "namespace", k.namespace, "name", k.name)
+	return nil
+}
+
+func (k *kubernetesSink) patchSecret(data map[string]string) (int, error) {
+	body := map[string]interface{}{
+		"data": data,
+	}
+	path := fmt.Sprintf("/api/v1/namespaces/%s/secrets/%s", url.PathEscape(k.namespace), url.PathEscape(k.name))
+	return k.do(http.MethodPatch, path, "application/merge-patch+json", body, http.StatusNotFound)
+}
+
+func (k *kubernetesSink) createSecret(data map[string]string) (int, error) {
+	body := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"type":       "Opaque",
+		"metadata": map[string]interface{}{
+			"name":      k.name,
+			"namespace": k.namespace,
+		},
+		"data": data,
+	}
+	path := fmt.Sprintf("/api/v1/namespaces/%s/secrets", url.PathEscape(k.namespace))
+	return k.do(http.MethodPost, path, "application/json", body, http.StatusConflict)
+}
+
+// do sends a request to the API server. It returns an error unless the request
+// succeeds, or the response has the allowed status.
+func (k *kubernetesSink) do(method, path, contentType string, body interface{}, allowedStatus int) (int, error) {
+	saToken, err := ioutil.ReadFile(k.tokenPath)
+	if err != nil {
+		return 0, errwrap.Wrapf("error reading service account token: {{err}}", err)
+	}
+
+	reqBody, err := json.Marshal(body)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest(method, k.address+path, bytes.NewReader(reqBody))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(saToken)))
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return 0, errwrap.Wrapf(fmt.Sprintf("error writing secret %s/%s: {{err}}", k.namespace, k.name), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 || resp.StatusCode == allowedStatus {
+		return resp.StatusCode, nil
+	}
+
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, fmt.Errorf("error writing secret %s/%s: unexpected status %d: %s", k.namespace, k.name, resp.StatusCode, strings.TrimSpace(string(respBody)))
+}
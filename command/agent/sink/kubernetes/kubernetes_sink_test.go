@@ -0,0 +1,256 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/command/agent/sink"
+	"github.com/hashicorp/vault/helper/dhutil"
+	"github.com/hashicorp/vault/sdk/helper/jsonutil"
+	"github.com/hashicorp/vault/sdk/helper/logging"
+)
+
+const testServiceAccountToken = "test-sa-token"
+
+// testAPIServer emulates the Secrets API of a Kubernetes API server
+type testAPIServer struct {
+	l       sync.Mutex
+	secrets map[string]map[string]string
+}
+
+func (s *testAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer "+testServiceAccountToken {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var body struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		Data map[string]string `json:"data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/namespaces/"), "/")
+	switch {
+	case r.Method == http.MethodPost && len(parts) == 2 && parts[1] == "secrets":
+		key := parts[0] + "/" + body.Metadata.Name
+		if _, ok := s.secrets[key]; ok {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		s.secrets[key] = body.Data
+		w.WriteHeader(http.StatusCreated)
+
+	case r.Method == http.MethodPatch && len(parts) == 3 && parts[1] == "secrets":
+		if r.Header.Get("Content-Type") != "application/merge-patch+json" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		secret, ok := s.secrets[parts[0]+"/"+parts[2]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		for k, v := range body.Data {
+			secret[k] = v
+		}
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *testAPIServer) value(t *testing.T, name, key string) string {
+	t.Helper()
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	secret, ok := s.secrets[name]
+	if !ok {
+		t.Fatalf("secret %q not found", name)
+	}
+	v, err := base64.StdEncoding.DecodeString(secret[key])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(v)
+}
+
+func testKubernetesSink(t *testing.T, config map[string]interface{}) (*sink.SinkConfig, *testAPIServer, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "vault-agent-kubernetes-sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenPath := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenPath, []byte(testServiceAccountToken+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	server := &testAPIServer{secrets: make(map[string]map[string]string)}
+	ts := httptest.NewServer(server)
+
+	config["address"] = ts.URL
+	config["token_path"] = tokenPath
+	sinkConfig := &sink.SinkConfig{
+		Logger: logging.NewVaultLogger(hclog.Trace).Named("sink.kubernetes"),
+		Config: config,
+	}
+	s, err := NewKubernetesSink(sinkConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sinkConfig.Sink = s
+
+	return sinkConfig, server, func() {
+		ts.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestKubernetesSink(t *testing.T) {
+	config, server, cleanup := testKubernetesSink(t, map[string]interface{}{
+		"namespace": "apps",
+		"name":      "vault-token",
+	})
+	defer cleanup()
+
+	// The secret is created on the first write
+	if err := config.WriteToken("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if v := server.value(t, "apps/vault-token", "token"); v != "foo" {
+		t.Fatalf("expected %q, got %q", "foo", v)
+	}
+
+	// Other keys of the secret are preserved on updates
+	server.secrets["apps/vault-token"]["other"] = base64.StdEncoding.EncodeToString([]byte("bar"))
+	if err := config.WriteToken("baz"); err != nil {
+		t.Fatal(err)
+	}
+	if v := server.value(t, "apps/vault-token", "token"); v != "baz" {
+		t.Fatalf("expected %q, got %q", "baz", v)
+	}
+	if v := server.value(t, "apps/vault-token", "other"); v != "bar" {
+		t.Fatalf("expected %q, got %q", "bar", v)
+	}
+}
+
+func TestKubernetesSink_Config(t *testing.T) {
+	cases := map[string]map[string]interface{}{
+		"no name":          {"namespace": "apps", "address": "http://127.0.0.1"},
+		"bad name":         {"namespace": "apps", "address": "http://127.0.0.1", "name": 1},
+		"no namespace":     {"name": "vault-token", "address": "http://127.0.0.1"},
+		"bad ca_cert":      {"name": "vault-token", "namespace": "apps", "address": "https://127.0.0.1", "ca_cert": "/nonexistent"},
+		"no address":       {"name": "vault-token", "namespace": "apps"},
+		"bad token_path":   {"name": "vault-token", "namespace": "apps", "address": "http://127.0.0.1", "token_path": true},
+		"bad key":          {"name": "vault-token", "namespace": "apps", "address": "http://127.0.0.1", "key": []string{"token"}},
+		"bad address":      {"name": "vault-token", "namespace": "apps", "address": 8443},
+		"bad namespace":    {"name": "vault-token", "namespace": 1, "address": "http://127.0.0.1"},
+		"bad ca_cert type": {"name": "vault-token", "namespace": "apps", "address": "https://127.0.0.1", "ca_cert": 1},
+	}
+
+	os.Unsetenv("KUBERNETES_SERVICE_HOST")
+	for name, config := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewKubernetesSink(&sink.SinkConfig{
+				Logger: logging.NewVaultLogger(hclog.Trace),
+				Config: config,
+			})
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestKubernetesSink_Encrypted(t *testing.T) {
+	config, server, cleanup := testKubernetesSink(t, map[string]interface{}{
+		"namespace": "apps",
+		"name":      "vault-token",
+		"key":       "encrypted",
+	})
+	defer cleanup()
+
+	// Write the public key of the consumer of the token
+	pub, pri, err := dhutil.GeneratePublicPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKeyInfo, err := jsonutil.EncodeJSON(&dhutil.PublicKeyInfo{Curve25519PublicKey: pub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dhPath := filepath.Join(filepath.Dir(config.Config["token_path"].(string)), "dh")
+	if err := ioutil.WriteFile(dhPath, pubKeyInfo, 0600); err != nil {
+		t.Fatal(err)
+	}
+	config.DHType = "curve25519"
+	config.DHPath = dhPath
+	config.AAD = "foobar"
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	ss := sink.NewSinkServer(&sink.SinkServerConfig{
+		Logger: config.Logger.Named("sink.server"),
+	})
+	in := make(chan string)
+	go ss.Run(ctx, in, []*sink.SinkConfig{config})
+
+	in <- "foo"
+
+	// Give it time to finish writing
+	var envelope string
+	for i := 0; i < 20 && envelope == ""; i++ {
+		time.Sleep(100 * time.Millisecond)
+		server.l.Lock()
+		if secret, ok := server.secrets["apps/vault-token"]; ok {
+			envelope = secret["encrypted"]
+		}
+		server.l.Unlock()
+	}
+	if envelope == "" {
+		t.Fatal("token was not written")
+	}
+
+	cancelFunc()
+	<-ss.DoneCh
+
+	resp := new(dhutil.Envelope)
+	if err := jsonutil.DecodeJSON([]byte(server.value(t, "apps/vault-token", "encrypted")), resp); err != nil {
+		t.Fatal(err)
+	}
+	aesKey, err := dhutil.GenerateSharedKey(pri, resp.Curve25519PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := dhutil.DecryptAES(aesKey, resp.EncryptedPayload, resp.Nonce, []byte("foobar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(token) != "foo" {
+		t.Fatalf("expected %q, got %q", "foo", string(token))
+	}
+}
@@ -0,0 +1,391 @@
+package template
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/command/agent/config"
+)
+
+const (
+	// certRetryMinBackoff and certRetryMaxBackoff bound the delay before
+	// issuing a certificate again after a failure
+	certRetryMinBackoff = time.Second
+	certRetryMaxBackoff = 5 * time.Minute
+)
+
+// CertServerConfig is the configuration of the CertServer
+type CertServerConfig struct {
+	Logger        hclog.Logger
+	Client        *api.Client
+	Namespace     string
+	ExitAfterAuth bool
+}
+
+// CertServer issues certificates from PKI secrets engines and writes them to
+// disk. Unlike templates, which issue a new certificate each time they are
+// rendered, a certificate is reused, including across restarts of the agent
+// and changes of the auto-auth token, until the configured fraction of its
+// lifetime has elapsed.
+type CertServer struct {
+	DoneCh chan struct{}
+
+	logger        hclog.Logger
+	client        *api.Client
+	namespace     string
+	exitAfterAuth bool
+
+	// err is the error that stopped the server with exit after auth
+	err error
+}
+
+// pkiCert is the state of a configured certificate
+type pkiCert struct {
+	conf *config.PKICertificate
+
+	// issued is set once the certificate has been written, or an existing
+	// one is reused
+	issued bool
+
+	// renewAt is the time at which the certificate is issued again. It is
+	// zero if the certificate has not been issued yet.
+	renewAt time.Time
+
+	// backoff is the delay before retrying after a failure to issue the
+	// certificate
+	backoff time.Duration
+}
+
+// NewCertServer returns a new configured CertServer
+func NewCertServer(conf *CertServerConfig) *CertServer {
+	return &CertServer{
+		DoneCh:        make(chan struct{}),
+		logger:        conf.Logger,
+		client:        conf.Client,
+		namespace:     conf.Namespace,
+		exitAfterAuth: conf.ExitAfterAuth,
+	}
+}
+
+// Err returns the error that stopped the server with exit after auth, if any.
+// It is only meaningful once DoneCh is closed.
+func (cs *CertServer) Err() error {
+	return cs.err
+}
+
+// Run issues the certificates using the latest token received from the
+// AuthHandler, and issues them again when they are due for renewal, until the
+// context is cancelled. With exit after auth, Run returns once all
+// certificates have been written, or as soon as one fails to be issued.
+func (cs *CertServer) Run(ctx context.Context, incoming chan string, certs []*config.PKICertificate) {
+	cs.logger.Info("starting certificate server")
+	defer func() {
+		cs.logger.Info("certificate server stopped")
+		close(cs.DoneCh)
+	}()
+
+	if incoming == nil {
+		panic("incoming channel is nil")
+	}
+
+	if len(certs) == 0 {
+		cs.logger.Info("no certificates found")
+		return
+	}
+
+	states := make([]*pkiCert, 0, len(certs))
+	for _, conf := range certs {
+		c := &pkiCert{
+			conf: conf,
+		}
+		renewAt, err := existingRenewTime(conf)
+		switch {
+		case err != nil:
+			cs.logger.Debug("not reusing certificate", "path", conf.Path, "destination", conf.CertDestination, "error", err)
+		case time.Now().Before(renewAt):
+			cs.logger.Info("reusing certificate", "path", conf.Path, "destination", conf.CertDestination, "renew_at", renewAt)
+			c.renewAt = renewAt
+			c.issued = true
+		}
+		states = append(states, c)
+	}
+
+	var token string
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		var next time.Time
+		done := true
+		for _, c := range states {
+			if token != "" && !time.Now().Before(c.renewAt) {
+				if err := cs.issue(c, token); err != nil && cs.exitAfterAuth {
+					cs.err = errwrap.Wrapf(fmt.Sprintf("error issuing certificate from %s: {{err}}", c.conf.Path), err)
+					return
+				}
+			}
+			if !c.issued {
+				done = false
+			}
+			if next.IsZero() || c.renewAt.Before(next) {
+				next = c.renewAt
+			}
+		}
+
+		if done && cs.exitAfterAuth {
+			return
+		}
+
+		// Without a token, wait for one before issuing the certificates
+		var timerCh <-chan time.Time
+		if token != "" {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(time.Until(next))
+			timerCh = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case newToken, ok := <-incoming:
+			if !ok {
+				// The auth handler stopped, keep the latest token
+				incoming = nil
+				continue
+			}
+			if newToken != token {
+				cs.logger.Info("certificate server received new token")
+				token = newToken
+			}
+		case <-timerCh:
+		}
+	}
+}
+
+// issue issues the certificate and writes it to disk, scheduling its renewal.
+// On failure, it is retried after a backoff.
+func (cs *CertServer) issue(c *pkiCert, token string) error {
+	err := cs.issueCert(c, token)
+	if err == nil {
+		c.issued = true
+		c.backoff = 0
+		return nil
+	}
+
+	metrics.IncrCounter([]string{"agent", "template", "certificate", "error"}, 1)
+
+	switch {
+	case c.backoff == 0:
+		c.backoff = certRetryMinBackoff
+	case c.backoff < certRetryMaxBackoff:
+		c.backoff *= 2
+		if c.backoff > certRetryMaxBackoff {
+			c.backoff = certRetryMaxBackoff
+		}
+	}
+	cs.logger.Error("error issuing certificate", "path", c.conf.Path, "error", err, "backoff", c.backoff.String())
+	c.renewAt = time.Now().Add(c.backoff)
+	return err
+}
+
+func (cs *CertServer) issueCert(c *pkiCert, token string) error {
+	client, err := cs.client.Clone()
+	if err != nil {
+		return err
+	}
+	client.SetToken(token)
+	if cs.namespace != "" {
+		client.SetNamespace(cs.namespace)
+	}
+
+	secret, err := client.Logical().Write(c.conf.Path, c.conf.Parameters)
+	if err != nil {
+		return err
+	}
+	if secret == nil || secret.Data == nil {
+		return errors.New("no certificate returned")
+	}
+
+	certPEM, _ := secret.Data["certificate"].(string)
+	keyPEM, _ := secret.Data["private_key"].(string)
+	if certPEM == "" || keyPEM == "" {
+		return errors.New("response does not contain a certificate and a private key")
+	}
+	cert, err := parseCertificate([]byte(certPEM))
+	if err != nil {
+		return err
+	}
+
+	files := []*certFile{
+		{path: c.conf.KeyDestination, contents: keyPEM, perms: c.conf.KeyPerms},
+		{path: c.conf.CertDestination, contents: certPEM, perms: c.conf.Perms},
+	}
+	if c.conf.ChainDestination != "" {
+		files = append(files, &certFile{path: c.conf.ChainDestination, contents: caChain(secret.Data), perms: c.conf.Perms})
+	}
+	if err := writeFilesAtomic(files); err != nil {
+		return err
+	}
+
+	c.renewAt = renewTime(cert, c.conf.RenewFraction)
+	if !time.Now().Before(c.renewAt) {
+		// The certificate was issued with a lifetime too short to wait for
+		// the renew fraction of it to elapse
+		c.renewAt = time.Now().Add(certRetryMinBackoff)
+	}
+
+	metrics.IncrCounter([]string{"agent", "template", "certificate", "issued"}, 1)
+	cs.logger.Info("certificate issued", "path", c.conf.Path, "destination", c.conf.CertDestination, "serial_number", secret.Data["serial_number"], "renew_at", c.renewAt)
+	return nil
+}
+
+// caChain returns the chain of the issued certificate, which is ca_chain when
+// the response contains it, and the issuing CA otherwise
+func caChain(data map[string]interface{}) string {
+	var chain []string
+	if raw, ok := data["ca_chain"].([]interface{}); ok {
+		for _, cert := range raw {
+			if s, ok := cert.(string); ok && s != "" {
+				chain = append(chain, s)
+			}
+		}
+	}
+	if len(chain) == 0 {
+		if s, ok := data["issuing_ca"].(string); ok && s != "" {
+			chain = append(chain, s)
+		}
+	}
+	return strings.Join(chain, "\n")
+}
+
+// renewTime returns the time at which the given fraction of the lifetime of
+// the certificate has elapsed
+func renewTime(cert *x509.Certificate, fraction float64) time.Time {
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	return cert.NotBefore.Add(time.Duration(float64(lifetime) * fraction))
+}
+
+// existingRenewTime returns the renewal time of the certificate previously
+// written to disk, returning an error if it cannot be reused
+func existingRenewTime(conf *config.PKICertificate) (time.Time, error) {
+	certPEM, err := ioutil.ReadFile(conf.CertDestination)
+	if err != nil {
+		return time.Time{}, err
+	}
+	cert, err := parseCertificate(certPEM)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// The key and chain must have been written along with the certificate
+	for _, path := range []string{conf.KeyDestination, conf.ChainDestination} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.Size() == 0 {
+			return time.Time{}, fmt.Errorf("%s is empty", path)
+		}
+	}
+
+	// Do not reuse a certificate issued for another common name
+	if cn, ok := conf.Parameters["common_name"].(string); ok && cn != cert.Subject.CommonName {
+		return time.Time{}, fmt.Errorf("certificate was issued for %q", cert.Subject.CommonName)
+	}
+
+	return renewTime(cert, conf.RenewFraction), nil
+}
+
+func parseCertificate(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM encoded certificate found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, errwrap.Wrapf("error parsing certificate: {{err}}", err)
+	}
+	return cert, nil
+}
+
+// certFile is a file written by writeFilesAtomic
+type certFile struct {
+	path     string
+	contents string
+	perms    os.FileMode
+	tmpPath  string
+}
+
+// writeFilesAtomic writes each file to a temporary file in the same directory,
+// and only once all of them have been written, renames them in place. Readers
+// never see a partially written file, and the window during which they may
+// see a certificate not matching the key is kept as short as possible.
+func writeFilesAtomic(files []*certFile) error {
+	defer func() {
+		for _, f := range files {
+			if f.tmpPath != "" {
+				os.Remove(f.tmpPath)
+			}
+		}
+	}()
+
+	for _, f := range files {
+		dir := filepath.Dir(f.path)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return errwrap.Wrapf(fmt.Sprintf("error creating directory %s: {{err}}", dir), err)
+		}
+
+		tmp, err := ioutil.TempFile(dir, "."+filepath.Base(f.path)+".tmp")
+		if err != nil {
+			return errwrap.Wrapf(fmt.Sprintf("error creating temporary file for %s: {{err}}", f.path), err)
+		}
+		f.tmpPath = tmp.Name()
+
+		contents := f.contents
+		if !strings.HasSuffix(contents, "\n") {
+			contents += "\n"
+		}
+		err = tmp.Chmod(f.perms)
+		if err == nil {
+			_, err = tmp.WriteString(contents)
+		}
+		if err == nil {
+			err = tmp.Sync()
+		}
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return errwrap.Wrapf(fmt.Sprintf("error writing %s: {{err}}", f.path), err)
+		}
+	}
+
+	for _, f := range files {
+		if err := os.Rename(f.tmpPath, f.path); err != nil {
+			return errwrap.Wrapf(fmt.Sprintf("error renaming %s: {{err}}", f.path), err)
+		}
+		f.tmpPath = ""
+	}
+
+	return nil
+}
@@ -0,0 +1,196 @@
+package template
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/command/agent/cache"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+)
+
+// pkiCache is the Proxier the templates are rendered through. It reuses the
+// certificates issued by PKI secrets engines until the renew fraction of their
+// lifetime has elapsed. Consul Template writes to the issue endpoint each time
+// it fetches the secret, including each time the runner is restarted with a
+// new token, so every render would otherwise issue a new certificate.
+type pkiCache struct {
+	proxier       cache.Proxier
+	logger        hclog.Logger
+	renewFraction float64
+
+	l       sync.Mutex
+	entries map[string]*pkiCacheEntry
+}
+
+// pkiCacheEntry is a response containing a certificate and its private key
+type pkiCacheEntry struct {
+	header   http.Header
+	secret   map[string]interface{}
+	leaseID  string
+	issuedAt time.Time
+	renewAt  time.Time
+}
+
+func newPKICache(proxier cache.Proxier, logger hclog.Logger, renewFraction float64) *pkiCache {
+	return &pkiCache{
+		proxier:       proxier,
+		logger:        logger,
+		renewFraction: renewFraction,
+		entries:       make(map[string]*pkiCacheEntry),
+	}
+}
+
+// Send returns the cached response of a write that issued a certificate not
+// yet due for renewal, and forwards any other request.
+func (c *pkiCache) Send(ctx context.Context, req *cache.SendRequest) (*cache.SendResponse, error) {
+	method := req.Request.Method
+	if method != http.MethodPut && method != http.MethodPost {
+		return c.proxier.Send(ctx, req)
+	}
+
+	// The certificate is reused with any token, as the runner is restarted
+	// each time the auto-auth token changes
+	key := pkiCacheKey(req)
+
+	c.l.Lock()
+	entry := c.entries[key]
+	c.l.Unlock()
+	if entry != nil && time.Now().Before(entry.renewAt) {
+		c.logger.Debug("reusing certificate", "path", req.Request.URL.Path, "renew_at", entry.renewAt)
+		metrics.IncrCounter([]string{"agent", "template", "certificate", "reused"}, 1)
+		return entry.response(true)
+	}
+
+	resp, err := c.proxier.Send(ctx, req)
+	if err != nil || resp.Response.StatusCode != http.StatusOK {
+		return resp, err
+	}
+
+	entry, err = c.newEntry(req.Request.URL.Path, resp)
+	if err != nil {
+		c.logger.Warn("not reusing certificate", "path", req.Request.URL.Path, "error", err)
+		return resp, nil
+	}
+	if entry == nil {
+		// Not a certificate
+		return resp, nil
+	}
+
+	c.l.Lock()
+	c.entries[key] = entry
+	c.l.Unlock()
+
+	metrics.IncrCounter([]string{"agent", "template", "certificate", "issued"}, 1)
+	c.logger.Info("certificate issued", "path", req.Request.URL.Path, "lease_id", entry.leaseID, "renew_at", entry.renewAt)
+	return entry.response(false)
+}
+
+// newEntry returns the entry of a response containing a certificate and its
+// private key, and nil for other responses
+func (c *pkiCache) newEntry(reqPath string, resp *cache.SendResponse) (*pkiCacheEntry, error) {
+	var secret map[string]interface{}
+	if err := json.Unmarshal(resp.ResponseBody, &secret); err != nil {
+		return nil, nil
+	}
+	data, _ := secret["data"].(map[string]interface{})
+	certPEM, _ := data["certificate"].(string)
+	keyPEM, _ := data["private_key"].(string)
+	if certPEM == "" || keyPEM == "" {
+		return nil, nil
+	}
+
+	cert, err := parseCertificate([]byte(certPEM))
+	if err != nil {
+		return nil, err
+	}
+
+	renewAt := renewTime(cert, c.renewFraction)
+	if !time.Now().Before(renewAt) {
+		return nil, fmt.Errorf("certificate is already due for renewal at %s", renewAt)
+	}
+
+	// Certificates issued without a lease are given one named after their
+	// serial number, like the ones issued with generate_lease
+	leaseID, _ := secret["lease_id"].(string)
+	if leaseID == "" {
+		leaseID = fmt.Sprintf("%s/%v", strings.TrimPrefix(path.Clean(reqPath), "/v1/"), data["serial_number"])
+	}
+
+	return &pkiCacheEntry{
+		header:   resp.Response.Header,
+		secret:   secret,
+		leaseID:  leaseID,
+		issuedAt: time.Now(),
+		renewAt:  renewAt,
+	}, nil
+}
+
+// response returns the response of the entry. Consul Template fetches a
+// certificate again after most of its lifetime has elapsed, measured from the
+// time it fetched it, unless the response has a lease, so the lease of the
+// response is set to expire by the time the certificate is due for renewal.
+func (e *pkiCacheEntry) response(hit bool) (*cache.SendResponse, error) {
+	secret := make(map[string]interface{}, len(e.secret))
+	for k, v := range e.secret {
+		secret[k] = v
+	}
+
+	// Consul Template waits between 85% and 95% of the lease duration
+	// before fetching the certificate again, and falls back to a default
+	// duration if it is zero
+	leaseDuration := math.Ceil(time.Until(e.renewAt).Seconds() / 0.95)
+	if leaseDuration < 1 {
+		leaseDuration = 1
+	}
+	secret["lease_id"] = e.leaseID
+	secret["lease_duration"] = int(leaseDuration)
+
+	body, err := json.Marshal(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make(http.Header, len(e.header))
+	for k, v := range e.header {
+		header[k] = v
+	}
+	header.Del("Content-Length")
+
+	resp, err := cache.NewSendResponse(&api.Response{
+		Response: &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     header,
+			Body:       ioutil.NopCloser(bytes.NewReader(body)),
+		},
+	}, body)
+	if err != nil {
+		return nil, err
+	}
+	if hit {
+		resp.CacheMeta.Hit = true
+		resp.CacheMeta.Age = time.Since(e.issuedAt)
+	}
+	return resp, nil
+}
+
+// pkiCacheKey returns the key of the request: its namespace, path and body
+func pkiCacheKey(req *cache.SendRequest) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00", req.Request.Header.Get(consts.NamespaceHeaderName), path.Clean(req.Request.URL.Path))
+	h.Write(req.RequestBody)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,324 @@
+package template
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/command/agent/config"
+	"github.com/hashicorp/vault/sdk/helper/logging"
+)
+
+// testPKIServer emulates the issue endpoint of a PKI secrets engine, issuing
+// self-signed certificates with the configured lifetime
+type testPKIServer struct {
+	*httptest.Server
+
+	l        sync.Mutex
+	ttl      time.Duration
+	issued   int
+	tokens   []string
+	serial   int64
+	lastCert string
+}
+
+func newTestPKIServer(t *testing.T, ttl time.Duration) *testPKIServer {
+	t.Helper()
+
+	s := &testPKIServer{
+		ttl: ttl,
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.Path != "/v1/pki/issue/web" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		var params map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		cn, _ := params["common_name"].(string)
+
+		s.l.Lock()
+		defer s.l.Unlock()
+
+		s.serial++
+		certPEM, keyPEM := testIssueCert(t, cn, s.serial, s.ttl)
+		s.issued++
+		s.tokens = append(s.tokens, r.Header.Get("X-Vault-Token"))
+		s.lastCert = certPEM
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"certificate":   certPEM,
+				"private_key":   keyPEM,
+				"issuing_ca":    "issuing-ca",
+				"ca_chain":      []string{"issuing-ca", "root-ca"},
+				"serial_number": s.serial,
+			},
+		})
+	}))
+	return s
+}
+
+func (s *testPKIServer) status() (int, []string, string) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.issued, append([]string(nil), s.tokens...), s.lastCert
+}
+
+func testIssueCert(t *testing.T, cn string, serial int64, ttl time.Duration) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    now,
+		NotAfter:     now.Add(ttl),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return string(certPEM), string(keyPEM)
+}
+
+func testCertServer(t *testing.T, address string, exitAfterAuth bool) *CertServer {
+	t.Helper()
+
+	clientConfig := api.DefaultConfig()
+	clientConfig.Address = address
+	client, err := api.NewClient(clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewCertServer(&CertServerConfig{
+		Logger:        logging.NewVaultLogger(hclog.Trace),
+		Client:        client,
+		ExitAfterAuth: exitAfterAuth,
+	})
+}
+
+func testPKICertificate(dir string) *config.PKICertificate {
+	return &config.PKICertificate{
+		Path: "pki/issue/web",
+		Parameters: map[string]interface{}{
+			"common_name": "web.example.com",
+		},
+		RenewFraction:    0.5,
+		CertDestination:  filepath.Join(dir, "certs", "cert.pem"),
+		KeyDestination:   filepath.Join(dir, "certs", "key.pem"),
+		ChainDestination: filepath.Join(dir, "certs", "chain.pem"),
+		Perms:            0644,
+		KeyPerms:         0600,
+	}
+}
+
+func TestCertServer(t *testing.T) {
+	pki := newTestPKIServer(t, 4*time.Second)
+	defer pki.Close()
+
+	dir, err := ioutil.TempDir("", "agent-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cert := testPKICertificate(dir)
+	cs := testCertServer(t, pki.URL, false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		<-cs.DoneCh
+	}()
+
+	incoming := make(chan string, 1)
+	go cs.Run(ctx, incoming, []*config.PKICertificate{cert})
+
+	// Nothing is issued without a token
+	time.Sleep(200 * time.Millisecond)
+	if issued, _, _ := pki.status(); issued != 0 {
+		t.Fatalf("expected no certificate to be issued without a token, got %d", issued)
+	}
+
+	incoming <- "token-1"
+	time.Sleep(500 * time.Millisecond)
+
+	issued, tokens, lastCert := pki.status()
+	if issued != 1 || tokens[0] != "token-1" {
+		t.Fatalf("expected one certificate issued with the token, got %d with %v", issued, tokens)
+	}
+
+	expected := map[string]string{
+		cert.CertDestination:  lastCert,
+		cert.ChainDestination: "issuing-ca\nroot-ca\n",
+	}
+	for path, contents := range expected {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != contents {
+			t.Fatalf("unexpected contents of %s: %q", path, data)
+		}
+	}
+	// The private key keeps its own, stricter, mode
+	modes := map[string]os.FileMode{
+		cert.CertDestination:  0644,
+		cert.ChainDestination: 0644,
+		cert.KeyDestination:   0600,
+	}
+	for path, mode := range modes {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != mode {
+			t.Fatalf("expected mode %o for %s, got %o", mode, path, info.Mode().Perm())
+		}
+	}
+
+	// No temporary file is left behind
+	entries, err := ioutil.ReadDir(filepath.Dir(cert.CertDestination))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 files, got %d", len(entries))
+	}
+
+	// A new token does not cause the certificate to be issued again
+	incoming <- "token-2"
+	time.Sleep(500 * time.Millisecond)
+	if issued, _, _ := pki.status(); issued != 1 {
+		t.Fatalf("expected the certificate to be reused, got %d issued", issued)
+	}
+
+	// The certificate is issued again with the latest token once half of its
+	// lifetime has elapsed
+	time.Sleep(1500 * time.Millisecond)
+	issued, tokens, lastCert = pki.status()
+	if issued != 2 || tokens[1] != "token-2" {
+		t.Fatalf("expected the certificate to be issued again with the new token, got %d with %v", issued, tokens)
+	}
+	data, err := ioutil.ReadFile(cert.CertDestination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != lastCert {
+		t.Fatal("expected the new certificate to be written")
+	}
+}
+
+func TestCertServer_ReuseExisting(t *testing.T) {
+	pki := newTestPKIServer(t, time.Hour)
+	defer pki.Close()
+
+	dir, err := ioutil.TempDir("", "agent-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cert := testPKICertificate(dir)
+
+	// The first run issues the certificate
+	cs := testCertServer(t, pki.URL, true)
+	incoming := make(chan string, 1)
+	incoming <- "token"
+	go cs.Run(context.Background(), incoming, []*config.PKICertificate{cert})
+	select {
+	case <-cs.DoneCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the certificate server to exit after issuing the certificate")
+	}
+	if issued, _, _ := pki.status(); issued != 1 {
+		t.Fatalf("expected one certificate to be issued, got %d", issued)
+	}
+
+	// The next run reuses the certificate on disk
+	cs = testCertServer(t, pki.URL, true)
+	incoming <- "token"
+	go cs.Run(context.Background(), incoming, []*config.PKICertificate{cert})
+	select {
+	case <-cs.DoneCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the certificate server to exit")
+	}
+	if issued, _, _ := pki.status(); issued != 1 {
+		t.Fatalf("expected the certificate to be reused, got %d issued", issued)
+	}
+
+	// A certificate issued for another common name is not reused
+	cert.Parameters["common_name"] = "other.example.com"
+	cs = testCertServer(t, pki.URL, true)
+	go cs.Run(context.Background(), incoming, []*config.PKICertificate{cert})
+	select {
+	case <-cs.DoneCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the certificate server to exit")
+	}
+	if issued, _, _ := pki.status(); issued != 2 {
+		t.Fatalf("expected the certificate to be issued again, got %d issued", issued)
+	}
+}
+
+func TestCertServer_ExitAfterAuthError(t *testing.T) {
+	pki := newTestPKIServer(t, time.Hour)
+	defer pki.Close()
+
+	dir, err := ioutil.TempDir("", "agent-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The server fails to issue the certificate, which must not be counted as
+	// written
+	cert := testPKICertificate(dir)
+	cert.Path = "pki/issue/missing"
+	cs := testCertServer(t, pki.URL, true)
+	incoming := make(chan string, 1)
+	incoming <- "token"
+	go cs.Run(context.Background(), incoming, []*config.PKICertificate{cert})
+	select {
+	case <-cs.DoneCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the certificate server to exit")
+	}
+	if cs.Err() == nil {
+		t.Fatal("expected an error")
+	}
+	if _, err := os.Stat(cert.CertDestination); !os.IsNotExist(err) {
+		t.Fatalf("expected no certificate to be written, got %v", err)
+	}
+}
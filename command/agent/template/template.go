@@ -2,18 +2,29 @@
 // disk. The Server type accepts configuration to communicate to a Vault server
 // and a Vault token for authentication. Internally, the Server creates a Consul
 // Template Runner which manages reading secrets from Vault and rendering
-// templates to disk at configured locations
+// templates to disk at configured locations. The CertServer type issues
+// certificates from PKI secrets engines and writes them to disk, reusing them
+// until they are due for renewal.
 package template
 
 import (
 	"context"
 	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
+	metrics "github.com/armon/go-metrics"
 	ctconfig "github.com/hashicorp/consul-template/config"
 	ctlogging "github.com/hashicorp/consul-template/logging"
 	"github.com/hashicorp/consul-template/manager"
 	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/command/agent/cache"
 	"github.com/hashicorp/vault/command/agent/config"
 	"github.com/hashicorp/vault/sdk/helper/pointerutil"
 )
@@ -22,7 +33,10 @@ import (
 // Server
 type ServerConfig struct {
 	Logger hclog.Logger
-	// Client        *api.Client
+
+	// Client, if set, is the client the templates are rendered through, see
+	// Server.Run. Otherwise, they are rendered against VaultConf's address.
+	Client        *api.Client
 	VaultConf     *config.Vault
 	ExitAfterAuth bool
 
@@ -36,6 +50,11 @@ type ServerConfig struct {
 	// the same io.Writer that Vault Agent itself is using.
 	LogLevel  hclog.Level
 	LogWriter io.Writer
+
+	// PKIRenewFraction is the fraction of the lifetime of a certificate
+	// issued by a template after which it is issued again. It defaults to
+	// config.DefaultPKIRenewFraction.
+	PKIRenewFraction float64
 }
 
 // Server manages the Consul Template Runner which renders templates
@@ -73,7 +92,9 @@ func NewServer(conf *ServerConfig) *Server {
 
 // Run kicks off the internal Consul Template runner, and listens for changes to
 // the token from the AuthHandler. If Done() is called on the context, shut down
-// the Runner and return
+// the Runner and return. With a client, the runner's requests are sent through
+// it by a proxy, which reuses the certificates issued by PKI secrets engines
+// until they are due for renewal.
 func (ts *Server) Run(ctx context.Context, incoming chan string, templates []*ctconfig.TemplateConfig) {
 	latestToken := new(string)
 	ts.logger.Info("starting template server")
@@ -97,11 +118,29 @@ func (ts *Server) Run(ctx context.Context, incoming chan string, templates []*ct
 	// configuration
 	var runnerConfig *ctconfig.Config
 	var runnerConfigErr error
-	if runnerConfig, runnerConfigErr = newRunnerConfig(ts.config, templates); runnerConfigErr != nil {
+	if runnerConfig, runnerConfigErr = NewRunnerConfig(ts.config, templates); runnerConfigErr != nil {
 		ts.logger.Error("template server failed to generate runner config", "error", runnerConfigErr)
 		return
 	}
 
+	if ts.config.Client != nil {
+		address, closeProxy, err := ts.startProxy(ctx)
+		if err != nil {
+			ts.logger.Error("template server failed to start proxy", "error", err)
+			return
+		}
+		defer closeProxy()
+
+		runnerConfig = runnerConfig.Merge(&ctconfig.Config{
+			Vault: &ctconfig.VaultConfig{
+				Address: pointerutil.StringPtr(address),
+				SSL: &ctconfig.SSLConfig{
+					Enabled: pointerutil.BoolPtr(false),
+				},
+			},
+		})
+	}
+
 	var err error
 	ts.runner, err = manager.NewRunner(runnerConfig, false)
 	if err != nil {
@@ -153,10 +192,12 @@ func (ts *Server) Run(ctx context.Context, incoming chan string, templates []*ct
 			}
 		case err := <-ts.runner.ErrCh:
 			ts.logger.Error("template server error", "error", err.Error())
+			metrics.IncrCounter([]string{"agent", "template", "error"}, 1)
 			return
 		case <-ts.runner.TemplateRenderedCh():
 			// A template has been rendered, figure out what to do
 			events := ts.runner.RenderEvents()
+			metrics.IncrCounter([]string{"agent", "template", "rendered"}, 1)
 
 			// events are keyed by template ID, and can be matched up to the id's from
 			// the lookupMap
@@ -185,9 +226,53 @@ func (ts *Server) Run(ctx context.Context, incoming chan string, templates []*ct
 	}
 }
 
-// newRunnerConfig returns a consul-template runner configuration, setting the
+// startProxy serves the runner's requests on a unix socket in a new private
+// directory, returning its address and a function stopping it
+func (ts *Server) startProxy(ctx context.Context) (string, func(), error) {
+	dir, err := ioutil.TempDir("", "vault-agent-template")
+	if err != nil {
+		return "", nil, err
+	}
+	socketPath := filepath.Join(dir, "vault.sock")
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", nil, err
+	}
+
+	proxyLogger := ts.logger.Named("proxy")
+	apiProxy, err := cache.NewAPIProxy(&cache.APIProxyConfig{
+		Client: ts.config.Client,
+		Logger: proxyLogger,
+	})
+	if err != nil {
+		ln.Close()
+		os.RemoveAll(dir)
+		return "", nil, err
+	}
+
+	renewFraction := ts.config.PKIRenewFraction
+	if renewFraction == 0 {
+		renewFraction = config.DefaultPKIRenewFraction
+	}
+	proxier := newPKICache(apiProxy, proxyLogger, renewFraction)
+
+	server := &http.Server{
+		Handler:           cache.Handler(ctx, proxyLogger, proxier, nil, nil),
+		ReadHeaderTimeout: 10 * time.Second,
+		ErrorLog:          proxyLogger.StandardLogger(nil),
+	}
+	go server.Serve(ln)
+
+	return "unix://" + socketPath, func() {
+		server.Close()
+		os.RemoveAll(dir)
+	}, nil
+}
+
+// NewRunnerConfig returns a consul-template runner configuration, setting the
 // Vault and Consul configurations based on the clients configs.
-func newRunnerConfig(sc *ServerConfig, templates ctconfig.TemplateConfigs) (*ctconfig.Config, error) {
+func NewRunnerConfig(sc *ServerConfig, templates ctconfig.TemplateConfigs) (*ctconfig.Config, error) {
 	conf := ctconfig.DefaultConfig()
 	conf.Templates = templates.Copy()
 
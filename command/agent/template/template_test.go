@@ -2,16 +2,20 @@ package template
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	ctconfig "github.com/hashicorp/consul-template/config"
 	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/api"
 	"github.com/hashicorp/vault/command/agent/config"
 	"github.com/hashicorp/vault/sdk/helper/logging"
 	"github.com/hashicorp/vault/sdk/helper/pointerutil"
@@ -162,6 +166,97 @@ func TestServerRun(t *testing.T) {
 	}
 }
 
+func TestServerRun_PKI(t *testing.T) {
+	pki := newTestPKIServer(t, 6*time.Second)
+	defer pki.Close()
+
+	tmpDir, err := ioutil.TempDir("", "agent-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	certFile := filepath.Join(tmpDir, "cert.pem")
+	keyFile := filepath.Join(tmpDir, "key.pem")
+
+	clientConfig := api.DefaultConfig()
+	clientConfig.Address = pki.URL
+	client, err := api.NewClient(clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewServer(&ServerConfig{
+		Logger:           logging.NewVaultLogger(hclog.Trace),
+		Client:           client,
+		VaultConf:        &config.Vault{},
+		LogLevel:         hclog.Trace,
+		LogWriter:        hclog.DefaultOutput,
+		PKIRenewFraction: 0.5,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		<-server.DoneCh
+	}()
+	templateTokenCh := make(chan string, 1)
+	go server.Run(ctx, templateTokenCh, []*ctconfig.TemplateConfig{
+		{
+			Contents:    pointerutil.StringPtr(`{{ with secret "pki/issue/web" "common_name=web.example.com" }}{{ .Data.certificate }}{{ end }}`),
+			Destination: pointerutil.StringPtr(certFile),
+		},
+		{
+			Contents:    pointerutil.StringPtr(`{{ with secret "pki/issue/web" "common_name=web.example.com" }}{{ .Data.private_key }}{{ end }}`),
+			Destination: pointerutil.StringPtr(keyFile),
+		},
+	})
+
+	// waitForCert waits for the given certificate to be rendered along with
+	// its private key
+	waitForCert := func(expected string) {
+		t.Helper()
+		var certPEM, keyPEM []byte
+		for i := 0; i < 100; i++ {
+			certPEM, _ = ioutil.ReadFile(certFile)
+			keyPEM, _ = ioutil.ReadFile(keyFile)
+			if string(certPEM) == expected {
+				if _, err := tls.X509KeyPair(certPEM, keyPEM); err == nil {
+					return
+				}
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatalf("expected the certificate to be rendered with its key, got %q", certPEM)
+	}
+
+	templateTokenCh <- "token-1"
+	time.Sleep(500 * time.Millisecond)
+	issued, _, lastCert := pki.status()
+	if issued != 1 {
+		t.Fatalf("expected one certificate to be issued, got %d", issued)
+	}
+	waitForCert(lastCert)
+
+	// The runner is restarted with a new token, and renders the same
+	// certificate
+	templateTokenCh <- "token-2"
+	time.Sleep(500 * time.Millisecond)
+	if issued, _, _ := pki.status(); issued != 1 {
+		t.Fatalf("expected the certificate to be reused, got %d issued", issued)
+	}
+	waitForCert(lastCert)
+
+	// The certificate is issued again once half of its lifetime has elapsed
+	for i := 0; i < 100 && issued < 2; i++ {
+		time.Sleep(50 * time.Millisecond)
+		issued, _, lastCert = pki.status()
+	}
+	if issued != 2 {
+		t.Fatalf("expected the certificate to be issued again, got %d issued", issued)
+	}
+	waitForCert(lastCert)
+}
+
 func handleRequest(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintln(w, jsonResponse)
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"reflect"
 	"strings"
@@ -18,6 +19,8 @@ import (
 	"github.com/hashicorp/vault/api"
 	credAppRole "github.com/hashicorp/vault/builtin/credential/approle"
 	"github.com/hashicorp/vault/command/agent"
+	"github.com/hashicorp/vault/command/agent/auth"
+	"github.com/hashicorp/vault/command/server"
 	vaulthttp "github.com/hashicorp/vault/http"
 	"github.com/hashicorp/vault/sdk/helper/consts"
 	"github.com/hashicorp/vault/sdk/helper/logging"
@@ -1167,6 +1170,103 @@ func request(t *testing.T, client *api.Client, req *api.Request, expectedStatusC
 	return body
 }
 
+func TestAgent_Metrics(t *testing.T) {
+	metricsHelper, err := setupTelemetry(cli.NewMockUi(), &server.Telemetry{
+		PrometheusRetentionTime: time.Minute,
+		DisableHostname:         true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := handleAgentMetrics(metricsHelper)
+
+	cases := map[string]struct {
+		url         string
+		accept      string
+		code        int
+		contentType string
+	}{
+		"generic":           {consts.AgentPathMetrics, "", http.StatusOK, "application/json"},
+		"prometheus query":  {consts.AgentPathMetrics + "?format=prometheus", "", http.StatusOK, "text/plain"},
+		"prometheus header": {consts.AgentPathMetrics, "application/openmetrics-text", http.StatusOK, "text/plain"},
+		"unknown format":    {consts.AgentPathMetrics + "?format=foo", "", http.StatusBadRequest, "text/plain"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.url, nil)
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tc.code {
+				t.Fatalf("expected status %d, got %d: %s", tc.code, rec.Code, rec.Body.String())
+			}
+			if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, tc.contentType) {
+				t.Fatalf("expected content type %q, got %q", tc.contentType, ct)
+			}
+		})
+	}
+
+	// Without a telemetry stanza, no metrics are collected
+	rec := httptest.NewRecorder()
+	handleAgentMetrics(nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, consts.AgentPathMetrics, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404 without telemetry, got %d", rec.Code)
+	}
+}
+
+func TestAgent_Health(t *testing.T) {
+	get := func(t *testing.T, handler http.Handler, url string) (int, *AgentHealthResponse) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+
+		var body AgentHealthResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code, &body
+	}
+
+	t.Run("no auto-auth", func(t *testing.T) {
+		code, body := get(t, handleAgentHealth(nil), consts.AgentPathHealth)
+		if code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", code)
+		}
+		if body.AutoAuth != nil {
+			t.Fatalf("expected no auto-auth status, got %#v", body.AutoAuth)
+		}
+	})
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		ah := auth.NewAuthHandler(&auth.AuthHandlerConfig{
+			Logger: logging.NewVaultLogger(hclog.Trace),
+		})
+		handler := handleAgentHealth(ah)
+
+		code, body := get(t, handler, consts.AgentPathHealth)
+		if code != http.StatusServiceUnavailable {
+			t.Fatalf("expected status 503, got %d", code)
+		}
+		if body.AutoAuth == nil || body.AutoAuth.Authenticated {
+			t.Fatalf("bad: %#v", body.AutoAuth)
+		}
+
+		code, _ = get(t, handler, consts.AgentPathHealth+"?unauthenticatedcode=200")
+		if code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", code)
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, consts.AgentPathHealth+"?unauthenticatedcode=foo", nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400, got %d", rec.Code)
+		}
+	})
+}
+
 // makeTempFile creates a temp file and populates it.
 func makeTempFile(t *testing.T, name, contents string) string {
 	t.Helper()
@@ -188,6 +188,13 @@ func TestAuditEnableCommand_Run(t *testing.T) {
 			switch b {
 			case "file":
 				args = append(args, "file_path=discard")
+			case "http":
+				dir, err := ioutil.TempDir("", "vault-test_audit_http-spool")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer os.RemoveAll(dir)
+				args = append(args, "url=http://127.0.0.1:8888", "spool_dir="+dir)
 			case "socket":
 				args = append(args, "address=127.0.0.1:8888")
 			case "syslog":
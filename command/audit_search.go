@@ -0,0 +1,480 @@
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/audit"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/mitchellh/cli"
+	"github.com/posener/complete"
+	"github.com/ryanuber/columnize"
+)
+
+var _ cli.Command = (*AuditSearchCommand)(nil)
+var _ cli.CommandAutocomplete = (*AuditSearchCommand)(nil)
+
+type AuditSearchCommand struct {
+	*BaseCommand
+
+	flagDevice        string
+	flagSince         string
+	flagUntil         string
+	flagPath          string
+	flagAccessor      string
+	flagClientToken   string
+	flagEntityID      string
+	flagRemoteAddress string
+	flagValues        []string
+
+	testStdin io.Reader // for tests
+}
+
+func (c *AuditSearchCommand) Synopsis() string {
+	return "Searches an audit log"
+}
+
+func (c *AuditSearchCommand) Help() string {
+	helpText := `
+Usage: vault audit search [options] FILE
+
+  Searches a log written by an audit device in the "json" format, printing the
+  entries matching all the given options. If the file is "-", the log is read
+  from stdin.
+
+  The plaintext values of the -accessor, -client-token and -value options are
+  hashed with the salt of the audit device enabled at the -device path, which
+  requires sudo capability on "sys/audit-hash/PATH". Without -device, they are
+  matched as given, e.g. in logs written with "log_raw" or with values hashed
+  previously.
+
+  Search the requests made to sys/ paths in the last hour:
+
+      $ vault audit search -since=1h -path="sys/*" /var/log/vault_audit.log
+
+  Search the entries of a token:
+
+      $ vault audit search -device=file/ -client-token=s.abc... \
+          /var/log/vault_audit.log
+
+  Print the matching entries as they are in the log:
+
+      $ vault audit search -format=json -entity-id=... /var/log/vault_audit.log
+
+` + c.Flags().Help()
+
+	return strings.TrimSpace(helpText)
+}
+
+func (c *AuditSearchCommand) Flags() *FlagSets {
+	set := c.flagSet(FlagSetHTTP | FlagSetOutputFormat)
+
+	f := set.NewFlagSet("Command Options")
+
+	f.StringVar(&StringVar{
+		Name:    "device",
+		Target:  &c.flagDevice,
+		Default: "",
+		EnvVar:  "",
+		Usage: "Path of the audit device which wrote the log, whose salt hashes " +
+			"the plaintext values.",
+	})
+
+	f.StringVar(&StringVar{
+		Name:    "since",
+		Target:  &c.flagSince,
+		Default: "",
+		EnvVar:  "",
+		Usage: "Only match entries written at or after this time, given in " +
+			"RFC3339 format or as a duration before now such as \"30m\".",
+	})
+
+	f.StringVar(&StringVar{
+		Name:    "until",
+		Target:  &c.flagUntil,
+		Default: "",
+		EnvVar:  "",
+		Usage: "Only match entries written before this time, given in RFC3339 " +
+			"format or as a duration before now.",
+	})
+
+	f.StringVar(&StringVar{
+		Name:    "path",
+		Target:  &c.flagPath,
+		Default: "",
+		EnvVar:  "",
+		Usage: "Only match entries whose request path matches this pattern, in " +
+			"which \"*\" matches any sequence of characters.",
+	})
+
+	f.StringVar(&StringVar{
+		Name:    "accessor",
+		Target:  &c.flagAccessor,
+		Default: "",
+		EnvVar:  "",
+		Usage:   "Only match entries of the token with this accessor.",
+	})
+
+	f.StringVar(&StringVar{
+		Name:    "client-token",
+		Target:  &c.flagClientToken,
+		Default: "",
+		EnvVar:  "",
+		Usage:   "Only match entries of this token.",
+	})
+
+	f.StringVar(&StringVar{
+		Name:    "entity-id",
+		Target:  &c.flagEntityID,
+		Default: "",
+		EnvVar:  "",
+		Usage:   "Only match entries of the entity with this ID.",
+	})
+
+	f.StringVar(&StringVar{
+		Name:    "remote-address",
+		Target:  &c.flagRemoteAddress,
+		Default: "",
+		EnvVar:  "",
+		Usage:   "Only match entries of requests made from this address.",
+	})
+
+	f.StringSliceVar(&StringSliceVar{
+		Name:    "value",
+		Target:  &c.flagValues,
+		Default: nil,
+		EnvVar:  "",
+		Usage: "Only match entries containing this value in any field. This " +
+			"can be specified multiple times, in which case the entries " +
+			"containing any of the values match.",
+	})
+
+	return set
+}
+
+func (c *AuditSearchCommand) AutocompleteArgs() complete.Predictor {
+	return complete.PredictFiles("*")
+}
+
+func (c *AuditSearchCommand) AutocompleteFlags() complete.Flags {
+	return c.Flags().Completions()
+}
+
+func (c *AuditSearchCommand) Run(args []string) int {
+	f := c.Flags()
+
+	if err := f.Parse(args); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	args = f.Args()
+	switch {
+	case len(args) < 1:
+		c.UI.Error(fmt.Sprintf("Not enough arguments (expected 1, got %d)", len(args)))
+		return 1
+	case len(args) > 1:
+		c.UI.Error(fmt.Sprintf("Too many arguments (expected 1, got %d)", len(args)))
+		return 1
+	}
+
+	m, err := c.matcher(time.Now())
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	if c.flagDevice != "" {
+		if code := c.hashValues(m); code != 0 {
+			return code
+		}
+	}
+
+	var r io.Reader
+	if args[0] == "-" {
+		r = os.Stdin
+		if c.testStdin != nil {
+			r = c.testStdin
+		}
+	} else {
+		f, err := os.Open(args[0])
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error opening audit log: %s", err))
+			return 1
+		}
+		defer f.Close()
+		r = f
+	}
+
+	format := strings.ToLower(c.flagFormat)
+
+	// Errors and paths may contain "|", which is the default delimiter
+	columns := []string{strings.Join([]string{"Time", "Type", "Operation", "Path", "Remote Address", "Display Name", "Error"}, hopeDelim)}
+	var entries []*audit.AuditResponseEntry
+	skipped := 0
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+
+		// Skip the prefix of the entry, if any
+		i := bytes.IndexByte(line, '{')
+		if i < 0 {
+			if len(bytes.TrimSpace(line)) > 0 {
+				skipped++
+			}
+			continue
+		}
+		line = line[i:]
+
+		entry := new(audit.AuditResponseEntry)
+		if err := json.Unmarshal(line, entry); err != nil {
+			skipped++
+			continue
+		}
+		if !m.match(entry, line) {
+			continue
+		}
+
+		switch format {
+		case "json":
+			// Matching entries are printed as they are found
+			c.UI.Output(string(line))
+		case "table":
+			columns = append(columns, auditSearchRow(entry))
+		default:
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		c.UI.Error(fmt.Sprintf("Error reading audit log: %s", err))
+		return 1
+	}
+
+	if skipped > 0 {
+		c.UI.Warn(fmt.Sprintf("Skipped %d lines which are not JSON audit entries", skipped))
+	}
+
+	switch format {
+	case "json":
+		return 0
+	case "table":
+		if len(columns) == 1 {
+			c.UI.Output("No matching audit log entries.")
+			return 2
+		}
+		c.UI.Output(tableOutput(columns, &columnize.Config{Delim: hopeDelim}))
+		return 0
+	default:
+		return OutputData(c.UI, entries)
+	}
+}
+
+// matcher returns the matcher of the options, in which the plaintext values
+// are not hashed yet
+func (c *AuditSearchCommand) matcher(now time.Time) (*auditSearchMatcher, error) {
+	m := &auditSearchMatcher{
+		entityID:      c.flagEntityID,
+		remoteAddress: c.flagRemoteAddress,
+	}
+
+	var err error
+	if c.flagSince != "" {
+		if m.since, err = parseAuditSearchTime(c.flagSince, now); err != nil {
+			return nil, fmt.Errorf("Invalid -since: %s", err)
+		}
+	}
+	if c.flagUntil != "" {
+		if m.until, err = parseAuditSearchTime(c.flagUntil, now); err != nil {
+			return nil, fmt.Errorf("Invalid -until: %s", err)
+		}
+	}
+
+	if c.flagPath != "" {
+		pattern := strings.Replace(regexp.QuoteMeta(c.flagPath), `\*`, ".*", -1)
+		m.path = regexp.MustCompile("^" + pattern + "$")
+	}
+
+	if c.flagAccessor != "" {
+		m.accessors = []string{c.flagAccessor}
+	}
+	if c.flagClientToken != "" {
+		m.clientTokens = []string{c.flagClientToken}
+	}
+	for _, v := range c.flagValues {
+		if v != "" {
+			m.values = append(m.values, []byte(v))
+		}
+	}
+
+	return m, nil
+}
+
+// hashValues adds the hashes of the plaintext values to the matcher, reading
+// them once before the log is searched. As the accessors are not hashed by
+// devices with hmac_accessor disabled, entries holding either the plaintext
+// or the hashed values match.
+func (c *AuditSearchCommand) hashValues(m *auditSearchMatcher) int {
+	var plaintexts []string
+	plaintexts = append(plaintexts, m.accessors...)
+	plaintexts = append(plaintexts, m.clientTokens...)
+	for _, v := range m.values {
+		plaintexts = append(plaintexts, string(v))
+	}
+	if len(plaintexts) == 0 {
+		return 0
+	}
+
+	client, err := c.Client()
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 2
+	}
+
+	device := ensureTrailingSlash(sanitizePath(c.flagDevice))
+	hashes := make(map[string]string, len(plaintexts))
+	for _, plaintext := range plaintexts {
+		if _, ok := hashes[plaintext]; ok {
+			continue
+		}
+		hash, err := client.Sys().AuditHash(device, plaintext)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error hashing values: %s", err))
+			return 2
+		}
+		hashes[plaintext] = hash
+	}
+
+	if c.flagAccessor != "" {
+		m.accessors = append(m.accessors, hashes[c.flagAccessor])
+	}
+	if c.flagClientToken != "" {
+		m.clientTokens = append(m.clientTokens, hashes[c.flagClientToken])
+	}
+	for _, v := range c.flagValues {
+		if v != "" {
+			m.values = append(m.values, []byte(hashes[v]))
+		}
+	}
+	return 0
+}
+
+// auditSearchMatcher selects the entries matching all the options
+type auditSearchMatcher struct {
+	since         time.Time
+	until         time.Time
+	path          *regexp.Regexp
+	accessors     []string
+	clientTokens  []string
+	entityID      string
+	remoteAddress string
+	values        [][]byte
+}
+
+func (m *auditSearchMatcher) match(entry *audit.AuditResponseEntry, line []byte) bool {
+	auth := entry.Auth
+	if auth == nil {
+		auth = &audit.AuditAuth{}
+	}
+	req := entry.Request
+	if req == nil {
+		req = &audit.AuditRequest{}
+	}
+
+	if !m.since.IsZero() || !m.until.IsZero() {
+		t, err := time.Parse(time.RFC3339Nano, entry.Time)
+		if err != nil {
+			return false
+		}
+		if !m.since.IsZero() && t.Before(m.since) {
+			return false
+		}
+		if !m.until.IsZero() && !t.Before(m.until) {
+			return false
+		}
+	}
+
+	if m.path != nil && !m.path.MatchString(req.Path) {
+		return false
+	}
+	if m.accessors != nil && !auditSearchContains(m.accessors, auth.Accessor, req.ClientTokenAccessor) {
+		return false
+	}
+	if m.clientTokens != nil && !auditSearchContains(m.clientTokens, auth.ClientToken, req.ClientToken) {
+		return false
+	}
+	if m.entityID != "" && auth.EntityID != m.entityID {
+		return false
+	}
+	if m.remoteAddress != "" && req.RemoteAddr != m.remoteAddress {
+		return false
+	}
+
+	if m.values != nil {
+		found := false
+		for _, v := range m.values {
+			if bytes.Contains(line, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// auditSearchContains reports whether one of the non-empty candidates is in
+// the list
+func auditSearchContains(list []string, candidates ...string) bool {
+	for _, candidate := range candidates {
+		if candidate != "" && strutil.StrListContains(list, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAuditSearchTime parses a time in RFC3339 format, or a duration before
+// now
+func parseAuditSearchTime(raw string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(raw); err == nil {
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither an RFC3339 time nor a duration", raw)
+	}
+	return t, nil
+}
+
+func auditSearchRow(entry *audit.AuditResponseEntry) string {
+	auth := entry.Auth
+	if auth == nil {
+		auth = &audit.AuditAuth{}
+	}
+	req := entry.Request
+	if req == nil {
+		req = &audit.AuditRequest{}
+	}
+
+	return strings.Join([]string{
+		entry.Time,
+		entry.Type,
+		string(req.Operation),
+		req.Path,
+		req.RemoteAddr,
+		auth.DisplayName,
+		entry.Error,
+	}, hopeDelim)
+}
@@ -0,0 +1,236 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/mitchellh/cli"
+)
+
+func testAuditSearchCommand(tb testing.TB) (*cli.MockUi, *AuditSearchCommand) {
+	tb.Helper()
+
+	ui := cli.NewMockUi()
+	return ui, &AuditSearchCommand{
+		BaseCommand: &BaseCommand{
+			UI: ui,
+		},
+	}
+}
+
+const testAuditSearchLog = `{"time":"2020-04-01T10:00:00Z","type":"request","auth":{"client_token":"hmac-sha256:aaa","accessor":"hmac-sha256:bbb","display_name":"token","entity_id":"entity-1"},"request":{"operation":"read","path":"sys/mounts","remote_address":"10.0.0.1"}}
+not an entry
+@prefix {"time":"2020-04-01T11:00:00Z","type":"request","auth":{"client_token":"hmac-sha256:ccc","display_name":"userpass-bob","entity_id":"entity-2"},"request":{"operation":"update","path":"secret/foo","remote_address":"10.0.0.2"},"error":"permission denied"}
+{"time":"2020-04-01T12:00:00Z","type":"response","auth":{"client_token":"hmac-sha256:aaa","accessor":"hmac-sha256:bbb","display_name":"token","entity_id":"entity-1"},"request":{"operation":"read","path":"secret/bar","remote_address":"10.0.0.1"}}
+`
+
+func TestAuditSearchCommand_Run(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		args []string
+		out  string
+		code int
+	}{
+		{
+			"not_enough_args",
+			[]string{},
+			"Not enough arguments",
+			1,
+		},
+		{
+			"too_many_args",
+			[]string{"foo", "bar"},
+			"Too many arguments",
+			1,
+		},
+		{
+			"invalid_since",
+			[]string{"-since=yesterday", "-"},
+			"Invalid -since",
+			1,
+		},
+		{
+			"no_file",
+			[]string{"does-not-exist.log"},
+			"Error opening audit log",
+			1,
+		},
+		{
+			"no_match",
+			[]string{"-path=auth/*", "-"},
+			"No matching audit log entries.",
+			2,
+		},
+		{
+			"skipped",
+			[]string{"-"},
+			"Skipped 1 lines",
+			0,
+		},
+		{
+			"path",
+			[]string{"-path=secret/*", "-"},
+			"secret/bar",
+			0,
+		},
+		{
+			"error",
+			[]string{"-remote-address=10.0.0.2", "-"},
+			"permission denied",
+			0,
+		},
+		{
+			"time",
+			[]string{"-since=2020-04-01T10:30:00Z", "-until=2020-04-01T12:00:00Z", "-"},
+			"userpass-bob",
+			0,
+		},
+		{
+			"hashed_value",
+			[]string{"-accessor=hmac-sha256:bbb", "-entity-id=entity-1", "-path=sys/*", "-"},
+			"sys/mounts",
+			0,
+		},
+		{
+			"json",
+			[]string{"-format=json", "-client-token=hmac-sha256:ccc", "-"},
+			`"display_name":"userpass-bob"`,
+			0,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ui, cmd := testAuditSearchCommand(t)
+			cmd.testStdin = strings.NewReader(testAuditSearchLog)
+
+			code := cmd.Run(tc.args)
+			if code != tc.code {
+				t.Errorf("expected %d to be %d", code, tc.code)
+			}
+
+			combined := ui.OutputWriter.String() + ui.ErrorWriter.String()
+			if !strings.Contains(combined, tc.out) {
+				t.Errorf("expected %q to contain %q", combined, tc.out)
+			}
+		})
+	}
+
+	t.Run("matches", func(t *testing.T) {
+		t.Parallel()
+
+		ui, cmd := testAuditSearchCommand(t)
+		cmd.testStdin = strings.NewReader(testAuditSearchLog)
+
+		code := cmd.Run([]string{"-format=json", "-value=hmac-sha256:aaa", "-"})
+		if exp := 0; code != exp {
+			t.Fatalf("expected %d to be %d", code, exp)
+		}
+
+		lines := strings.Split(strings.TrimSpace(ui.OutputWriter.String()), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("expected 2 entries, got %q", lines)
+		}
+		if !strings.Contains(lines[0], "sys/mounts") || !strings.Contains(lines[1], "secret/bar") {
+			t.Fatalf("bad entries: %q", lines)
+		}
+	})
+
+	t.Run("integration", func(t *testing.T) {
+		t.Parallel()
+
+		dir, err := ioutil.TempDir("", "vault-audit-search")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		logPath := filepath.Join(dir, "audit.log")
+
+		client, closer := testVaultServer(t)
+		defer closer()
+
+		if err := client.Sys().EnableAuditWithOptions("file", &api.EnableAuditOptions{
+			Type: "file",
+			Options: map[string]string{
+				"file_path": logPath,
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		secret, err := client.Auth().Token().Create(&api.TokenCreateRequest{
+			Policies: []string{"default"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokenClient, err := client.Clone()
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokenClient.SetToken(secret.Auth.ClientToken)
+		if _, err := tokenClient.Auth().Token().LookupSelf(); err != nil {
+			t.Fatal(err)
+		}
+
+		ui, cmd := testAuditSearchCommand(t)
+		cmd.client = client
+
+		code := cmd.Run([]string{
+			"-device=file/",
+			"-client-token=" + secret.Auth.ClientToken,
+			"-accessor=" + secret.Auth.Accessor,
+			logPath,
+		})
+		if exp := 0; code != exp {
+			t.Fatalf("expected %d to be %d: %s", code, exp, ui.ErrorWriter.String())
+		}
+
+		// The request and response of the lookup match, not the creation
+		out := ui.OutputWriter.String()
+		if !strings.Contains(out, "auth/token/lookup-self") || strings.Contains(out, "auth/token/create") {
+			t.Fatalf("bad output: %s", out)
+		}
+		if n := strings.Count(out, "auth/token/lookup-self"); n != 2 {
+			t.Fatalf("expected 2 entries, got %d: %s", n, out)
+		}
+	})
+
+	t.Run("communication_failure", func(t *testing.T) {
+		t.Parallel()
+
+		client, closer := testVaultServerBad(t)
+		defer closer()
+
+		ui, cmd := testAuditSearchCommand(t)
+		cmd.client = client
+
+		code := cmd.Run([]string{"-device=file/", "-value=foo", "audit.log"})
+		if exp := 2; code != exp {
+			t.Errorf("expected %d to be %d", code, exp)
+		}
+
+		expected := "Error hashing values: "
+		combined := ui.OutputWriter.String() + ui.ErrorWriter.String()
+		if !strings.Contains(combined, expected) {
+			t.Errorf("expected %q to contain %q", combined, expected)
+		}
+	})
+
+	t.Run("no_tabs", func(t *testing.T) {
+		t.Parallel()
+
+		_, cmd := testAuditSearchCommand(t)
+		assertNoTabs(t, cmd)
+	})
+}
@@ -0,0 +1,160 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/vault/audit"
+	"github.com/mitchellh/cli"
+	"github.com/posener/complete"
+)
+
+var _ cli.Command = (*AuditVerifyCommand)(nil)
+var _ cli.CommandAutocomplete = (*AuditVerifyCommand)(nil)
+
+type AuditVerifyCommand struct {
+	*BaseCommand
+
+	flagKey string
+
+	testStdin io.Reader // for tests
+}
+
+func (c *AuditVerifyCommand) Synopsis() string {
+	return "Verifies the hash chain of an audit log"
+}
+
+func (c *AuditVerifyCommand) Help() string {
+	helpText := `
+Usage: vault audit verify [options] PATH FILE
+
+  Verifies the hash chain of a log written by an audit device with the
+  "hash_chain" option enabled. Entries which were deleted, inserted, modified
+  or reordered are reported, as is a new chain starting in the middle of the
+  log. Entries missing from the end of the log can't be detected until the
+  device writes another entry.
+
+  The key of the chain is read from the audit device enabled at PATH, which
+  requires sudo capability on "sys/audit-hash-chain-key/PATH". If the file is
+  "-", the log is read from stdin.
+
+  Verify the log of the audit device enabled at "file/":
+
+      $ vault audit verify file/ /var/log/vault_audit.log
+
+  Verify a log with a key read previously, without connecting to Vault:
+
+      $ vault audit verify -key=... /var/log/vault_audit.log
+
+  The exit code is 2 if problems are found.
+
+` + c.Flags().Help()
+
+	return strings.TrimSpace(helpText)
+}
+
+func (c *AuditVerifyCommand) Flags() *FlagSets {
+	set := c.flagSet(FlagSetHTTP)
+
+	f := set.NewFlagSet("Command Options")
+
+	f.StringVar(&StringVar{
+		Name:    "key",
+		Target:  &c.flagKey,
+		Default: "",
+		EnvVar:  "",
+		Usage: "Key of the hash chain, as returned by the " +
+			"sys/audit-hash-chain-key endpoint. If set, PATH must be omitted " +
+			"and Vault is not contacted.",
+	})
+
+	return set
+}
+
+func (c *AuditVerifyCommand) AutocompleteArgs() complete.Predictor {
+	return c.PredictVaultAudits()
+}
+
+func (c *AuditVerifyCommand) AutocompleteFlags() complete.Flags {
+	return c.Flags().Completions()
+}
+
+func (c *AuditVerifyCommand) Run(args []string) int {
+	f := c.Flags()
+
+	if err := f.Parse(args); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	args = f.Args()
+	expected := 2
+	if c.flagKey != "" {
+		expected = 1
+	}
+	switch {
+	case len(args) < expected:
+		c.UI.Error(fmt.Sprintf("Not enough arguments (expected %d, got %d)", expected, len(args)))
+		return 1
+	case len(args) > expected:
+		c.UI.Error(fmt.Sprintf("Too many arguments (expected %d, got %d)", expected, len(args)))
+		return 1
+	}
+
+	key := c.flagKey
+	if key == "" {
+		path := ensureTrailingSlash(sanitizePath(args[0]))
+
+		client, err := c.Client()
+		if err != nil {
+			c.UI.Error(err.Error())
+			return 2
+		}
+
+		key, err = client.Sys().AuditHashChainKey(path)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error reading hash chain key: %s", err))
+			return 2
+		}
+	}
+
+	var r io.Reader
+	file := args[len(args)-1]
+	if file == "-" {
+		r = os.Stdin
+		if c.testStdin != nil {
+			r = c.testStdin
+		}
+	} else {
+		f, err := os.Open(file)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error opening audit log: %s", err))
+			return 1
+		}
+		defer f.Close()
+		r = f
+	}
+
+	report, err := audit.VerifyHashChain(r, []byte(key))
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error reading audit log: %s", err))
+		return 1
+	}
+
+	for _, line := range report.Restarts {
+		c.UI.Info(fmt.Sprintf("Line %d: new chain started", line))
+	}
+	for _, problem := range report.Problems {
+		c.UI.Warn(fmt.Sprintf("Line %d: %s", problem.Line, problem.Message))
+	}
+
+	if !report.Valid() {
+		c.UI.Error(fmt.Sprintf("Verification failed! Found %d problems in %d entries", len(report.Problems), report.Entries))
+		return 2
+	}
+
+	c.UI.Output(fmt.Sprintf("Success! Verified %d entries", report.Entries))
+	return 0
+}
@@ -0,0 +1,167 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/mitchellh/cli"
+)
+
+func testAuditVerifyCommand(tb testing.TB) (*cli.MockUi, *AuditVerifyCommand) {
+	tb.Helper()
+
+	ui := cli.NewMockUi()
+	return ui, &AuditVerifyCommand{
+		BaseCommand: &BaseCommand{
+			UI: ui,
+		},
+	}
+}
+
+func TestAuditVerifyCommand_Run(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		args []string
+		out  string
+		code int
+	}{
+		{
+			"not_enough_args",
+			[]string{"file/"},
+			"Not enough arguments",
+			1,
+		},
+		{
+			"too_many_args",
+			[]string{"-key=foo", "file/", "audit.log"},
+			"Too many arguments",
+			1,
+		},
+		{
+			"no_file",
+			[]string{"-key=foo", "does-not-exist.log"},
+			"Error opening audit log",
+			1,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ui, cmd := testAuditVerifyCommand(t)
+
+			code := cmd.Run(tc.args)
+			if code != tc.code {
+				t.Errorf("expected %d to be %d", code, tc.code)
+			}
+
+			combined := ui.OutputWriter.String() + ui.ErrorWriter.String()
+			if !strings.Contains(combined, tc.out) {
+				t.Errorf("expected %q to contain %q", combined, tc.out)
+			}
+		})
+	}
+
+	t.Run("integration", func(t *testing.T) {
+		t.Parallel()
+
+		dir, err := ioutil.TempDir("", "vault-audit-verify")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		logPath := filepath.Join(dir, "audit.log")
+
+		client, closer := testVaultServer(t)
+		defer closer()
+
+		if err := client.Sys().EnableAuditWithOptions("chained", &api.EnableAuditOptions{
+			Type: "file",
+			Options: map[string]string{
+				"file_path":  logPath,
+				"hash_chain": "true",
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			if _, err := client.Sys().ListMounts(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		ui, cmd := testAuditVerifyCommand(t)
+		cmd.client = client
+
+		code := cmd.Run([]string{"chained/", logPath})
+		if exp := 0; code != exp {
+			t.Fatalf("expected %d to be %d: %s", code, exp, ui.ErrorWriter.String())
+		}
+		expected := "Success! Verified"
+		if combined := ui.OutputWriter.String() + ui.ErrorWriter.String(); !strings.Contains(combined, expected) {
+			t.Errorf("expected %q to contain %q", combined, expected)
+		}
+
+		key, err := client.Sys().AuditHashChainKey("chained")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Remove an entry from the log
+		content, err := ioutil.ReadFile(logPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.SplitAfter(string(content), "\n")
+		tampered := strings.Join(append(lines[:2:2], lines[3:]...), "")
+
+		ui, cmd = testAuditVerifyCommand(t)
+		cmd.testStdin = strings.NewReader(tampered)
+
+		code = cmd.Run([]string{"-key=" + key, "-"})
+		if exp := 2; code != exp {
+			t.Fatalf("expected %d to be %d", code, exp)
+		}
+		expected = "Line 3: entry 3 is missing"
+		if combined := ui.OutputWriter.String() + ui.ErrorWriter.String(); !strings.Contains(combined, expected) {
+			t.Errorf("expected %q to contain %q", combined, expected)
+		}
+	})
+
+	t.Run("communication_failure", func(t *testing.T) {
+		t.Parallel()
+
+		client, closer := testVaultServerBad(t)
+		defer closer()
+
+		ui, cmd := testAuditVerifyCommand(t)
+		cmd.client = client
+
+		code := cmd.Run([]string{"file/", "audit.log"})
+		if exp := 2; code != exp {
+			t.Errorf("expected %d to be %d", code, exp)
+		}
+
+		expected := "Error reading hash chain key: "
+		combined := ui.OutputWriter.String() + ui.ErrorWriter.String()
+		if !strings.Contains(combined, expected) {
+			t.Errorf("expected %q to contain %q", combined, expected)
+		}
+	})
+
+	t.Run("no_tabs", func(t *testing.T) {
+		t.Parallel()
+
+		_, cmd := testAuditVerifyCommand(t)
+		assertNoTabs(t, cmd)
+	})
+}
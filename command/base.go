@@ -59,6 +59,10 @@ type BaseCommand struct {
 
 	tokenHelper token.TokenHelper
 
+	// clientConfigFunc, if set, is called with the client's configuration
+	// before the client is built
+	clientConfigFunc func(*api.Config) error
+
 	client *api.Client
 }
 
@@ -105,6 +109,12 @@ func (c *BaseCommand) Client() (*api.Client, error) {
 		}
 	}
 
+	if c.clientConfigFunc != nil {
+		if err := c.clientConfigFunc(config); err != nil {
+			return nil, errors.Wrap(err, "failed to configure client")
+		}
+	}
+
 	// Build the client
 	client, err := api.NewClient(config)
 	if err != nil {
@@ -107,9 +107,11 @@ func (b *BaseCommand) PredictVaultAvailableAuths() complete.Predictor {
 		"gcp",
 		"github",
 		"ldap",
+		"oidc-device",
 		"okta",
 		"plugin",
 		"radius",
+		"spiffe",
 		"userpass",
 	)
 }
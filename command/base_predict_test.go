@@ -359,6 +359,7 @@ func TestPredict_Plugins(t *testing.T) {
 				"nomad",
 				"oci",
 				"oidc",
+				"oidc-device",
 				"okta",
 				"pcf", // Deprecated.
 				"pki",
@@ -366,6 +367,7 @@ func TestPredict_Plugins(t *testing.T) {
 				"postgresql-database-plugin",
 				"rabbitmq",
 				"radius",
+				"spiffe",
 				"ssh",
 				"totp",
 				"transit",
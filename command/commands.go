@@ -20,6 +20,7 @@ import (
 	_ "github.com/hashicorp/vault/helper/builtinplugins"
 
 	auditFile "github.com/hashicorp/vault/builtin/audit/file"
+	auditHTTP "github.com/hashicorp/vault/builtin/audit/http"
 	auditSocket "github.com/hashicorp/vault/builtin/audit/socket"
 	auditSyslog "github.com/hashicorp/vault/builtin/audit/syslog"
 
@@ -34,7 +35,9 @@ import (
 	credCert "github.com/hashicorp/vault/builtin/credential/cert"
 	credGitHub "github.com/hashicorp/vault/builtin/credential/github"
 	credLdap "github.com/hashicorp/vault/builtin/credential/ldap"
+	credOIDCDevice "github.com/hashicorp/vault/builtin/credential/oidc-device"
 	credOkta "github.com/hashicorp/vault/builtin/credential/okta"
+	credSPIFFE "github.com/hashicorp/vault/builtin/credential/spiffe"
 	credToken "github.com/hashicorp/vault/builtin/credential/token"
 	credUserpass "github.com/hashicorp/vault/builtin/credential/userpass"
 
@@ -114,6 +117,7 @@ const (
 var (
 	auditBackends = map[string]audit.Factory{
 		"file":   auditFile.Factory,
+		"http":   auditHTTP.Factory,
 		"socket": auditSocket.Factory,
 		"syslog": auditSyslog.Factory,
 	}
@@ -170,23 +174,25 @@ var Commands map[string]cli.CommandFactory
 
 func initCommands(ui, serverCmdUi cli.Ui, runOpts *RunOptions) {
 	loginHandlers := map[string]LoginHandler{
-		"alicloud": &credAliCloud.CLIHandler{},
-		"aws":      &credAws.CLIHandler{},
-		"centrify": &credCentrify.CLIHandler{},
-		"cert":     &credCert.CLIHandler{},
-		"cf":       &credCF.CLIHandler{},
-		"gcp":      &credGcp.CLIHandler{},
-		"github":   &credGitHub.CLIHandler{},
-		"kerberos": &credKerb.CLIHandler{},
-		"ldap":     &credLdap.CLIHandler{},
-		"oci":      &credOCI.CLIHandler{},
-		"oidc":     &credOIDC.CLIHandler{},
-		"okta":     &credOkta.CLIHandler{},
-		"pcf":      &credCF.CLIHandler{}, // Deprecated.
+		"alicloud":    &credAliCloud.CLIHandler{},
+		"aws":         &credAws.CLIHandler{},
+		"centrify":    &credCentrify.CLIHandler{},
+		"cert":        &credCert.CLIHandler{},
+		"cf":          &credCF.CLIHandler{},
+		"gcp":         &credGcp.CLIHandler{},
+		"github":      &credGitHub.CLIHandler{},
+		"kerberos":    &credKerb.CLIHandler{},
+		"ldap":        &credLdap.CLIHandler{},
+		"oci":         &credOCI.CLIHandler{},
+		"oidc":        &credOIDC.CLIHandler{},
+		"oidc-device": &credOIDCDevice.CLIHandler{},
+		"okta":        &credOkta.CLIHandler{},
+		"pcf":         &credCF.CLIHandler{}, // Deprecated.
 		"radius": &credUserpass.CLIHandler{
 			DefaultMount: "radius",
 		},
-		"token": &credToken.CLIHandler{},
+		"spiffe": &credSPIFFE.CLIHandler{},
+		"token":  &credToken.CLIHandler{},
 		"userpass": &credUserpass.CLIHandler{
 			DefaultMount: "userpass",
 		},
@@ -230,6 +236,16 @@ func initCommands(ui, serverCmdUi cli.Ui, runOpts *RunOptions) {
 				BaseCommand: getBaseCommand(),
 			}, nil
 		},
+		"audit search": func() (cli.Command, error) {
+			return &AuditSearchCommand{
+				BaseCommand: getBaseCommand(),
+			}, nil
+		},
+		"audit verify": func() (cli.Command, error) {
+			return &AuditVerifyCommand{
+				BaseCommand: getBaseCommand(),
+			}, nil
+		},
 		"auth tune": func() (cli.Command, error) {
 			return &AuthTuneCommand{
 				BaseCommand: getBaseCommand(),
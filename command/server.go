@@ -906,7 +906,7 @@ func (c *ServerCommand) Run(args []string) int {
 				"in a Docker container, provide the IPC_LOCK cap to the container."))
 	}
 
-	metricsHelper, err := c.setupTelemetry(config)
+	metricsHelper, err := setupTelemetry(c.UI, config.Telemetry)
 	if err != nil {
 		c.UI.Error(fmt.Sprintf("Error initializing telemetry: %s", err))
 		return 1
@@ -2295,8 +2295,9 @@ func (c *ServerCommand) detectRedirect(detect physical.RedirectDetect,
 	return url.String(), nil
 }
 
-// setupTelemetry is used to setup the telemetry sub-systems and returns the in-memory sink to be used in http configuration
-func (c *ServerCommand) setupTelemetry(config *server.Config) (*metricsutil.MetricsHelper, error) {
+// setupTelemetry is used to setup the telemetry sub-systems and returns the in-memory sink to be used in http configuration.
+// It is shared by the server and the agent.
+func setupTelemetry(ui cli.Ui, config *server.Telemetry) (*metricsutil.MetricsHelper, error) {
 	/* Setup telemetry
 	Aggregate on 10 second intervals for 1 minute. Expose the
 	metrics over stderr when there is a SIGUSR1 received.
@@ -2305,8 +2306,8 @@ func (c *ServerCommand) setupTelemetry(config *server.Config) (*metricsutil.Metr
 	metrics.DefaultInmemSignal(inm)
 
 	var telConfig *server.Telemetry
-	if config.Telemetry != nil {
-		telConfig = config.Telemetry
+	if config != nil {
+		telConfig = config
 	} else {
 		telConfig = &server.Telemetry{}
 	}
@@ -2422,7 +2423,7 @@ func (c *ServerCommand) setupTelemetry(config *server.Config) (*metricsutil.Metr
 	if len(fanout) > 1 {
 		// Hostname enabled will create poor quality metrics name for prometheus
 		if !telConfig.DisableHostname {
-			c.UI.Warn("telemetry.disable_hostname has been set to false. Recommended setting is true for Prometheus to avoid poorly named metrics.")
+			ui.Warn("telemetry.disable_hostname has been set to false. Recommended setting is true for Prometheus to avoid poorly named metrics.")
 		}
 	} else {
 		metricsConf.EnableHostname = false
@@ -2462,6 +2463,15 @@ func (c *ServerCommand) Reload(lock *sync.RWMutex, reloadFuncs *map[string][]rel
 					}
 				}
 			}
+
+		case strings.HasPrefix(k, "audit_http|"):
+			for _, relFunc := range relFuncs {
+				if relFunc != nil {
+					if err := relFunc(nil); err != nil {
+						reloadErrors = multierror.Append(reloadErrors, errwrap.Wrapf(fmt.Sprintf("error encountered reloading http audit device at path %q: {{err}}", strings.TrimPrefix(k, "audit_http|")), err))
+					}
+				}
+			}
 		}
 	}
 
@@ -20,8 +20,10 @@ import (
 	credCert "github.com/hashicorp/vault/builtin/credential/cert"
 	credGitHub "github.com/hashicorp/vault/builtin/credential/github"
 	credLdap "github.com/hashicorp/vault/builtin/credential/ldap"
+	credOIDCDevice "github.com/hashicorp/vault/builtin/credential/oidc-device"
 	credOkta "github.com/hashicorp/vault/builtin/credential/okta"
 	credRadius "github.com/hashicorp/vault/builtin/credential/radius"
+	credSPIFFE "github.com/hashicorp/vault/builtin/credential/spiffe"
 	credUserpass "github.com/hashicorp/vault/builtin/credential/userpass"
 
 	dbElastic "github.com/hashicorp/vault-plugin-database-elasticsearch"
@@ -67,26 +69,28 @@ type BuiltinFactory func() (interface{}, error)
 func newRegistry() *registry {
 	reg := &registry{
 		credentialBackends: map[string]logical.Factory{
-			"alicloud":   credAliCloud.Factory,
-			"app-id":     credAppId.Factory,
-			"approle":    credAppRole.Factory,
-			"aws":        credAws.Factory,
-			"azure":      credAzure.Factory,
-			"centrify":   credCentrify.Factory,
-			"cert":       credCert.Factory,
-			"cf":         credCF.Factory,
-			"gcp":        credGcp.Factory,
-			"github":     credGitHub.Factory,
-			"jwt":        credJWT.Factory,
-			"kerberos":   credKerb.Factory,
-			"kubernetes": credKube.Factory,
-			"ldap":       credLdap.Factory,
-			"oci":        credOCI.Factory,
-			"oidc":       credJWT.Factory,
-			"okta":       credOkta.Factory,
-			"pcf":        credCF.Factory, // Deprecated.
-			"radius":     credRadius.Factory,
-			"userpass":   credUserpass.Factory,
+			"alicloud":    credAliCloud.Factory,
+			"app-id":      credAppId.Factory,
+			"approle":     credAppRole.Factory,
+			"aws":         credAws.Factory,
+			"azure":       credAzure.Factory,
+			"centrify":    credCentrify.Factory,
+			"cert":        credCert.Factory,
+			"cf":          credCF.Factory,
+			"gcp":         credGcp.Factory,
+			"github":      credGitHub.Factory,
+			"jwt":         credJWT.Factory,
+			"kerberos":    credKerb.Factory,
+			"kubernetes":  credKube.Factory,
+			"ldap":        credLdap.Factory,
+			"oci":         credOCI.Factory,
+			"oidc":        credJWT.Factory,
+			"oidc-device": credOIDCDevice.Factory,
+			"okta":        credOkta.Factory,
+			"pcf":         credCF.Factory, // Deprecated.
+			"radius":      credRadius.Factory,
+			"spiffe":      credSPIFFE.Factory,
+			"userpass":    credUserpass.Factory,
 		},
 		databasePlugins: map[string]BuiltinFactory{
 			// These four plugins all use the same mysql implementation but with
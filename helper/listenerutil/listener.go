@@ -38,8 +38,11 @@ func (l *rmListener) Close() error {
 		return err
 	}
 
-	// Remove the file
-	return os.Remove(l.Path)
+	// Remove the file, unless the listener already did
+	if err := os.Remove(l.Path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
 }
 
 func UnixSocketListener(path string, unixSocketsConfig *UnixSocketsConfig) (net.Listener, error) {
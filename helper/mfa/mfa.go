@@ -16,6 +16,7 @@ import (
 	"context"
 
 	"github.com/hashicorp/vault/helper/mfa/duo"
+	"github.com/hashicorp/vault/helper/mfa/totp"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 )
@@ -26,14 +27,16 @@ import (
 func MFAPaths(originalBackend *framework.Backend, loginPath *framework.Path) []*framework.Path {
 	var b backend
 	b.Backend = originalBackend
-	return append(duo.DuoPaths(), pathMFAConfig(&b), wrapLoginPath(&b, loginPath))
+	paths := append(duo.DuoPaths(), totp.TOTPPaths()...)
+	return append(paths, pathMFAConfig(&b), wrapLoginPath(&b, loginPath))
 }
 
 // MFARootPaths returns path strings used to configure MFA. When adding MFA
 // to a backend, these paths should be included in
 // Backend.PathsSpecial.Root.
 func MFARootPaths() []string {
-	return append(duo.DuoRootPaths(), "mfa_config")
+	rootPaths := append(duo.DuoRootPaths(), totp.TOTPRootPaths()...)
+	return append(rootPaths, "mfa_config")
 }
 
 // HandlerFunc is the callback called to handle MFA for a login request.
@@ -41,7 +44,8 @@ type HandlerFunc func(context.Context, *logical.Request, *framework.FieldData, *
 
 // handlers maps each supported MFA type to its handler.
 var handlers = map[string]HandlerFunc{
-	"duo": duo.DuoHandler,
+	"duo":  duo.DuoHandler,
+	"totp": totp.TOTPHandler,
 }
 
 type backend struct {
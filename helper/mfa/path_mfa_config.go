@@ -13,7 +13,7 @@ func pathMFAConfig(b *backend) *framework.Path {
 		Fields: map[string]*framework.FieldSchema{
 			"type": &framework.FieldSchema{
 				Type:        framework.TypeString,
-				Description: "Enables MFA with given backend (available: duo)",
+				Description: "Enables MFA with given backend (available: duo, totp)",
 			},
 		},
 
@@ -83,5 +83,5 @@ Configure multi factor backend.
 
 const pathMFAConfigHelpDesc = `
 This endpoint allows you to turn on multi-factor authentication with a given backend.
-Currently only Duo is supported.
+Currently Duo and TOTP are supported.
 `
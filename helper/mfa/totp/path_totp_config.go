@@ -0,0 +1,187 @@
+package totp
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	otplib "github.com/pquerna/otp"
+)
+
+func pathTOTPConfig() *framework.Path {
+	return &framework.Path{
+		Pattern: `totp/config`,
+		Fields: map[string]*framework.FieldSchema{
+			"issuer": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Issuer shown in authenticator apps for enrolled secrets (default \"Vault\")",
+			},
+			"period": &framework.FieldSchema{
+				Type:        framework.TypeDurationSecond,
+				Description: "Length of time each passcode is valid for (default 30s)",
+			},
+			"digits": &framework.FieldSchema{
+				Type:        framework.TypeInt,
+				Description: "Number of digits in each passcode, either 6 or 8 (default 6)",
+			},
+			"algorithm": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Hash algorithm used to generate passcodes, one of SHA1, SHA256 or SHA512 (default SHA1)",
+			},
+			"skew": &framework.FieldSchema{
+				Type:        framework.TypeInt,
+				Description: "Number of periods before and after the current one whose passcodes are accepted, either 0 or 1 (default 1)",
+			},
+			"key_size": &framework.FieldSchema{
+				Type:        framework.TypeInt,
+				Description: "Size in bytes of generated secrets (default 20)",
+			},
+			"qr_size": &framework.FieldSchema{
+				Type:        framework.TypeInt,
+				Description: "Pixel size of the square QR code returned on enrollment; 0 disables it (default 200)",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: pathTOTPConfigWrite,
+			logical.ReadOperation:   pathTOTPConfigRead,
+		},
+
+		HelpSynopsis:    pathTOTPConfigHelpSyn,
+		HelpDescription: pathTOTPConfigHelpDesc,
+	}
+}
+
+func GetTOTPConfig(ctx context.Context, req *logical.Request) (*TOTPConfig, error) {
+	result := TOTPConfig{
+		Issuer:    "Vault",
+		Period:    30,
+		Digits:    6,
+		Algorithm: "SHA1",
+		Skew:      1,
+		KeySize:   20,
+		QRSize:    200,
+	}
+	// all config parameters are optional, so path need not exist
+	entry, err := req.Storage.Get(ctx, "totp/config")
+	if err != nil {
+		return nil, err
+	}
+	if entry != nil {
+		if err := entry.DecodeJSON(&result); err != nil {
+			return nil, err
+		}
+	}
+	return &result, nil
+}
+
+func pathTOTPConfigWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config, err := GetTOTPConfig(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if issuer, ok := d.GetOk("issuer"); ok {
+		config.Issuer = issuer.(string)
+	}
+	if period, ok := d.GetOk("period"); ok {
+		config.Period = period.(int)
+	}
+	if digits, ok := d.GetOk("digits"); ok {
+		config.Digits = digits.(int)
+	}
+	if algorithm, ok := d.GetOk("algorithm"); ok {
+		config.Algorithm = algorithm.(string)
+	}
+	if skew, ok := d.GetOk("skew"); ok {
+		config.Skew = skew.(int)
+	}
+	if keySize, ok := d.GetOk("key_size"); ok {
+		config.KeySize = keySize.(int)
+	}
+	if qrSize, ok := d.GetOk("qr_size"); ok {
+		config.QRSize = qrSize.(int)
+	}
+
+	switch {
+	case config.Issuer == "":
+		return logical.ErrorResponse("issuer must not be empty"), nil
+	case config.Period <= 0:
+		return logical.ErrorResponse("period must be greater than zero"), nil
+	case config.Digits != 6 && config.Digits != 8:
+		return logical.ErrorResponse("digits must be 6 or 8"), nil
+	case config.Skew != 0 && config.Skew != 1:
+		return logical.ErrorResponse("skew must be 0 or 1"), nil
+	case config.KeySize <= 0:
+		return logical.ErrorResponse("key_size must be greater than zero"), nil
+	case config.QRSize < 0:
+		return logical.ErrorResponse("qr_size must be greater than or equal to zero"), nil
+	}
+	if _, err := config.algorithm(); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	entry, err := logical.StorageEntryJSON("totp/config", config)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func pathTOTPConfigRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config, err := GetTOTPConfig(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"issuer":    config.Issuer,
+			"period":    config.Period,
+			"digits":    config.Digits,
+			"algorithm": config.Algorithm,
+			"skew":      config.Skew,
+			"key_size":  config.KeySize,
+			"qr_size":   config.QRSize,
+		},
+	}, nil
+}
+
+type TOTPConfig struct {
+	Issuer    string `json:"issuer"`
+	Period    int    `json:"period"`
+	Digits    int    `json:"digits"`
+	Algorithm string `json:"algorithm"`
+	Skew      int    `json:"skew"`
+	KeySize   int    `json:"key_size"`
+	QRSize    int    `json:"qr_size"`
+}
+
+func (c *TOTPConfig) algorithm() (otplib.Algorithm, error) {
+	switch c.Algorithm {
+	case "SHA1":
+		return otplib.AlgorithmSHA1, nil
+	case "SHA256":
+		return otplib.AlgorithmSHA256, nil
+	case "SHA512":
+		return otplib.AlgorithmSHA512, nil
+	default:
+		return 0, fmt.Errorf("unsupported algorithm %q", c.Algorithm)
+	}
+}
+
+const pathTOTPConfigHelpSyn = `
+Configure TOTP second factor behavior.
+`
+
+const pathTOTPConfigHelpDesc = `
+This endpoint allows you to configure the parameters of the TOTP secrets
+generated when users are enrolled. Changes only apply to users enrolled
+afterwards.
+`
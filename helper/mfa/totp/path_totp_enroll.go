@@ -0,0 +1,191 @@
+package totp
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"image/png"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	otplib "github.com/pquerna/otp"
+	totplib "github.com/pquerna/otp/totp"
+)
+
+const enrollmentPrefix = "totp/enroll/"
+
+func pathTOTPEnrollList() *framework.Path {
+	return &framework.Path{
+		Pattern: `totp/enroll/?`,
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: pathTOTPEnrollmentsList,
+		},
+
+		HelpSynopsis:    pathTOTPEnrollHelpSyn,
+		HelpDescription: pathTOTPEnrollHelpDesc,
+	}
+}
+
+func pathTOTPEnroll() *framework.Path {
+	return &framework.Path{
+		Pattern: `totp/enroll/(?P<username>.+)`,
+		Fields: map[string]*framework.FieldSchema{
+			"username": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Username of the user to enroll, as reported by the auth method",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: pathTOTPEnrollWrite,
+			logical.ReadOperation:   pathTOTPEnrollRead,
+			logical.DeleteOperation: pathTOTPEnrollDelete,
+		},
+
+		HelpSynopsis:    pathTOTPEnrollHelpSyn,
+		HelpDescription: pathTOTPEnrollHelpDesc,
+	}
+}
+
+// GetTOTPEnrollment returns the enrollment of the given user, or nil if the
+// user is not enrolled.
+func GetTOTPEnrollment(ctx context.Context, s logical.Storage, username string) (*TOTPEnrollment, error) {
+	entry, err := s.Get(ctx, enrollmentPrefix+username)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var result TOTPEnrollment
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func putTOTPEnrollment(ctx context.Context, s logical.Storage, username string, enrollment *TOTPEnrollment) error {
+	entry, err := logical.StorageEntryJSON(enrollmentPrefix+username, enrollment)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+func pathTOTPEnrollmentsList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	usernames, err := req.Storage.List(ctx, enrollmentPrefix)
+	if err != nil {
+		return nil, err
+	}
+	return logical.ListResponse(usernames), nil
+}
+
+// pathTOTPEnrollWrite generates a new secret for the user, replacing any
+// existing one, and returns it as an otpauth URL and QR code.
+func pathTOTPEnrollWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	username := d.Get("username").(string)
+	if username == "" {
+		return logical.ErrorResponse("missing username"), nil
+	}
+
+	config, err := GetTOTPConfig(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	algorithm, err := config.algorithm()
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := totplib.Generate(totplib.GenerateOpts{
+		Issuer:      config.Issuer,
+		AccountName: username,
+		Period:      uint(config.Period),
+		Digits:      otplib.Digits(config.Digits),
+		Algorithm:   algorithm,
+		SecretSize:  uint(config.KeySize),
+	})
+	if err != nil {
+		return nil, errwrap.Wrapf("error generating TOTP secret: {{err}}", err)
+	}
+
+	lock := locksutil.LockForKey(enrollmentLocks, username)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := putTOTPEnrollment(ctx, req.Storage, username, &TOTPEnrollment{
+		Secret:      key.Secret(),
+		Issuer:      config.Issuer,
+		AccountName: username,
+		Period:      uint(config.Period),
+		Digits:      otplib.Digits(config.Digits),
+		Algorithm:   algorithm,
+		Skew:        uint(config.Skew),
+	}); err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{
+		"url": key.String(),
+	}
+	if config.QRSize > 0 {
+		barcode, err := key.Image(config.QRSize, config.QRSize)
+		if err != nil {
+			return nil, errwrap.Wrapf("failed to generate QR code image: {{err}}", err)
+		}
+
+		var buff bytes.Buffer
+		if err := png.Encode(&buff, barcode); err != nil {
+			return nil, errwrap.Wrapf("failed to encode QR code image: {{err}}", err)
+		}
+		data["barcode"] = base64.StdEncoding.EncodeToString(buff.Bytes())
+	}
+
+	return &logical.Response{
+		Data: data,
+	}, nil
+}
+
+func pathTOTPEnrollRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	enrollment, err := GetTOTPEnrollment(ctx, req.Storage, d.Get("username").(string))
+	if err != nil {
+		return nil, err
+	}
+	if enrollment == nil {
+		return nil, nil
+	}
+
+	// The secret itself is only ever returned on enrollment
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"issuer":       enrollment.Issuer,
+			"account_name": enrollment.AccountName,
+			"period":       enrollment.Period,
+			"digits":       int(enrollment.Digits),
+			"algorithm":    enrollment.Algorithm.String(),
+			"skew":         enrollment.Skew,
+		},
+	}, nil
+}
+
+func pathTOTPEnrollDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if err := req.Storage.Delete(ctx, enrollmentPrefix+d.Get("username").(string)); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+const pathTOTPEnrollHelpSyn = `
+Enroll users in TOTP second factor authentication.
+`
+
+const pathTOTPEnrollHelpDesc = `
+Writing to this endpoint generates a new TOTP secret for the user, replacing
+any existing one, and returns it as an otpauth URL along with a base64 encoded
+PNG QR code that can be scanned by authenticator apps. The secret cannot be
+read back afterwards. Deleting the enrollment removes the user's secret.
+`
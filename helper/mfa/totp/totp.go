@@ -0,0 +1,130 @@
+// Package totp provides a TOTP MFA handler to authenticate users
+// with time-based one-time passcodes. This handler is registered
+// as the "totp" type in mfa_config.
+package totp
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	otplib "github.com/pquerna/otp"
+	totplib "github.com/pquerna/otp/totp"
+)
+
+// enrollmentLocks serialize passcode checks for a user so that the same
+// passcode cannot be accepted twice by concurrent logins.
+var enrollmentLocks = locksutil.CreateLocks()
+
+// TOTPPaths returns path functions to configure TOTP and enroll users.
+func TOTPPaths() []*framework.Path {
+	return []*framework.Path{
+		pathTOTPConfig(),
+		pathTOTPEnrollList(),
+		pathTOTPEnroll(),
+	}
+}
+
+// TOTPRootPaths returns the paths that are used to configure TOTP.
+func TOTPRootPaths() []string {
+	return []string{
+		"totp/config",
+		"totp/enroll/*",
+	}
+}
+
+// TOTPHandler checks the passcode given with a login request against the
+// user's enrolled TOTP secret. If successful, the original response from the
+// login backend is returned.
+func TOTPHandler(ctx context.Context, req *logical.Request, d *framework.FieldData, resp *logical.Response) (
+	*logical.Response, error) {
+	username, ok := resp.Auth.Metadata["username"]
+	if !ok {
+		return logical.ErrorResponse("Could not read username for MFA"), nil
+	}
+
+	lock := locksutil.LockForKey(enrollmentLocks, username)
+	lock.Lock()
+	defer lock.Unlock()
+
+	enrollment, err := GetTOTPEnrollment(ctx, req.Storage, username)
+	if err != nil {
+		return nil, err
+	}
+	if enrollment == nil {
+		return logical.ErrorResponse("User is not enrolled in TOTP"), nil
+	}
+
+	passcode := d.Get("passcode").(string)
+	if passcode == "" {
+		return logical.ErrorResponse("A TOTP passcode is required"), nil
+	}
+
+	step, ok, err := enrollment.match(passcode, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return logical.ErrorResponse("Invalid TOTP passcode"), nil
+	}
+
+	// Passcodes are only accepted for time steps later than the last one
+	// used, which prevents replays within the passcode's validity window
+	if step <= enrollment.LastUsedStep {
+		return logical.ErrorResponse("TOTP passcode has already been used; wait for the next passcode"), nil
+	}
+	enrollment.LastUsedStep = step
+	if err := putTOTPEnrollment(ctx, req.Storage, username, enrollment); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// match returns the time step whose passcode matches the given one, checking
+// the current step and Skew steps on either side of it.
+func (e *TOTPEnrollment) match(passcode string, now time.Time) (int64, bool, error) {
+	if len(passcode) != e.Digits.Length() {
+		return 0, false, nil
+	}
+
+	opts := totplib.ValidateOpts{
+		Period:    e.Period,
+		Digits:    e.Digits,
+		Algorithm: e.Algorithm,
+	}
+
+	period := int64(e.Period)
+	current := now.Unix() / period
+	skew := int64(e.Skew)
+	for step := current - skew; step <= current+skew; step++ {
+		expected, err := totplib.GenerateCodeCustom(e.Secret, time.Unix(step*period, 0), opts)
+		if err != nil {
+			return 0, false, fmt.Errorf("error generating TOTP passcode: %v", err)
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(passcode)) == 1 {
+			return step, true, nil
+		}
+	}
+
+	return 0, false, nil
+}
+
+// TOTPEnrollment holds a user's TOTP secret along with the parameters it was
+// generated with.
+type TOTPEnrollment struct {
+	Secret      string           `json:"secret"`
+	Issuer      string           `json:"issuer"`
+	AccountName string           `json:"account_name"`
+	Period      uint             `json:"period"`
+	Digits      otplib.Digits    `json:"digits"`
+	Algorithm   otplib.Algorithm `json:"algorithm"`
+	Skew        uint             `json:"skew"`
+
+	// LastUsedStep is the time step of the last accepted passcode
+	LastUsedStep int64 `json:"last_used_step"`
+}
@@ -0,0 +1,219 @@
+package totp
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	otplib "github.com/pquerna/otp"
+	totplib "github.com/pquerna/otp/totp"
+)
+
+func testTOTPBackend(t *testing.T) (*framework.Backend, logical.Storage) {
+	b := &framework.Backend{
+		Paths: TOTPPaths(),
+	}
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+	if err := b.Setup(context.Background(), config); err != nil {
+		t.Fatal(err)
+	}
+	return b, config.StorageView
+}
+
+func testTOTPRequest(t *testing.T, b *framework.Backend, s logical.Storage, op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: op,
+		Path:      path,
+		Storage:   s,
+		Data:      data,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+	return resp
+}
+
+func testTOTPLogin(t *testing.T, s logical.Storage, username, passcode string) *logical.Response {
+	successResp := &logical.Response{
+		Auth: &logical.Auth{
+			Metadata: map[string]string{
+				"username": username,
+			},
+		},
+	}
+	d := &framework.FieldData{
+		Raw: map[string]interface{}{
+			"passcode": passcode,
+		},
+		Schema: map[string]*framework.FieldSchema{
+			"passcode": &framework.FieldSchema{Type: framework.TypeString},
+		},
+	}
+	resp, err := TOTPHandler(context.Background(), &logical.Request{Storage: s}, d, successResp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestTOTPHandler(t *testing.T) {
+	b, s := testTOTPBackend(t)
+
+	// Logins fail until the user is enrolled
+	resp := testTOTPLogin(t, s, "user", "123456")
+	if !resp.IsError() {
+		t.Fatalf("expected error for unenrolled user, got %#v", resp)
+	}
+
+	resp = testTOTPRequest(t, b, s, logical.UpdateOperation, "totp/enroll/user", nil)
+	key, err := otplib.NewKeyFromURL(resp.Data["url"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.Issuer() != "Vault" || key.AccountName() != "user" {
+		t.Fatalf("unexpected key: %s", key.String())
+	}
+	if _, err := base64.StdEncoding.DecodeString(resp.Data["barcode"].(string)); err != nil || resp.Data["barcode"] == "" {
+		t.Fatalf("expected a QR code, got %#v", resp.Data["barcode"])
+	}
+
+	resp = testTOTPRequest(t, b, s, logical.ReadOperation, "totp/enroll/user", nil)
+	if _, ok := resp.Data["secret"]; ok {
+		t.Fatal("secret must not be returned on read")
+	}
+	if resp.Data["algorithm"] != "SHA1" || resp.Data["digits"] != 6 {
+		t.Fatalf("unexpected enrollment: %#v", resp.Data)
+	}
+
+	resp = testTOTPLogin(t, s, "user", "")
+	if !resp.IsError() {
+		t.Fatalf("expected error for missing passcode, got %#v", resp)
+	}
+
+	code, err := totplib.GenerateCode(key.Secret(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	resp = testTOTPLogin(t, s, "user", wrong)
+	if !resp.IsError() {
+		t.Fatalf("expected error for invalid passcode, got %#v", resp)
+	}
+
+	resp = testTOTPLogin(t, s, "user", code)
+	if resp.IsError() || resp.Auth == nil {
+		t.Fatalf("expected successful login, got %#v", resp)
+	}
+
+	// The same passcode cannot be used twice
+	resp = testTOTPLogin(t, s, "user", code)
+	if !resp.IsError() || !strings.Contains(resp.Data["error"].(string), "already been used") {
+		t.Fatalf("expected replay to be rejected, got %#v", resp)
+	}
+
+	// Nor can an earlier one that is still within the skew
+	previous, err := totplib.GenerateCode(key.Secret(), time.Now().Add(-30*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if previous != code {
+		resp = testTOTPLogin(t, s, "user", previous)
+		if !resp.IsError() {
+			t.Fatalf("expected earlier passcode to be rejected, got %#v", resp)
+		}
+	}
+
+	// A passcode for the next period is accepted
+	next, err := totplib.GenerateCode(key.Secret(), time.Now().Add(30*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp = testTOTPLogin(t, s, "user", next)
+	if resp.IsError() {
+		t.Fatalf("expected next passcode to be accepted, got %#v", resp)
+	}
+
+	resp = testTOTPRequest(t, b, s, logical.ListOperation, "totp/enroll/", nil)
+	if keys := resp.Data["keys"].([]string); len(keys) != 1 || keys[0] != "user" {
+		t.Fatalf("unexpected enrollments: %#v", keys)
+	}
+
+	testTOTPRequest(t, b, s, logical.DeleteOperation, "totp/enroll/user", nil)
+	resp = testTOTPLogin(t, s, "user", code)
+	if !resp.IsError() {
+		t.Fatalf("expected error after unenrolling, got %#v", resp)
+	}
+}
+
+func TestTOTPEnroll_Usernames(t *testing.T) {
+	b, s := testTOTPBackend(t)
+
+	// Any username the wrapped login path accepts can be enrolled, such as
+	// LDAP names containing spaces, backslashes or equal signs
+	for _, username := range []string{"user@example.com", "Bob Smith", `EXAMPLE\bob`, "uid=bob"} {
+		testTOTPRequest(t, b, s, logical.UpdateOperation, "totp/enroll/"+username, nil)
+		resp := testTOTPRequest(t, b, s, logical.ReadOperation, "totp/enroll/"+username, nil)
+		if resp == nil || resp.Data["account_name"] != username {
+			t.Fatalf("unexpected enrollment for %q: %#v", username, resp)
+		}
+	}
+}
+
+func TestTOTPConfig(t *testing.T) {
+	b, s := testTOTPBackend(t)
+
+	testTOTPRequest(t, b, s, logical.UpdateOperation, "totp/config", map[string]interface{}{
+		"issuer":    "Example",
+		"digits":    8,
+		"algorithm": "SHA256",
+		"qr_size":   0,
+	})
+	resp := testTOTPRequest(t, b, s, logical.ReadOperation, "totp/config", nil)
+	if resp.Data["issuer"] != "Example" || resp.Data["digits"] != 8 || resp.Data["period"] != 30 {
+		t.Fatalf("unexpected config: %#v", resp.Data)
+	}
+
+	resp = testTOTPRequest(t, b, s, logical.UpdateOperation, "totp/enroll/user@example.com", nil)
+	if _, ok := resp.Data["barcode"]; ok {
+		t.Fatal("expected no QR code when qr_size is 0")
+	}
+	key, err := otplib.NewKeyFromURL(resp.Data["url"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := totplib.GenerateCodeCustom(key.Secret(), time.Now(), totplib.ValidateOpts{
+		Period:    30,
+		Digits:    otplib.DigitsEight,
+		Algorithm: otplib.AlgorithmSHA256,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := testTOTPLogin(t, s, "user@example.com", code); resp.IsError() {
+		t.Fatalf("expected successful login, got %#v", resp)
+	}
+
+	for _, data := range []map[string]interface{}{
+		{"digits": 7},
+		{"algorithm": "MD5"},
+		{"skew": 2},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "totp/config",
+			Storage:   s,
+			Data:      data,
+		})
+		if err != nil || resp == nil || !resp.IsError() {
+			t.Fatalf("expected error for %#v, got err:%v resp:%#v", data, err, resp)
+		}
+	}
+}
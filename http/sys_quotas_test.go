@@ -0,0 +1,37 @@
+package http
+
+import (
+	"testing"
+
+	"github.com/hashicorp/vault/vault"
+)
+
+func TestSysQuotasRateLimit(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	resp := testHttpPut(t, token, addr+"/v1/sys/quotas/rate-limit/kv", map[string]interface{}{
+		"path":     "secret/",
+		"rate":     1,
+		"interval": "1m",
+	})
+	testResponseStatus(t, resp, 204)
+
+	resp = testHttpGet(t, token, addr+"/v1/secret/foo")
+	testResponseStatus(t, resp, 404)
+
+	resp = testHttpGet(t, token, addr+"/v1/secret/foo")
+	testResponseStatus(t, resp, 429)
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter == "" || retryAfter == "0" {
+		t.Fatalf("bad Retry-After header: %q", retryAfter)
+	}
+
+	// Quotas can still be managed
+	resp = testHttpDelete(t, token, addr+"/v1/sys/quotas/rate-limit/kv")
+	testResponseStatus(t, resp, 204)
+
+	resp = testHttpGet(t, token, addr+"/v1/secret/foo")
+	testResponseStatus(t, resp, 404)
+}
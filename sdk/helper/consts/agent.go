@@ -3,3 +3,18 @@ package consts
 // AgentPathCacheClear is the path that the agent will use as its cache-clear
 // endpoint.
 const AgentPathCacheClear = "/agent/v1/cache-clear"
+
+// AgentPathMetrics is the path the agent will use to expose its internal
+// metrics.
+const AgentPathMetrics = "/agent/v1/metrics"
+
+// AgentPathHealth is the path the agent will use to expose its health.
+const AgentPathHealth = "/agent/v1/health"
+
+// AgentPathTokenExchange is the path the agent will use to issue child tokens
+// of the auto-auth token to local clients.
+const AgentPathTokenExchange = "/agent/v1/token"
+
+// AgentClientSecretHeaderName is the name of the header identifying a local
+// client of the agent's token exchange endpoint.
+const AgentClientSecretHeaderName = "X-Vault-Agent-Client-Secret"
@@ -100,8 +100,38 @@ type Auth struct {
 
 	// Orphan is set if the token does not have a parent
 	Orphan bool `json:"orphan"`
+
+	// PolicyResults is the result of the evaluation of the ACL policies of
+	// the token for a request. This is filled in by Vault core when checking
+	// the token of a request, for audit logging.
+	PolicyResults *PolicyResults `json:"policy_results"`
 }
 
 func (a *Auth) GoString() string {
 	return fmt.Sprintf("*%#v", *a)
 }
+
+// PolicyResults holds the policies and the path rule which decided whether a
+// request is allowed
+type PolicyResults struct {
+	Allowed bool `json:"allowed"`
+
+	// GrantingPolicies are the policies granting the capability required by
+	// the request, and DenyingPolicies the ones explicitly denying the path
+	GrantingPolicies []PolicyInfo `json:"granting_policies"`
+	DenyingPolicies  []PolicyInfo `json:"denying_policies"`
+
+	// Path is the path rule which matched the request, including the path of
+	// the namespace of the policies, and MatchType is how it matched:
+	// "exact", "glob" or "segment_wildcard"
+	Path      string `json:"path"`
+	MatchType string `json:"match_type"`
+}
+
+// PolicyInfo identifies a policy
+type PolicyInfo struct {
+	Name          string `json:"name"`
+	NamespaceID   string `json:"namespace_id"`
+	NamespacePath string `json:"namespace_path"`
+	Type          string `json:"type"`
+}
@@ -25,6 +25,14 @@ var (
 	// response from an upstream
 	ErrUpstreamRateLimited = errors.New("upstream rate limited")
 
+	// ErrRateLimitQuotaExceeded is returned when a request is rejected by a
+	// rate limit quota
+	ErrRateLimitQuotaExceeded = errors.New("rate limit quota exceeded")
+
+	// ErrLeaseCountQuotaExceeded is returned when a request would create a
+	// lease exceeding a lease count quota
+	ErrLeaseCountQuotaExceeded = errors.New("lease count quota exceeded")
+
 	// ErrPerfStandbyForward is returned when Vault is in a state such that a
 	// perf standby cannot satisfy a request
 	ErrPerfStandbyPleaseForward = errors.New("please forward to the active node")
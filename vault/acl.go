@@ -55,6 +55,16 @@ type ACLResults struct {
 	MFAMethods         []string
 	ControlGroup       *ControlGroup
 	CapabilitiesBitmap uint32
+
+	// GrantingPolicies are the policies granting the capability required by
+	// the operation, and DenyingPolicies the ones explicitly denying the path
+	GrantingPolicies []logical.PolicyInfo
+	DenyingPolicies  []logical.PolicyInfo
+
+	// RulePath is the path rule which matched the request, and MatchType how
+	// it matched: "exact", "glob" or "segment_wildcard"
+	RulePath  string
+	MatchType string
 }
 
 // NewACL is used to construct a policy based ACL from a set of policies.
@@ -103,6 +113,15 @@ func NewACL(ctx context.Context, policies []*Policy) (*ACL, error) {
 			a.root = true
 		}
 
+		policyInfo := logical.PolicyInfo{
+			Name: policy.Name,
+			Type: "acl",
+		}
+		if policy.namespace != nil {
+			policyInfo.NamespaceID = policy.namespace.ID
+			policyInfo.NamespacePath = policy.namespace.Path
+		}
+
 		for _, pc := range policy.Paths {
 			var raw interface{}
 			var ok bool
@@ -127,10 +146,16 @@ func NewACL(ctx context.Context, policies []*Policy) (*ACL, error) {
 				if err != nil {
 					return nil, errwrap.Wrapf("error cloning ACL permissions: {{err}}", err)
 				}
+				clonedPerms.GrantingPoliciesMap = addGrantingPolicy(nil, pc.Permissions.CapabilitiesBitmap, policyInfo)
 				switch {
 				case pc.HasSegmentWildcards:
+					clonedPerms.rulePath, clonedPerms.matchType = pc.Path, "segment_wildcard"
 					a.segmentWildcardPaths[pc.Path] = clonedPerms
+				case pc.IsPrefix:
+					clonedPerms.rulePath, clonedPerms.matchType = pc.Path+"*", "glob"
+					tree.Insert(pc.Path, clonedPerms)
 				default:
+					clonedPerms.rulePath, clonedPerms.matchType = pc.Path, "exact"
 					tree.Insert(pc.Path, clonedPerms)
 				}
 				continue
@@ -142,7 +167,10 @@ func NewACL(ctx context.Context, policies []*Policy) (*ACL, error) {
 			switch {
 			case existingPerms.CapabilitiesBitmap&DenyCapabilityInt > 0:
 				// If we are explicitly denied in the existing capability set,
-				// don't save anything else
+				// don't save anything else but the policies also denying
+				if pc.Permissions.CapabilitiesBitmap&DenyCapabilityInt > 0 {
+					existingPerms.GrantingPoliciesMap = addGrantingPolicy(existingPerms.GrantingPoliciesMap, DenyCapabilityInt, policyInfo)
+				}
 				continue
 
 			case pc.Permissions.CapabilitiesBitmap&DenyCapabilityInt > 0:
@@ -150,12 +178,14 @@ func NewACL(ctx context.Context, policies []*Policy) (*ACL, error) {
 				existingPerms.CapabilitiesBitmap = DenyCapabilityInt
 				existingPerms.AllowedParameters = nil
 				existingPerms.DeniedParameters = nil
+				existingPerms.GrantingPoliciesMap = addGrantingPolicy(nil, DenyCapabilityInt, policyInfo)
 				goto INSERT
 
 			default:
 				// Insert the capabilities in this new policy into the existing
 				// value
 				existingPerms.CapabilitiesBitmap = existingPerms.CapabilitiesBitmap | pc.Permissions.CapabilitiesBitmap
+				existingPerms.GrantingPoliciesMap = addGrantingPolicy(existingPerms.GrantingPoliciesMap, pc.Permissions.CapabilitiesBitmap, policyInfo)
 			}
 
 			// Note: In these stanzas, we're preferring minimum lifetimes. So
@@ -272,6 +302,20 @@ func NewACL(ctx context.Context, policies []*Policy) (*ACL, error) {
 	return a, nil
 }
 
+// addGrantingPolicy records the policy as granting each capability of the
+// bitmap
+func addGrantingPolicy(m map[uint32][]logical.PolicyInfo, bitmap uint32, policy logical.PolicyInfo) map[uint32][]logical.PolicyInfo {
+	if m == nil {
+		m = make(map[uint32][]logical.PolicyInfo)
+	}
+	for capability := DenyCapabilityInt; capability <= SudoCapabilityInt; capability <<= 1 {
+		if bitmap&capability > 0 {
+			m[capability] = append(m[capability], policy)
+		}
+	}
+	return m
+}
+
 func (a *ACL) Capabilities(ctx context.Context, path string) (pathCapabilities []string) {
 	req := &logical.Request{
 		Path: path,
@@ -323,6 +367,11 @@ func (a *ACL) AllowOperation(ctx context.Context, req *logical.Request, capCheck
 		ret.Allowed = true
 		ret.RootPrivs = true
 		ret.IsRoot = true
+		ret.GrantingPolicies = []logical.PolicyInfo{{
+			Name:        "root",
+			NamespaceID: namespace.RootNamespaceID,
+			Type:        "acl",
+		}}
 		return
 	}
 	op := req.Operation
@@ -379,6 +428,12 @@ func (a *ACL) AllowOperation(ctx context.Context, req *logical.Request, capCheck
 	return
 
 CHECK:
+	ret.RulePath = permissions.rulePath
+	ret.MatchType = permissions.matchType
+	if capabilities&DenyCapabilityInt > 0 {
+		ret.DenyingPolicies = permissions.GrantingPoliciesMap[DenyCapabilityInt]
+	}
+
 	// Check if the minimum permissions are met
 	// If "deny" has been explicitly set, only deny will be in the map, so we
 	// only need to check for the existence of other values
@@ -394,31 +449,32 @@ CHECK:
 	ret.MFAMethods = permissions.MFAMethods
 	ret.ControlGroup = permissions.ControlGroup
 
-	operationAllowed := false
+	var requiredCapability uint32
 	switch op {
 	case logical.ReadOperation:
-		operationAllowed = capabilities&ReadCapabilityInt > 0
+		requiredCapability = ReadCapabilityInt
 	case logical.ListOperation:
-		operationAllowed = capabilities&ListCapabilityInt > 0
+		requiredCapability = ListCapabilityInt
 	case logical.UpdateOperation:
-		operationAllowed = capabilities&UpdateCapabilityInt > 0
+		requiredCapability = UpdateCapabilityInt
 	case logical.DeleteOperation:
-		operationAllowed = capabilities&DeleteCapabilityInt > 0
+		requiredCapability = DeleteCapabilityInt
 	case logical.CreateOperation:
-		operationAllowed = capabilities&CreateCapabilityInt > 0
+		requiredCapability = CreateCapabilityInt
 
 	// These three re-use UpdateCapabilityInt since that's the most appropriate
 	// capability/operation mapping
 	case logical.RevokeOperation, logical.RenewOperation, logical.RollbackOperation:
-		operationAllowed = capabilities&UpdateCapabilityInt > 0
+		requiredCapability = UpdateCapabilityInt
 
 	default:
 		return
 	}
 
-	if !operationAllowed {
+	if capabilities&requiredCapability == 0 {
 		return
 	}
+	ret.GrantingPolicies = permissions.GrantingPoliciesMap[requiredCapability]
 
 	if permissions.MaxWrappingTTL > 0 {
 		if req.WrapInfo == nil || req.WrapInfo.TTL > permissions.MaxWrappingTTL {
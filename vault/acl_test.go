@@ -359,6 +359,64 @@ func testLayeredACL(t *testing.T, acl *ACL, ns *namespace.Namespace) {
 	}
 }
 
+func TestACL_PolicyResults(t *testing.T) {
+	policy1, err := ParseACLPolicy(namespace.RootNamespace, aclPolicy)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	policy2, err := ParseACLPolicy(namespace.RootNamespace, aclPolicy2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	acl, err := NewACL(namespace.RootContext(nil), []*Policy{policy1, policy2})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	dev := logical.PolicyInfo{Name: "DeV", NamespaceID: namespace.RootNamespaceID, Type: "acl"}
+	ops := logical.PolicyInfo{Name: "OpS", NamespaceID: namespace.RootNamespaceID, Type: "acl"}
+
+	tcases := []struct {
+		op        logical.Operation
+		path      string
+		allowed   bool
+		granting  []logical.PolicyInfo
+		denying   []logical.PolicyInfo
+		rulePath  string
+		matchType string
+	}{
+		{logical.ReadOperation, "dev/foo", true, []logical.PolicyInfo{dev}, nil, "dev/*", "glob"},
+		{logical.ReadOperation, "dev/hide/foo", false, nil, []logical.PolicyInfo{ops}, "dev/hide/*", "glob"},
+		{logical.ReadOperation, "prod/foo", true, []logical.PolicyInfo{dev, ops}, nil, "prod/*", "glob"},
+		{logical.UpdateOperation, "prod/foo", true, []logical.PolicyInfo{ops}, nil, "prod/*", "glob"},
+		{logical.ReadOperation, "foo/bar", false, nil, []logical.PolicyInfo{ops}, "foo/bar", "exact"},
+		{logical.UpdateOperation, "sys/seal", true, []logical.PolicyInfo{ops}, nil, "sys/seal", "exact"},
+		{logical.ReadOperation, "test/foo/segment", true, []logical.PolicyInfo{dev}, nil, "test/+/segment", "segment_wildcard"},
+		{logical.ReadOperation, "nope", false, nil, nil, "", ""},
+	}
+
+	for _, tc := range tcases {
+		request := &logical.Request{
+			Operation: tc.op,
+			Path:      tc.path,
+		}
+
+		authResults := acl.AllowOperation(namespace.RootContext(nil), request, false)
+		if authResults.Allowed != tc.allowed {
+			t.Fatalf("bad: case %s %s: allowed %v", tc.op, tc.path, authResults.Allowed)
+		}
+		if !reflect.DeepEqual(authResults.GrantingPolicies, tc.granting) {
+			t.Fatalf("bad: case %s %s: granting %#v", tc.op, tc.path, authResults.GrantingPolicies)
+		}
+		if !reflect.DeepEqual(authResults.DenyingPolicies, tc.denying) {
+			t.Fatalf("bad: case %s %s: denying %#v", tc.op, tc.path, authResults.DenyingPolicies)
+		}
+		if authResults.RulePath != tc.rulePath || authResults.MatchType != tc.matchType {
+			t.Fatalf("bad: case %s %s: rule %q %q", tc.op, tc.path, authResults.RulePath, authResults.MatchType)
+		}
+	}
+}
+
 func TestACL_PolicyMerge(t *testing.T) {
 	t.Run("root-ns", func(t *testing.T) {
 		t.Parallel()
@@ -5,8 +5,10 @@ import (
 	"crypto/sha256"
 	"errors"
 	"fmt"
+	"net/http"
 	"strings"
 
+	"github.com/hashicorp/errwrap"
 	uuid "github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/vault/audit"
 	"github.com/hashicorp/vault/helper/namespace"
@@ -93,6 +95,15 @@ func (c *Core) enableAudit(ctx context.Context, entry *MountEntry, updateStorage
 	view.setReadOnlyErr(logical.ErrSetupReadOnly)
 	defer view.setReadOnlyErr(origViewReadOnlyErr)
 
+	filter, err := parseAuditFilter(entry)
+	if err != nil {
+		return logical.CodedError(http.StatusBadRequest, err.Error())
+	}
+	async, err := audit.ParseAsyncConfig(entry.Options)
+	if err != nil {
+		return logical.CodedError(http.StatusBadRequest, err.Error())
+	}
+
 	// Lookup the new backend
 	backend, err := c.newAuditBackend(ctx, entry, view, entry.Options)
 	if err != nil {
@@ -107,6 +118,7 @@ func (c *Core) enableAudit(ctx context.Context, entry *MountEntry, updateStorage
 
 	ns, err := namespace.FromContext(ctx)
 	if err != nil {
+		closeAuditBackend(backend)
 		return err
 	}
 	entry.NamespaceID = ns.ID
@@ -114,6 +126,7 @@ func (c *Core) enableAudit(ctx context.Context, entry *MountEntry, updateStorage
 
 	if updateStorage {
 		if err := c.persistAudit(ctx, newTable, entry.Local); err != nil {
+			closeAuditBackend(backend)
 			return errors.New("failed to update audit table")
 		}
 	}
@@ -121,7 +134,7 @@ func (c *Core) enableAudit(ctx context.Context, entry *MountEntry, updateStorage
 	c.audit = newTable
 
 	// Register the backend
-	c.auditBroker.Register(entry.Path, backend, view, entry.Local)
+	c.auditBroker.Register(entry.Path, backend, view, entry.Local, filter, async)
 	if c.logger.IsInfo() {
 		c.logger.Info("enabled audit backend", "path", entry.Path, "type", entry.Type)
 	}
@@ -351,6 +364,7 @@ func (c *Core) setupAudits(ctx context.Context) error {
 	brokerLogger := c.baseLogger.Named("audit")
 	c.AddLogger(brokerLogger)
 	broker := NewAuditBroker(brokerLogger)
+	broker.mountEntryFunc = c.router.MatchingMountEntry
 
 	c.auditLock.Lock()
 	defer c.auditLock.Unlock()
@@ -372,6 +386,17 @@ func (c *Core) setupAudits(ctx context.Context) error {
 			view.setReadOnlyErr(origViewReadOnlyErr)
 		})
 
+		filter, err := parseAuditFilter(entry)
+		if err != nil {
+			c.logger.Error("failed to parse audit filter", "path", entry.Path, "error", err)
+			continue
+		}
+		async, err := audit.ParseAsyncConfig(entry.Options)
+		if err != nil {
+			c.logger.Error("failed to parse audit async options", "path", entry.Path, "error", err)
+			continue
+		}
+
 		// Initialize the backend
 		backend, err := c.newAuditBackend(ctx, entry, view, entry.Options)
 		if err != nil {
@@ -384,7 +409,7 @@ func (c *Core) setupAudits(ctx context.Context) error {
 		}
 
 		// Mount the backend
-		broker.Register(entry.Path, backend, view, entry.Local)
+		broker.Register(entry.Path, backend, view, entry.Local, filter, async)
 
 		successCount++
 	}
@@ -410,6 +435,10 @@ func (c *Core) teardownAudits() error {
 		}
 	}
 
+	if c.auditBroker != nil {
+		c.auditBroker.Close()
+	}
+
 	c.audit = nil
 	c.auditBroker = nil
 	return nil
@@ -419,8 +448,8 @@ func (c *Core) teardownAudits() error {
 // audit lock needs to be held before calling this.
 func (c *Core) removeAuditReloadFunc(entry *MountEntry) {
 	switch entry.Type {
-	case "file":
-		key := "audit_file|" + entry.Path
+	case "file", "http":
+		key := "audit_" + entry.Type + "|" + entry.Path
 		c.reloadFuncsLock.Lock()
 
 		if c.logger.IsDebug() {
@@ -433,6 +462,28 @@ func (c *Core) removeAuditReloadFunc(entry *MountEntry) {
 	}
 }
 
+// parseAuditFilter parses the filter option of an audit backend, returning
+// nil if it is not set
+func parseAuditFilter(entry *MountEntry) (*audit.Filter, error) {
+	raw, ok := entry.Options[audit.FilterOption]
+	if !ok {
+		return nil, nil
+	}
+	filter, err := audit.ParseFilter(raw)
+	if err != nil {
+		return nil, errwrap.Wrapf("invalid audit filter: {{err}}", err)
+	}
+	return filter, nil
+}
+
+// closeAuditBackend closes a backend which was never registered with the
+// audit broker
+func closeAuditBackend(backend audit.Backend) {
+	if closer, ok := backend.(audit.Closer); ok {
+		closer.Close()
+	}
+}
+
 // newAuditBackend is used to create and configure a new audit backend by name
 func (c *Core) newAuditBackend(ctx context.Context, entry *MountEntry, view logical.Storage, conf map[string]string) (audit.Backend, error) {
 	f, ok := c.auditBackends[entry.Type]
@@ -445,10 +496,14 @@ func (c *Core) newAuditBackend(ctx context.Context, entry *MountEntry, view logi
 		Location: salt.DefaultLocation,
 	}
 
+	auditLogger := c.baseLogger.Named("audit")
+	c.AddLogger(auditLogger)
+
 	be, err := f(ctx, &audit.BackendConfig{
 		SaltView:   view,
 		SaltConfig: saltConfig,
 		Config:     conf,
+		Logger:     auditLogger.With("path", entry.Path),
 	})
 	if err != nil {
 		return nil, err
@@ -457,9 +512,6 @@ func (c *Core) newAuditBackend(ctx context.Context, entry *MountEntry, view logi
 		return nil, fmt.Errorf("nil backend returned from %q factory function", entry.Type)
 	}
 
-	auditLogger := c.baseLogger.Named("audit")
-	c.AddLogger(auditLogger)
-
 	switch entry.Type {
 	case "file":
 		key := "audit_file|" + entry.Path
@@ -480,6 +532,26 @@ func (c *Core) newAuditBackend(ctx context.Context, entry *MountEntry, view logi
 			return be.Reload(ctx)
 		})
 
+		c.reloadFuncsLock.Unlock()
+	case "http":
+		key := "audit_http|" + entry.Path
+
+		c.reloadFuncsLock.Lock()
+
+		if auditLogger.IsDebug() {
+			auditLogger.Debug("adding reload function", "path", entry.Path)
+			if entry.Options != nil {
+				auditLogger.Debug("http backend options", "path", entry.Path, "url", entry.Options["url"], "spool_dir", entry.Options["spool_dir"])
+			}
+		}
+
+		c.reloadFuncs[key] = append(c.reloadFuncs[key], func(map[string]interface{}) error {
+			if auditLogger.IsInfo() {
+				auditLogger.Info("reloading http audit backend", "path", entry.Path)
+			}
+			return be.Reload(ctx)
+		})
+
 		c.reloadFuncsLock.Unlock()
 	case "socket":
 		if auditLogger.IsDebug() {
@@ -10,6 +10,7 @@ import (
 	log "github.com/hashicorp/go-hclog"
 	multierror "github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/vault/audit"
+	"github.com/hashicorp/vault/helper/namespace"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
@@ -17,6 +18,12 @@ type backendEntry struct {
 	backend audit.Backend
 	view    *BarrierView
 	local   bool
+
+	// filter, if set, selects the requests logged by the backend
+	filter *audit.Filter
+
+	// queue, if set, delivers the entries of the backend asynchronously
+	queue *auditQueue
 }
 
 // AuditBroker is used to provide a single ingest interface to auditable
@@ -25,6 +32,11 @@ type AuditBroker struct {
 	sync.RWMutex
 	backends map[string]backendEntry
 	logger   log.Logger
+
+	// mountEntryFunc returns the mount entry of a path, which is used to
+	// evaluate the filters of the backends on requests that have not been
+	// routed yet
+	mountEntryFunc func(ctx context.Context, path string) *MountEntry
 }
 
 // NewAuditBroker creates a new audit broker
@@ -36,24 +48,60 @@ func NewAuditBroker(log log.Logger) *AuditBroker {
 	return b
 }
 
-// Register is used to add new audit backend to the broker
-func (a *AuditBroker) Register(name string, b audit.Backend, v *BarrierView, local bool) {
+// Register is used to add new audit backend to the broker. If async is set,
+// the entries of the backend are queued and logged by a worker.
+func (a *AuditBroker) Register(name string, b audit.Backend, v *BarrierView, local bool, filter *audit.Filter, async *audit.AsyncConfig) {
 	a.Lock()
 	defer a.Unlock()
-	a.backends[name] = backendEntry{
+	be := backendEntry{
 		backend: b,
 		view:    v,
 		local:   local,
+		filter:  filter,
 	}
+	if async != nil {
+		be.queue = newAuditQueue(name, b, async, a.logger)
+	}
+	a.backends[name] = be
 }
 
 // Deregister is used to remove an audit backend from the broker
 func (a *AuditBroker) Deregister(name string) {
 	a.Lock()
 	defer a.Unlock()
+	if be, ok := a.backends[name]; ok {
+		a.closeBackend(name, be)
+	}
 	delete(a.backends, name)
 }
 
+// Close closes the audit backends of the broker which hold resources. The
+// broker is not used afterwards.
+func (a *AuditBroker) Close() {
+	a.Lock()
+	defer a.Unlock()
+	for name, be := range a.backends {
+		a.closeBackend(name, be)
+	}
+}
+
+// closeBackend logs the queued entries of the backend, if any, and closes it.
+// The lock must be held before calling this, so that nothing is queued
+// afterwards.
+func (a *AuditBroker) closeBackend(name string, be backendEntry) {
+	if be.queue != nil {
+		be.queue.close()
+	}
+
+	closer, ok := be.backend.(audit.Closer)
+	if !ok {
+		return
+	}
+	if err := closer.Close(); err != nil {
+		a.logger.Error("failed to close audit backend", "path", name, "error", err)
+	}
+}
+
 // IsRegistered is used to check if a given audit backend is registered
 func (a *AuditBroker) IsRegistered(name string) bool {
 	a.RLock()
@@ -85,8 +133,68 @@ func (a *AuditBroker) GetHash(ctx context.Context, name string, input string) (s
 	return be.backend.GetHash(ctx, input)
 }
 
+// HashChainKey returns the key of the hash chain of the given backend
+func (a *AuditBroker) HashChainKey(ctx context.Context, name string) ([]byte, error) {
+	a.RLock()
+	defer a.RUnlock()
+	be, ok := a.backends[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown audit backend %q", name)
+	}
+	chainer, ok := be.backend.(audit.HashChainer)
+	if !ok {
+		return nil, fmt.Errorf("audit backend %q does not support hash chaining", name)
+	}
+
+	return chainer.HashChainKey(ctx)
+}
+
+// filterInput returns the fields of the request the filters of the backends
+// are evaluated against
+func (a *AuditBroker) filterInput(ctx context.Context, req *logical.Request) *audit.FilterInput {
+	in := &audit.FilterInput{
+		MountType: req.MountType,
+		MountPath: req.MountPoint,
+		Path:      req.Path,
+		Operation: string(req.Operation),
+	}
+
+	if ns, err := namespace.FromContext(ctx); err == nil {
+		in.Namespace = ns.Path
+	}
+
+	// Requests are logged before being routed, in which case the mount is
+	// looked up
+	if in.MountType == "" && a.mountEntryFunc != nil {
+		if entry := a.mountEntryFunc(ctx, req.Path); entry != nil {
+			in.MountType = entry.Type
+			in.MountPath = entry.Path
+			if entry.Table == credentialTableType {
+				in.MountPath = credentialRoutePrefix + entry.Path
+			}
+		}
+	}
+
+	return in
+}
+
+// backendMatches reports whether the backend logs the request, computing the
+// input of the filters on first use
+func (a *AuditBroker) backendMatches(ctx context.Context, be backendEntry, req *logical.Request, in **audit.FilterInput) bool {
+	if be.filter == nil {
+		return true
+	}
+	if *in == nil {
+		*in = a.filterInput(ctx, req)
+	}
+	return be.filter.Evaluate(*in)
+}
+
 // LogRequest is used to ensure all the audit backends have an opportunity to
-// log the given request and that *at least one* succeeds.
+// log the given request and that *at least one* succeeds. Only the backends
+// whose filter matches the request log it, and at least one of them must
+// succeed. Asynchronous backends queue the request instead, and only count
+// towards this when no synchronous backend matches the request.
 func (a *AuditBroker) LogRequest(ctx context.Context, in *logical.LogInput, headersConfig *AuditedHeadersConfig) (ret error) {
 	defer metrics.MeasureSince([]string{"audit", "log_request"}, time.Now())
 	a.RLock()
@@ -121,8 +229,18 @@ func (a *AuditBroker) LogRequest(ctx context.Context, in *logical.LogInput, head
 	}()
 
 	// Ensure at least one backend logs
-	anyLogged := false
+	var r auditBrokerResult
+	var filterIn *audit.FilterInput
 	for name, be := range a.backends {
+		if !a.backendMatches(ctx, be, in.Request, &filterIn) {
+			continue
+		}
+		if be.queue != nil {
+			r.asyncMatched = true
+		} else {
+			r.syncMatched = true
+		}
+
 		in.Request.Headers = nil
 		transHeaders, thErr := headersConfig.ApplyConfig(ctx, headers, be.backend.GetHash)
 		if thErr != nil {
@@ -131,24 +249,30 @@ func (a *AuditBroker) LogRequest(ctx context.Context, in *logical.LogInput, head
 		}
 		in.Request.Headers = transHeaders
 
+		if be.queue != nil {
+			r.enqueued(name, be.queue, be.queue.enqueue(ctx, in, false), a.logger)
+			continue
+		}
+
 		start := time.Now()
 		lrErr := be.backend.LogRequest(ctx, in)
 		metrics.MeasureSince([]string{"audit", name, "log_request"}, start)
 		if lrErr != nil {
 			a.logger.Error("backend failed to log request", "backend", name, "error", lrErr)
 		} else {
-			anyLogged = true
+			r.logged = true
 		}
 	}
-	if !anyLogged && len(a.backends) > 0 {
-		retErr = multierror.Append(retErr, fmt.Errorf("no audit backend succeeded in logging the request"))
+	if err := r.err("request"); err != nil {
+		retErr = multierror.Append(retErr, err)
 	}
 
 	return retErr.ErrorOrNil()
 }
 
 // LogResponse is used to ensure all the audit backends have an opportunity to
-// log the given response and that *at least one* succeeds.
+// log the given response and that *at least one* succeeds. As with requests,
+// only the backends whose filter matches the request log the response.
 func (a *AuditBroker) LogResponse(ctx context.Context, in *logical.LogInput, headersConfig *AuditedHeadersConfig) (ret error) {
 	defer metrics.MeasureSince([]string{"audit", "log_response"}, time.Now())
 	a.RLock()
@@ -177,8 +301,18 @@ func (a *AuditBroker) LogResponse(ctx context.Context, in *logical.LogInput, hea
 	}()
 
 	// Ensure at least one backend logs
-	anyLogged := false
+	var r auditBrokerResult
+	var filterIn *audit.FilterInput
 	for name, be := range a.backends {
+		if !a.backendMatches(ctx, be, in.Request, &filterIn) {
+			continue
+		}
+		if be.queue != nil {
+			r.asyncMatched = true
+		} else {
+			r.syncMatched = true
+		}
+
 		in.Request.Headers = nil
 		transHeaders, thErr := headersConfig.ApplyConfig(ctx, headers, be.backend.GetHash)
 		if thErr != nil {
@@ -187,22 +321,77 @@ func (a *AuditBroker) LogResponse(ctx context.Context, in *logical.LogInput, hea
 		}
 		in.Request.Headers = transHeaders
 
+		if be.queue != nil {
+			r.enqueued(name, be.queue, be.queue.enqueue(ctx, in, true), a.logger)
+			continue
+		}
+
 		start := time.Now()
 		lrErr := be.backend.LogResponse(ctx, in)
 		metrics.MeasureSince([]string{"audit", name, "log_response"}, start)
 		if lrErr != nil {
 			a.logger.Error("backend failed to log response", "backend", name, "error", lrErr)
 		} else {
-			anyLogged = true
+			r.logged = true
 		}
 	}
-	if !anyLogged && len(a.backends) > 0 {
-		retErr = multierror.Append(retErr, fmt.Errorf("no audit backend succeeded in logging the response"))
+	if err := r.err("response"); err != nil {
+		retErr = multierror.Append(retErr, err)
 	}
 
 	return retErr.ErrorOrNil()
 }
 
+// auditBrokerResult tracks the outcome of logging an entry to the backends
+type auditBrokerResult struct {
+	// syncMatched and logged are set if a synchronous backend matched the
+	// entry, and if one logged it
+	syncMatched bool
+	logged      bool
+
+	// asyncMatched and queued are set if an asynchronous backend matched the
+	// entry, and if one queued it or dropped it as its overflow policy allows
+	asyncMatched bool
+	queued       bool
+
+	// overflowed holds the asynchronous backends whose overflow policy fails
+	// the request and which could not queue the entry
+	overflowed []string
+}
+
+func (r *auditBrokerResult) enqueued(name string, q *auditQueue, err error, logger log.Logger) {
+	switch {
+	case err == nil:
+		r.queued = true
+	case err == errAuditQueueFull && q.config.Overflow == audit.OverflowDrop:
+		// Dropping the entry is the configured way of handling a full
+		// queue, so it doesn't fail the request; it's counted by the
+		// queue_dropped metric
+		r.queued = true
+	case err == errAuditQueueFull && q.config.Overflow == audit.OverflowFail:
+		r.overflowed = append(r.overflowed, name)
+	default:
+		logger.Error("backend failed to queue entry", "backend", name, "error", err)
+	}
+}
+
+// err returns the error failing the request, if any. The entry must be logged
+// by at least one of the synchronous backends which matched it, or, if only
+// asynchronous backends did, be queued or dropped by at least one of them.
+func (r *auditBrokerResult) err(kind string) error {
+	var retErr *multierror.Error
+	for _, name := range r.overflowed {
+		retErr = multierror.Append(retErr, fmt.Errorf("audit backend %q could not queue the %s: %v", name, kind, errAuditQueueFull))
+	}
+	switch {
+	case r.syncMatched && !r.logged:
+		retErr = multierror.Append(retErr, fmt.Errorf("no audit backend succeeded in logging the %s", kind))
+	case !r.syncMatched && r.asyncMatched && !r.queued:
+		retErr = multierror.Append(retErr, fmt.Errorf("no audit backend succeeded in queuing the %s", kind))
+	}
+	return retErr.ErrorOrNil()
+}
+
 func (a *AuditBroker) Invalidate(ctx context.Context, key string) {
 	// For now we ignore the key as this would only apply to salts. We just
 	// sort of brute force it on each one.
@@ -0,0 +1,216 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	log "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/audit"
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/mitchellh/copystructure"
+)
+
+var errAuditQueueFull = errors.New("audit queue is full")
+
+// auditQueueEntry is a request or response waiting to be logged
+type auditQueueEntry struct {
+	ctx        context.Context
+	in         *logical.LogInput
+	response   bool
+	enqueuedAt time.Time
+}
+
+// auditQueue delivers the entries of an asynchronous audit backend: they are
+// queued by the broker and logged in order by a worker
+type auditQueue struct {
+	name    string
+	backend audit.Backend
+	config  *audit.AsyncConfig
+	logger  log.Logger
+
+	entries chan *auditQueueEntry
+	doneCh  chan struct{}
+}
+
+func newAuditQueue(name string, backend audit.Backend, config *audit.AsyncConfig, logger log.Logger) *auditQueue {
+	q := &auditQueue{
+		name:    name,
+		backend: backend,
+		config:  config,
+		logger:  logger,
+		entries: make(chan *auditQueueEntry, config.QueueSize),
+		doneCh:  make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// enqueue queues a copy of the input, applying the overflow policy if the
+// queue is full
+func (q *auditQueue) enqueue(ctx context.Context, in *logical.LogInput, response bool) error {
+	cp, err := copyLogInput(in)
+	if err != nil {
+		return err
+	}
+
+	// The worker must not be affected by the cancellation of the request
+	entryCtx := context.Background()
+	if ns, err := namespace.FromContext(ctx); err == nil {
+		entryCtx = namespace.ContextWithNamespace(entryCtx, ns)
+	}
+
+	entry := &auditQueueEntry{
+		ctx:        entryCtx,
+		in:         cp,
+		response:   response,
+		enqueuedAt: time.Now(),
+	}
+
+	if q.config.Overflow == audit.OverflowBlock {
+		select {
+		case q.entries <- entry:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	} else {
+		select {
+		case q.entries <- entry:
+		default:
+			metrics.IncrCounter([]string{"audit", q.name, "queue_dropped"}, 1)
+			return errAuditQueueFull
+		}
+	}
+
+	metrics.SetGauge([]string{"audit", q.name, "queue_depth"}, float32(len(q.entries)))
+	return nil
+}
+
+func (q *auditQueue) run() {
+	defer close(q.doneCh)
+
+	for entry := range q.entries {
+		metrics.SetGauge([]string{"audit", q.name, "queue_depth"}, float32(len(q.entries)))
+
+		var err error
+		if entry.response {
+			err = q.backend.LogResponse(entry.ctx, entry.in)
+		} else {
+			err = q.backend.LogRequest(entry.ctx, entry.in)
+		}
+		metrics.MeasureSince([]string{"audit", q.name, "delivery_latency"}, entry.enqueuedAt)
+		if err != nil {
+			metrics.IncrCounter([]string{"audit", q.name, "delivery_failure"}, 1)
+			q.logger.Error("backend failed to log queued entry", "backend", q.name, "error", err)
+		}
+	}
+}
+
+// close logs the queued entries and stops the worker. Nothing must be queued
+// afterwards.
+func (q *auditQueue) close() {
+	close(q.entries)
+	<-q.doneCh
+}
+
+// copyLogInput deep copies the input, so that it can be logged later while
+// the request moves on and modifies it
+func copyLogInput(in *logical.LogInput) (*logical.LogInput, error) {
+	cp := *in
+	cp.NonHMACReqDataKeys = copyStrings(in.NonHMACReqDataKeys)
+	cp.NonHMACRespDataKeys = copyStrings(in.NonHMACRespDataKeys)
+
+	if in.Auth != nil {
+		auth, err := copystructure.Copy(in.Auth)
+		if err != nil {
+			return nil, err
+		}
+		cp.Auth = auth.(*logical.Auth)
+	}
+
+	if in.Request != nil {
+		req, err := copyRequest(in.Request)
+		if err != nil {
+			return nil, err
+		}
+		cp.Request = req
+	}
+
+	if in.Response != nil {
+		resp, err := copystructure.Copy(in.Response)
+		if err != nil {
+			return nil, err
+		}
+		cp.Response = resp.(*logical.Response)
+	}
+
+	return &cp, nil
+}
+
+// copyRequest deep copies the fields of a request which are logged. The
+// request holds unexported fields, storage and HTTP state which can't be
+// copied with copystructure, so it is copied field by field.
+func copyRequest(in *logical.Request) (*logical.Request, error) {
+	req := *in
+
+	if in.Data != nil {
+		data, err := copystructure.Copy(in.Data)
+		if err != nil {
+			return nil, err
+		}
+		req.Data = data.(map[string]interface{})
+	}
+
+	if in.Headers != nil {
+		headers, err := copystructure.Copy(in.Headers)
+		if err != nil {
+			return nil, err
+		}
+		req.Headers = headers.(map[string][]string)
+	}
+
+	if in.MFACreds != nil {
+		creds, err := copystructure.Copy(in.MFACreds)
+		if err != nil {
+			return nil, err
+		}
+		req.MFACreds = creds.(logical.MFACreds)
+	}
+
+	if in.Auth != nil {
+		auth, err := copystructure.Copy(in.Auth)
+		if err != nil {
+			return nil, err
+		}
+		req.Auth = auth.(*logical.Auth)
+	}
+
+	if in.Secret != nil {
+		secret, err := copystructure.Copy(in.Secret)
+		if err != nil {
+			return nil, err
+		}
+		req.Secret = secret.(*logical.Secret)
+	}
+
+	if in.WrapInfo != nil {
+		wrapInfo := *in.WrapInfo
+		req.WrapInfo = &wrapInfo
+	}
+
+	if in.Connection != nil {
+		conn := *in.Connection
+		req.Connection = &conn
+	}
+
+	return &req, nil
+}
+
+func copyStrings(in []string) []string {
+	if in == nil {
+		return nil
+	}
+	return append([]string(nil), in...)
+}
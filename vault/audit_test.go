@@ -10,6 +10,7 @@ import (
 
 	"errors"
 
+	"github.com/go-test/deep"
 	"github.com/hashicorp/errwrap"
 	log "github.com/hashicorp/go-hclog"
 	uuid "github.com/hashicorp/go-uuid"
@@ -17,6 +18,7 @@ import (
 	"github.com/hashicorp/vault/helper/namespace"
 	"github.com/hashicorp/vault/sdk/helper/jsonutil"
 	"github.com/hashicorp/vault/sdk/helper/logging"
+	"github.com/hashicorp/vault/sdk/helper/wrapping"
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/mitchellh/copystructure"
 )
@@ -357,8 +359,8 @@ func TestAuditBroker_LogRequest(t *testing.T) {
 	b := NewAuditBroker(l)
 	a1 := &NoopAudit{}
 	a2 := &NoopAudit{}
-	b.Register("foo", a1, nil, false)
-	b.Register("bar", a2, nil, false)
+	b.Register("foo", a1, nil, false, nil, nil)
+	b.Register("bar", a2, nil, false, nil, nil)
 
 	auth := &logical.Auth{
 		ClientToken: "foo",
@@ -443,8 +445,8 @@ func TestAuditBroker_LogResponse(t *testing.T) {
 	b := NewAuditBroker(l)
 	a1 := &NoopAudit{}
 	a2 := &NoopAudit{}
-	b.Register("foo", a1, nil, false)
-	b.Register("bar", a2, nil, false)
+	b.Register("foo", a1, nil, false, nil, nil)
+	b.Register("bar", a2, nil, false, nil, nil)
 
 	auth := &logical.Auth{
 		NumUses:     10,
@@ -549,8 +551,8 @@ func TestAuditBroker_AuditHeaders(t *testing.T) {
 	view := NewBarrierView(barrier, "headers/")
 	a1 := &NoopAudit{}
 	a2 := &NoopAudit{}
-	b.Register("foo", a1, nil, false)
-	b.Register("bar", a2, nil, false)
+	b.Register("foo", a1, nil, false, nil, nil)
+	b.Register("bar", a2, nil, false, nil, nil)
 
 	auth := &logical.Auth{
 		ClientToken: "foo",
@@ -624,3 +626,392 @@ func TestAuditBroker_AuditHeaders(t *testing.T) {
 		t.Fatalf("err: %v", err)
 	}
 }
+
+func TestCore_EnableAudit_Filter(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+
+	var backends []*NoopAudit
+	c.auditBackends["noop"] = func(ctx context.Context, config *audit.BackendConfig) (audit.Backend, error) {
+		be := &NoopAudit{
+			Config: config,
+		}
+		backends = append(backends, be)
+		return be, nil
+	}
+
+	// An invalid filter is rejected
+	err := c.enableAudit(namespace.RootContext(nil), &MountEntry{
+		Table:   auditTableType,
+		Path:    "bad",
+		Type:    "noop",
+		Options: map[string]string{"filter": `mount_type = "token"`},
+	}, true)
+	if err == nil {
+		t.Fatal("expected an error enabling an audit backend with an invalid filter")
+	}
+
+	for _, me := range []*MountEntry{
+		{
+			Table: auditTableType,
+			Path:  "all",
+			Type:  "noop",
+		},
+		{
+			Table:   auditTableType,
+			Path:    "siem",
+			Type:    "noop",
+			Options: map[string]string{"filter": `mount_type == "token" or path matches "sys/*"`},
+		},
+	} {
+		if err := c.enableAudit(namespace.RootContext(nil), me, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	all, siem := backends[0], backends[1]
+
+	for _, path := range []string{"secret/foo", "sys/mounts", "auth/token/lookup-self"} {
+		req := logical.TestRequest(t, logical.ReadOperation, path)
+		req.ClientToken = root
+		if _, err := c.HandleRequest(namespace.RootContext(nil), req); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+	}
+
+	paths := func(reqs []*logical.Request) []string {
+		var paths []string
+		for _, req := range reqs {
+			paths = append(paths, req.Path)
+		}
+		return paths
+	}
+
+	if diff := deep.Equal(paths(all.Req), []string{"secret/foo", "sys/mounts", "auth/token/lookup-self"}); diff != nil {
+		t.Fatal(diff)
+	}
+	if diff := deep.Equal(paths(siem.Req), []string{"sys/mounts", "auth/token/lookup-self"}); diff != nil {
+		t.Fatal(diff)
+	}
+	if diff := deep.Equal(paths(siem.RespReq), []string{"sys/mounts", "auth/token/lookup-self"}); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func TestAuditBroker_Filter(t *testing.T) {
+	b := NewAuditBroker(logging.NewVaultLogger(log.Trace))
+	b.mountEntryFunc = func(ctx context.Context, path string) *MountEntry {
+		if strings.HasPrefix(path, "sys/") {
+			return &MountEntry{Table: mountTableType, Type: "system", Path: "sys/"}
+		}
+		return &MountEntry{Table: mountTableType, Type: "kv", Path: "secret/"}
+	}
+
+	filter, err := audit.ParseFilter(`mount_type == "system"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a1 := &NoopAudit{}
+	a2 := &NoopAudit{}
+	b.Register("all", a1, nil, false, nil, nil)
+	b.Register("sys", a2, nil, false, filter, nil)
+
+	headersConf := &AuditedHeadersConfig{
+		Headers: make(map[string]*auditedHeaderSettings),
+	}
+	logRequest := func(path string) error {
+		return b.LogRequest(namespace.RootContext(nil), &logical.LogInput{
+			Request: &logical.Request{
+				Operation: logical.ReadOperation,
+				Path:      path,
+			},
+		}, headersConf)
+	}
+
+	if err := logRequest("secret/foo"); err != nil {
+		t.Fatal(err)
+	}
+	if len(a1.Req) != 1 || len(a2.Req) != 0 {
+		t.Fatalf("expected only the unfiltered backend to log the request, got %d and %d", len(a1.Req), len(a2.Req))
+	}
+
+	// A request is logged if one of the matching backends succeeds
+	a1.ReqErr = errors.New("failed")
+	if err := logRequest("sys/mounts"); err != nil {
+		t.Fatal(err)
+	}
+	if len(a2.Req) != 1 {
+		t.Fatalf("expected the filtered backend to log the request, got %d", len(a2.Req))
+	}
+
+	// The failure of the only matching backend fails the request, even if
+	// another backend would have succeeded
+	if err := logRequest("secret/foo"); !errwrap.Contains(err, "no audit backend succeeded in logging the request") {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+// slowAudit is a NoopAudit which waits to be released before logging
+// requests
+type slowAudit struct {
+	NoopAudit
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *slowAudit) LogRequest(ctx context.Context, in *logical.LogInput) error {
+	s.started <- struct{}{}
+	<-s.release
+	return s.NoopAudit.LogRequest(ctx, in)
+}
+
+func TestAuditBroker_Async(t *testing.T) {
+	headersConf := &AuditedHeadersConfig{
+		Headers: make(map[string]*auditedHeaderSettings),
+	}
+	logRequest := func(b *AuditBroker, ctx context.Context, path string) error {
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data: map[string]interface{}{
+				"foo": "bar",
+			},
+		}
+		err := b.LogRequest(ctx, &logical.LogInput{Request: req}, headersConf)
+
+		// The queued copies must not be affected by later changes
+		req.Path = "modified"
+		req.Data["foo"] = "modified"
+		return err
+	}
+
+	// setup registers a slow asynchronous backend with a queue of one entry,
+	// and fills its queue
+	setup := func(t *testing.T, overflow audit.OverflowPolicy, withSync bool) (*AuditBroker, *slowAudit, *NoopAudit) {
+		t.Helper()
+
+		b := NewAuditBroker(logging.NewVaultLogger(log.Trace))
+		slow := &slowAudit{
+			started: make(chan struct{}, 10),
+			release: make(chan struct{}),
+		}
+		b.Register("slow", slow, nil, false, nil, &audit.AsyncConfig{
+			QueueSize: 1,
+			Overflow:  overflow,
+		})
+		noop := &NoopAudit{}
+		if withSync {
+			b.Register("sync", noop, nil, false, nil, nil)
+		}
+
+		if err := logRequest(b, namespace.RootContext(nil), "first"); err != nil {
+			t.Fatal(err)
+		}
+		<-slow.started
+		if err := logRequest(b, namespace.RootContext(nil), "second"); err != nil {
+			t.Fatal(err)
+		}
+		return b, slow, noop
+	}
+
+	t.Run("drop", func(t *testing.T) {
+		b, slow, noop := setup(t, audit.OverflowDrop, true)
+
+		// The entry is dropped, and the synchronous backend logs it
+		if err := logRequest(b, namespace.RootContext(nil), "third"); err != nil {
+			t.Fatal(err)
+		}
+		if len(noop.Req) != 3 {
+			t.Fatalf("expected the synchronous backend to log 3 requests, got %d", len(noop.Req))
+		}
+
+		close(slow.release)
+		b.Deregister("slow")
+
+		var paths []string
+		for _, req := range slow.Req {
+			paths = append(paths, req.Path)
+			if req.Data["foo"] != "bar" {
+				t.Fatalf("bad data: %#v", req.Data)
+			}
+		}
+		if diff := deep.Equal(paths, []string{"first", "second"}); diff != nil {
+			t.Fatal(diff)
+		}
+	})
+
+	t.Run("drop_only_async", func(t *testing.T) {
+		b, slow, _ := setup(t, audit.OverflowDrop, false)
+
+		// Dropping the entry is how the only backend handles a full queue,
+		// so the request doesn't fail
+		if err := logRequest(b, namespace.RootContext(nil), "third"); err != nil {
+			t.Fatal(err)
+		}
+
+		close(slow.release)
+		b.Deregister("slow")
+
+		var paths []string
+		for _, req := range slow.Req {
+			paths = append(paths, req.Path)
+		}
+		if diff := deep.Equal(paths, []string{"first", "second"}); diff != nil {
+			t.Fatal(diff)
+		}
+	})
+
+	t.Run("fail", func(t *testing.T) {
+		b, slow, noop := setup(t, audit.OverflowFail, true)
+		defer func() {
+			close(slow.release)
+			b.Close()
+		}()
+
+		// The request fails, even though the synchronous backend logs it
+		err := logRequest(b, namespace.RootContext(nil), "third")
+		if err == nil || !strings.Contains(err.Error(), `audit backend "slow" could not queue the request`) {
+			t.Fatalf("err: %v", err)
+		}
+		if len(noop.Req) != 3 {
+			t.Fatalf("expected the synchronous backend to log 3 requests, got %d", len(noop.Req))
+		}
+	})
+
+	t.Run("block", func(t *testing.T) {
+		b, slow, _ := setup(t, audit.OverflowBlock, false)
+
+		ctx, cancel := context.WithCancel(namespace.RootContext(nil))
+		time.AfterFunc(50*time.Millisecond, cancel)
+		err := logRequest(b, ctx, "third")
+		if !errwrap.Contains(err, "no audit backend succeeded in queuing the request") {
+			t.Fatalf("err: %v", err)
+		}
+
+		// The request waits for the queue to have room
+		errCh := make(chan error)
+		go func() {
+			errCh <- logRequest(b, namespace.RootContext(nil), "fourth")
+		}()
+		select {
+		case err := <-errCh:
+			t.Fatalf("expected the request to wait, got %v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(slow.release)
+		if err := <-errCh; err != nil {
+			t.Fatal(err)
+		}
+		b.Close()
+
+		if len(slow.Req) != 3 {
+			t.Fatalf("expected 3 requests to be logged, got %d", len(slow.Req))
+		}
+	})
+}
+
+// encodingAudit is an audit backend encoding every input, so that the race
+// detector notices inputs shared with the request
+type encodingAudit struct {
+	NoopAudit
+	entries []string
+}
+
+func (e *encodingAudit) LogRequest(ctx context.Context, in *logical.LogInput) error {
+	return e.encode(in)
+}
+
+func (e *encodingAudit) LogResponse(ctx context.Context, in *logical.LogInput) error {
+	return e.encode(in)
+}
+
+func (e *encodingAudit) encode(in *logical.LogInput) error {
+	encoded, err := jsonutil.EncodeJSON(in)
+	if err != nil {
+		return err
+	}
+	e.entries = append(e.entries, string(encoded))
+	return nil
+}
+
+func TestAuditBroker_AsyncCopy(t *testing.T) {
+	b := NewAuditBroker(logging.NewVaultLogger(log.Trace))
+	backend := &encodingAudit{}
+	b.Register("async", backend, nil, false, nil, &audit.AsyncConfig{
+		QueueSize: 10,
+		Overflow:  audit.OverflowBlock,
+	})
+
+	auth := &logical.Auth{
+		ClientToken: "foo",
+		Policies:    []string{"original"},
+		Metadata:    map[string]string{"key": "original"},
+		PolicyResults: &logical.PolicyResults{
+			Allowed:          true,
+			GrantingPolicies: []logical.PolicyInfo{{Name: "original"}},
+		},
+	}
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "secret/foo",
+		Data:      map[string]interface{}{"key": "original"},
+		Headers:   map[string][]string{"X-Key": {"original"}},
+		Auth:      auth,
+		Secret:    &logical.Secret{LeaseID: "original"},
+		WrapInfo:  &logical.RequestWrapInfo{Format: "original"},
+	}
+	resp := &logical.Response{
+		Data:     map[string]interface{}{"key": "original"},
+		Headers:  map[string][]string{"X-Key": {"original"}},
+		Warnings: []string{"original"},
+		Auth: &logical.Auth{
+			Policies: []string{"original"},
+			Metadata: map[string]string{"key": "original"},
+		},
+		Secret: &logical.Secret{LeaseID: "original"},
+		WrapInfo: &wrapping.ResponseWrapInfo{
+			Token: "original",
+		},
+	}
+	in := &logical.LogInput{
+		Auth:     auth,
+		Request:  req,
+		Response: resp,
+	}
+
+	headersConf := &AuditedHeadersConfig{
+		Headers: make(map[string]*auditedHeaderSettings),
+	}
+	if err := b.LogRequest(namespace.RootContext(nil), in, headersConf); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.LogResponse(namespace.RootContext(nil), in, headersConf); err != nil {
+		t.Fatal(err)
+	}
+
+	// Modify the input while the worker logs the queued copies
+	auth.Policies[0] = "modified"
+	auth.Metadata["key"] = "modified"
+	auth.PolicyResults.GrantingPolicies[0].Name = "modified"
+	req.Data["key"] = "modified"
+	req.Headers["X-Key"][0] = "modified"
+	req.Secret.LeaseID = "modified"
+	req.WrapInfo.Format = "modified"
+	resp.Data["key"] = "modified"
+	resp.Headers["X-Key"][0] = "modified"
+	resp.Warnings[0] = "modified"
+	resp.Auth.Policies[0] = "modified"
+	resp.Auth.Metadata["key"] = "modified"
+	resp.Secret.LeaseID = "modified"
+	resp.WrapInfo.Token = "modified"
+
+	b.Close()
+
+	if len(backend.entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(backend.entries))
+	}
+	for _, entry := range backend.entries {
+		if strings.Contains(entry, "modified") {
+			t.Fatalf("expected the queued copies to be unaffected, got %s", entry)
+		}
+	}
+}
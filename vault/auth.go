@@ -337,6 +337,13 @@ func (c *Core) disableCredentialInternal(ctx context.Context, path string, updat
 		return err
 	}
 
+	if updateStorage {
+		// Remove the quotas of the mount
+		if err := c.quotaManager.HandleMountRemoved(ctx, ns.Path, path); err != nil {
+			return err
+		}
+	}
+
 	removePathCheckers(c, entry, viewPath)
 
 	if c.logger.IsInfo() {
@@ -44,6 +44,7 @@ import (
 	sr "github.com/hashicorp/vault/serviceregistration"
 	"github.com/hashicorp/vault/shamir"
 	"github.com/hashicorp/vault/vault/cluster"
+	"github.com/hashicorp/vault/vault/quotas"
 	vaultseal "github.com/hashicorp/vault/vault/seal"
 	cache "github.com/patrickmn/go-cache"
 	"google.golang.org/grpc"
@@ -402,6 +403,9 @@ type Core struct {
 	// CORS Information
 	corsConfig *CORSConfig
 
+	// quotaManager holds the quotas applied to requests
+	quotaManager *quotas.Manager
+
 	// The active set of upstream cluster addresses; stored via the Echo
 	// mechanism, loaded by the balancer
 	atomicPrimaryClusterAddrs *atomic.Value
@@ -794,6 +798,8 @@ func NewCore(conf *CoreConfig) (*Core, error) {
 		Enabled: new(uint32),
 	}
 
+	c.quotaManager = quotas.NewManager(c.logger.Named("quotas"))
+
 	if c.seal == nil {
 		c.seal = NewDefaultSeal(&vaultseal.Access{
 			Wrapper: aeadwrapper.NewWrapper(&wrapping.WrapperOptions{
@@ -1776,6 +1782,9 @@ func (s standardUnsealStrategy) unseal(ctx context.Context, logger log.Logger, c
 	if err := c.loadCORSConfig(ctx); err != nil {
 		return err
 	}
+	if err := c.setupQuotas(ctx); err != nil {
+		return err
+	}
 	if err := c.loadCurrentRequestCounters(ctx, time.Now()); err != nil {
 		return err
 	}
@@ -1812,6 +1821,7 @@ func (s standardUnsealStrategy) unseal(ctx context.Context, logger log.Logger, c
 		}
 	} else {
 		c.auditBroker = NewAuditBroker(c.logger)
+		c.auditBroker.mountEntryFunc = c.router.MatchingMountEntry
 	}
 
 	if c.getClusterListener() != nil && (c.ha != nil || shouldStartClusterListener(c)) {
@@ -1934,6 +1944,7 @@ func (c *Core) preSeal() error {
 	}
 	c.clusterParamsLock.Unlock()
 
+	c.teardownQuotas()
 	if err := c.teardownAudits(); err != nil {
 		result = multierror.Append(result, errwrap.Wrapf("error tearing down audits: {{err}}", err))
 	}
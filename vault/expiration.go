@@ -56,6 +56,11 @@ const (
 type pendingInfo struct {
 	exportLeaseTimes *leaseEntry
 	timer            *time.Timer
+
+	// namespacePath and mountPath are the scope the lease is counted in, for
+	// the lease count quotas
+	namespacePath string
+	mountPath     string
 }
 
 // ExpirationManager is used by the Core to manage leases. Secrets
@@ -240,10 +245,7 @@ func (m *ExpirationManager) invalidate(key string) {
 		// Clear from the pending expiration
 		leaseID := strings.TrimPrefix(key, leaseViewPrefix)
 		m.pendingLock.Lock()
-		if pending, ok := m.pending[leaseID]; ok {
-			pending.timer.Stop()
-			delete(m.pending, leaseID)
-		}
+		m.removePendingInternal(leaseID)
 		m.pendingLock.Unlock()
 	}
 }
@@ -654,10 +656,7 @@ func (m *ExpirationManager) revokeCommon(ctx context.Context, leaseID string, fo
 
 	// Clear the expiration handler
 	m.pendingLock.Lock()
-	if pending, ok := m.pending[leaseID]; ok {
-		pending.timer.Stop()
-		delete(m.pending, leaseID)
-	}
+	m.removePendingInternal(leaseID)
 	m.pendingLock.Unlock()
 
 	if m.logger.IsInfo() && !skipToken && m.logLeaseExpirations {
@@ -1092,6 +1091,8 @@ func (m *ExpirationManager) Register(ctx context.Context, req *logical.Request,
 		// want to revoke a generated secret (since an error means we may not
 		// be successfully tracking it), remove indexes, and delete the entry.
 		if retErr != nil {
+			m.releaseLease(le)
+
 			revokeCtx := namespace.ContextWithNamespace(m.quitContext, ns)
 			revResp, err := m.router.Route(revokeCtx, logical.RevokeRequest(req.Path, resp.Secret, resp.Data))
 			if err != nil {
@@ -1122,6 +1123,12 @@ func (m *ExpirationManager) Register(ctx context.Context, req *logical.Request,
 		}
 	}
 
+	// Reject the lease if it would exceed a lease count quota; the secret is
+	// revoked and the reservation released by the deferred rollback above
+	if err := m.reserveLease(le); err != nil {
+		return "", err
+	}
+
 	// Encode the entry
 	if err := m.persistEntry(ctx, le); err != nil {
 		return "", err
@@ -1209,8 +1216,13 @@ func (m *ExpirationManager) RegisterAuth(ctx context.Context, te *logical.TokenE
 		Version:     1,
 	}
 
+	if err := m.reserveLease(&le); err != nil {
+		return err
+	}
+
 	// Encode the entry
 	if err := m.persistEntry(ctx, &le); err != nil {
+		m.releaseLease(&le)
 		return err
 	}
 
@@ -1327,17 +1339,16 @@ func (m *ExpirationManager) updatePendingInternal(le *leaseEntry, leaseTotal tim
 	// If there is no expiry time, don't do anything
 	if le.ExpireTime.IsZero() {
 		// if the timer happened to exist, stop the time and delete it from the
-		// pending timers.
-		if ok {
-			pending.timer.Stop()
-			delete(m.pending, le.LeaseID)
-		}
+		// pending timers. Leases without a timer aren't counted.
+		m.removePendingInternal(le.LeaseID)
+		m.releaseLease(le)
 		return
 	}
 
 	// Create entry if it does not exist or reset if it does
 	if ok {
 		pending.timer.Reset(leaseTotal)
+		m.releaseLease(le)
 	} else {
 		timer := time.AfterFunc(leaseTotal, func() {
 			m.expireFunc(m.quitContext, m, le)
@@ -1345,6 +1356,14 @@ func (m *ExpirationManager) updatePendingInternal(le *leaseEntry, leaseTotal tim
 		pending = pendingInfo{
 			timer: timer,
 		}
+
+		// Count the lease, unless it was counted when it was reserved
+		pending.namespacePath, pending.mountPath = m.leaseQuotaScope(le)
+		if le.quotaReserved {
+			le.quotaReserved = false
+		} else {
+			m.core.quotaManager.LeaseCreated(pending.namespacePath, pending.mountPath)
+		}
 	}
 
 	// Extend the timer by the lease total
@@ -1353,6 +1372,57 @@ func (m *ExpirationManager) updatePendingInternal(le *leaseEntry, leaseTotal tim
 	m.pending[le.LeaseID] = pending
 }
 
+// removePendingInternal stops the timer of a lease and removes it from the
+// pending timers; do not call this without a write lock on m.pending
+func (m *ExpirationManager) removePendingInternal(leaseID string) {
+	pending, ok := m.pending[leaseID]
+	if !ok {
+		return
+	}
+
+	pending.timer.Stop()
+	delete(m.pending, leaseID)
+	m.core.quotaManager.LeaseDeleted(pending.namespacePath, pending.mountPath)
+}
+
+// leaseQuotaScope returns the paths of the namespace of the lease and of its
+// mount, relative to the namespace, which lease count quotas apply to
+func (m *ExpirationManager) leaseQuotaScope(le *leaseEntry) (string, string) {
+	ns := le.namespace
+	if ns == nil {
+		ns = namespace.RootNamespace
+	}
+
+	mount := m.router.MatchingMount(namespace.ContextWithNamespace(context.Background(), ns), le.Path)
+	return ns.Path, strings.TrimPrefix(mount, ns.Path)
+}
+
+// reserveLease counts a new lease against the lease count quotas before it is
+// persisted, returning an error if that would exceed a quota. The reservation
+// is taken over by the pending timer of the lease, or released by
+// releaseLease if the lease isn't created.
+func (m *ExpirationManager) reserveLease(le *leaseEntry) error {
+	nsPath, mountPath := m.leaseQuotaScope(le)
+	resp := m.core.quotaManager.ReserveLease(nsPath, mountPath)
+	if !resp.Allowed {
+		return errwrap.Wrapf(resp.Message+": {{err}}", logical.ErrLeaseCountQuotaExceeded)
+	}
+
+	le.quotaReserved = true
+	return nil
+}
+
+// releaseLease releases the reservation of a lease, if it holds one
+func (m *ExpirationManager) releaseLease(le *leaseEntry) {
+	if !le.quotaReserved {
+		return
+	}
+
+	le.quotaReserved = false
+	nsPath, mountPath := m.leaseQuotaScope(le)
+	m.core.quotaManager.LeaseDeleted(nsPath, mountPath)
+}
+
 // revokeEntry is used to attempt revocation of an internal entry
 func (m *ExpirationManager) revokeEntry(ctx context.Context, le *leaseEntry) error {
 	// Revocation of login tokens is special since we can by-pass the
@@ -1820,6 +1890,10 @@ type leaseEntry struct {
 	Version int `json:"version"`
 
 	namespace *namespace.Namespace
+
+	// quotaReserved is set while the lease is counted against the lease
+	// count quotas without a pending timer yet
+	quotaReserved bool
 }
 
 // encode is used to JSON encode the lease entry
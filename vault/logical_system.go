@@ -81,6 +81,7 @@ func NewSystemBackend(core *Core, logger log.Logger) *SystemBackend {
 				"remount",
 				"audit",
 				"audit/*",
+				"audit-hash-chain-key/*",
 				"raw",
 				"raw/*",
 				"replication/primary/secondary-token",
@@ -156,6 +157,7 @@ func NewSystemBackend(core *Core, logger log.Logger) *SystemBackend {
 	b.Backend.Paths = append(b.Backend.Paths, b.capabilitiesPaths()...)
 	b.Backend.Paths = append(b.Backend.Paths, b.internalPaths()...)
 	b.Backend.Paths = append(b.Backend.Paths, b.pprofPaths()...)
+	b.Backend.Paths = append(b.Backend.Paths, b.quotasPaths()...)
 	b.Backend.Paths = append(b.Backend.Paths, b.remountPath())
 	b.Backend.Paths = append(b.Backend.Paths, b.metricsPath())
 	b.Backend.Paths = append(b.Backend.Paths, b.hostInfoPath())
@@ -2107,6 +2109,23 @@ func (b *SystemBackend) handleAuditHash(ctx context.Context, req *logical.Reques
 	}, nil
 }
 
+// handleAuditHashChainKey is used to read the key of the hash chain of an
+// audit backend
+func (b *SystemBackend) handleAuditHashChainKey(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	path := sanitizeMountPath(data.Get("path").(string))
+
+	key, err := b.Core.auditBroker.HashChainKey(ctx, path)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"key": string(key),
+		},
+	}, nil
+}
+
 // handleEnableAudit is used to enable a new audit backend
 func (b *SystemBackend) handleEnableAudit(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	repState := b.Core.ReplicationState()
@@ -3770,6 +3789,15 @@ or delete a policy.
 		"",
 	},
 
+	"audit-hash-chain-key": {
+		"The key of the hash chain of the given audit backend",
+		`
+The key is used to verify the hash chain of the entries written by an audit
+backend with the "hash_chain" option enabled. It allows forging entries, so
+it requires sudo capability.
+		`,
+	},
+
 	"audit-table": {
 		"List the currently enabled audit backends.",
 		`
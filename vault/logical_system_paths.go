@@ -476,6 +476,24 @@ func (b *SystemBackend) auditPaths() []*framework.Path {
 			HelpDescription: strings.TrimSpace(sysHelp["audit-hash"][1]),
 		},
 
+		{
+			Pattern: "audit-hash-chain-key/(?P<path>.+)",
+
+			Fields: map[string]*framework.FieldSchema{
+				"path": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: strings.TrimSpace(sysHelp["audit_path"][0]),
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: b.handleAuditHashChainKey,
+			},
+
+			HelpSynopsis:    strings.TrimSpace(sysHelp["audit-hash-chain-key"][0]),
+			HelpDescription: strings.TrimSpace(sysHelp["audit-hash-chain-key"][1]),
+		},
+
 		{
 			Pattern: "audit$",
 
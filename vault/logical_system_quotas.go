@@ -0,0 +1,364 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/hashicorp/vault/vault/quotas"
+)
+
+// quotasPaths returns the paths to manage the quotas
+func (b *SystemBackend) quotasPaths() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "quotas$",
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleQuotasRead,
+					Summary:  "Retrieves all the quotas, with the current usage of the lease count quotas.",
+				},
+			},
+
+			HelpSynopsis:    strings.TrimSpace(sysQuotasHelp["quotas"][0]),
+			HelpDescription: strings.TrimSpace(sysQuotasHelp["quotas"][1]),
+		},
+		{
+			Pattern: "quotas/rate-limit/?$",
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.handleQuotasList(quotas.TypeRateLimit),
+					Summary:  "Lists the names of the rate limit quotas.",
+				},
+			},
+
+			HelpSynopsis:    strings.TrimSpace(sysQuotasHelp["rate-limit-list"][0]),
+			HelpDescription: strings.TrimSpace(sysQuotasHelp["rate-limit-list"][1]),
+		},
+		{
+			Pattern: "quotas/rate-limit/" + framework.GenericNameRegex("name"),
+
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeString,
+					Description: "The name of the quota.",
+				},
+				"path": {
+					Type:        framework.TypeString,
+					Description: "The mount the quota applies to, e.g. auth/approle/. If empty, the quota applies to the whole namespace, or to all requests in the root namespace.",
+				},
+				"rate": {
+					Type:        framework.TypeInt,
+					Description: "The number of requests allowed per interval.",
+				},
+				"interval": {
+					Type:        framework.TypeDurationSecond,
+					Description: "The interval the rate applies to. Defaults to 1s.",
+				},
+				"burst": {
+					Type:        framework.TypeInt,
+					Description: "The number of requests allowed at once, after a quiet period. Defaults to the rate.",
+				},
+				"bucket": {
+					Type:        framework.TypeString,
+					Description: `How the requests share the quota: "shared" for all of them, "client_ip" for a bucket per client address, or "entity" for a bucket per entity. Defaults to "shared".`,
+				},
+			},
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleRateLimitQuotaRead,
+					Summary:  "Retrieves the rate limit quota with the given name.",
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleRateLimitQuotaUpdate,
+					Summary:  "Creates or updates the rate limit quota with the given name.",
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.handleQuotaDelete(quotas.TypeRateLimit),
+					Summary:  "Deletes the rate limit quota with the given name.",
+				},
+			},
+
+			HelpSynopsis:    strings.TrimSpace(sysQuotasHelp["rate-limit"][0]),
+			HelpDescription: strings.TrimSpace(sysQuotasHelp["rate-limit"][1]),
+		},
+		{
+			Pattern: "quotas/lease-count/?$",
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.handleQuotasList(quotas.TypeLeaseCount),
+					Summary:  "Lists the names of the lease count quotas.",
+				},
+			},
+
+			HelpSynopsis:    strings.TrimSpace(sysQuotasHelp["lease-count-list"][0]),
+			HelpDescription: strings.TrimSpace(sysQuotasHelp["lease-count-list"][1]),
+		},
+		{
+			Pattern: "quotas/lease-count/" + framework.GenericNameRegex("name"),
+
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeString,
+					Description: "The name of the quota.",
+				},
+				"path": {
+					Type:        framework.TypeString,
+					Description: "The mount the quota applies to, e.g. auth/approle/. If empty, the quota applies to the whole namespace, or to all leases in the root namespace.",
+				},
+				"max_leases": {
+					Type:        framework.TypeInt,
+					Description: "The maximum number of leases.",
+				},
+			},
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleLeaseCountQuotaRead,
+					Summary:  "Retrieves the lease count quota with the given name, and its current usage.",
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleLeaseCountQuotaUpdate,
+					Summary:  "Creates or updates the lease count quota with the given name.",
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.handleQuotaDelete(quotas.TypeLeaseCount),
+					Summary:  "Deletes the lease count quota with the given name.",
+				},
+			},
+
+			HelpSynopsis:    strings.TrimSpace(sysQuotasHelp["lease-count"][0]),
+			HelpDescription: strings.TrimSpace(sysQuotasHelp["lease-count"][1]),
+		},
+	}
+}
+
+func (b *SystemBackend) handleQuotasRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	data := make(map[string]interface{}, len(quotas.Types))
+	for _, qType := range quotas.Types {
+		byName := make(map[string]interface{})
+		for _, name := range b.Core.quotaManager.QuotaNames(qType) {
+			switch quota := b.Core.quotaManager.QuotaByName(qType, name).(type) {
+			case *quotas.RateLimitQuota:
+				byName[name] = rateLimitQuotaData(quota)
+			case *quotas.LeaseCountQuota:
+				byName[name] = b.leaseCountQuotaData(quota)
+			}
+		}
+		data[string(qType)] = byName
+	}
+
+	return &logical.Response{
+		Data: data,
+	}, nil
+}
+
+func (b *SystemBackend) handleQuotasList(qType quotas.Type) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		return logical.ListResponse(b.Core.quotaManager.QuotaNames(qType)), nil
+	}
+}
+
+func (b *SystemBackend) handleQuotaDelete(qType quotas.Type) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		if err := b.Core.quotaManager.DeleteQuota(ctx, qType, d.Get("name").(string)); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+}
+
+func (b *SystemBackend) handleRateLimitQuotaRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	quota, ok := b.Core.quotaManager.QuotaByName(quotas.TypeRateLimit, d.Get("name").(string)).(*quotas.RateLimitQuota)
+	if !ok {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: rateLimitQuotaData(quota),
+	}, nil
+}
+
+func rateLimitQuotaData(quota *quotas.RateLimitQuota) map[string]interface{} {
+	return map[string]interface{}{
+		"name":     quota.Name,
+		"type":     string(quotas.TypeRateLimit),
+		"path":     quota.NamespacePath + quota.MountPath,
+		"rate":     quota.Rate,
+		"interval": int64(quota.Interval.Seconds()),
+		"burst":    quota.Burst,
+		"bucket":   quota.Bucket,
+	}
+}
+
+func (b *SystemBackend) handleRateLimitQuotaUpdate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	ns, err := namespace.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	name := d.Get("name").(string)
+	quota := &quotas.RateLimitQuota{
+		Name:          name,
+		NamespacePath: ns.Path,
+	}
+
+	// Start from the existing quota, if any, so that only the given fields
+	// are updated
+	if existing, ok := b.Core.quotaManager.QuotaByName(quotas.TypeRateLimit, name).(*quotas.RateLimitQuota); ok {
+		if existing.NamespacePath != ns.Path {
+			return logical.ErrorResponse(fmt.Sprintf("quota %q belongs to another namespace", name)), logical.ErrInvalidRequest
+		}
+		quota.MountPath = existing.MountPath
+		quota.Rate = existing.Rate
+		quota.Interval = existing.Interval
+		quota.Burst = existing.Burst
+		quota.Bucket = existing.Bucket
+	}
+
+	if raw, ok := d.GetOk("path"); ok {
+		mountPath, err := b.quotaMountPath(ctx, ns, raw.(string))
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+		quota.MountPath = mountPath
+	}
+	if raw, ok := d.GetOk("rate"); ok {
+		quota.Rate = raw.(int)
+	}
+	if raw, ok := d.GetOk("interval"); ok {
+		quota.Interval = time.Duration(raw.(int)) * time.Second
+	}
+	if raw, ok := d.GetOk("burst"); ok {
+		quota.Burst = raw.(int)
+	}
+	if raw, ok := d.GetOk("bucket"); ok {
+		quota.Bucket = raw.(string)
+	}
+
+	if err := b.Core.quotaManager.SetQuota(ctx, quota); err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+
+	return nil, nil
+}
+
+func (b *SystemBackend) handleLeaseCountQuotaRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	quota, ok := b.Core.quotaManager.QuotaByName(quotas.TypeLeaseCount, d.Get("name").(string)).(*quotas.LeaseCountQuota)
+	if !ok {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: b.leaseCountQuotaData(quota),
+	}, nil
+}
+
+func (b *SystemBackend) leaseCountQuotaData(quota *quotas.LeaseCountQuota) map[string]interface{} {
+	return map[string]interface{}{
+		"name":       quota.Name,
+		"type":       string(quotas.TypeLeaseCount),
+		"path":       quota.NamespacePath + quota.MountPath,
+		"max_leases": quota.MaxLeases,
+		"count":      b.Core.quotaManager.LeaseCount(quota.NamespacePath, quota.MountPath),
+	}
+}
+
+func (b *SystemBackend) handleLeaseCountQuotaUpdate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	ns, err := namespace.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	name := d.Get("name").(string)
+	quota := &quotas.LeaseCountQuota{
+		Name:          name,
+		NamespacePath: ns.Path,
+	}
+
+	if existing, ok := b.Core.quotaManager.QuotaByName(quotas.TypeLeaseCount, name).(*quotas.LeaseCountQuota); ok {
+		if existing.NamespacePath != ns.Path {
+			return logical.ErrorResponse(fmt.Sprintf("quota %q belongs to another namespace", name)), logical.ErrInvalidRequest
+		}
+		quota.MountPath = existing.MountPath
+		quota.MaxLeases = existing.MaxLeases
+	}
+
+	if raw, ok := d.GetOk("path"); ok {
+		mountPath, err := b.quotaMountPath(ctx, ns, raw.(string))
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+		quota.MountPath = mountPath
+	}
+	if raw, ok := d.GetOk("max_leases"); ok {
+		quota.MaxLeases = int64(raw.(int))
+	}
+
+	if err := b.Core.quotaManager.SetQuota(ctx, quota); err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+
+	return nil, nil
+}
+
+// quotaMountPath validates the path of a quota, relative to the namespace,
+// and returns the path of the mount
+func (b *SystemBackend) quotaMountPath(ctx context.Context, ns *namespace.Namespace, path string) (string, error) {
+	path = strings.TrimPrefix(path, "/")
+	if path == "" {
+		return "", nil
+	}
+	if !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+
+	if b.Core.router.MatchingMount(ctx, path) != ns.Path+path {
+		return "", fmt.Errorf("no mount found at %q", path)
+	}
+	return path, nil
+}
+
+var sysQuotasHelp = map[string][2]string{
+	"quotas": {
+		"Lists all the quotas.",
+		`
+Returns the quotas by type and name, with the current number of leases
+counted against each lease count quota.
+		`,
+	},
+	"rate-limit-list": {
+		"Lists the rate limit quotas.",
+		"",
+	},
+	"rate-limit": {
+		"Manages the rate limit quotas.",
+		`
+A rate limit quota limits the rate of the requests to a mount, to a namespace,
+or to all the requests. The most specific quota applying to a request is used.
+Requests exceeding the quota are rejected with a 429 status code.
+		`,
+	},
+	"lease-count-list": {
+		"Lists the lease count quotas.",
+		"",
+	},
+	"lease-count": {
+		"Manages the lease count quotas.",
+		`
+A lease count quota limits the number of leases of a mount, of a namespace, or
+of the whole server. The most specific quota applying to a new lease is used.
+Requests which would create a lease exceeding the quota are rejected with a
+429 status code. Leases which don't expire, such as those of root tokens, are
+not counted.
+		`,
+	},
+}
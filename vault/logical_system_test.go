@@ -36,6 +36,7 @@ func TestSystemBackend_RootPaths(t *testing.T) {
 		"remount",
 		"audit",
 		"audit/*",
+		"audit-hash-chain-key/*",
 		"raw",
 		"raw/*",
 		"replication/primary/secondary-token",
@@ -1765,6 +1766,53 @@ func TestSystemBackend_auditHash(t *testing.T) {
 	}
 }
 
+func TestSystemBackend_auditHashChainKey(t *testing.T) {
+	c, b, _ := testCoreSystemBackend(t)
+	c.auditBackends["noop"] = func(ctx context.Context, config *audit.BackendConfig) (audit.Backend, error) {
+		view := &logical.InmemStorage{}
+		view.Put(namespace.RootContext(nil), &logical.StorageEntry{
+			Key:   "salt",
+			Value: []byte("foo"),
+		})
+		config.SaltView = view
+		config.SaltConfig = &salt.Config{
+			HMAC:     sha256.New,
+			HMACType: "hmac-sha256",
+			Location: salt.DefaultLocation,
+		}
+		return &NoopAudit{
+			Config: config,
+		}, nil
+	}
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "audit/foo")
+	req.Data["type"] = "noop"
+	if _, err := b.HandleRequest(namespace.RootContext(nil), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req = logical.TestRequest(t, logical.ReadOperation, "audit-hash-chain-key/foo")
+	resp, err := b.HandleRequest(namespace.RootContext(nil), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || resp.Data == nil {
+		t.Fatalf("response or its data was nil")
+	}
+
+	// The key isn't the HMAC of any input, which audit-hash would disclose
+	expected := salt.SaltID("foo", "audit-hash-chain", salt.SHA256Hash)
+	if key := resp.Data["key"].(string); key != expected {
+		t.Fatalf("expected key %q, got %q", expected, key)
+	}
+
+	req = logical.TestRequest(t, logical.ReadOperation, "audit-hash-chain-key/bar")
+	resp, err = b.HandleRequest(namespace.RootContext(nil), req)
+	if err != nil || !resp.IsError() {
+		t.Fatalf("expected an error for an unknown device, got %#v, %v", resp, err)
+	}
+}
+
 func TestSystemBackend_enableAudit_invalid(t *testing.T) {
 	b := testSystemBackend(t)
 	req := logical.TestRequest(t, logical.UpdateOperation, "audit/foo")
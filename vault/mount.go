@@ -662,6 +662,13 @@ func (c *Core) unmountInternal(ctx context.Context, path string, updateStorage b
 		return err
 	}
 
+	if updateStorage {
+		// Remove the quotas of the mount
+		if err := c.quotaManager.HandleMountRemoved(ctx, ns.Path, path); err != nil {
+			return err
+		}
+	}
+
 	removePathCheckers(c, entry, viewPath)
 
 	if c.logger.IsInfo() {
@@ -15,6 +15,7 @@ import (
 	"github.com/hashicorp/vault/sdk/helper/hclutil"
 	"github.com/hashicorp/vault/sdk/helper/identitytpl"
 	"github.com/hashicorp/vault/sdk/helper/parseutil"
+	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/mitchellh/copystructure"
 )
 
@@ -161,6 +162,15 @@ type ACLPermissions struct {
 	RequiredParameters []string
 	MFAMethods         []string
 	ControlGroup       *ControlGroup
+
+	// GrantingPoliciesMap holds the policies granting each capability of the
+	// bitmap, once policies are merged into an ACL
+	GrantingPoliciesMap map[uint32][]logical.PolicyInfo
+
+	// rulePath and matchType describe the path rule of the permissions in an
+	// ACL, for the policy results of requests
+	rulePath  string
+	matchType string
 }
 
 func (p *ACLPermissions) Clone() (*ACLPermissions, error) {
@@ -217,6 +227,13 @@ func (p *ACLPermissions) Clone() (*ACLPermissions, error) {
 		ret.ControlGroup = clonedControlGroup.(*ControlGroup)
 	}
 
+	if p.GrantingPoliciesMap != nil {
+		ret.GrantingPoliciesMap = make(map[uint32][]logical.PolicyInfo, len(p.GrantingPoliciesMap))
+		for capability, policies := range p.GrantingPoliciesMap {
+			ret.GrantingPoliciesMap[capability] = append([]logical.PolicyInfo(nil), policies...)
+		}
+	}
+
 	return ret, nil
 }
 
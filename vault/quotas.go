@@ -0,0 +1,87 @@
+package vault
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/hashicorp/vault/vault/quotas"
+)
+
+const (
+	// quotasSubPath is the sub-path of the system view the quotas are
+	// stored under
+	quotasSubPath = "quotas/"
+)
+
+// setupQuotas loads the quotas. This should only be called with the core
+// state lock held for writing.
+func (c *Core) setupQuotas(ctx context.Context) error {
+	return c.quotaManager.Setup(ctx, c.systemBarrierView.SubView(quotasSubPath))
+}
+
+// teardownQuotas drops the quotas
+func (c *Core) teardownQuotas() {
+	c.quotaManager.Reset()
+}
+
+// applyRateLimitQuota applies the rate limit quotas to the request. If the
+// request is rejected, the returned response holds the Retry-After header and
+// the error is logical.ErrRateLimitQuotaExceeded.
+func (c *Core) applyRateLimitQuota(ctx context.Context, req *logical.Request, entityID string) (*logical.Response, error) {
+	// Always allow managing quotas, so that a quota can't lock operators out
+	if req.Path == "sys/quotas" || strings.HasPrefix(req.Path, "sys/quotas/") {
+		return nil, nil
+	}
+
+	ns, err := namespace.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	quotaReq := &quotas.Request{
+		Type:          quotas.TypeRateLimit,
+		NamespacePath: ns.Path,
+		MountPath:     strings.TrimPrefix(c.router.MatchingMount(ctx, req.Path), ns.Path),
+		EntityID:      entityID,
+	}
+	if req.Connection != nil {
+		quotaReq.ClientAddress = req.Connection.RemoteAddr
+	}
+
+	quotaResp, err := c.quotaManager.ApplyQuota(quotaReq)
+	if err != nil {
+		return nil, err
+	}
+	if quotaResp.Allowed {
+		return nil, nil
+	}
+
+	resp := &logical.Response{}
+	if quotaResp.RetryAfter > 0 {
+		retryAfter := int(math.Ceil(quotaResp.RetryAfter.Seconds()))
+		resp.Headers = map[string][]string{
+			"Retry-After": []string{strconv.Itoa(retryAfter)},
+		}
+	}
+	return resp, logical.ErrRateLimitQuotaExceeded
+}
+
+// auditRateLimitedRequest audits a request rejected by a rate limit quota.
+// Quotas are applied before the request is otherwise audited, so that the
+// rejection is recorded. The request is rejected whether or not auditing
+// succeeds.
+func (c *Core) auditRateLimitedRequest(ctx context.Context, req *logical.Request, auth *logical.Auth, nonHMACReqDataKeys []string) {
+	logInput := &logical.LogInput{
+		Auth:               auth,
+		Request:            req,
+		OuterErr:           logical.ErrRateLimitQuotaExceeded,
+		NonHMACReqDataKeys: nonHMACReqDataKeys,
+	}
+	if err := c.auditBroker.LogRequest(ctx, logInput, c.auditedHeaders); err != nil {
+		c.logger.Error("failed to audit request", "path", req.Path, "error", err)
+	}
+}
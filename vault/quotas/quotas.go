@@ -0,0 +1,411 @@
+package quotas
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	log "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// Type is the type of a quota
+type Type string
+
+const (
+	// TypeRateLimit limits the rate of the requests
+	TypeRateLimit Type = "rate-limit"
+
+	// TypeLeaseCount limits the number of leases
+	TypeLeaseCount Type = "lease-count"
+)
+
+// Types lists the types of quotas
+var Types = []Type{
+	TypeRateLimit,
+	TypeLeaseCount,
+}
+
+// Quota is implemented by each type of quota. A quota applies to a mount of a
+// namespace, to a namespace, or globally.
+type Quota interface {
+	// QuotaName returns the name of the quota
+	QuotaName() string
+
+	// QuotaType returns the type of the quota
+	QuotaType() Type
+
+	// QuotaScope returns the paths of the namespace and the mount, relative
+	// to the namespace, the quota applies to. The mount path is empty when
+	// the quota applies to a whole namespace, and both are empty when it
+	// applies globally.
+	QuotaScope() (namespacePath string, mountPath string)
+
+	// initialize validates the quota and sets up its internal state
+	initialize() error
+
+	// allow checks whether the request is within the quota
+	allow(m *Manager, req *Request) (*Response, error)
+}
+
+// Request holds the attributes of a request quotas are applied to
+type Request struct {
+	Type Type
+
+	// NamespacePath is the path of the namespace of the request
+	NamespacePath string
+
+	// MountPath is the path of the mount of the request, relative to its
+	// namespace
+	MountPath string
+
+	ClientAddress string
+	EntityID      string
+}
+
+// Response is the result of the application of a quota to a request
+type Response struct {
+	Allowed bool
+
+	// RetryAfter is how long the client should wait before retrying a
+	// request which isn't allowed, if known
+	RetryAfter time.Duration
+
+	// Message explains why the request isn't allowed, if set
+	Message string
+}
+
+// Manager holds the quotas and applies them to requests. Quotas are persisted
+// in the storage view given to Setup, under their type. The manager also
+// counts the leases of each mount, for the lease count quotas.
+type Manager struct {
+	logger log.Logger
+
+	lock   sync.RWMutex
+	view   logical.Storage
+	quotas map[Type]map[string]Quota
+
+	leaseCountsLock sync.Mutex
+	leaseCounts     map[scope]int64
+}
+
+// scope identifies a mount of a namespace
+type scope struct {
+	namespacePath string
+	mountPath     string
+}
+
+// NewManager returns a manager without any quota
+func NewManager(logger log.Logger) *Manager {
+	return &Manager{
+		logger:      logger,
+		quotas:      make(map[Type]map[string]Quota),
+		leaseCounts: make(map[scope]int64),
+	}
+}
+
+// newQuota returns an empty quota of the given type
+func newQuota(qType Type) (Quota, error) {
+	switch qType {
+	case TypeRateLimit:
+		return new(RateLimitQuota), nil
+	case TypeLeaseCount:
+		return new(LeaseCountQuota), nil
+	default:
+		return nil, fmt.Errorf("unknown quota type %q", qType)
+	}
+}
+
+// Setup loads the quotas from the storage view. The lease counts start from
+// zero, the leases being counted as they are restored.
+func (m *Manager) Setup(ctx context.Context, view logical.Storage) error {
+	m.resetLeaseCounts()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.view = view
+	m.quotas = make(map[Type]map[string]Quota)
+
+	for _, qType := range Types {
+		prefix := string(qType) + "/"
+		names, err := view.List(ctx, prefix)
+		if err != nil {
+			return errwrap.Wrapf(fmt.Sprintf("failed to list %s quotas: {{err}}", qType), err)
+		}
+
+		for _, name := range names {
+			entry, err := view.Get(ctx, prefix+name)
+			if err != nil {
+				return errwrap.Wrapf(fmt.Sprintf("failed to read %s quota %q: {{err}}", qType, name), err)
+			}
+			if entry == nil {
+				continue
+			}
+
+			quota, err := newQuota(qType)
+			if err != nil {
+				return err
+			}
+			if err := entry.DecodeJSON(quota); err != nil {
+				return errwrap.Wrapf(fmt.Sprintf("failed to decode %s quota %q: {{err}}", qType, name), err)
+			}
+			if err := quota.initialize(); err != nil {
+				m.logger.Error("failed to initialize quota, skipping", "type", qType, "name", name, "error", err)
+				continue
+			}
+			m.setQuotaLocked(quota)
+		}
+	}
+
+	return nil
+}
+
+// Reset drops the quotas, the storage view and the lease counts
+func (m *Manager) Reset() {
+	m.resetLeaseCounts()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.view = nil
+	m.quotas = make(map[Type]map[string]Quota)
+}
+
+// SetQuota validates, persists and applies the quota, replacing any quota
+// of the same type and name
+func (m *Manager) SetQuota(ctx context.Context, quota Quota) error {
+	if err := quota.initialize(); err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.view == nil {
+		return fmt.Errorf("quotas are not set up")
+	}
+
+	nsPath, mountPath := quota.QuotaScope()
+	for name, existing := range m.quotas[quota.QuotaType()] {
+		if name == quota.QuotaName() {
+			continue
+		}
+		if existingNSPath, existingMountPath := existing.QuotaScope(); existingNSPath == nsPath && existingMountPath == mountPath {
+			return fmt.Errorf("quota %q already applies to %s", name, scopeString(nsPath, mountPath))
+		}
+	}
+
+	entry, err := logical.StorageEntryJSON(string(quota.QuotaType())+"/"+quota.QuotaName(), quota)
+	if err != nil {
+		return errwrap.Wrapf("failed to encode quota: {{err}}", err)
+	}
+	if err := m.view.Put(ctx, entry); err != nil {
+		return errwrap.Wrapf("failed to persist quota: {{err}}", err)
+	}
+
+	m.setQuotaLocked(quota)
+	return nil
+}
+
+func (m *Manager) setQuotaLocked(quota Quota) {
+	quotas, ok := m.quotas[quota.QuotaType()]
+	if !ok {
+		quotas = make(map[string]Quota)
+		m.quotas[quota.QuotaType()] = quotas
+	}
+	quotas[quota.QuotaName()] = quota
+}
+
+// DeleteQuota removes the quota of the given type and name
+func (m *Manager) DeleteQuota(ctx context.Context, qType Type, name string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.view == nil {
+		return fmt.Errorf("quotas are not set up")
+	}
+
+	if err := m.view.Delete(ctx, string(qType)+"/"+name); err != nil {
+		return errwrap.Wrapf("failed to delete quota: {{err}}", err)
+	}
+
+	delete(m.quotas[qType], name)
+	return nil
+}
+
+// HandleMountRemoved deletes the quotas of all types which apply to the
+// removed mount
+func (m *Manager) HandleMountRemoved(ctx context.Context, nsPath, mountPath string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.view == nil {
+		return nil
+	}
+
+	for qType, quotas := range m.quotas {
+		for name, quota := range quotas {
+			if quotaNSPath, quotaMountPath := quota.QuotaScope(); quotaNSPath != nsPath || quotaMountPath != mountPath {
+				continue
+			}
+			if err := m.view.Delete(ctx, string(qType)+"/"+name); err != nil {
+				return errwrap.Wrapf("failed to delete quota: {{err}}", err)
+			}
+			delete(quotas, name)
+		}
+	}
+
+	return nil
+}
+
+// QuotaByName returns the quota of the given type and name, or nil
+func (m *Manager) QuotaByName(qType Type, name string) Quota {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.quotas[qType][name]
+}
+
+// QuotaNames returns the sorted names of the quotas of the given type
+func (m *Manager) QuotaNames(qType Type) []string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	names := make([]string, 0, len(m.quotas[qType]))
+	for name := range m.quotas[qType] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ApplyQuota applies the most specific quota of the request's type to it: a
+// quota on its mount takes precedence over one on its namespace, which takes
+// precedence over a global one. The request is allowed if no quota applies.
+func (m *Manager) ApplyQuota(req *Request) (*Response, error) {
+	m.lock.RLock()
+	quota := m.matchingQuotaLocked(req)
+	m.lock.RUnlock()
+
+	if quota == nil {
+		return &Response{Allowed: true}, nil
+	}
+	return quota.allow(m, req)
+}
+
+func (m *Manager) matchingQuotaLocked(req *Request) Quota {
+	var nsQuota, globalQuota Quota
+	for _, quota := range m.quotas[req.Type] {
+		nsPath, mountPath := quota.QuotaScope()
+		switch {
+		case nsPath == "" && mountPath == "":
+			globalQuota = quota
+		case nsPath != req.NamespacePath:
+		case mountPath == "":
+			nsQuota = quota
+		case mountPath == req.MountPath:
+			return quota
+		}
+	}
+
+	if nsQuota != nil {
+		return nsQuota
+	}
+	return globalQuota
+}
+
+// ReserveLease applies the lease count quotas to a new lease of the mount
+// and, if it is allowed, counts it right away, so that concurrent requests
+// can't exceed a quota between checking it and creating their leases. The
+// lease must be released with LeaseDeleted if it isn't created after all.
+func (m *Manager) ReserveLease(nsPath, mountPath string) *Response {
+	m.lock.RLock()
+	quota, _ := m.matchingQuotaLocked(&Request{
+		Type:          TypeLeaseCount,
+		NamespacePath: nsPath,
+		MountPath:     mountPath,
+	}).(*LeaseCountQuota)
+	m.lock.RUnlock()
+
+	m.leaseCountsLock.Lock()
+	defer m.leaseCountsLock.Unlock()
+
+	if quota != nil {
+		if resp := quota.allowLocked(m); !resp.Allowed {
+			return resp
+		}
+	}
+
+	m.leaseCounts[scope{nsPath, mountPath}]++
+	return &Response{
+		Allowed: true,
+	}
+}
+
+// LeaseCreated counts a new lease of the mount
+func (m *Manager) LeaseCreated(nsPath, mountPath string) {
+	m.leaseCountsLock.Lock()
+	defer m.leaseCountsLock.Unlock()
+
+	m.leaseCounts[scope{nsPath, mountPath}]++
+}
+
+// LeaseDeleted stops counting a lease of the mount
+func (m *Manager) LeaseDeleted(nsPath, mountPath string) {
+	m.leaseCountsLock.Lock()
+	defer m.leaseCountsLock.Unlock()
+
+	key := scope{nsPath, mountPath}
+	if m.leaseCounts[key] <= 1 {
+		delete(m.leaseCounts, key)
+		return
+	}
+	m.leaseCounts[key]--
+}
+
+// LeaseCount returns the number of leases of the mount, of the namespace if
+// the mount path is empty, or of all the mounts if both paths are empty
+func (m *Manager) LeaseCount(nsPath, mountPath string) int64 {
+	m.leaseCountsLock.Lock()
+	defer m.leaseCountsLock.Unlock()
+
+	return m.leaseCountLocked(nsPath, mountPath)
+}
+
+// leaseCountLocked is the locked version of LeaseCount; do not call this
+// without the lease counts lock
+func (m *Manager) leaseCountLocked(nsPath, mountPath string) int64 {
+	if mountPath != "" {
+		return m.leaseCounts[scope{nsPath, mountPath}]
+	}
+
+	var count int64
+	for key, n := range m.leaseCounts {
+		if nsPath == "" || key.namespacePath == nsPath {
+			count += n
+		}
+	}
+	return count
+}
+
+func (m *Manager) resetLeaseCounts() {
+	m.leaseCountsLock.Lock()
+	defer m.leaseCountsLock.Unlock()
+
+	m.leaseCounts = make(map[scope]int64)
+}
+
+func scopeString(nsPath, mountPath string) string {
+	switch {
+	case nsPath == "" && mountPath == "":
+		return "all requests"
+	case mountPath == "":
+		return fmt.Sprintf("namespace %q", nsPath)
+	default:
+		return fmt.Sprintf("mount %q", nsPath+mountPath)
+	}
+}
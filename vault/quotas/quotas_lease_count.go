@@ -0,0 +1,63 @@
+package quotas
+
+import (
+	"fmt"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+// LeaseCountQuota limits the number of leases. The leases are counted by the
+// manager, so the quota applies to the leases which existed before it. While
+// the leases are restored after unsealing, only the restored ones are
+// counted.
+type LeaseCountQuota struct {
+	Name          string `json:"name"`
+	NamespacePath string `json:"namespace_path"`
+	MountPath     string `json:"mount_path"`
+	MaxLeases     int64  `json:"max_leases"`
+}
+
+func (q *LeaseCountQuota) QuotaName() string {
+	return q.Name
+}
+
+func (q *LeaseCountQuota) QuotaType() Type {
+	return TypeLeaseCount
+}
+
+func (q *LeaseCountQuota) QuotaScope() (string, string) {
+	return q.NamespacePath, q.MountPath
+}
+
+func (q *LeaseCountQuota) initialize() error {
+	if q.Name == "" {
+		return fmt.Errorf("missing quota name")
+	}
+	if q.MaxLeases <= 0 {
+		return fmt.Errorf("max_leases must be positive")
+	}
+	return nil
+}
+
+// allow checks whether one more lease can be created
+func (q *LeaseCountQuota) allow(m *Manager, req *Request) (*Response, error) {
+	m.leaseCountsLock.Lock()
+	defer m.leaseCountsLock.Unlock()
+
+	return q.allowLocked(m), nil
+}
+
+// allowLocked is the locked version of allow; do not call this without the
+// lease counts lock
+func (q *LeaseCountQuota) allowLocked(m *Manager) *Response {
+	if m.leaseCountLocked(q.NamespacePath, q.MountPath) >= q.MaxLeases {
+		metrics.IncrCounter([]string{"quota", "lease_count", q.Name, "violation"}, 1)
+		return &Response{
+			Message: fmt.Sprintf("quota %q allows at most %d leases", q.Name, q.MaxLeases),
+		}
+	}
+
+	return &Response{
+		Allowed: true,
+	}
+}
@@ -0,0 +1,155 @@
+package quotas
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// BucketShared makes all the requests a rate limit quota applies to
+	// share a single bucket
+	BucketShared = "shared"
+
+	// BucketClientIP gives each client address its own bucket
+	BucketClientIP = "client_ip"
+
+	// BucketEntity gives each entity its own bucket. Requests without an
+	// entity, such as logins, fall back to a bucket per client address.
+	BucketEntity = "entity"
+)
+
+var (
+	// rateLimitPurgeInterval is how often the buckets of the clients which
+	// are idle are dropped. Making this a package var allows tests to modify.
+	rateLimitPurgeInterval = time.Minute
+)
+
+// RateLimitQuota limits the rate of the requests with a token bucket, which
+// holds up to Burst requests and is refilled with Rate requests every Interval
+type RateLimitQuota struct {
+	Name          string        `json:"name"`
+	NamespacePath string        `json:"namespace_path"`
+	MountPath     string        `json:"mount_path"`
+	Rate          int           `json:"rate"`
+	Interval      time.Duration `json:"interval"`
+	Burst         int           `json:"burst"`
+	Bucket        string        `json:"bucket"`
+
+	lock      sync.Mutex
+	limiter   *rate.Limiter
+	clients   map[string]*rateLimitClient
+	lastPurge time.Time
+}
+
+type rateLimitClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func (q *RateLimitQuota) QuotaName() string {
+	return q.Name
+}
+
+func (q *RateLimitQuota) QuotaType() Type {
+	return TypeRateLimit
+}
+
+func (q *RateLimitQuota) QuotaScope() (string, string) {
+	return q.NamespacePath, q.MountPath
+}
+
+func (q *RateLimitQuota) initialize() error {
+	if q.Name == "" {
+		return fmt.Errorf("missing quota name")
+	}
+	if q.Rate <= 0 {
+		return fmt.Errorf("rate must be positive")
+	}
+	if q.Interval <= 0 {
+		q.Interval = time.Second
+	}
+	if q.Burst <= 0 {
+		q.Burst = q.Rate
+	}
+
+	switch q.Bucket {
+	case "":
+		q.Bucket = BucketShared
+	case BucketShared, BucketClientIP, BucketEntity:
+	default:
+		return fmt.Errorf("invalid bucket %q, must be %q, %q or %q", q.Bucket, BucketShared, BucketClientIP, BucketEntity)
+	}
+
+	q.limiter = rate.NewLimiter(q.limit(), q.Burst)
+	q.clients = make(map[string]*rateLimitClient)
+	q.lastPurge = time.Now()
+	return nil
+}
+
+// limit returns the rate the bucket is refilled at, in requests per second
+func (q *RateLimitQuota) limit() rate.Limit {
+	return rate.Limit(float64(q.Rate) / q.Interval.Seconds())
+}
+
+func (q *RateLimitQuota) allow(_ *Manager, req *Request) (*Response, error) {
+	now := time.Now()
+
+	limiter := q.limiter
+	if q.Bucket != BucketShared {
+		limiter = q.clientLimiter(q.clientKey(req), now)
+	}
+
+	reservation := limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		// Don't count the rejected request against the bucket
+		reservation.CancelAt(now)
+		metrics.IncrCounter([]string{"quota", "rate_limit", q.Name, "violation"}, 1)
+		return &Response{
+			RetryAfter: delay,
+		}, nil
+	}
+
+	return &Response{
+		Allowed: true,
+	}, nil
+}
+
+func (q *RateLimitQuota) clientKey(req *Request) string {
+	if q.Bucket == BucketEntity && req.EntityID != "" {
+		return "entity:" + req.EntityID
+	}
+	return "client_ip:" + req.ClientAddress
+}
+
+// clientLimiter returns the bucket of the client, dropping the buckets of the
+// clients which have been idle long enough for them to be full again, since
+// they are then no different than new ones
+func (q *RateLimitQuota) clientLimiter(key string, now time.Time) *rate.Limiter {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if now.Sub(q.lastPurge) >= rateLimitPurgeInterval {
+		refill := time.Duration(q.Burst) * q.Interval / time.Duration(q.Rate)
+		for k, client := range q.clients {
+			if now.Sub(client.lastSeen) > refill {
+				delete(q.clients, k)
+			}
+		}
+		q.lastPurge = now
+	}
+
+	client, ok := q.clients[key]
+	if !ok {
+		client = &rateLimitClient{
+			limiter: rate.NewLimiter(q.limit(), q.Burst),
+		}
+		q.clients[key] = client
+	}
+	client.lastSeen = now
+
+	return client.limiter
+}
@@ -0,0 +1,284 @@
+package quotas
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	log "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func testManager(t *testing.T) (*Manager, logical.Storage) {
+	t.Helper()
+
+	view := new(logical.InmemStorage)
+	m := NewManager(log.NewNullLogger())
+	if err := m.Setup(context.Background(), view); err != nil {
+		t.Fatal(err)
+	}
+	return m, view
+}
+
+func TestManager_ApplyQuota(t *testing.T) {
+	m, _ := testManager(t)
+	ctx := context.Background()
+
+	for _, quota := range []*RateLimitQuota{
+		{Name: "global", Rate: 1},
+		{Name: "ns", NamespacePath: "ns1/", Rate: 1},
+		{Name: "mount", NamespacePath: "ns1/", MountPath: "auth/approle/", Rate: 1},
+	} {
+		if err := m.SetQuota(ctx, quota); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each request only counts against the most specific quota, so a single
+	// request is allowed for each of them
+	for _, req := range []*Request{
+		{Type: TypeRateLimit, NamespacePath: "ns1/", MountPath: "auth/approle/"},
+		{Type: TypeRateLimit, NamespacePath: "ns1/", MountPath: "secret/"},
+		{Type: TypeRateLimit, MountPath: "auth/approle/"},
+	} {
+		resp, err := m.ApplyQuota(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !resp.Allowed {
+			t.Fatalf("expected the first request to %#v to be allowed", req)
+		}
+
+		resp, err = m.ApplyQuota(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Allowed {
+			t.Fatalf("expected the second request to %#v to be rejected", req)
+		}
+		if resp.RetryAfter <= 0 || resp.RetryAfter > time.Second {
+			t.Fatalf("bad retry after: %s", resp.RetryAfter)
+		}
+	}
+}
+
+func TestManager_SetQuota(t *testing.T) {
+	m, view := testManager(t)
+	ctx := context.Background()
+
+	if err := m.SetQuota(ctx, &RateLimitQuota{Name: "foo", Rate: 0}); err == nil {
+		t.Fatal("expected an error for a missing rate")
+	}
+	if err := m.SetQuota(ctx, &RateLimitQuota{Name: "foo", Rate: 1, Bucket: "bar"}); err == nil {
+		t.Fatal("expected an error for an invalid bucket")
+	}
+
+	if err := m.SetQuota(ctx, &RateLimitQuota{Name: "foo", MountPath: "secret/", Rate: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetQuota(ctx, &RateLimitQuota{Name: "bar", MountPath: "secret/", Rate: 10}); err == nil {
+		t.Fatal("expected an error for a second quota on the same mount")
+	}
+
+	// The quota is loaded back with its defaults
+	m = NewManager(log.NewNullLogger())
+	if err := m.Setup(ctx, view); err != nil {
+		t.Fatal(err)
+	}
+	if names := m.QuotaNames(TypeRateLimit); !reflect.DeepEqual(names, []string{"foo"}) {
+		t.Fatalf("bad names: %v", names)
+	}
+	quota := m.QuotaByName(TypeRateLimit, "foo").(*RateLimitQuota)
+	if quota.MountPath != "secret/" || quota.Rate != 10 || quota.Burst != 10 || quota.Interval != time.Second || quota.Bucket != BucketShared {
+		t.Fatalf("bad quota: %#v", quota)
+	}
+
+	if err := m.HandleMountRemoved(ctx, "", "secret/"); err != nil {
+		t.Fatal(err)
+	}
+	if names := m.QuotaNames(TypeRateLimit); len(names) != 0 {
+		t.Fatalf("expected the quota to be removed with its mount, got %v", names)
+	}
+	if keys, err := view.List(ctx, string(TypeRateLimit)+"/"); err != nil || len(keys) != 0 {
+		t.Fatalf("expected the quota to be deleted from storage, got %v, %v", keys, err)
+	}
+}
+
+func TestRateLimitQuota_Bucket(t *testing.T) {
+	cases := map[string]struct {
+		bucket  string
+		second  *Request
+		allowed bool
+	}{
+		"shared": {
+			BucketShared,
+			&Request{ClientAddress: "10.0.0.2", EntityID: "entity-2"},
+			false,
+		},
+		"client_ip": {
+			BucketClientIP,
+			&Request{ClientAddress: "10.0.0.2", EntityID: "entity-1"},
+			true,
+		},
+		"client_ip_same": {
+			BucketClientIP,
+			&Request{ClientAddress: "10.0.0.1", EntityID: "entity-2"},
+			false,
+		},
+		"entity": {
+			BucketEntity,
+			&Request{ClientAddress: "10.0.0.1", EntityID: "entity-2"},
+			true,
+		},
+		"entity_fallback": {
+			BucketEntity,
+			&Request{ClientAddress: "10.0.0.2"},
+			true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			quota := &RateLimitQuota{
+				Name:   "test",
+				Rate:   1,
+				Bucket: tc.bucket,
+			}
+			if err := quota.initialize(); err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := quota.allow(nil, &Request{ClientAddress: "10.0.0.1", EntityID: "entity-1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !resp.Allowed {
+				t.Fatal("expected the first request to be allowed")
+			}
+
+			resp, err = quota.allow(nil, tc.second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Allowed != tc.allowed {
+				t.Fatalf("expected allowed to be %t", tc.allowed)
+			}
+		})
+	}
+}
+
+func TestRateLimitQuota_Purge(t *testing.T) {
+	quota := &RateLimitQuota{
+		Name:     "test",
+		Rate:     1,
+		Interval: time.Millisecond,
+		Bucket:   BucketClientIP,
+	}
+	if err := quota.initialize(); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	quota.clientLimiter("client_ip:10.0.0.1", now)
+	quota.clientLimiter("client_ip:10.0.0.2", now.Add(rateLimitPurgeInterval))
+	if len(quota.clients) != 1 {
+		t.Fatalf("expected the idle client to be purged, got %d clients", len(quota.clients))
+	}
+}
+
+func TestLeaseCountQuota(t *testing.T) {
+	m, _ := testManager(t)
+	ctx := context.Background()
+
+	if err := m.SetQuota(ctx, &LeaseCountQuota{Name: "foo"}); err == nil {
+		t.Fatal("expected an error for missing max leases")
+	}
+	if err := m.SetQuota(ctx, &LeaseCountQuota{Name: "ns", NamespacePath: "ns1/", MaxLeases: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	req := &Request{Type: TypeLeaseCount, NamespacePath: "ns1/", MountPath: "secret/"}
+	apply := func(allowed bool) {
+		t.Helper()
+		resp, err := m.ApplyQuota(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Allowed != allowed {
+			t.Fatalf("expected allowed to be %t, got %#v", allowed, resp)
+		}
+	}
+
+	// The leases of all the mounts of the namespace count against the quota
+	apply(true)
+	m.LeaseCreated("ns1/", "secret/")
+	m.LeaseCreated("ns1/", "auth/approle/")
+	m.LeaseCreated("", "secret/")
+	apply(false)
+	if count := m.LeaseCount("ns1/", ""); count != 2 {
+		t.Fatalf("bad count: %d", count)
+	}
+	if count := m.LeaseCount("", ""); count != 3 {
+		t.Fatalf("bad total count: %d", count)
+	}
+
+	m.LeaseDeleted("ns1/", "auth/approle/")
+	apply(true)
+
+	// Deleting a lease which wasn't counted doesn't go below zero
+	m.LeaseDeleted("ns1/", "auth/approle/")
+	if count := m.LeaseCount("ns1/", "auth/approle/"); count != 0 {
+		t.Fatalf("bad count: %d", count)
+	}
+
+	m.Reset()
+	if count := m.LeaseCount("", ""); count != 0 {
+		t.Fatalf("expected the counts to be reset, got %d", count)
+	}
+}
+
+func TestLeaseCountQuota_Reserve(t *testing.T) {
+	m, _ := testManager(t)
+	ctx := context.Background()
+
+	if err := m.SetQuota(ctx, &LeaseCountQuota{Name: "secret", MountPath: "secret/", MaxLeases: 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Concurrent reservations can't exceed the quota
+	var wg sync.WaitGroup
+	var allowed int64
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if m.ReserveLease("", "secret/").Allowed {
+				atomic.AddInt64(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 10 {
+		t.Fatalf("expected 10 reservations to be allowed, got %d", allowed)
+	}
+	if count := m.LeaseCount("", "secret/"); count != 10 {
+		t.Fatalf("bad count: %d", count)
+	}
+
+	// Releasing a reservation makes room for another lease
+	m.LeaseDeleted("", "secret/")
+	if !m.ReserveLease("", "secret/").Allowed {
+		t.Fatal("expected the reservation to be allowed after a release")
+	}
+
+	// Leases of mounts without a quota are counted too
+	if !m.ReserveLease("", "kv/").Allowed {
+		t.Fatal("expected the reservation to be allowed")
+	}
+	if count := m.LeaseCount("", "kv/"); count != 1 {
+		t.Fatalf("bad count: %d", count)
+	}
+}
@@ -0,0 +1,346 @@
+package vault
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/hashicorp/vault/vault/quotas"
+)
+
+func TestCore_RateLimitQuota(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+	ctx := namespace.RootContext(nil)
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "sys/quotas/rate-limit/kv")
+	req.ClientToken = root
+	req.Data = map[string]interface{}{
+		"path":   "nope",
+		"rate":   1,
+		"bucket": "client_ip",
+	}
+	if _, err := c.HandleRequest(ctx, req); err == nil {
+		t.Fatal("expected an error for a missing mount")
+	}
+
+	req.Data["path"] = "secret"
+	if _, err := c.HandleRequest(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+
+	req = logical.TestRequest(t, logical.ReadOperation, "sys/quotas/rate-limit/kv")
+	req.ClientToken = root
+	resp, err := c.HandleRequest(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"name":     "kv",
+		"type":     "rate-limit",
+		"path":     "secret/",
+		"rate":     1,
+		"interval": int64(1),
+		"burst":    1,
+		"bucket":   "client_ip",
+	}
+	if !reflect.DeepEqual(resp.Data, expected) {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+
+	read := func(remoteAddr string) (*logical.Response, error) {
+		req := logical.TestRequest(t, logical.ReadOperation, "secret/foo")
+		req.ClientToken = root
+		req.Connection = &logical.Connection{
+			RemoteAddr: remoteAddr,
+		}
+		return c.HandleRequest(ctx, req)
+	}
+
+	if _, err := read("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = read("10.0.0.1")
+	if err != logical.ErrRateLimitQuotaExceeded {
+		t.Fatalf("expected the quota to be exceeded, got %v", err)
+	}
+	if resp == nil || len(resp.Headers["Retry-After"]) != 1 || resp.Headers["Retry-After"][0] != "1" {
+		t.Fatalf("bad response: %#v", resp)
+	}
+	if _, err := read("10.0.0.2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Other mounts aren't limited
+	for i := 0; i < 3; i++ {
+		req := logical.TestRequest(t, logical.ReadOperation, "cubbyhole/foo")
+		req.ClientToken = root
+		req.Connection = &logical.Connection{
+			RemoteAddr: "10.0.0.1",
+		}
+		if _, err := c.HandleRequest(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req = logical.TestRequest(t, logical.ListOperation, "sys/quotas/rate-limit")
+	req.ClientToken = root
+	resp, err = c.HandleRequest(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := resp.Data["keys"]; !reflect.DeepEqual(keys, []string{"kv"}) {
+		t.Fatalf("bad keys: %#v", keys)
+	}
+
+	// The quota is removed with its mount
+	req = logical.TestRequest(t, logical.DeleteOperation, "sys/mounts/secret")
+	req.ClientToken = root
+	if _, err := c.HandleRequest(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if quota := c.quotaManager.QuotaByName(quotas.TypeRateLimit, "kv"); quota != nil {
+		t.Fatalf("expected the quota to be removed, got %#v", quota)
+	}
+}
+
+func TestCore_LeaseCountQuota(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+	ctx := namespace.RootContext(nil)
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "sys/quotas/lease-count/tokens")
+	req.ClientToken = root
+	req.Data = map[string]interface{}{
+		"path":       "auth/token",
+		"max_leases": 2,
+	}
+	if _, err := c.HandleRequest(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+
+	createToken := func() (*logical.Response, error) {
+		req := logical.TestRequest(t, logical.UpdateOperation, "auth/token/create")
+		req.ClientToken = root
+		req.Data = map[string]interface{}{
+			"ttl": "1h",
+		}
+		return c.HandleRequest(ctx, req)
+	}
+
+	var tokens []string
+	for i := 0; i < 2; i++ {
+		resp, err := createToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, resp.Auth.ClientToken)
+	}
+	if _, err := createToken(); !errwrap.Contains(err, logical.ErrLeaseCountQuotaExceeded.Error()) {
+		t.Fatalf("expected the quota to be exceeded, got %v", err)
+	}
+
+	readCount := func() int64 {
+		t.Helper()
+		req := logical.TestRequest(t, logical.ReadOperation, "sys/quotas/lease-count/tokens")
+		req.ClientToken = root
+		resp, err := c.HandleRequest(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Data["count"].(int64)
+	}
+	if count := readCount(); count != 2 {
+		t.Fatalf("bad count: %d", count)
+	}
+
+	// Revoking a token frees its lease
+	req = logical.TestRequest(t, logical.UpdateOperation, "auth/token/revoke")
+	req.ClientToken = root
+	req.Data = map[string]interface{}{
+		"token": tokens[0],
+	}
+	if _, err := c.HandleRequest(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if count := readCount(); count != 1 {
+		t.Fatalf("bad count after revocation: %d", count)
+	}
+	if _, err := createToken(); err != nil {
+		t.Fatal(err)
+	}
+
+	req = logical.TestRequest(t, logical.ReadOperation, "sys/quotas")
+	req.ClientToken = root
+	resp, err := c.HandleRequest(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"rate-limit": map[string]interface{}{},
+		"lease-count": map[string]interface{}{
+			"tokens": map[string]interface{}{
+				"name":       "tokens",
+				"type":       "lease-count",
+				"path":       "auth/token/",
+				"max_leases": int64(2),
+				"count":      int64(2),
+			},
+		},
+	}
+	if !reflect.DeepEqual(resp.Data, expected) {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+}
+
+func TestCore_LeaseCountQuota_Reserve(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+	ctx := namespace.RootContext(nil)
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "sys/quotas/lease-count/tokens")
+	req.ClientToken = root
+	req.Data = map[string]interface{}{
+		"path":       "auth/token",
+		"max_leases": 5,
+	}
+	if _, err := c.HandleRequest(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+
+	createToken := func() error {
+		req := logical.TestRequest(t, logical.UpdateOperation, "auth/token/create")
+		req.ClientToken = root
+		req.Data = map[string]interface{}{
+			"ttl": "1h",
+		}
+		_, err := c.HandleRequest(ctx, req)
+		return err
+	}
+
+	// Concurrent registrations can't exceed the quota
+	var wg sync.WaitGroup
+	var created int64
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := createToken(); err == nil {
+				atomic.AddInt64(&created, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if created != 5 {
+		t.Fatalf("expected 5 tokens to be created, got %d", created)
+	}
+	if count := c.quotaManager.LeaseCount("", "auth/token/"); count != 5 {
+		t.Fatalf("bad count: %d", count)
+	}
+
+	// The reservation of a lease which can't be persisted is released
+	req.Data["max_leases"] = 10
+	if _, err := c.HandleRequest(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	idView := c.expiration.idView
+	storage := &logical.InmemStorage{}
+	storage.Underlying().FailPut(true)
+	c.expiration.idView = NewBarrierView(storage, "")
+	if err := createToken(); err == nil {
+		t.Fatal("expected an error persisting the lease")
+	}
+	c.expiration.idView = idView
+	if count := c.quotaManager.LeaseCount("", "auth/token/"); count != 5 {
+		t.Fatalf("expected the reservation to be released, got a count of %d", count)
+	}
+}
+
+func TestCore_RateLimitQuota_Audit(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+	ctx := namespace.RootContext(nil)
+
+	noop := &NoopAudit{}
+	c.auditBroker.Register("noop", noop, nil, false, nil, nil)
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "sys/quotas/rate-limit/kv")
+	req.ClientToken = root
+	req.Data = map[string]interface{}{
+		"path":   "secret",
+		"rate":   1,
+		"bucket": "client_ip",
+	}
+	if _, err := c.HandleRequest(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(token string) (*logical.Response, error) {
+		req := logical.TestRequest(t, logical.ReadOperation, "secret/foo")
+		req.ClientToken = token
+		req.Connection = &logical.Connection{
+			RemoteAddr: "10.0.0.1",
+		}
+		return c.HandleRequest(ctx, req)
+	}
+
+	// An invalid token is denied while the quota isn't exceeded
+	if _, err := read("invalid"); err == nil || !errwrap.Contains(err, logical.ErrPermissionDenied.Error()) {
+		t.Fatalf("expected permission denied, got %v", err)
+	}
+
+	// Once it is, requests are rejected with or without a valid token, and
+	// the rejections are audited
+	reqs := len(noop.Req)
+	for _, token := range []string{root, "invalid"} {
+		if _, err := read(token); err != logical.ErrRateLimitQuotaExceeded {
+			t.Fatalf("expected the quota to be exceeded, got %v", err)
+		}
+	}
+	if n := len(noop.Req) - reqs; n != 2 {
+		t.Fatalf("expected 2 rejected requests to be audited, got %d", n)
+	}
+	for i := reqs; i < len(noop.Req); i++ {
+		if noop.Req[i].Path != "secret/foo" || noop.ReqErrs[i] != logical.ErrRateLimitQuotaExceeded {
+			t.Fatalf("bad audited request %q: %v", noop.Req[i].Path, noop.ReqErrs[i])
+		}
+	}
+
+	// Rejected logins are audited too
+	c.credentialBackends["noop"] = func(ctx context.Context, conf *logical.BackendConfig) (logical.Backend, error) {
+		return &NoopBackend{
+			Login:       []string{"login"},
+			BackendType: logical.TypeCredential,
+		}, nil
+	}
+	req = logical.TestRequest(t, logical.UpdateOperation, "sys/auth/foo")
+	req.Data["type"] = "noop"
+	req.ClientToken = root
+	if _, err := c.HandleRequest(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	req = logical.TestRequest(t, logical.UpdateOperation, "sys/quotas/rate-limit/login")
+	req.ClientToken = root
+	req.Data = map[string]interface{}{
+		"path": "auth/foo",
+		"rate": 1,
+	}
+	if _, err := c.HandleRequest(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+
+	reqs = len(noop.Req)
+	for i := 0; i < 2; i++ {
+		_, err := c.HandleRequest(ctx, &logical.Request{
+			Path: "auth/foo/login",
+		})
+		if i == 1 && err != logical.ErrRateLimitQuotaExceeded {
+			t.Fatalf("expected the quota to be exceeded, got %v", err)
+		}
+	}
+	last := len(noop.Req) - 1
+	if last < reqs || noop.Req[last].Path != "auth/foo/login" || noop.ReqErrs[last] != logical.ErrRateLimitQuotaExceeded {
+		t.Fatal("expected the rejected login to be audited")
+	}
+}
@@ -353,6 +353,16 @@ func (c *Core) checkToken(ctx context.Context, req *logical.Request, unauth bool
 		RootPrivsRequired: rootPath,
 	})
 
+	auth.PolicyResults = &logical.PolicyResults{
+		Allowed: authResults.Allowed,
+	}
+	if authResults.ACLResults != nil {
+		auth.PolicyResults.GrantingPolicies = authResults.ACLResults.GrantingPolicies
+		auth.PolicyResults.DenyingPolicies = authResults.ACLResults.DenyingPolicies
+		auth.PolicyResults.Path = authResults.ACLResults.RulePath
+		auth.PolicyResults.MatchType = authResults.ACLResults.MatchType
+	}
+
 	if !authResults.Allowed {
 		retErr := authResults.Error
 
@@ -592,6 +602,21 @@ func (c *Core) handleRequest(ctx context.Context, req *logical.Request) (retResp
 		return nil, nil, ctErr
 	}
 
+	// Apply the rate limit quotas before the token is used, so that rejected
+	// requests don't count against its uses. They also apply to requests
+	// with an invalid token, which get a 429 rather than a 403 once over the
+	// quota, so that guessing tokens is limited too.
+	var entityID string
+	if te != nil {
+		entityID = te.EntityID
+	}
+	if quotaResp, err := c.applyRateLimitQuota(ctx, req, entityID); err != nil {
+		if err == logical.ErrRateLimitQuotaExceeded {
+			c.auditRateLimitedRequest(ctx, req, auth, nonHMACReqDataKeys)
+		}
+		return quotaResp, auth, err
+	}
+
 	// We run this logic first because we want to decrement the use count even
 	// in the case of an error (assuming we can successfully look up; if we
 	// need to forward, we exit before now)
@@ -814,6 +839,10 @@ func (c *Core) handleRequest(ctx context.Context, req *logical.Request) (retResp
 
 			leaseID, err := registerFunc(ctx, req, resp)
 			if err != nil {
+				if errwrap.Contains(err, logical.ErrLeaseCountQuotaExceeded.Error()) {
+					retErr = multierror.Append(retErr, err)
+					return nil, auth, retErr
+				}
 				c.logger.Error("failed to register lease", "request_path", req.Path, "error", err)
 				retErr = multierror.Append(retErr, ErrInternalError)
 				return nil, auth, retErr
@@ -883,6 +912,10 @@ func (c *Core) handleRequest(ctx context.Context, req *logical.Request) (retResp
 				if err := c.tokenStore.revokeOrphan(ctx, resp.Auth.ClientToken); err != nil {
 					c.logger.Warn("failed to clean up token lease during auth/token/ request", "request_path", req.Path, "error", err)
 				}
+				if errwrap.Contains(err, logical.ErrLeaseCountQuotaExceeded.Error()) {
+					retErr = multierror.Append(retErr, err)
+					return nil, auth, retErr
+				}
 				c.logger.Error("failed to register token lease during auth/token/ request", "request_path", req.Path, "error", err)
 				retErr = multierror.Append(retErr, ErrInternalError)
 				return nil, auth, retErr
@@ -930,6 +963,15 @@ func (c *Core) handleLoginRequest(ctx context.Context, req *logical.Request) (re
 		}
 	}
 
+	// Apply the rate limit quotas before anything else, logins don't have an
+	// entity yet
+	if quotaResp, err := c.applyRateLimitQuota(ctx, req, ""); err != nil {
+		if err == logical.ErrRateLimitQuotaExceeded {
+			c.auditRateLimitedRequest(ctx, req, nil, nonHMACReqDataKeys)
+		}
+		return quotaResp, nil, err
+	}
+
 	// Do an unauth check. This will cause EGP policies to be checked
 	var auth *logical.Auth
 	var ctErr error
@@ -1150,6 +1192,8 @@ func (c *Core) handleLoginRequest(ctx context.Context, req *logical.Request) (re
 		case err == nil:
 		case err == ErrInternalError:
 			return nil, auth, err
+		case errwrap.Contains(err, logical.ErrLeaseCountQuotaExceeded.Error()):
+			return nil, auth, err
 		default:
 			return logical.ErrorResponse(err.Error()), auth, logical.ErrInvalidRequest
 		}
@@ -1222,6 +1266,9 @@ func (c *Core) RegisterAuth(ctx context.Context, tokenTTL time.Duration, path st
 			if err := c.tokenStore.revokeOrphan(ctx, te.ID); err != nil {
 				c.logger.Warn("failed to clean up token lease during login request", "request_path", path, "error", err)
 			}
+			if errwrap.Contains(err, logical.ErrLeaseCountQuotaExceeded.Error()) {
+				return err
+			}
 			c.logger.Error("failed to register token lease during login request", "request_path", path, "error", err)
 			return ErrInternalError
 		}
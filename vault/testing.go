@@ -1916,6 +1916,14 @@ func (n *NoopAudit) GetHash(ctx context.Context, data string) (string, error) {
 	return salt.GetIdentifiedHMAC(data), nil
 }
 
+func (n *NoopAudit) HashChainKey(ctx context.Context) ([]byte, error) {
+	salt, err := n.Salt(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return audit.HashChainKey(salt), nil
+}
+
 func (n *NoopAudit) Reload(ctx context.Context) error {
 	return nil
 }
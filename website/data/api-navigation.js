@@ -80,8 +80,10 @@ export default [
       { category: 'kubernetes' },
       { category: 'ldap' },
       { category: 'oci' },
+      { category: 'oidc-device' },
       { category: 'okta' },
       { category: 'radius' },
+      { category: 'spiffe' },
       { category: 'cert' },
       { category: 'token' },
       { category: 'userpass' },
@@ -93,6 +95,7 @@ export default [
     content: [
       'audit',
       'audit-hash',
+      'audit-hash-chain-key',
       'auth',
       'capabilities',
       'capabilities-accessor',
@@ -125,6 +128,9 @@ export default [
       'policy',
       'policies',
       'pprof',
+      'quotas',
+      'quotas-lease-count',
+      'quotas-rate-limit',
       'raw',
       'rekey',
       'rekey-recovery-key',
@@ -97,7 +97,7 @@ export default [
       'agent',
       {
         category: 'audit',
-        content: ['disable', 'enable', 'list']
+        content: ['disable', 'enable', 'list', 'search', 'verify']
       },
       {
         category: 'auth',
@@ -190,12 +190,13 @@ export default [
           },
           {
             category: 'sinks',
-            content: ['file']
+            content: ['file', 'fifo', 'kubernetes']
           }
         ]
       },
       { category: 'caching' },
-      { category: 'template' }
+      { category: 'template' },
+      { category: 'exec' }
     ]
   },
   '----------------',
@@ -266,8 +267,10 @@ export default [
       'github',
       'ldap',
       'oci',
+      'oidc-device',
       'okta',
       'radius',
+      'spiffe',
       'cert',
       'token',
       'userpass',
@@ -278,7 +281,7 @@ export default [
   },
   {
     category: 'audit',
-    content: ['file', 'syslog', 'socket']
+    content: ['file', 'http', 'syslog', 'socket']
   },
   {
     category: 'plugin'